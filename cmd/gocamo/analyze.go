@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/analysis"
+	"github.com/bradsec/gocamo/internal/utils"
+)
+
+type analyzeResult struct {
+	File string `json:"file"`
+	analysis.Metrics
+}
+
+func runAnalyze(args []string) error {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "Print metrics as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocamo analyze [-json] <image or directory>...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no images specified")
+	}
+
+	var files []string
+	for _, arg := range fs.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return fmt.Errorf("failed to access %s: %w", arg, err)
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		images, err := utils.GetImageFiles(arg, utils.ImageFilter{})
+		if err != nil {
+			return fmt.Errorf("failed to get image files: %w", err)
+		}
+		files = append(files, images...)
+	}
+
+	var results []analyzeResult
+	for _, file := range files {
+		img, err := utils.LoadImage(file)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", file, err)
+		}
+		results = append(results, analyzeResult{File: file, Metrics: analysis.Analyze(img)})
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	for _, r := range results {
+		printMetrics(r)
+	}
+	return nil
+}
+
+func printMetrics(r analyzeResult) {
+	fmt.Printf("%s (%dx%d)\n", r.File, r.Width, r.Height)
+	fmt.Printf("  Edge density:       %.4f\n", r.EdgeDensity)
+	fmt.Printf("  Fractal dimension:  %.3f\n", r.FractalDimension)
+	fmt.Printf("  Spectral slope:     %.3f\n", r.SpectralSlope)
+	fmt.Printf("  Spectral centroid:  %.3f\n", r.SpectralCentroid)
+	fmt.Printf("  Spectrum:           %s\n", sparkline(r.Spectrum))
+	fmt.Printf("  Contrast (RMS):     %.3f\n", r.Contrast)
+	fmt.Printf("  Unique colors:      %d\n", r.UniqueColors)
+	for _, a := range r.ColorAreas {
+		fmt.Printf("    %-10s %6.2f%%\n", a.Hex, a.Ratio*100)
+	}
+	fmt.Println()
+}
+
+// sparkline renders values as a row of block characters scaled to the
+// largest value.
+func sparkline(values []float64) string {
+	const levels = "▁▂▃▄▅▆▇█"
+	blocks := []rune(levels)
+
+	var peak float64
+	for _, v := range values {
+		if v > peak {
+			peak = v
+		}
+	}
+
+	var sb strings.Builder
+	for _, v := range values {
+		i := 0
+		if peak > 0 {
+			i = int(v / peak * float64(len(blocks)-1))
+		}
+		sb.WriteRune(blocks[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/bench"
+)
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	iterations := fs.Int("n", 3, "Number of runs per case (the fastest is reported)")
+	patterns := fs.String("t", strings.Join(bench.Patterns, ","), "Comma-separated pattern types to benchmark")
+	sizes := fs.String("sizes", "1080p,4k", "Comma-separated sizes to benchmark (1080p, 4k)")
+	outFile := fs.String("o", "", "Write the results as a JSON baseline to this file")
+	compareFile := fs.String("compare", "", "Compare the results against a JSON baseline")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocamo bench [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *iterations < 1 {
+		return fmt.Errorf("-n must be at least 1")
+	}
+
+	var selected []bench.Size
+	for _, name := range strings.Split(*sizes, ",") {
+		found := false
+		for _, s := range bench.Sizes {
+			if strings.EqualFold(s.Name, strings.TrimSpace(name)) {
+				selected = append(selected, s)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown size: %s", name)
+		}
+	}
+
+	var baseline *bench.Baseline
+	if *compareFile != "" {
+		var err error
+		if baseline, err = bench.ReadBaseline(*compareFile); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%-14s %12s %14s %12s  %s\n", "case", "ms/op", "bytes/op", "allocs/op", "output")
+	results, err := bench.Run(context.Background(), strings.Split(*patterns, ","), selected, *iterations, func(r bench.Result) {
+		fmt.Printf("%-14s %12.1f %14d %12d  %s\n", r.Name, r.MsPerOp, r.BytesPerOp, r.AllocsPerOp, r.OutputHash)
+	})
+	if err != nil {
+		return err
+	}
+
+	if baseline != nil {
+		fmt.Printf("\nCompared with %s (%s):\n", *compareFile, baseline.Time.Format("2006-01-02 15:04"))
+		for _, c := range bench.Compare(baseline, results) {
+			if c.Baseline == nil {
+				fmt.Printf("%-14s not in baseline\n", c.Name)
+				continue
+			}
+			status := "output unchanged"
+			if c.OutputChanged {
+				status = "OUTPUT CHANGED"
+			}
+			fmt.Printf("%-14s %10.1f -> %10.1f ms  %+6.1f%%  %s\n",
+				c.Name, c.Baseline.MsPerOp, c.Current.MsPerOp, c.TimeDelta*100, status)
+		}
+	}
+
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			return fmt.Errorf("failed to create baseline file: %w", err)
+		}
+		defer f.Close()
+		if err := results.Write(f); err != nil {
+			return fmt.Errorf("failed to write baseline: %w", err)
+		}
+		fmt.Printf("\nBaseline written to %s\n", *outFile)
+	}
+
+	return nil
+}
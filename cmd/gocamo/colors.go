@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// runColors prints the main colors of images as found by -t image, without
+// rendering a pattern, optionally as a palette file ready for -j.
+func runColors(args []string) error {
+	fs := flag.NewFlagSet("colors", flag.ExitOnError)
+	input := fs.String("i", "", "Image file or directory of images to take the colors from")
+	k := fs.Int("k", 4, "Number of main colors to find")
+	pixelSize := fs.Int("b", 4, "Base pixel size the image is pooled into before clustering")
+	batch := fs.Int("kmeans-batch", 1024, "Sample size per k-means iteration (0 for full k-means: slower, more accurate)")
+	scaler := fs.String("scaler", "bilinear", "Resampling used when pooling (nearest, approx, bilinear, or catmullrom)")
+	seed := fs.Uint64("seed", 0, "Seed for the k-means starting colors")
+	jsonOutput := fs.Bool("json", false, "Print the palettes as JSON ready for -j")
+	outFile := fs.String("o", "", "Also write the palettes as a JSON file ready for -j")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocamo colors -i <image or directory> [flags]\n\n")
+		fmt.Fprintf(fs.Output(), "Finds the main colors of images the way -t image does, without rendering\n")
+		fmt.Fprintf(fs.Output(), "a pattern. Each image gives one palette named after the file.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *input == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("no image specified")
+	}
+	if *k < 1 {
+		return fmt.Errorf("invalid -k value %d (must be at least 1)", *k)
+	}
+	if *pixelSize < 1 {
+		return fmt.Errorf("invalid -b value %d (must be at least 1)", *pixelSize)
+	}
+	if _, ok := generator.Scalers[*scaler]; !ok {
+		return fmt.Errorf("invalid scaler: %s (must be 'nearest', 'approx', 'bilinear', or 'catmullrom')", *scaler)
+	}
+
+	files := []string{*input}
+	if info, err := os.Stat(*input); err != nil {
+		return fmt.Errorf("failed to access %s: %w", *input, err)
+	} else if info.IsDir() {
+		if files, err = utils.GetImageFiles(*input, utils.ImageFilter{}); err != nil {
+			return fmt.Errorf("failed to get image files: %w", err)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no images found in %s", *input)
+		}
+	}
+
+	settings := &config.Config{
+		BasePixelSize: *pixelSize,
+		KValue:        *k,
+		KMeansBatch:   *batch,
+		Scaler:        *scaler,
+		Seed:          *seed,
+	}
+	var palettes []config.CamoColors
+	for i, file := range files {
+		p, shares, err := extractPalette(context.Background(), settings, file, i)
+		if err != nil {
+			return err
+		}
+		palettes = append(palettes, p)
+
+		if !*jsonOutput {
+			printExtracted(file, p.Colors, shares)
+		}
+	}
+
+	if *outFile != "" {
+		data, err := json.MarshalIndent(palettes, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*outFile, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", *outFile, err)
+		}
+		if !*jsonOutput {
+			fmt.Printf("Palettes written to %s\n", *outFile)
+		}
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(palettes)
+	}
+	return nil
+}
+
+// extractPalette finds the main colors of the image file with the -k, -b,
+// -kmeans-batch, -scaler and -seed settings of cfg, and returns them as a
+// palette named after the file with the share of the image each covers.
+// The whole image is clustered at its own size.
+func extractPalette(ctx context.Context, cfg *config.Config, file string, index int) (config.CamoColors, []float64, error) {
+	img, err := utils.LoadImage(file)
+	if err != nil {
+		return config.CamoColors{}, nil, fmt.Errorf("failed to load %s: %w", file, err)
+	}
+	b := img.Bounds()
+	clusterCfg := &config.Config{
+		PatternType:   "image",
+		Width:         b.Dx(),
+		Height:        b.Dy(),
+		BasePixelSize: cfg.BasePixelSize,
+		KValue:        cfg.KValue,
+		KMeansBatch:   cfg.KMeansBatch,
+		Scaler:        cfg.Scaler,
+		Seed:          cfg.Seed,
+	}
+	colors, shares, err := generator.ExtractColors(ctx, clusterCfg, img, index)
+	if err != nil {
+		return config.CamoColors{}, nil, fmt.Errorf("failed to find the colors of %s: %w", file, err)
+	}
+
+	p := config.CamoColors{Name: strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))}
+	for _, c := range colors {
+		p.Colors = append(p.Colors, fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B))
+	}
+	return p, shares, nil
+}
+
+// printExtracted lists the colors found in file with the share of the image
+// each covers, drawn as swatches on a color terminal.
+func printExtracted(file string, colors []string, shares []float64) {
+	fmt.Printf("%s:\n", file)
+	swatches := colorTerminal()
+	for i, hex := range colors {
+		line := "  "
+		if swatches {
+			line += paletteSwatches([]string{hex}) + " "
+		}
+		fmt.Printf("%s%-10s %6.2f%%\n", line, hex, shares[i]*100)
+	}
+	fmt.Printf("  -c %q\n", strings.Join(colors, ","))
+}
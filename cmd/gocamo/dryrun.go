@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// dryRun checks every palette of a run and prints the files it would write,
+// relative to the output directory, with an estimate of how long it would
+// take. Nothing is generated and no directories are created. Image jobs
+// show their colors, which k-means finds later, as a *. With -skip-existing
+// the outputs that already exist are listed as skipped.
+func dryRun(cfg *config.Config, outputAbsPath string, camoList []config.CamoColors, imagePaths []string) error {
+	var total, longest time.Duration
+	jobs, skipped := 0, 0
+	add := func(jobCfg *config.Config, path string, err error) error {
+		exists := errors.Is(err, generator.ErrOutputExists)
+		if err != nil && !exists {
+			return err
+		}
+		rel, err := filepath.Rel(outputAbsPath, path)
+		if err != nil {
+			rel = path
+		}
+		if exists {
+			fmt.Println(filepath.ToSlash(rel) + " (exists, skipped)")
+			skipped++
+			return nil
+		}
+		fmt.Println(filepath.ToSlash(rel))
+		d := generator.EstimateDuration(jobCfg)
+		total += d
+		if d > longest {
+			longest = d
+		}
+		jobs++
+		return nil
+	}
+
+	if cfg.PatternType == "image" {
+		for i, imagePath := range imagePaths {
+			path, err := generator.ImagePatternPath(cfg, imagePath, nil, i, outputAbsPath)
+			if err := add(cfg, path, err); err != nil {
+				return err
+			}
+		}
+	} else {
+		variants := jobVariants(cfg)
+		for i, camo := range camoList {
+			if err := generator.CheckPalette(cfg, camo); err != nil {
+				return fmt.Errorf("palette %q: %w", camo.Name, err)
+			}
+			outputPath := outputAbsPath
+			if cfg.Family {
+				outputPath = familyDir(outputAbsPath, i, camo)
+			}
+			palCfg, err := cfg.ForPalette(camo)
+			if err != nil {
+				return fmt.Errorf("palette %q: %w", camo.Name, err)
+			}
+			for _, v := range variants {
+				c, jobCfg := v.apply(camo, palCfg)
+				c.Name += v.suffix
+				path, err := generator.PatternPath(jobCfg, c, i, outputPath)
+				if err := add(jobCfg, path, err); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Jobs share the cores, but none finishes sooner than on its own
+	wall := total / time.Duration(cfg.Cores)
+	if wall < longest {
+		wall = longest
+	}
+	if skipped > 0 {
+		slog.Info(fmt.Sprintf("Dry run: %d job(s) skipped as their output already exists", skipped), "skipped", skipped)
+	}
+	slog.Info(fmt.Sprintf("Dry run: %d job(s), estimated %s on %d CPU cores (%s of rendering); nothing was generated",
+		jobs, wall.Round(100*time.Millisecond), cfg.Cores, total.Round(100*time.Millisecond)),
+		"jobs", jobs, "seconds", wall.Seconds(), "cores", cfg.Cores, "cpu_seconds", total.Seconds())
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/bradsec/gocamo/internal/analysis"
+	"github.com/bradsec/gocamo/internal/utils"
+)
+
+type evaluateResult struct {
+	Pattern    string `json:"pattern"`
+	Background string `json:"background"`
+	analysis.Evaluation
+}
+
+// runEvaluate reports how well patterns blend into a background photo.
+func runEvaluate(args []string) error {
+	fs := flag.NewFlagSet("evaluate", flag.ExitOnError)
+	pattern := fs.String("pattern", "", "Pattern image, or a directory of patterns to rank")
+	background := fs.String("background", "", "Photo of the environment the pattern should blend into")
+	jsonOutput := fs.Bool("json", false, "Print the results as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocamo evaluate -pattern <image or directory> -background <photo> [-json]\n\n")
+		fmt.Fprintf(fs.Output(), "Compares patterns with a photo of their environment by color, texture and\n")
+		fmt.Fprintf(fs.Output(), "saliency, and scores how well each blends in, best first.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *pattern == "" || *background == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("a pattern and a background are required")
+	}
+
+	bg, err := utils.LoadImage(*background)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", *background, err)
+	}
+
+	files := []string{*pattern}
+	if info, err := os.Stat(*pattern); err != nil {
+		return fmt.Errorf("failed to access %s: %w", *pattern, err)
+	} else if info.IsDir() {
+		if files, err = utils.GetImageFiles(*pattern, utils.ImageFilter{}); err != nil {
+			return fmt.Errorf("failed to get image files: %w", err)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no images found in %s", *pattern)
+		}
+	}
+
+	var results []evaluateResult
+	for _, file := range files {
+		img, err := utils.LoadImage(file)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", file, err)
+		}
+		results = append(results, evaluateResult{Pattern: file, Background: *background, Evaluation: analysis.Evaluate(img, bg)})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	for _, r := range results {
+		printEvaluation(r)
+	}
+	return nil
+}
+
+func printEvaluation(r evaluateResult) {
+	fmt.Printf("%s against %s\n", r.Pattern, r.Background)
+	fmt.Printf("  Color histogram distance:  %.3f (CIELAB, 0 for the same colors, 1 for none shared)\n", r.HistogramDistance)
+	fmt.Printf("  Mean color difference:     %.1f ΔE\n", r.MeanColorDifference)
+	fmt.Printf("  Spectral slope:            %.2f pattern, %.2f background\n", r.PatternSlope, r.BackgroundSlope)
+	fmt.Printf("  Spectrum correlation:      %.3f\n", r.SpectrumCorrelation)
+	fmt.Printf("  Edge density:              %.3f pattern, %.3f background\n", r.PatternEdgeDensity, r.BackgroundEdgeDensity)
+	fmt.Printf("  Saliency:                  %.2f (1 for as conspicuous as the background around it)\n", r.Saliency)
+	fmt.Printf("  Scores:                    color %.0f, texture %.0f, saliency %.0f\n", r.ColorScore, r.TextureScore, r.SaliencyScore)
+	fmt.Printf("  Blend score:               %.0f/100\n", r.Score)
+	fmt.Println()
+}
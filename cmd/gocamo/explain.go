@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/internal/qr"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+const explainUsage = `Usage: gocamo explain <output.png>
+       gocamo explain [generation flags]
+
+Prints every setting and derived value used to generate a pattern, read
+from the metadata embedded in an output or worked out from the flags a run
+would be started with.
+`
+
+// explainedJob is one output to explain.
+type explainedJob struct {
+	name   string
+	colors []string
+	source string
+	index  int
+	// seedKnown is false for runs that pick a random seed when started
+	seedKnown bool
+}
+
+// runExplain prints a breakdown of how patterns are generated, either from
+// the metadata embedded in an existing output or for generation flags
+// before they are run.
+func runExplain(args []string) error {
+	switch {
+	case len(args) == 0:
+		fmt.Print(explainUsage)
+		return fmt.Errorf("no output image or flags specified")
+	case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+		return explainFile(args[0])
+	}
+	return explainFlags(args)
+}
+
+// explainFile explains an output from its embedded metadata.
+func explainFile(path string) error {
+	meta, err := readOutputMetadata(path)
+	if err != nil {
+		return err
+	}
+
+	cfg := config.Parse(meta.Args)
+	cfg.Seed = meta.Seed
+
+	fmt.Printf("%s\n", path)
+	fmt.Printf("Generated with: gocamo %s\n", quoteArgs(meta.Args))
+	if meta.Version != "" {
+		fmt.Printf("Generated by:   gocamo %s\n", meta.Version)
+	}
+	fmt.Println()
+
+	var colors []string
+	if cfg.ColorsString != "" {
+		colors = strings.Split(cfg.ColorsString, ",")
+	}
+	return explainJob(cfg, explainedJob{
+		name:      meta.Name,
+		colors:    colors,
+		source:    meta.Source,
+		index:     meta.Index,
+		seedKnown: true,
+	})
+}
+
+// readOutputMetadata returns the metadata embedded in an earlier output.
+func readOutputMetadata(path string) (*generator.Metadata, error) {
+	meta, err := generator.ReadMetadata(path)
+	if errors.Is(err, generator.ErrNoMetadata) {
+		return nil, fmt.Errorf("%s: %w (it may come from an older version)", path, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return meta, nil
+}
+
+// explainFlags explains every output a run with the flags would make.
+func explainFlags(args []string) error {
+	cfg := config.Parse(args)
+
+	if cfg.UVTemplate != "" {
+		w, h, err := generator.UVTemplateSize(cfg.UVTemplate)
+		if err != nil {
+			return err
+		}
+		cfg.Width, cfg.Height = w, h
+	}
+	if cfg.Mask != "" {
+		if err := applyMaskSize(cfg); err != nil {
+			return err
+		}
+	}
+
+	seedKnown := cfg.Golden || config.IsFlagPassed("seed")
+	var jobs []explainedJob
+	var configs []*config.Config
+	switch cfg.PatternType {
+	case "image":
+		paths, err := utils.GetImageFiles(cfg.ImageDir, imageFilter(cfg))
+		if err != nil {
+			return fmt.Errorf("failed to get image files: %w", err)
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("no image files found in directory: %s", cfg.ImageDir)
+		}
+		for i, path := range paths {
+			jobs = append(jobs, explainedJob{name: path, source: path, index: i, seedKnown: seedKnown})
+			configs = append(configs, cfg)
+		}
+	case "box", "blob", "pat6", "hex", "composite", config.AllPatterns:
+		camoList, err := loadPalettes(cfg)
+		if err != nil {
+			return err
+		}
+		if err := checkColorRatios(cfg, camoList); err != nil {
+			return err
+		}
+		variants := jobVariants(cfg)
+		for i, camo := range camoList {
+			palCfg, err := cfg.ForPalette(camo)
+			if err != nil {
+				return fmt.Errorf("palette %q: %w", camo.Name, err)
+			}
+			for _, v := range variants {
+				c, jobCfg := v.apply(camo, palCfg)
+				jobs = append(jobs, explainedJob{name: c.Name + v.suffix, colors: c.Colors, index: i, seedKnown: seedKnown || camo.Seed != nil})
+				configs = append(configs, jobCfg)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid pattern type: %s (must be 'box', 'blob', 'pat6', 'hex', 'composite', 'image', or 'all')", cfg.PatternType)
+	}
+
+	for i, job := range jobs {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := explainJob(configs[i], job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// explainJob prints the settings and derived values of one output.
+func explainJob(cfg *config.Config, job explainedJob) error {
+	line := func(label, format string, a ...any) {
+		fmt.Printf("  %-18s %s\n", label, fmt.Sprintf(format, a...))
+	}
+
+	fmt.Printf("%s (job %d)\n", job.name, job.index)
+	line("Pattern type", "%s", cfg.PatternType)
+	if cfg.PatternType == "image" {
+		line("Reference image", "%s", job.source)
+		if cfg.KMeansBatch > 0 {
+			line("Colors", "%d main colors by mini-batch k-means (%d samples per iteration)", cfg.KValue, cfg.KMeansBatch)
+		} else {
+			line("Colors", "%d main colors by full k-means", cfg.KValue)
+		}
+		line("Resampling", "%s", cfg.Scaler)
+	} else {
+		line("Palette", "%s", strings.Join(job.colors, " "))
+	}
+	line("Size", "%dx%d pixels", cfg.Width, cfg.Height)
+	if preset, ok := config.ScalePresets[cfg.Scale]; ok {
+		line("Scale", "%s (%s), structures %dx their usual size", preset.Name, preset.Description, cfg.StructureScale())
+	}
+
+	pixelSize, note := generator.PixelSize(cfg)
+	line("Base pixel size", "%d (requested %d)", pixelSize, cfg.BasePixelSize)
+	if note != "" {
+		line("", "%s", note)
+	}
+	cellSize, cols, rows := generator.GridSize(cfg)
+	if cfg.PatternType == "image" {
+		line("Pooling grid", "%dx%d blocks of %dx%d pixels", cols, rows, cellSize, cellSize)
+	} else {
+		line("Grid", "%dx%d cells of %dx%d pixels", cols, rows, cellSize, cellSize)
+	}
+
+	if job.seedKnown {
+		line("Seed", "%#016x, stream %d", cfg.Seed, job.index)
+	} else {
+		line("Seed", "chosen at random for each run (set with -seed), stream %d", job.index)
+	}
+
+	if cfg.PatternType != "image" {
+		n := len(job.colors)
+		for _, layer := range []struct {
+			label  string
+			ratios config.ColorRatios
+			equal  string
+		}{
+			{"Macro colors", cfg.ColorRatios.Macro, "equal shares"},
+			{"Medium shapes", cfg.ColorRatios.Medium, "color of the shape underneath"},
+			{"Detail colors", cfg.ColorRatios.Detail, "equal shares"},
+		} {
+			if (cfg.PatternType == "blob" || cfg.PatternType == "hex") && layer.label == "Medium shapes" {
+				continue
+			}
+			if cfg.PatternType == "pat6" && layer.label == "Medium shapes" {
+				layer.label, layer.equal = "Edge bands", "color furthest below its share"
+			}
+			shares, err := generator.RatioShares(layer.ratios, n)
+			if err != nil {
+				return err
+			}
+			if shares == nil {
+				line(layer.label, "%s", layer.equal)
+				continue
+			}
+			parts := make([]string, n)
+			for i, share := range shares {
+				parts[i] = fmt.Sprintf("%s %.1f%%", job.colors[i], 100*share)
+			}
+			line(layer.label, "%s (%s)", strings.Join(parts, ", "), layer.ratios)
+		}
+
+		if cfg.PatternType == "composite" {
+			line("Layers", "%s under %s, the top layer covering %d%% in organic patches", cfg.Layers[0], cfg.Layers[1], cfg.LayerOpacity)
+		} else if cfg.PatternType == "pat6" {
+			k := cfg.StructureScale()
+			line("Strokes", "%d-%d cells long and %d-%d cells thick over the most common color, laid until each color has its share",
+				k*generator.StrokeMinLength, k*generator.StrokeMaxLength, k*generator.StrokeMinThickness, k*generator.StrokeMaxThickness)
+			if angle := config.NormalizeAngle(cfg.FlowAngle); angle != 0 {
+				line("Flow", "%g degrees counterclockwise from horizontal", angle)
+			} else {
+				line("Flow", "horizontal")
+			}
+		} else if cfg.PatternType == "hex" {
+			radius, hexCols, hexRows := generator.HexGrid(cfg)
+			line("Hex cells", "%dx%d hexagons %.0f pixels across", hexCols, hexRows, math.Sqrt(3)*radius)
+			balanced := ""
+			if !cfg.ColorRatios.Macro.IsZero() {
+				balanced = ", balanced to the macro ratios"
+			}
+			line("Clustering", "%d cellular automaton passes over each hexagon and its 6 neighbors%s%s",
+				cfg.AutomatonPasses(), automatonChance(cfg, "hex"), balanced)
+		} else {
+			k := cfg.StructureScale() * cfg.AutomatonRadius(cfg.PatternType)
+			neighborhood := fmt.Sprintf("%d cell", k)
+			if k > 1 {
+				neighborhood += "s"
+			}
+			if cfg.PatternType == "box" && k > cfg.StructureScale() {
+				neighborhood = fmt.Sprintf("%d-%d cells", cfg.StructureScale(), k)
+			}
+			balanced := ""
+			if !cfg.ColorRatios.Macro.IsZero() {
+				balanced = ", balanced to the macro ratios"
+			}
+			line("Clustering", "%d cellular automaton passes over neighborhoods of %s%s%s",
+				cfg.AutomatonPasses(), neighborhood, automatonChance(cfg, cfg.PatternType), balanced)
+		}
+		if cfg.UsesPattern("box") {
+			line("Shapes", "squares and rectangles up to %d cells (%d pixels)", cfg.ShapeSize, cfg.ShapeSize*cellSize)
+		}
+	}
+
+	if cfg.Distort > 0 {
+		line("Distortion", "Perlin noise, up to %d cells", cfg.Distort)
+	}
+	if cfg.Blend > 0 {
+		line("Feathering", "%d pixel radius", cfg.Blend)
+	}
+	if cfg.AddNoise {
+		line("Noise", "on, %g%% of pixels", cfg.NoiseChance()*100)
+	} else {
+		line("Noise", "off")
+	}
+	if cfg.AddEdge {
+		line("Edge details", "on, up to ±%d steps", cfg.EdgeSteps())
+	} else {
+		line("Edge details", "off")
+	}
+	if cfg.AddNoise || cfg.AddEdge || cfg.Blend > 0 {
+		if cfg.LegacyBlend {
+			line("Blending", "gamma encoded sRGB (legacy)")
+		} else {
+			line("Blending", "linear light")
+		}
+	}
+	if cfg.AlphaColor != "" {
+		line("Transparent color", "%s", cfg.AlphaColor)
+	}
+	if cfg.QRText != "" {
+		code, err := qr.Encode([]byte(cfg.QRText))
+		if err != nil {
+			return fmt.Errorf("invalid -qr value: %w", err)
+		}
+		line("QR code", "%s, version %d (%dx%d modules), level %s", strconv.Quote(cfg.QRText), code.Version, code.Size, code.Size, code.Level)
+	}
+	if cfg.Mask != "" {
+		if cfg.MaskPattern != "" {
+			line("Mask", "%s, a %s pattern in its dark areas", cfg.Mask, cfg.MaskPattern)
+		} else {
+			line("Mask", "%s, transparent in its dark areas", cfg.Mask)
+		}
+	}
+	if cfg.UVTemplate != "" {
+		line("UV layout", "%s, islands grown by %d pixels", cfg.UVTemplate, cfg.UVBleed)
+	}
+	return nil
+}
+
+// quoteArgs joins command line arguments, quoting any that need it.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\"'#;&|<>()$`\\*?") {
+			a = strconv.Quote(a)
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+// automatonChance describes the chance that a cell of patternType takes the
+// most common color around it, if it doesn't always.
+func automatonChance(cfg *config.Config, patternType string) string {
+	chance := cfg.AutomatonChance(patternType)
+	if chance >= 1 {
+		return ""
+	}
+	return fmt.Sprintf(", taken with a %.0f%% chance", 100*chance)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/internal/worker"
+)
+
+// errorLogName is the failure report written to the output directory.
+const errorLogName = "errors.log"
+
+// failureGroup is the jobs that failed with the same error.
+type failureGroup struct {
+	err  string
+	jobs []worker.JobResult
+}
+
+// groupFailures groups failed jobs by their error, largest group first,
+// with the jobs of each group in job order. Jobs that panicked are grouped
+// by the panic value, whatever their stacks.
+func groupFailures(failures []worker.JobResult) []failureGroup {
+	sort.SliceStable(failures, func(i, j int) bool { return failures[i].Index < failures[j].Index })
+	var groups []failureGroup
+	index := make(map[string]int)
+	for _, f := range failures {
+		msg := failureKey(f.Err)
+		i, ok := index[msg]
+		if !ok {
+			i = len(groups)
+			index[msg] = i
+			groups = append(groups, failureGroup{err: msg})
+		}
+		groups[i].jobs = append(groups[i].jobs, f)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].jobs) > len(groups[j].jobs) })
+	return groups
+}
+
+// failureKey returns the message jobs that failed with err are grouped by:
+// the panic alone for jobs that panicked, or else the error.
+func failureKey(err error) string {
+	var pe *generator.PanicError
+	if errors.As(err, &pe) {
+		return pe.Error()
+	}
+	return err.Error()
+}
+
+// failedJob identifies a failed job by its number, input and pattern type.
+func failedJob(f worker.JobResult) string {
+	return fmt.Sprintf("[%03d] %s (%s, after %.2fs)", f.Index, f.Name, f.Pattern, f.Duration.Seconds())
+}
+
+// panicStack returns the stack of a job that failed with a panic, or nil.
+func panicStack(err error) []byte {
+	var pe *generator.PanicError
+	if errors.As(err, &pe) {
+		return pe.Stack
+	}
+	return nil
+}
+
+// reportFailures logs the failed jobs grouped by error. The stacks of jobs
+// that panicked are only in the JSON log and errors.log.
+func reportFailures(failures []worker.JobResult, total int) {
+	slog.Error(fmt.Sprintf("%d out of %d jobs failed:", len(failures), total), "failed", len(failures), "total", total)
+	for _, g := range groupFailures(failures) {
+		slog.Error(fmt.Sprintf("  %s (%d job(s)):", g.err, len(g.jobs)), "error", g.err, "jobs", len(g.jobs))
+		for _, f := range g.jobs {
+			attrs := []any{"index", f.Index, "name", f.Name, "pattern", f.Pattern, "seconds", f.Duration.Seconds(), "error", g.err}
+			if stack := panicStack(f.Err); stack != nil {
+				attrs = append(attrs, "stack", string(stack))
+			}
+			slog.Error("    "+failedJob(f), attrs...)
+		}
+	}
+}
+
+// writeErrorLog writes the failure report to errors.log in the output
+// directory. A run without failures removes the log of an earlier run so
+// it isn't mistaken for this one's.
+func writeErrorLog(outputDir string, started time.Time, failures []worker.JobResult, total int) error {
+	path := filepath.Join(outputDir, errorLogName)
+	if len(failures) == 0 {
+		if err := os.Remove(utils.LongPath(path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove old %s: %w", errorLogName, err)
+		}
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "gocamo run started %s: %d out of %d jobs failed\n", started.UTC().Format(time.RFC3339), len(failures), total)
+	for _, g := range groupFailures(failures) {
+		fmt.Fprintf(&b, "\n%s (%d job(s)):\n", g.err, len(g.jobs))
+		for _, f := range g.jobs {
+			fmt.Fprintf(&b, "  %s\n", failedJob(f))
+			if stack := panicStack(f.Err); stack != nil {
+				fmt.Fprintf(&b, "\n%s\n", stack)
+			}
+		}
+	}
+	if err := os.WriteFile(utils.LongPath(path), []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", errorLogName, err)
+	}
+	slog.Info("Failure report written to "+path, "file", path)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/internal/worker"
+)
+
+func TestGroupFailures(t *testing.T) {
+	failures := []worker.JobResult{
+		{Index: 0, Err: &generator.PanicError{Value: "bad cell", Stack: []byte("stack 1")}},
+		{Index: 1, Err: errors.New("disk full")},
+		{Index: 2, Err: fmt.Errorf("error saving image: %w", &generator.PanicError{Value: "bad cell", Stack: []byte("stack 2")})},
+		{Index: 3, Err: &generator.PanicError{Value: "other", Stack: []byte("stack 3")}},
+	}
+	groups := groupFailures(failures)
+
+	want := []struct {
+		err  string
+		jobs []int
+	}{
+		{"panic: bad cell", []int{0, 2}},
+		{"disk full", []int{1}},
+		{"panic: other", []int{3}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("got %d groups, want %d", len(groups), len(want))
+	}
+	for i, w := range want {
+		g := groups[i]
+		var jobs []int
+		for _, f := range g.jobs {
+			jobs = append(jobs, f.Index)
+		}
+		if g.err != w.err || fmt.Sprint(jobs) != fmt.Sprint(w.jobs) {
+			t.Errorf("group %d = %q %v, want %q %v", i, g.err, jobs, w.err, w.jobs)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/bradsec/gocamo/internal/golden"
+)
+
+// runGolden compares golden mode output with the committed golden images.
+// It is meant to be run from the repository root before and after changing
+// a generator.
+func runGolden(args []string) error {
+	fs := flag.NewFlagSet("golden", flag.ExitOnError)
+	dir := fs.String("dir", "testdata/golden", "Directory containing the golden PNG files")
+	update := fs.Bool("update", false, "Regenerate the golden files instead of comparing")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocamo golden [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ctx := context.Background()
+	if *update {
+		if err := golden.Update(ctx, *dir); err != nil {
+			return err
+		}
+		fmt.Printf("Updated %d golden image(s) in %s\n", len(golden.Cases), *dir)
+		return nil
+	}
+
+	results, err := golden.Compare(ctx, *dir)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		switch {
+		case r.Match:
+			fmt.Printf("ok    %s\n", r.Case.Name)
+			continue
+		case r.DiffPixels < 0:
+			fmt.Printf("FAIL  %s: missing %s\n", r.Case.Name, golden.Path(*dir, r.Case))
+		case r.DiffPixels == 0:
+			fmt.Printf("FAIL  %s: pixels match but encoded bytes differ\n", r.Case.Name)
+		default:
+			fmt.Printf("FAIL  %s: %d pixel(s) differ\n", r.Case.Name, r.DiffPixels)
+		}
+		failed++
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d golden image(s) differ (run with -update if the change is intended)", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/bradsec/gocamo/internal/generator"
+)
+
+// errInterrupted ends a run stopped with Ctrl+C after its summary has been
+// printed.
+var errInterrupted = errors.New("interrupted")
+
+// handleInterrupts returns a context cancelled by the first Ctrl+C or
+// SIGTERM, which stops new jobs while those in progress finish and are
+// saved, so no output is left half written. A second Ctrl+C quits at once.
+// Call stop when the run is over.
+func handleInterrupts() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sig:
+			signal.Stop(sig)
+			slog.Warn("Interrupted: finishing the jobs in progress, press Ctrl+C again to quit at once")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
+	}
+}
+
+// reportInterrupted lists the outputs an interrupted run completed,
+// relative to the output directory, and how many jobs it didn't start.
+func reportInterrupted(outputDir string, outputs []*generator.Output, cancelled, total int) {
+	slog.Warn(fmt.Sprintf("Run interrupted: %d out of %d jobs completed, %d not started", len(outputs), total, cancelled),
+		"completed", len(outputs), "total", total, "cancelled", cancelled)
+	files := make([]string, len(outputs))
+	for i, out := range outputs {
+		rel, err := filepath.Rel(outputDir, out.FilePath)
+		if err != nil {
+			rel = out.FilePath
+		}
+		files[i] = filepath.ToSlash(rel)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		slog.Info("  "+f, "file", f)
+	}
+}
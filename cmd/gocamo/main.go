@@ -1,26 +1,74 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/bradsec/gocamo/internal/analysis"
+	"github.com/bradsec/gocamo/internal/atlas"
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/internal/logging"
+	"github.com/bradsec/gocamo/internal/qr"
+	"github.com/bradsec/gocamo/internal/sheet"
+	"github.com/bradsec/gocamo/internal/stego"
 	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/internal/webp"
 	"github.com/bradsec/gocamo/internal/worker"
 	"github.com/bradsec/gocamo/pkg/config"
 )
 
+// subcommands maps the first command line argument to an alternative entry
+// point. Anything else is treated as flags for pattern generation.
+var subcommands = map[string]func(args []string) error{
+	"analyze":  runAnalyze,
+	"bench":    runBench,
+	"colors":   runColors,
+	"evaluate": runEvaluate,
+	"explain":  runExplain,
+	"golden":   runGolden,
+	"palettes": runPalettes,
+	"regen":    runRegen,
+	"reveal":   runReveal,
+	"serve":    runServe,
+	"upscale":  runUpscale,
+}
+
+// progressOut is where the progress bar is drawn, io.Discard when it would
+// get in the way of the log.
+var progressOut io.Writer = os.Stdout
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			logging.Setup(logging.Options{})
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	cfg := config.ParseFlags()
+	progressOut = logging.Setup(logging.Options{Quiet: cfg.Quiet, Verbose: cfg.Verbose, JSON: cfg.JSONLog, Stderr: cfg.Stdout})
 
-	utils.PrintBanner()
+	if !cfg.Quiet && !cfg.JSONLog && !cfg.Stdout {
+		utils.PrintBanner()
+	}
 
 	if err := run(cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, errInterrupted) {
+			os.Exit(130)
+		}
+		slog.Error(err.Error())
 		os.Exit(1)
 	}
 }
@@ -28,13 +76,15 @@ func main() {
 func run(cfg *config.Config) error {
 	startTime := time.Now()
 
-	outputAbsPath, err := filepath.Abs(cfg.OutputDir)
+	stopProfiling, err := startProfiling(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return err
 	}
+	defer stopProfiling()
 
-	if err := os.MkdirAll(outputAbsPath, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	outputAbsPath, err := filepath.Abs(cfg.OutputDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
 	var camoList []config.CamoColors
@@ -47,97 +97,846 @@ func run(cfg *config.Config) error {
 	// Handle input type and validation
 	switch cfg.PatternType {
 	case "image":
-		imagePaths, err = utils.GetImageFiles(cfg.ImageDir)
+		imagePaths, err = utils.GetImageFiles(cfg.ImageDir, imageFilter(cfg))
 		if err != nil {
 			return fmt.Errorf("failed to get image files: %w", err)
 		}
 		if len(imagePaths) == 0 {
 			return fmt.Errorf("no image files found in directory: %s", cfg.ImageDir)
 		}
-	case "box", "blob":
-		if cfg.ColorsString != "" {
-			colors := strings.Split(cfg.ColorsString, ",")
-			camoList = append(camoList, config.CamoColors{Name: "custom", Colors: colors})
-		} else if cfg.JSONFile != "" {
-			file, err := os.Open(cfg.JSONFile)
-			if err != nil {
-				return fmt.Errorf("failed to open JSON file: %w", err)
-			}
-			defer file.Close()
-
-			if err := json.NewDecoder(file).Decode(&camoList); err != nil {
-				return fmt.Errorf("failed to decode JSON: %w", err)
-			}
-			if len(camoList) == 0 {
-				return fmt.Errorf("no color palettes found in JSON file")
-			}
-		} else {
-			return fmt.Errorf("no input specified. Use -c for colors, -j for JSON file, or -i for image directory")
+		if cfg.AlphaColor != "" {
+			return fmt.Errorf("-alpha-color is only supported for box, blob, pat6 and hex patterns")
+		}
+		if cfg.DebugLayers || cfg.ORA {
+			return fmt.Errorf("-debug-layers and -ora are only supported for box, blob, pat6 and hex patterns")
+		}
+		if cfg.QRText != "" {
+			return fmt.Errorf("-qr is only supported for box, blob, pat6 and hex patterns")
+		}
+		if cfg.Animate > 0 {
+			return fmt.Errorf("-animate is only supported for box, blob, pat6 and hex patterns")
+		}
+		if cfg.Mask != "" {
+			return fmt.Errorf("-mask is only supported for box, blob, pat6 and hex patterns")
+		}
+		if len(cfg.Wallpapers) > 0 || cfg.Family {
+			return fmt.Errorf("-wallpapers and -family are only supported for box, blob, pat6 and hex patterns")
+		}
+		if cfg.Format == "svg" {
+			return fmt.Errorf("-format svg is only supported for box, blob, pat6 and hex patterns")
+		}
+		if _, ok := generator.Scalers[cfg.Scaler]; !ok {
+			return fmt.Errorf("invalid scaler: %s (must be 'nearest', 'approx', 'bilinear', or 'catmullrom')", cfg.Scaler)
+		}
+	case "box", "blob", "pat6", "hex", "composite", config.AllPatterns:
+		if camoList, err = loadPalettes(cfg); err != nil {
+			return err
 		}
 	default:
-		return fmt.Errorf("invalid pattern type: %s (must be 'box', 'blob', or 'image')", cfg.PatternType)
+		return fmt.Errorf("invalid pattern type: %s (must be 'box', 'blob', 'pat6', 'hex', 'composite', 'image', or 'all')", cfg.PatternType)
 	}
 
-	// Print configuration information
-	fmt.Printf("Generating patterns with dimensions %dx%d, base pixel size %d\n", cfg.Width, cfg.Height, cfg.BasePixelSize)
+	switch cfg.Format {
+	case "svg":
+		if err := checkVectorOutput(cfg); err != nil {
+			return err
+		}
+
+	case "jpeg":
+		if cfg.Payload != "" {
+			return fmt.Errorf("-payload needs a lossless format and cannot be used with -format jpeg")
+		}
+		if cfg.AtlasFile != "" {
+			return fmt.Errorf("-atlas needs PNG output")
+		}
+	case "webp":
+		if cfg.AtlasFile != "" {
+			return fmt.Errorf("-atlas needs PNG output")
+		}
+	}
+
+	// The contact sheet reads the patterns back from their files
+	if cfg.ContactSheet != "" && cfg.Format == "svg" {
+		return fmt.Errorf("-contact-sheet needs PNG, JPEG or WebP output")
+	}
+
+	if cfg.NameTemplate != "" {
+		if err := generator.CheckNameTemplate(cfg.NameTemplate); err != nil {
+			return fmt.Errorf("invalid -name-template value: %w", err)
+		}
+		if !strings.Contains(cfg.NameTemplate, "index") && !strings.Contains(cfg.NameTemplate, "Index") {
+			slog.Warn("-name-template has no {index}, so outputs that share a name get numeric suffixes in the order they are rendered, or overwrite each other with -force", "template", cfg.NameTemplate)
+		}
+	}
+
+	if len(cfg.Payload) > stego.MaxPayload {
+		return fmt.Errorf("-payload is %d bytes, at most %d are supported", len(cfg.Payload), stego.MaxPayload)
+	}
+
+	if cfg.QRText != "" {
+		code, err := qr.Encode([]byte(cfg.QRText))
+		if err != nil {
+			return fmt.Errorf("invalid -qr value: %w", err)
+		}
+		slog.Info(fmt.Sprintf("QR code: version %d (%dx%d modules), error correction level %s", code.Version, code.Size, code.Size, code.Level),
+			"version", code.Version, "modules", code.Size, "level", code.Level.String())
+	}
+
+	if cfg.ColorProfile != "" && cfg.Format != "png" {
+		return fmt.Errorf("-color-profile needs PNG output")
+	}
+	if _, err := generator.LoadColorProfile(cfg.ColorProfile); err != nil {
+		return err
+	}
+
+	if cfg.ICCProfile != "" && !cfg.CMYK {
+		return fmt.Errorf("-icc requires -cmyk")
+	}
+	if _, err := generator.LoadCMYKProfile(cfg.ICCProfile); err != nil {
+		return err
+	}
+
+	if len(cfg.Wallpapers) > 0 {
+		if cfg.Family {
+			return fmt.Errorf("-wallpapers cannot be used with -family")
+		}
+		if cfg.UVTemplate != "" {
+			return fmt.Errorf("-wallpapers cannot be used with -uv")
+		}
+		largestWallpaper(cfg)
+	}
+
+	if cfg.UVTemplate != "" {
+		if cfg.UVBleed < 0 {
+			return fmt.Errorf("UV bleed cannot be negative: %d", cfg.UVBleed)
+		}
+		w, h, err := generator.UVTemplateSize(cfg.UVTemplate)
+		if err != nil {
+			return err
+		}
+		cfg.Width, cfg.Height = w, h
+		slog.Info(fmt.Sprintf("UV layout: using %dx%d from %s", w, h, cfg.UVTemplate), "width", w, "height", h, "template", cfg.UVTemplate)
+	}
+
+	if cfg.Mask != "" {
+		if err := applyMaskSize(cfg); err != nil {
+			return err
+		}
+		slog.Info("Mask: "+cfg.Mask, "mask", cfg.Mask, "mask_pattern", cfg.MaskPattern)
+	}
+
+	if err := checkColorRatios(cfg, camoList); err != nil {
+		return err
+	}
+
+	// Each palette makes one output per variant, which are only built once
+	// tuning has settled the shared settings
+	totalJobs := max(len(camoList), len(imagePaths)) * variantCount(cfg)
+
+	if cfg.Stdout {
+		if err := checkStdout(cfg, totalJobs); err != nil {
+			return err
+		}
+	}
+
+	if cfg.AutoTune {
+		autoTune(cfg, camoList, totalJobs)
+	}
+
+	if cfg.MaxMemory > 0 {
+		if err := applyMemoryBudget(cfg, camoList); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Banded {
+		if cfg.PatternType == "image" {
+			return fmt.Errorf("banded generation is only supported for box, blob, pat6 and hex patterns")
+		}
+		if feature := wholeFrameFeature(cfg); feature != "" {
+			return fmt.Errorf("%s cannot be used with banded generation", feature)
+		}
+	}
+
+	if cfg.Width < generator.MinDimension || cfg.Height < generator.MinDimension {
+		return fmt.Errorf("width and height must be at least %d pixels, got %dx%d", generator.MinDimension, cfg.Width, cfg.Height)
+	}
+	if cfg.Format == "webp" && (cfg.Width > webp.MaxDimension || cfg.Height > webp.MaxDimension) {
+		return fmt.Errorf("WebP images can be at most %d pixels wide and high, got %dx%d", webp.MaxDimension, cfg.Width, cfg.Height)
+	}
+	if err := checkPaletteSizes(cfg, camoList); err != nil {
+		return err
+	}
+
+	// Log the configuration
+	if len(cfg.Wallpapers) > 0 {
+		slog.Info("Generating lock and home screen wallpapers for "+describeWallpapers(cfg), "width", cfg.Width, "height", cfg.Height)
+	} else {
+		pixelSize, pixelNote := generator.PixelSize(cfg)
+		slog.Info(fmt.Sprintf("Generating patterns with dimensions %dx%d, base pixel size %d", cfg.Width, cfg.Height, pixelSize),
+			"width", cfg.Width, "height", cfg.Height, "pixel_size", pixelSize)
+		if pixelNote != "" {
+			slog.Warn(pixelNote, "requested", cfg.BasePixelSize, "pixel_size", pixelSize)
+		}
+	}
+	if preset, ok := config.ScalePresets[cfg.Scale]; ok {
+		slog.Info(fmt.Sprintf("Scale: %s (%s), base pixel size %d, shapes up to %d cells, structures %dx their usual size",
+			preset.Name, preset.Description, cfg.BasePixelSize, cfg.ShapeSize, cfg.StructureScale()),
+			"scale", preset.Name, "pixel_size", cfg.BasePixelSize, "shape_cells", cfg.ShapeSize, "structure", cfg.StructureScale())
+	}
+	if preset, ok := config.UsePresets[cfg.Use]; ok {
+		pixel, shape := preset.ElementSizes()
+		slog.Info(fmt.Sprintf("Use: %s (%s), %.1f mm elements from %.0f m and %.0f mm shapes (%d cells) to %.0f m at %d DPI",
+			preset.Name, preset.Description, pixel, preset.Near, shape, cfg.ShapeSize, preset.Far, cfg.DPI),
+			"use", preset.Name, "element_mm", pixel, "shape_mm", shape, "shape_cells", cfg.ShapeSize, "dpi", cfg.DPI)
+		widthMM, heightMM := float64(cfg.Width)/float64(cfg.DPI)*25.4, float64(cfg.Height)/float64(cfg.DPI)*25.4
+		slog.Info(fmt.Sprintf("Printed size: %.0fx%.0f mm", widthMM, heightMM), "width_mm", widthMM, "height_mm", heightMM)
+	}
 	if cfg.PatternType == "image" {
-		fmt.Printf("Processing %d images using %d CPU cores\n", len(imagePaths), cfg.Cores)
+		slog.Info(fmt.Sprintf("Processing %d images using %d CPU cores", len(imagePaths), cfg.Cores), "images", len(imagePaths), "cores", cfg.Cores)
 	} else {
-		fmt.Printf("Processing %d color palette(s) using %d CPU cores\n", len(camoList), cfg.Cores)
+		slog.Info(fmt.Sprintf("Processing %d color palette(s) using %d CPU cores", len(camoList), cfg.Cores), "palettes", len(camoList), "cores", cfg.Cores)
+	}
+	slog.Info("Pattern type: "+cfg.PatternType, "pattern", cfg.PatternType)
+	if n := countOverrides(camoList); n > 0 {
+		slog.Info(fmt.Sprintf("%d palette(s) set their own pattern type, size, ratios or seed", n), "palettes", n)
+	}
+	if cfg.PatternType == "composite" {
+		slog.Info(fmt.Sprintf("Layers: %s under %s, top layer opacity %d%%", cfg.Layers[0], cfg.Layers[1], cfg.LayerOpacity),
+			"bottom", cfg.Layers[0], "top", cfg.Layers[1], "opacity", cfg.LayerOpacity)
+	}
+	slog.Info(fmt.Sprintf("Seed: %#x", cfg.Seed), "seed", fmt.Sprintf("%#x", cfg.Seed))
+	slog.Info(fmt.Sprintf("Add edge details: %v, Add noise: %v", cfg.AddEdge, cfg.AddNoise), "edge", cfg.AddEdge, "noise", cfg.AddNoise)
+	timeout := "no timeout"
+	if d := generator.JobTimeout(cfg); d >= time.Second {
+		timeout = fmt.Sprintf("timeout %v", d.Round(time.Second))
+	} else if d > 0 {
+		timeout = fmt.Sprintf("timeout %v", d)
+	}
+	slog.Debug(fmt.Sprintf("Estimated per job: %s memory, %.1fs render time (%s)",
+		formatBytes(generator.EstimateMemory(cfg)), generator.EstimateDuration(cfg).Seconds(), timeout),
+		"memory_bytes", generator.EstimateMemory(cfg), "seconds", generator.EstimateDuration(cfg).Seconds(), "timeout_seconds", generator.JobTimeout(cfg).Seconds())
+	if cfg.Stdout {
+		slog.Info("Output: standard output", "dir", "-")
+	} else {
+		slog.Info("Output path: "+outputAbsPath, "dir", outputAbsPath)
 	}
-	fmt.Printf("Pattern type: %s\n", cfg.PatternType)
-	fmt.Printf("Add edge details: %v, Add noise: %v\n", cfg.AddEdge, cfg.AddNoise)
-	fmt.Printf("Output path: %s\n\n", outputAbsPath)
 
-	// Set up worker pools and channels
-	totalJobs := max(len(camoList), len(imagePaths))
-	jobs := make(chan worker.Job, totalJobs)
-	results := make(chan error, totalJobs)
-	progressDone := make(chan bool)
-	var wg sync.WaitGroup
+	if cfg.DryRun {
+		return dryRun(cfg, outputAbsPath, camoList, imagePaths)
+	}
 
-	// Start worker pool
-	for w := 1; w <= cfg.Cores; w++ {
-		wg.Add(1)
-		go worker.Work(jobs, results, &wg)
+	if cfg.Stdout {
+		return writeStdout(cfg, outputAbsPath, camoList, imagePaths)
 	}
 
+	if err := os.MkdirAll(utils.LongPath(outputAbsPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	if cfg.Family {
+		for i, camo := range camoList {
+			if err := os.MkdirAll(utils.LongPath(familyDir(outputAbsPath, i, camo)), 0755); err != nil {
+				return fmt.Errorf("failed to create family directory: %w", err)
+			}
+		}
+	}
+
+	ctx, stopInterrupts := handleInterrupts()
+	defer stopInterrupts()
+
+	pool := worker.NewPool(cfg, cfg.Cores)
+	pool.Timeouts = cfg.Timeouts
+	errs := make(chan error, totalJobs)
+
 	// Start progress tracking
-	go utils.TrackProgress(results, totalJobs, progressDone)
+	progress := make(chan utils.ProgressSummary, 1)
+	go func() {
+		progress <- utils.TrackProgress(ctx, progressOut, errs, totalJobs)
+	}()
+
+	// Collect outputs for the metrics and score reports and forward errors
+	// to the progress tracker
+	var records []analysis.Record
+	var scores []analysis.ScoreRecord
+	var failures []worker.JobResult
+	var outputs []*generator.Output
+	var entries []manifestEntry
+	skipped, cancelled := 0, 0
+	collected := make(chan struct{})
+	go func() {
+		for r := range pool.Results() {
+			if r.Output != nil && r.Output.Metrics != nil {
+				records = append(records, newRecord(r))
+				scores = append(scores, newScoreRecord(cfg, r.Output))
+			}
+			if errors.Is(r.Err, worker.ErrCancelled) {
+				cancelled++
+				errs <- nil
+				continue
+			}
+			if errors.Is(r.Err, generator.ErrOutputExists) {
+				skipped++
+				slog.Debug(fmt.Sprintf("[%03d] %s skipped: output exists", r.Index, r.Name), "index", r.Index, "name", r.Name)
+				errs <- nil
+				continue
+			}
+			if r.Err != nil {
+				failures = append(failures, r)
+			} else if r.Output != nil {
+				outputs = append(outputs, r.Output)
+				entries = append(entries, newManifestEntry(outputAbsPath, r))
+				logJob(r)
+			}
+			errs <- r.Err
+		}
+		close(errs)
+		close(collected)
+	}()
+
+	var limiter *worker.Limiter
+	if cfg.Adaptive {
+		budget := cfg.MaxMemory
+		if budget == 0 {
+			budget = utils.AvailableMemory() / 4 * 3
+		}
+		limiter = worker.NewLimiter(budget, cfg.Cores)
+	}
 
 	// Queue jobs based on pattern type
 	if cfg.PatternType == "image" {
 		for i, imagePath := range imagePaths {
-			jobs <- worker.Job{
+			pool.Submit(worker.Job{
 				ImagePath:  imagePath,
 				Index:      i,
 				Config:     cfg,
 				OutputPath: outputAbsPath,
-			}
+				Priority:   worker.Priority(cfg),
+				Limiter:    limiter,
+			})
 		}
 	} else {
+		variants := jobVariants(cfg)
 		for i, camo := range camoList {
-			jobs <- worker.Job{
-				Camo:       camo,
-				Index:      i,
-				Config:     cfg,
-				OutputPath: outputAbsPath,
+			outputPath := outputAbsPath
+			if cfg.Family {
+				outputPath = familyDir(outputAbsPath, i, camo)
+			}
+			// Checked by checkColorRatios
+			palCfg, _ := cfg.ForPalette(camo)
+			for _, v := range variants {
+				c, jobCfg := v.apply(camo, palCfg)
+				c.Name += v.suffix
+				pool.Submit(worker.Job{
+					Camo:       c,
+					Index:      i,
+					Config:     jobCfg,
+					OutputPath: outputPath,
+					Priority:   worker.Priority(jobCfg),
+					Limiter:    limiter,
+				})
 			}
 		}
 	}
-	close(jobs)
+	pool.Close()
+
+	// Every job is queued before the workers start, so they start in order:
+	// those estimated to finish soonest first
+	pool.Start(ctx)
 
 	// Wait for all jobs to complete
-	wg.Wait()
-	close(results)
-	<-progressDone
+	<-collected
+	summary := <-progress
+
+	if cfg.MetricsFile != "" {
+		sort.Slice(records, func(i, j int) bool { return records[i].File < records[j].File })
+		if err := analysis.WriteRecords(cfg.MetricsFile, records); err != nil {
+			return fmt.Errorf("failed to write metrics: %w", err)
+		}
+		slog.Info(fmt.Sprintf("Metrics for %d pattern(s) written to %s", len(records), cfg.MetricsFile), "patterns", len(records), "file", cfg.MetricsFile)
+	}
+
+	if cfg.ScoreFile != "" {
+		if err := writeScores(cfg.ScoreFile, scores); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Verbose {
+		logCoverage(outputs)
+	}
+
+	if cfg.AtlasFile != "" && len(outputs) > 0 {
+		if err := writeAtlas(cfg, outputs); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ContactSheet != "" && len(outputs) > 0 {
+		if err := writeContactSheet(cfg, outputs); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Manifest {
+		if err := writeManifest(cfg, outputAbsPath, startTime, entries, failures); err != nil {
+			return err
+		}
+	}
+
+	if skipped > 0 {
+		slog.Info(fmt.Sprintf("Skipped %d job(s) whose output already exists", skipped), "skipped", skipped)
+	}
+
+	if len(failures) > 0 {
+		reportFailures(failures, summary.Total)
+	}
+	if err := writeErrorLog(outputAbsPath, startTime, failures, summary.Total); err != nil {
+		return err
+	}
 
 	duration := time.Since(startTime)
-	fmt.Printf("\nRuntime %.2f seconds.\n", duration.Seconds())
+	slog.Info(fmt.Sprintf("Runtime %.2f seconds.", duration.Seconds()), "seconds", duration.Seconds(), "jobs", summary.Total, "failed", len(failures))
+
+	if summary.Cancelled {
+		reportInterrupted(outputAbsPath, outputs, cancelled, totalJobs)
+		return errInterrupted
+	}
+	return nil
+}
+
+// loadPalettes returns the palette given with -c, the palettes in the -j
+// file, or those taken from the -i images with -image-mode palette-only or
+// environment.
+func loadPalettes(cfg *config.Config) ([]config.CamoColors, error) {
+	switch {
+	case cfg.ImageMode == config.ImagePaletteOnly:
+		return imagePalettes(cfg)
+	case cfg.ImageMode == config.ImageEnvironment:
+		return environmentPalette(cfg)
+	case cfg.ColorsString != "":
+		return []config.CamoColors{{Name: "custom", Colors: strings.Split(cfg.ColorsString, ",")}}, nil
+	case cfg.JSONFile != "":
+		return config.LoadPalettes(cfg.JSONFile, cfg.Strict)
+	case len(cfg.Palettes) > 0:
+		return cfg.Palettes, nil
+	}
+	return nil, fmt.Errorf("no input specified. Use -c for colors, -j for a palette file, -p for built-in palettes, or -i for image directory")
+}
+
+// imagePalettes takes the main colors of each -i image as a palette named
+// after the file, for -image-mode palette-only.
+func imagePalettes(cfg *config.Config) ([]config.CamoColors, error) {
+	paths, err := paletteImages(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info(fmt.Sprintf("Finding the main colors of %d image(s)", len(paths)), "images", len(paths))
+	palettes := make([]config.CamoColors, len(paths))
+	for i, path := range paths {
+		p, _, err := extractPalette(context.Background(), cfg, path, i)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug(fmt.Sprintf("%s: %s", path, strings.Join(p.Colors, ",")), "file", path, "colors", p.Colors)
+		palettes[i] = p
+	}
+	return palettes, nil
+}
+
+// environmentPalette takes the main colors of all the -i images together
+// as one palette named after the directory, for -image-mode environment.
+func environmentPalette(cfg *config.Config) ([]config.CamoColors, error) {
+	paths, err := paletteImages(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info(fmt.Sprintf("Finding the main colors of %d image(s) together", len(paths)), "images", len(paths))
+	colors, shares, err := generator.ExtractEnvironmentColors(context.Background(), cfg, paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the colors of %s: %w", cfg.ImageDir, err)
+	}
+
+	name := "environment"
+	if dir, err := filepath.Abs(cfg.ImageDir); err == nil {
+		name = filepath.Base(dir)
+	}
+	p := config.CamoColors{Name: name}
+	for i, c := range colors {
+		hex := fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+		p.Colors = append(p.Colors, hex)
+		slog.Debug(fmt.Sprintf("  %s %6.2f%%", hex, shares[i]*100), "color", hex, "share", shares[i])
+	}
+	slog.Info(fmt.Sprintf("Environment palette %s: %s", name, strings.Join(p.Colors, ",")), "name", name, "colors", p.Colors)
+	return []config.CamoColors{p}, nil
+}
+
+// paletteImages returns the -i images that -image-mode palette-only and
+// environment take their colors from.
+func paletteImages(cfg *config.Config) ([]string, error) {
+	if _, ok := generator.Scalers[cfg.Scaler]; !ok {
+		return nil, fmt.Errorf("invalid scaler: %s (must be 'nearest', 'approx', 'bilinear', or 'catmullrom')", cfg.Scaler)
+	}
+	paths, err := utils.GetImageFiles(cfg.ImageDir, imageFilter(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image files: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no image files found in directory: %s", cfg.ImageDir)
+	}
+	return paths, nil
+}
+
+// imageFilter selects the images of the -i directory with -include,
+// -exclude and -no-recursive.
+func imageFilter(cfg *config.Config) utils.ImageFilter {
+	return utils.ImageFilter{Include: cfg.Include, Exclude: cfg.Exclude, NoRecursive: cfg.NoRecursive}
+}
+
+// writeAtlas packs the generated patterns into a single atlas image.
+func writeAtlas(cfg *config.Config, outputs []*generator.Output) error {
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].FilePath < outputs[j].FilePath })
+	sprites := make([]atlas.Sprite, len(outputs))
+	for i, out := range outputs {
+		sprites[i] = atlas.Sprite{Name: out.Name, File: out.FilePath}
+	}
+
+	m, err := atlas.Pack(cfg.AtlasFile, sprites, cfg.AtlasPadding)
+	if err != nil {
+		return fmt.Errorf("failed to write atlas: %w", err)
+	}
+	slog.Info(fmt.Sprintf("Atlas of %d pattern(s) (%dx%d) written to %s", len(m.Sprites), m.Width, m.Height, cfg.AtlasFile),
+		"patterns", len(m.Sprites), "width", m.Width, "height", m.Height, "file", cfg.AtlasFile)
+	return nil
+}
+
+// writeContactSheet draws a labeled preview of each generated pattern on
+// a single image.
+func writeContactSheet(cfg *config.Config, outputs []*generator.Output) error {
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].FilePath < outputs[j].FilePath })
+	tiles := make([]sheet.Tile, len(outputs))
+	for i, out := range outputs {
+		tiles[i] = sheet.Tile{File: out.FilePath, Title: fmt.Sprintf("%s (%s)", out.Name, out.Pattern), Colors: out.Colors}
+	}
+
+	size, err := sheet.Write(cfg.ContactSheet, tiles)
+	if err != nil {
+		return fmt.Errorf("failed to write contact sheet: %w", err)
+	}
+	slog.Info(fmt.Sprintf("Contact sheet of %d pattern(s) (%dx%d) written to %s", len(tiles), size.X, size.Y, cfg.ContactSheet),
+		"patterns", len(tiles), "width", size.X, "height", size.Y, "file", cfg.ContactSheet)
+	return nil
+}
+
+// logJob logs the timing and settings of a finished job.
+func logJob(r worker.JobResult) {
+	file := filepath.Base(r.Output.FilePath)
+	retried := ""
+	if r.Retries > 0 {
+		retried = fmt.Sprintf(", %d retries", r.Retries)
+	}
+	slog.Debug(fmt.Sprintf("[%03d] %s in %.2fs (seed %#x, base pixel size %d%s)", r.Index, file, r.Duration.Seconds(), r.Config.Seed, r.Output.PixelSize, retried),
+		"index", r.Index, "name", r.Name, "file", file, "seconds", r.Duration.Seconds(),
+		"seed", fmt.Sprintf("%#x", r.Config.Seed), "pixel_size", r.Output.PixelSize, "retries", r.Retries)
+}
+
+// logCoverage lists how much of each output every palette color covers
+// next to the share it was meant to cover.
+func logCoverage(outputs []*generator.Output) {
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].FilePath < outputs[j].FilePath })
+	for _, out := range outputs {
+		if out.Coverage == nil {
+			continue
+		}
+		file := filepath.Base(out.FilePath)
+		slog.Debug(fmt.Sprintf("Color coverage of %s:", file), "file", file)
+		for i, code := range out.Colors {
+			msg := fmt.Sprintf("  #%s %5.1f%%", code, 100*out.Coverage[i])
+			attrs := []any{"file", file, "color", "#" + code, "coverage", out.Coverage[i]}
+			if out.Target != nil {
+				msg += fmt.Sprintf("  (target %5.1f%%, %+5.1f)", 100*out.Target[i], 100*(out.Coverage[i]-out.Target[i]))
+				attrs = append(attrs, "target", out.Target[i])
+			}
+			slog.Debug(msg, attrs...)
+		}
+	}
+}
+
+// checkColorRatios makes sure explicit -r weights, or those a palette sets
+// for itself, match the palette so a mismatch is reported before any work
+// starts.
+func checkColorRatios(cfg *config.Config, camoList []config.CamoColors) error {
+	if cfg.PatternType == "image" {
+		if !cfg.ColorRatios.IsZero() {
+			return fmt.Errorf("color ratios are not supported for image patterns")
+		}
+		return nil
+	}
+	for _, camo := range camoList {
+		pc, err := cfg.ForPalette(camo)
+		if err != nil {
+			return fmt.Errorf("palette %q: %w", camo.Name, err)
+		}
+		for layer, ratios := range pc.ColorRatios.Layers() {
+			if ratios.Weights != nil && len(camo.Colors) != len(ratios.Weights) {
+				return fmt.Errorf("%d %s color ratio(s) given but palette %q has %d colors",
+					len(ratios.Weights), layer, camo.Name, len(camo.Colors))
+			}
+		}
+	}
+	return nil
+}
+
+// countOverrides returns the number of palettes that set their own pattern
+// settings.
+func countOverrides(camoList []config.CamoColors) int {
+	n := 0
+	for _, camo := range camoList {
+		if camo.HasOverrides() {
+			n++
+		}
+	}
+	return n
+}
+
+// checkPaletteSizes checks the sizes palettes set for themselves, which
+// replace the size of the run.
+func checkPaletteSizes(cfg *config.Config, camoList []config.CamoColors) error {
+	for _, camo := range camoList {
+		if camo.Width == 0 && camo.Height == 0 {
+			continue
+		}
+		switch {
+		case cfg.UVTemplate != "":
+			return fmt.Errorf("palette %q sets its own size, which can't be used with -uv", camo.Name)
+		case len(cfg.Wallpapers) > 0:
+			return fmt.Errorf("palette %q sets its own size, which can't be used with -wallpapers", camo.Name)
+		}
+		pc, err := cfg.ForPalette(camo)
+		if err != nil {
+			return fmt.Errorf("palette %q: %w", camo.Name, err)
+		}
+		if pc.Width < generator.MinDimension || pc.Height < generator.MinDimension {
+			return fmt.Errorf("palette %q: width and height must be at least %d pixels, got %dx%d", camo.Name, generator.MinDimension, pc.Width, pc.Height)
+		}
+		if pc.Format == "webp" && (pc.Width > webp.MaxDimension || pc.Height > webp.MaxDimension) {
+			return fmt.Errorf("palette %q: WebP images can be at most %d pixels wide and high, got %dx%d", camo.Name, webp.MaxDimension, pc.Width, pc.Height)
+		}
+	}
+	return nil
+}
+
+// checkVectorOutput rejects settings that only work on pixels when patterns
+// are saved as SVG.
+func checkVectorOutput(cfg *config.Config) error {
+	if cfg.AddNoise || cfg.AddEdge {
+		return fmt.Errorf("-noise and -edge add pixel details and cannot be used with -format svg")
+	}
+	for patternType := range cfg.PatternSettings {
+		c := *cfg
+		c.PatternType = patternType
+		if pc := c.ForPattern(); pc.AddNoise || pc.AddEdge {
+			return fmt.Errorf("the -profile settings for %s patterns add noise or edge details and cannot be used with -format svg", patternType)
+		}
+	}
+	if cfg.Blend > 0 {
+		return fmt.Errorf("-blend feathers pixels and cannot be used with -format svg")
+	}
+	if cfg.AtlasFile != "" {
+		return fmt.Errorf("-atlas needs PNG output")
+	}
+	if feature := wholeFrameFeature(cfg); feature != "" {
+		return fmt.Errorf("%s needs PNG output", feature)
+	}
+	return nil
+}
+
+// wholeFrameFeature returns the name of the first requested feature that
+// needs each pattern in memory as a whole, which rules out banded generation.
+func wholeFrameFeature(cfg *config.Config) string {
+	switch {
+	case cfg.MetricsFile != "":
+		return "metrics"
+	case cfg.ScoreFile != "":
+		return "scoring"
+	case cfg.Mipmaps:
+		return "mipmaps"
+	case cfg.Animate > 0:
+		return "animation"
+	case cfg.UVTemplate != "":
+		return "UV layouts"
+	case cfg.Mask != "":
+		return "masks"
+	case cfg.CMYK:
+		return "CMYK output"
+	case cfg.CVD:
+		return "color blindness simulation"
+	case cfg.DebugLayers:
+		return "debug layers"
+	case cfg.ORA:
+		return "layered export"
+	case cfg.Payload != "":
+		return "payload embedding"
+	case cfg.Format == "jpeg" || cfg.Format == "webp":
+		return "JPEG and WebP output"
+	case cfg.Stdout:
+		return "-o -"
+	}
+	return ""
+}
+
+// applyMaskSize gives the outputs the size of the -mask image unless -w or
+// -h is set or a UV layout or wallpapers set it, in which case the mask is
+// scaled to fit.
+func applyMaskSize(cfg *config.Config) error {
+	w, h, err := generator.MaskSize(cfg.Mask)
+	if err != nil {
+		return err
+	}
+	if cfg.UVTemplate == "" && len(cfg.Wallpapers) == 0 && !config.IsFlagPassed("w") && !config.IsFlagPassed("h") {
+		cfg.Width, cfg.Height = w, h
+	}
+	return nil
+}
+
+// largestJob returns the config of the job of the run estimated to need the
+// most memory. Palettes and variants may set their own size, so each job is
+// estimated with its own settings.
+func largestJob(cfg *config.Config, camoList []config.CamoColors) *config.Config {
+	if cfg.PatternType == "image" || len(camoList) == 0 {
+		return cfg
+	}
+	var largest *config.Config
+	var most uint64
+	variants := jobVariants(cfg)
+	for _, camo := range camoList {
+		// Checked by checkColorRatios
+		palCfg, err := cfg.ForPalette(camo)
+		if err != nil {
+			continue
+		}
+		for _, v := range variants {
+			_, jobCfg := v.apply(camo, palCfg)
+			if mem := generator.EstimateMemory(jobCfg); largest == nil || mem > most {
+				largest, most = jobCfg, mem
+			}
+		}
+	}
+	if largest == nil {
+		return cfg
+	}
+	return largest
+}
+
+// applyMemoryBudget lowers the number of concurrent workers so that the
+// estimated memory of all in-flight jobs fits the budget, switching to banded
+// generation if even a single whole-frame worker would not fit.
+func applyMemoryBudget(cfg *config.Config, camoList []config.CamoColors) error {
+	perJob := generator.EstimateMemory(largestJob(cfg, camoList))
+	workers := workersWithinBudget(cfg, perJob)
+	if workers < 1 && !cfg.Banded && cfg.PatternType != "image" && wholeFrameFeature(cfg) == "" {
+		cfg.Banded = true
+		perJob = generator.EstimateMemory(largestJob(cfg, camoList))
+		workers = workersWithinBudget(cfg, perJob)
+		slog.Info(fmt.Sprintf("Memory budget: switching to banded generation (%s per job)", formatBytes(perJob)), "memory_bytes", perJob)
+	}
+	if perJob > cfg.MaxMemory {
+		return fmt.Errorf("estimated memory per job (%s) exceeds the -max-mem budget (%s)",
+			formatBytes(perJob), formatBytes(cfg.MaxMemory))
+	}
+
+	// In adaptive mode the limiter enforces the budget per job instead
+	workers = max(workers, 1)
+	if workers < cfg.Cores && !cfg.Adaptive {
+		slog.Info(fmt.Sprintf("Memory budget: reducing workers from %d to %d (%s per job)", cfg.Cores, workers, formatBytes(perJob)),
+			"cores", cfg.Cores, "workers", workers, "memory_bytes", perJob)
+		cfg.Cores = workers
+	}
+	return nil
+}
 
+// workersWithinBudget returns how many workers fit in the memory budget.
+// Whole frames are held by the rendering and encoding stages plus one queued
+// between them, so n workers may hold 2n+1 frames. Banded jobs are written
+// as they render and hold nothing once done.
+func workersWithinBudget(cfg *config.Config, perJob uint64) int {
+	frames := int(cfg.MaxMemory / perJob)
+	if cfg.Banded {
+		return frames
+	}
+	return (frames - 1) / 2
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// newScoreRecord rates a generated pattern for the -score report.
+func newScoreRecord(cfg *config.Config, out *generator.Output) analysis.ScoreRecord {
+	return analysis.ScoreRecord{
+		File:        out.FilePath,
+		Name:        out.Name,
+		PatternType: out.Pattern,
+		Colors:      out.Colors,
+		Coverage:    out.Coverage,
+		Target:      out.Target,
+		Score:       analysis.Rate(*out.Metrics, out.Coverage, out.Target),
+		Metrics:     *out.Metrics,
+	}
+}
+
+// writeScores writes the -score report, best scoring pattern first, and
+// logs the best one.
+func writeScores(path string, scores []analysis.ScoreRecord) error {
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].Overall != scores[j].Overall {
+			return scores[i].Overall > scores[j].Overall
+		}
+		return scores[i].File < scores[j].File
+	})
+	if err := analysis.WriteScores(path, scores); err != nil {
+		return fmt.Errorf("failed to write score report: %w", err)
+	}
+	slog.Info(fmt.Sprintf("Scores for %d pattern(s) written to %s", len(scores), path), "patterns", len(scores), "file", path)
+	if len(scores) > 0 {
+		best := filepath.Base(scores[0].File)
+		slog.Info(fmt.Sprintf("Best score: %.1f for %s", scores[0].Overall, best), "score", scores[0].Overall, "file", best)
+	}
 	return nil
 }
 
+// newRecord returns the -metrics row of a job, with the settings it was
+// rendered with rather than those of the run, which palettes, variants and
+// -t all change.
+func newRecord(r worker.JobResult) analysis.Record {
+	out, cfg := r.Output, r.Config
+	rec := analysis.Record{
+		File:          out.FilePath,
+		Name:          out.Name,
+		PatternType:   out.Pattern,
+		Colors:        out.Colors,
+		BasePixelSize: out.PixelSize,
+		AddEdge:       cfg.AddEdge,
+		AddNoise:      cfg.AddNoise,
+		Metrics:       *out.Metrics,
+	}
+	if out.Pattern == "image" {
+		rec.KValue = cfg.KValue
+	}
+	return rec
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/internal/worker"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// manifestName is the file in the output directory that lists the outputs
+// of the last run.
+const manifestName = "manifest.json"
+
+// manifest describes a run for asset pipelines to pick up its outputs.
+type manifest struct {
+	Seed     string          `json:"seed"`
+	Started  time.Time       `json:"started"`
+	Seconds  float64         `json:"seconds"`
+	Patterns []manifestEntry `json:"patterns"`
+	Failed   []manifestError `json:"failed,omitempty"`
+}
+
+// manifestEntry is a generated file. File is relative to the output
+// directory, with forward slashes.
+type manifestEntry struct {
+	File      string   `json:"file"`
+	Name      string   `json:"name"`
+	Colors    []string `json:"colors"`
+	Pattern   string   `json:"pattern"`
+	Source    string   `json:"source,omitempty"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	PixelSize int      `json:"pixel_size"`
+	Seed      string   `json:"seed"`
+	Index     int      `json:"index"`
+	Seconds   float64  `json:"seconds"`
+	// Coverage is the share of the pattern each color covers, and Target
+	// the share it was meant to cover
+	Coverage []float64 `json:"coverage,omitempty"`
+	Target   []float64 `json:"target,omitempty"`
+	// Args are the flags that make the pattern again with -seed
+	Args []string `json:"args,omitempty"`
+}
+
+// manifestError is a job that failed.
+type manifestError struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// newManifestEntry lists the output of r with the settings of its own job,
+// whose seed may be set by its palette.
+func newManifestEntry(outputDir string, r worker.JobResult) manifestEntry {
+	out := r.Output
+	file, err := filepath.Rel(outputDir, out.FilePath)
+	if err != nil {
+		file = out.FilePath
+	}
+	colors := make([]string, len(out.Colors))
+	for i, c := range out.Colors {
+		if !utils.IsTransparent(c) {
+			c = "#" + c
+		}
+		colors[i] = c
+	}
+	e := manifestEntry{
+		File:      filepath.ToSlash(file),
+		Name:      out.Name,
+		Colors:    colors,
+		Pattern:   out.Pattern,
+		Width:     out.Width,
+		Height:    out.Height,
+		PixelSize: out.PixelSize,
+		Seed:      fmt.Sprintf("%#x", r.Config.Seed),
+		Index:     r.Index,
+		Seconds:   r.Duration.Seconds(),
+		Coverage:  out.Coverage,
+		Target:    out.Target,
+	}
+	if out.Metadata != nil {
+		e.Source = out.Metadata.Source
+		e.Args = out.Metadata.Args
+	}
+	return e
+}
+
+// writeManifest lists the run's outputs and failures in manifest.json in
+// the output directory, replacing the manifest of any earlier run.
+func writeManifest(cfg *config.Config, outputDir string, started time.Time, entries []manifestEntry, failures []worker.JobResult) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].File < entries[j].File })
+	m := manifest{
+		Seed:     fmt.Sprintf("%#x", cfg.Seed),
+		Started:  started.UTC().Truncate(time.Second),
+		Seconds:  time.Since(started).Seconds(),
+		Patterns: entries,
+	}
+	if m.Patterns == nil {
+		m.Patterns = []manifestEntry{}
+	}
+	for _, f := range failures {
+		m.Failed = append(m.Failed, manifestError{Index: f.Index, Name: f.Name, Error: f.Err.Error()})
+	}
+	sort.Slice(m.Failed, func(i, j int) bool { return m.Failed[i].Index < m.Failed[j].Index })
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(outputDir, manifestName)
+	if err := os.WriteFile(utils.LongPath(path), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// paletteEntry is a palette listed by the palettes command.
+type paletteEntry struct {
+	name, description string
+	colors            []string
+}
+
+// paletteGroup is a titled list of palettes from one source.
+type paletteGroup struct {
+	title    string
+	palettes []paletteEntry
+}
+
+// runPalettes lists the built-in palettes -p can select and the palettes of
+// a palette file, optionally only those matching a search term.
+func runPalettes(args []string) error {
+	fs := flag.NewFlagSet("palettes", flag.ExitOnError)
+	jsonFile := fs.String("j", "", "Also list the palettes of this JSON, YAML or TOML file, or of every such file in a directory")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocamo palettes [flags] [search term]\n\n")
+		fmt.Fprintf(fs.Output(), "Lists the built-in palettes and those of a palette file. A search term keeps\n")
+		fmt.Fprintf(fs.Output(), "the palettes whose name, description or colors contain it.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	// Flags may also follow the search term
+	term := strings.ToLower(strings.TrimSpace(fs.Arg(0)))
+	if fs.NArg() > 0 {
+		fs.Parse(fs.Args()[1:])
+		if fs.NArg() > 0 {
+			fs.Usage()
+			return fmt.Errorf("too many search terms")
+		}
+	}
+
+	var builtin []paletteEntry
+	for _, name := range config.NamedPaletteNames() {
+		p := config.NamedPalettes[name]
+		builtin = append(builtin, paletteEntry{name: p.Name, description: p.Description, colors: p.Colors})
+	}
+	groups := []paletteGroup{{"Built-in palettes (use with -p)", builtin}}
+
+	if *jsonFile != "" {
+		camoList, err := config.LoadPalettes(*jsonFile, false)
+		if err != nil {
+			return err
+		}
+		var user []paletteEntry
+		for _, camo := range camoList {
+			user = append(user, paletteEntry{name: camo.Name, colors: camo.Colors})
+		}
+		groups = append(groups, paletteGroup{fmt.Sprintf("Palettes in %s (use with -j)", *jsonFile), user})
+	}
+
+	swatches := colorTerminal()
+	found := 0
+	for _, g := range groups {
+		var matches []paletteEntry
+		for _, p := range g.palettes {
+			if p.matches(term) {
+				matches = append(matches, p)
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		if found > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s:\n", g.title)
+		for _, p := range matches {
+			line := fmt.Sprintf("  %-20s", p.name)
+			if swatches {
+				line += " " + paletteSwatches(p.colors)
+			}
+			if p.description != "" {
+				line += " " + p.description
+			}
+			fmt.Println(strings.TrimRight(line, " "))
+			fmt.Printf("  %-20s %s\n", "", strings.Join(p.colors, " "))
+		}
+		found += len(matches)
+	}
+
+	if found == 0 {
+		return fmt.Errorf("no palettes match %q", term)
+	}
+	return nil
+}
+
+// matches reports whether the palette's name, description or colors
+// contain the lower case term. An empty term matches every palette.
+func (p paletteEntry) matches(term string) bool {
+	if term == "" {
+		return true
+	}
+	fields := append([]string{p.name, p.description}, p.colors...)
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// paletteSwatches draws a block of each color with ANSI truecolor
+// backgrounds. Transparent and invalid colors are drawn as shaded blocks.
+func paletteSwatches(colors []string) string {
+	var sb strings.Builder
+	for _, hex := range colors {
+		c, err := utils.ParseColor(hex)
+		if err != nil || c.A == 0 {
+			sb.WriteString("░░░")
+			continue
+		}
+		fmt.Fprintf(&sb, "\x1b[48;2;%d;%d;%dm   \x1b[0m", c.R, c.G, c.B)
+	}
+	return sb.String()
+}
+
+// colorTerminal reports whether standard output is a terminal that color
+// can be written to. Setting NO_COLOR turns color off.
+func colorTerminal() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
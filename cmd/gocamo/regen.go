@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/internal/stego"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+const regenUsage = `Usage: gocamo regen <output.png> [generation flags]
+
+Renders the pattern of an earlier output again from the settings and seed
+embedded in it. Flags change settings, for example -w and -h for a new
+resolution, -o for the output directory or -cmyk for another format.
+
+Outputs without embedded metadata, such as JPEG and SVG files or those of
+older versions, are rendered from the pattern type, size, palette and seed
+in their file name. Other settings of the original run, such as -b, -noise
+or -r, have to be given again.
+`
+
+// runRegen re-renders an earlier output from its embedded metadata, or
+// from its file name if it has none.
+func runRegen(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Print(regenUsage)
+		return fmt.Errorf("no output image specified")
+	}
+	path, overrides := args[0], args[1:]
+
+	meta, err := regenMetadata(path)
+	if err != nil {
+		return err
+	}
+
+	// Later flags win, so the overrides replace the recorded settings
+	cfg := config.Parse(append(slices.Clone(meta.Args), overrides...))
+	cfg.Seed = meta.Seed
+	keepGrid(cfg, meta.Args, overrides)
+	if v := utils.Version(); meta.Version != "" && meta.Version != v {
+		slog.Warn(fmt.Sprintf("%s was generated by gocamo %s, this is %s, so the pattern may differ", path, meta.Version, v),
+			"file", path, "generated_by", meta.Version, "version", v)
+	}
+
+	if len(cfg.Payload) > stego.MaxPayload {
+		return fmt.Errorf("-payload is %d bytes, at most %d are supported", len(cfg.Payload), stego.MaxPayload)
+	}
+	if cfg.Banded {
+		if feature := wholeFrameFeature(cfg); feature != "" {
+			return fmt.Errorf("%s cannot be used with banded generation", feature)
+		}
+	}
+
+	outputPath, err := filepath.Abs(cfg.OutputDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if err := os.MkdirAll(utils.LongPath(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	ctx, cancel := generator.JobContext(context.Background(), cfg)
+	defer cancel()
+
+	var frame *generator.Frame
+	if cfg.PatternType == "image" {
+		frame, err = generator.RenderFromImage(ctx, cfg, meta.Source, meta.Index, outputPath)
+	} else {
+		camo := config.CamoColors{Name: meta.Name, Colors: strings.Split(cfg.ColorsString, ",")}
+		frame, err = generator.RenderPattern(ctx, cfg, camo, meta.Index, outputPath)
+	}
+	if err != nil {
+		return err
+	}
+	out, err := frame.Save(cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Regenerated %s at %dx%d\n", out.FilePath, cfg.Width, cfg.Height)
+	return nil
+}
+
+// regenMetadata returns the metadata embedded in an earlier output, or what
+// its file name records if it has none.
+func regenMetadata(path string) (*generator.Metadata, error) {
+	meta, err := readOutputMetadata(path)
+	if err == nil {
+		return meta, nil
+	}
+	if _, statErr := os.Stat(path); statErr != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, statErr)
+	}
+	meta, nameErr := generator.ParseFileName(filepath.Base(path))
+	if nameErr != nil {
+		return nil, fmt.Errorf("%w, and its file name can't be used instead: %w", err, nameErr)
+	}
+	slog.Warn(fmt.Sprintf("%s has no embedded metadata, so only the pattern type, size, palette and seed in its name are used; "+
+		"pass any other flags the original run used, such as -b, -noise or -r", path), "file", path)
+	return meta, nil
+}
+
+// keepGrid scales the base pixel size along with a new -w or -h, unless -b
+// is given too, so the pattern keeps its grid of cells and comes out the
+// same at the new size. It reports when the new size can't keep the grid.
+func keepGrid(cfg *config.Config, recorded, overrides []string) {
+	set := flagNames(overrides)
+	if !set["w"] && !set["h"] {
+		return
+	}
+
+	original := *cfg
+	original.Width = recordedInt(recorded, "w", cfg.Width)
+	original.Height = recordedInt(recorded, "h", cfg.Height)
+	original.BasePixelSize = recordedInt(recorded, "b", cfg.BasePixelSize)
+	if original.Width == cfg.Width && original.Height == cfg.Height {
+		return
+	}
+
+	if !set["b"] {
+		scale := min(float64(cfg.Width)/float64(original.Width), float64(cfg.Height)/float64(original.Height))
+		cfg.BasePixelSize = max(1, int(math.Round(float64(original.BasePixelSize)*scale)))
+	}
+
+	_, cols, rows := generator.GridSize(&original)
+	_, newCols, newRows := generator.GridSize(cfg)
+	if cols == newCols && rows == newRows {
+		fmt.Printf("Scaling the %dx%d cell grid to %dx%d (base pixel size %d)\n", cols, rows, cfg.Width, cfg.Height, cfg.BasePixelSize)
+	} else {
+		fmt.Printf("Note: the grid changes from %dx%d to %dx%d cells, so the pattern is laid out again rather than scaled "+
+			"(use a whole multiple of %dx%d to keep it)\n", cols, rows, newCols, newRows, original.Width, original.Height)
+	}
+}
+
+// flagNames returns the names of the flags in args.
+func flagNames(args []string) map[string]bool {
+	names := make(map[string]bool)
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		names[name] = true
+	}
+	return names
+}
+
+// recordedInt returns the value of an integer flag in recorded metadata
+// arguments, or fallback if it is missing.
+func recordedInt(args []string, name string, fallback int) int {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == "-"+name {
+			if v, err := strconv.Atoi(args[i+1]); err == nil {
+				return v
+			}
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegenMetadataFileNameFallback(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+		wantArg string
+	}{
+		{
+			name:    "gocamo file name",
+			path:    write("gocamo_007_woodland_5e8553_5c4f42_blob_w640x480_s4f2a9c1e7b3d5086.png"),
+			wantArg: "-t blob -w 640 -h 480 -c #5e8553,#5c4f42",
+		},
+		{
+			name:    "other file name",
+			path:    write("photo.png"),
+			wantErr: "and its file name can't be used instead: photo.png does not follow the gocamo file name format",
+		},
+		{
+			name:    "missing file",
+			path:    filepath.Join(dir, "gocamo_007_woodland_5e8553_5c4f42_blob_w640x480_s4f2a9c1e7b3d5086_2.png"),
+			wantErr: "failed to read",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := regenMetadata(tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("regenMetadata() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if args := strings.Join(meta.Args, " "); args != tt.wantArg || meta.Index != 7 || meta.Seed != 0x4f2a9c1e7b3d5086 {
+				t.Errorf("regenMetadata() = args %q, index %d, seed %#x; want args %q, index 7, seed 0x4f2a9c1e7b3d5086",
+					args, meta.Index, meta.Seed, tt.wantArg)
+			}
+		})
+	}
+}
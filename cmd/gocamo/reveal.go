@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/bradsec/gocamo/internal/stego"
+	"github.com/bradsec/gocamo/internal/utils"
+)
+
+// runReveal prints the payload embedded in each image with -payload.
+func runReveal(args []string) error {
+	fs := flag.NewFlagSet("reveal", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocamo reveal <image>...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no images specified")
+	}
+
+	found := 0
+	for _, file := range fs.Args() {
+		img, err := utils.LoadImage(file)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", file, err)
+		}
+		payload, err := stego.Extract(img)
+		switch {
+		case errors.Is(err, stego.ErrNoPayload):
+			fmt.Printf("%s: no payload\n", file)
+		case err != nil:
+			fmt.Printf("%s: %v\n", file, err)
+		default:
+			fmt.Printf("%s: %s\n", file, strconv.Quote(string(payload)))
+			found++
+		}
+	}
+
+	if found == 0 {
+		return fmt.Errorf("no payload found in %d image(s)", fs.NArg())
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/internal/server"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// runServe starts an HTTP server rendering patterns on demand.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "Address to listen on")
+	cores := fs.Int("cores", runtime.NumCPU(), "Most patterns rendered at once")
+	maxMem := fs.String("max-mem", "", "Memory budget for the patterns rendered at once (e.g. 2G, 512M)")
+	maxSize := fs.Int("max-size", 4096, "Largest width or height a request may ask for")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocamo serve [flags]\n\n")
+		fmt.Fprintf(fs.Output(), "Serves patterns as PNG images at GET /pattern, with the query parameters\n")
+		fmt.Fprintf(fs.Output(), "colors (required), type, w, h, b, r, noise, edge and seed.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *cores < 1 {
+		return fmt.Errorf("-cores must be at least 1")
+	}
+	if *maxSize < generator.MinDimension {
+		return fmt.Errorf("-max-size must be at least %d", generator.MinDimension)
+	}
+	opts := server.Options{Workers: *cores, MaxDimension: *maxSize}
+	if *maxMem != "" {
+		size, err := config.ParseByteSize(*maxMem)
+		if err != nil {
+			return fmt.Errorf("invalid -max-mem value: %w", err)
+		}
+		opts.MaxMemory = size
+	}
+
+	fmt.Printf("Serving patterns at http://%s/pattern using up to %d CPU cores\n", *addr, *cores)
+	return http.ListenAndServe(*addr, server.New(opts).Handler())
+}
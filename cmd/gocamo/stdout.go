@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// checkStdout checks that a -o - run makes a single image and nothing that
+// needs an output directory.
+func checkStdout(cfg *config.Config, totalJobs int) error {
+	if totalJobs != 1 {
+		return fmt.Errorf("-o - writes a single image, but this run makes %d; choose one palette and pattern type", totalJobs)
+	}
+	if cfg.Format == "svg" {
+		return fmt.Errorf("-o - needs PNG, JPEG or WebP output")
+	}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"-dry-run", cfg.DryRun},
+		{"-skip-existing", cfg.SkipExisting},
+		{"-atlas", cfg.AtlasFile != ""},
+		{"-contact-sheet", cfg.ContactSheet != ""},
+		{"-metrics", cfg.MetricsFile != ""},
+		{"-score", cfg.ScoreFile != ""},
+		{"-mipmaps", cfg.Mipmaps},
+		{"-animate", cfg.Animate > 0},
+		{"-cmyk", cfg.CMYK},
+		{"-cvd", cfg.CVD},
+		{"-debug-layers", cfg.DebugLayers},
+		{"-ora", cfg.ORA},
+	} {
+		if f.set {
+			return fmt.Errorf("%s writes files of its own and cannot be used with -o -", f.name)
+		}
+	}
+	// Writing to /dev/null, say to time a run, is fine
+	info, err := os.Stdout.Stat()
+	null, _ := os.Stat(os.DevNull)
+	if err == nil && info.Mode()&os.ModeCharDevice != 0 && !os.SameFile(info, null) {
+		return fmt.Errorf("-o - writes image data, so standard output must be redirected to a file or pipe")
+	}
+	return nil
+}
+
+// writeStdout renders the single job of a -o - run and writes the image to
+// standard output. No files or directories are created: the manifest and
+// error log are left out, and a failure is returned as the error of the run.
+// Ctrl+C quits at once, as there is no partly written file to avoid.
+func writeStdout(cfg *config.Config, outputAbsPath string, camoList []config.CamoColors, imagePaths []string) error {
+	ctx := context.Background()
+	var frame *generator.Frame
+	var err error
+	if cfg.PatternType == "image" {
+		jobCtx, cancel := generator.JobContext(ctx, cfg)
+		defer cancel()
+		frame, err = generator.RenderFromImage(jobCtx, cfg, imagePaths[0], 0, outputAbsPath)
+	} else {
+		// Checked by checkColorRatios
+		palCfg, _ := cfg.ForPalette(camoList[0])
+		camo, jobCfg := jobVariants(cfg)[0].apply(camoList[0], palCfg)
+		jobCtx, cancel := generator.JobContext(ctx, jobCfg)
+		defer cancel()
+		frame, err = generator.RenderPattern(jobCtx, jobCfg, camo, 0, outputAbsPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if err := frame.Write(cfg, w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing to standard output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"image/png"
+	"log/slog"
+	"runtime"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// fastCompressionPixels is the frame size above which noisy patterns are
+// encoded with fast compression. Noise and edge details barely compress, so
+// the default level costs a lot of time for little size benefit.
+const fastCompressionPixels = 16_000_000
+
+// autoTune picks cores, PNG compression and banded generation for the
+// machine and the size of the largest job. Settings given explicitly on the
+// command line are left alone, and every change is reported so the user
+// can override it.
+func autoTune(cfg *config.Config, camoList []config.CamoColors, totalJobs int) {
+	var changes []string
+
+	// More workers than jobs only adds memory
+	if !config.IsFlagPassed("cores") && totalJobs > 0 && cfg.Cores > totalJobs {
+		cfg.Cores = totalJobs
+		changes = append(changes, fmt.Sprintf("%d worker(s) for %d job(s)", cfg.Cores, totalJobs))
+	}
+
+	// An explicit -max-mem is handled by applyMemoryBudget instead
+	if available := utils.AvailableMemory(); available > 0 && cfg.MaxMemory == 0 {
+		budget := available / 4 * 3
+		perJob := generator.EstimateMemory(largestJob(cfg, camoList))
+
+		canBand := cfg.PatternType != "image" && wholeFrameFeature(cfg) == ""
+		if !cfg.Banded && !config.IsFlagPassed("banded") && canBand && 3*perJob > budget {
+			changes = append(changes, fmt.Sprintf("banded generation (a whole-frame worker needs about %s, %s available)",
+				formatBytes(3*perJob), formatBytes(available)))
+			cfg.Banded = true
+			perJob = generator.EstimateMemory(largestJob(cfg, camoList))
+		}
+
+		if !config.IsFlagPassed("cores") && !cfg.Adaptive {
+			tuned := *cfg
+			tuned.MaxMemory = budget
+			if workers := max(workersWithinBudget(&tuned, perJob), 1); workers < cfg.Cores {
+				cfg.Cores = workers
+				changes = append(changes, fmt.Sprintf("%d worker(s) to fit %s of available memory (%s per job)",
+					workers, formatBytes(available), formatBytes(perJob)))
+			}
+		}
+	}
+
+	largest := largestJob(cfg, camoList)
+	if !config.IsFlagPassed("png-compression") && (cfg.AddNoise || cfg.AddEdge) &&
+		largest.Width*largest.Height >= fastCompressionPixels {
+		cfg.Compression = png.BestSpeed
+		changes = append(changes, "fast PNG compression for large noisy images")
+	}
+
+	if len(changes) > 0 {
+		slog.Info(fmt.Sprintf("Auto-tuned for %d CPU(s):", runtime.NumCPU()), "cpus", runtime.NumCPU(), "changes", changes)
+		for _, c := range changes {
+			slog.Info("  - " + c)
+		}
+		slog.Info("  (set the corresponding flags or -auto=false to override)")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/upscale"
+	"github.com/bradsec/gocamo/internal/utils"
+)
+
+// maxUpscaleDimension is the largest width or height upscale writes.
+const maxUpscaleDimension = 65535
+
+// runUpscale enlarges existing patterns, keeping the cells of digital
+// patterns crisp.
+func runUpscale(args []string) error {
+	fs := flag.NewFlagSet("upscale", flag.ExitOnError)
+	scale := fs.Float64("scale", 2, "Factor to enlarge the images by")
+	width := fs.Int("w", 0, "Width to enlarge the images to, keeping their aspect ratio (instead of -scale)")
+	height := fs.Int("h", 0, "Height to enlarge the images to, keeping their aspect ratio (instead of -scale)")
+	mode := fs.String("mode", "auto", "How to enlarge: cells to repeat the pixels of each grid cell, smooth to resample, or auto to pick cells when a grid is found")
+	outputDir := fs.String("o", "", "The output directory for the upscaled images (default next to each image)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocamo upscale [flags] <image>...\n\n")
+		fmt.Fprintf(fs.Output(), "Enlarges existing patterns. Digital patterns are enlarged cell by cell so they\n")
+		fmt.Fprintf(fs.Output(), "stay sharp; patterns without a grid of cells are resampled smoothly.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no images specified")
+	}
+	sizes := 0
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "scale" || f.Name == "w" || f.Name == "h" {
+			sizes++
+		}
+	})
+	if sizes > 1 {
+		return fmt.Errorf("give only one of -scale, -w and -h")
+	}
+	if *scale < 1 || math.IsInf(*scale, 0) {
+		return fmt.Errorf("invalid -scale value: %v (must be at least 1)", *scale)
+	}
+	if *width < 0 || *height < 0 {
+		return fmt.Errorf("-w and -h cannot be negative")
+	}
+	*mode = strings.ToLower(*mode)
+	if *mode != "auto" && *mode != "cells" && *mode != "smooth" {
+		return fmt.Errorf("invalid -mode value: %s (must be 'auto', 'cells' or 'smooth')", *mode)
+	}
+
+	for _, file := range fs.Args() {
+		img, err := utils.LoadImage(file)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", file, err)
+		}
+		b := img.Bounds()
+
+		factor := *scale
+		switch {
+		case *width > 0:
+			factor = float64(*width) / float64(b.Dx())
+		case *height > 0:
+			factor = float64(*height) / float64(b.Dy())
+		}
+		if factor < 1 {
+			return fmt.Errorf("%s is already %dx%d, larger than requested", file, b.Dx(), b.Dy())
+		}
+
+		var out image.Image
+		var method string
+		cellW, cellH := upscale.CellSize(img)
+		grid := cellW > 1 && cellH > 1
+		switch {
+		case *mode == "cells" && !grid:
+			return fmt.Errorf("no grid of cells found in %s; use -mode smooth", file)
+		case *mode == "cells" || *mode == "auto" && grid:
+			// Whole cells keep the same size, so the factor is rounded to
+			// whole pixels per cell
+			newW := max(1, int(math.Round(float64(cellW)*factor)))
+			newH := max(1, int(math.Round(float64(cellH)*factor)))
+			if err := checkUpscaleSize(file, b.Dx()*newW/cellW, b.Dy()*newH/cellH); err != nil {
+				return err
+			}
+			out = upscale.Cells(img, cellW, cellH, newW, newH)
+			method = fmt.Sprintf("%dx%d pixel cells enlarged to %dx%d", cellW, cellH, newW, newH)
+		default:
+			w := max(1, int(math.Round(float64(b.Dx())*factor)))
+			h := max(1, int(math.Round(float64(b.Dy())*factor)))
+			if err := checkUpscaleSize(file, w, h); err != nil {
+				return err
+			}
+			out = upscale.Smooth(img, w, h)
+			method = "no grid of cells, resampled smoothly"
+			if grid {
+				method = "resampled smoothly"
+			}
+		}
+
+		path, err := upscalePath(file, *outputDir, out.Bounds())
+		if err != nil {
+			return err
+		}
+		if err := writePNG(path, out); err != nil {
+			return err
+		}
+		fmt.Printf("Upscaled %s to %s (%dx%d, %s)\n", file, path, out.Bounds().Dx(), out.Bounds().Dy(), method)
+	}
+	return nil
+}
+
+// checkUpscaleSize checks that an upscaled image isn't too large to write.
+func checkUpscaleSize(file string, width, height int) error {
+	if width > maxUpscaleDimension || height > maxUpscaleDimension {
+		return fmt.Errorf("%s would be %dx%d, at most %d pixels wide and high are supported", file, width, height, maxUpscaleDimension)
+	}
+	return nil
+}
+
+// upscalePath returns the path of the upscaled copy of file: its name with
+// the new size added, in dir or else next to it.
+func upscalePath(file, dir string, bounds image.Rectangle) (string, error) {
+	if dir == "" {
+		dir = filepath.Dir(file)
+	}
+	if err := os.MkdirAll(utils.LongPath(dir), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	return filepath.Join(dir, fmt.Sprintf("%s_upscaled_%dx%d.png", base, bounds.Dx(), bounds.Dy())), nil
+}
+
+// writePNG saves img as a PNG file at path.
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(utils.LongPath(path))
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		return fmt.Errorf("error saving image %s: %w", path, err)
+	}
+	return f.Close()
+}
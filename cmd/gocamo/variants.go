@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// homeScreenScale enlarges the pattern of the home screen wallpaper a
+// little over the lock screen, so the pair matches without being identical
+// and the busier home screen gets calmer, larger elements behind its icons.
+const homeScreenScale = 1.25
+
+// variant is one output made from each palette. apply returns the palette
+// and config for the variant's job; suffix is added to the palette name.
+type variant struct {
+	suffix string
+	apply  func(camo config.CamoColors, cfg *config.Config) (config.CamoColors, *config.Config)
+}
+
+// jobVariants returns the outputs to make from each palette: the palette
+// itself, a lock and home screen per device with -wallpapers, or a family
+// of accent patterns with -family, each in every pattern type with -t all.
+// Every variant of a palette shares its seed, and takes the -profile
+// settings of its pattern type.
+func jobVariants(cfg *config.Config) []variant {
+	variants := []variant{{apply: unchanged}}
+	switch {
+	case len(cfg.Wallpapers) > 0:
+		variants = wallpaperVariants(cfg)
+	case cfg.Family:
+		variants = familyVariants()
+	}
+	if cfg.PatternType == config.AllPatterns {
+		variants = patternVariants(variants)
+	}
+	if len(cfg.PatternSettings) > 0 {
+		return patternSettingVariants(variants)
+	}
+	return variants
+}
+
+// variantCount returns the number of outputs made from each palette.
+func variantCount(cfg *config.Config) int {
+	return len(jobVariants(cfg))
+}
+
+func unchanged(camo config.CamoColors, cfg *config.Config) (config.CamoColors, *config.Config) {
+	return camo, cfg
+}
+
+func wallpaperVariants(cfg *config.Config) []variant {
+	var variants []variant
+	for _, d := range cfg.Wallpapers {
+		for _, screen := range []struct {
+			name  string
+			scale float64
+		}{{"lock", 1}, {"home", homeScreenScale}} {
+			variants = append(variants, variant{
+				suffix: "_" + d.Name + "_" + screen.name,
+				apply: func(camo config.CamoColors, cfg *config.Config) (config.CamoColors, *config.Config) {
+					c := *cfg
+					c.Width, c.Height = d.Width, d.Height
+					c.BasePixelSize = max(int(math.Round(float64(cfg.BasePixelSize)*d.Scale*screen.scale)), 1)
+					return camo, &c
+				},
+			})
+		}
+	}
+	return variants
+}
+
+// familyVariants returns the main pattern and its accents for trim and
+// lining: the palette ratios inverted, a micro-scale version and a
+// reduction to the darkest and lightest colors.
+func familyVariants() []variant {
+	return []variant{
+		{suffix: "_main", apply: unchanged},
+		{suffix: "_inverted", apply: invertRatios},
+		{suffix: "_micro", apply: func(camo config.CamoColors, cfg *config.Config) (config.CamoColors, *config.Config) {
+			c := *cfg
+			c.BasePixelSize = max(cfg.BasePixelSize/2, 1)
+			return camo, &c
+		}},
+		{suffix: "_two_color", apply: twoColors},
+	}
+}
+
+// patternVariants repeats the variants in each pattern type. The type is
+// already part of the filename, so no suffix is added.
+func patternVariants(variants []variant) []variant {
+	var all []variant
+	for _, t := range generator.PatternTypes {
+		for _, v := range variants {
+			all = append(all, variant{
+				suffix: v.suffix,
+				apply: func(camo config.CamoColors, cfg *config.Config) (config.CamoColors, *config.Config) {
+					camo, vc := v.apply(camo, cfg)
+					c := *vc
+					c.PatternType = t
+					return camo, &c
+				},
+			})
+		}
+	}
+	return all
+}
+
+// patternSettingVariants applies the -profile settings of the pattern type
+// each variant ends up with, which a palette may choose for itself.
+func patternSettingVariants(variants []variant) []variant {
+	all := make([]variant, len(variants))
+	for i, v := range variants {
+		all[i] = variant{
+			suffix: v.suffix,
+			apply: func(camo config.CamoColors, cfg *config.Config) (config.CamoColors, *config.Config) {
+				camo, vc := v.apply(camo, cfg)
+				return camo, vc.ForPattern()
+			},
+		}
+	}
+	return all
+}
+
+// familyDir returns the folder that holds the family of the palette at
+// index i.
+func familyDir(outputPath string, i int, camo config.CamoColors) string {
+	return filepath.Join(outputPath, fmt.Sprintf("family_%03d_%s", i, generator.SanitizeName(camo.Name)))
+}
+
+// invertRatios reverses the palette so the weight of each position applies
+// to the opposite color. Without -r every color is equally common, so the
+// marpat scheme is used to make the last colors dominant instead.
+func invertRatios(camo config.CamoColors, cfg *config.Config) (config.CamoColors, *config.Config) {
+	camo.Colors = slices.Clone(camo.Colors)
+	slices.Reverse(camo.Colors)
+	if !cfg.ColorRatios.Macro.IsZero() {
+		return camo, cfg
+	}
+	c := *cfg
+	c.ColorRatios.Macro = config.ColorRatios{Scheme: "marpat"}
+	return camo, &c
+}
+
+// twoColors reduces the palette to its darkest and lightest colors,
+// keeping their weights in any explicit ratios.
+func twoColors(camo config.CamoColors, cfg *config.Config) (config.CamoColors, *config.Config) {
+	colors, err := utils.HexToRGBA(camo.Colors)
+	if err != nil || len(colors) <= 2 {
+		return camo, cfg
+	}
+	dark, light, ok := generator.ExtremeColors(colors)
+	if !ok || dark == light {
+		return camo, cfg
+	}
+	keep := []int{dark, light}
+	if light < dark {
+		keep = []int{light, dark}
+	}
+
+	camo.Colors = []string{camo.Colors[keep[0]], camo.Colors[keep[1]]}
+	c := *cfg
+	for _, r := range []*config.ColorRatios{&c.ColorRatios.Macro, &c.ColorRatios.Medium, &c.ColorRatios.Detail} {
+		if r.Weights != nil {
+			r.Weights = []float64{r.Weights[keep[0]], r.Weights[keep[1]]}
+		}
+	}
+	return camo, &c
+}
+
+// largestWallpaper sets the config dimensions to the largest wallpaper, so
+// memory estimates and tuning allow for the biggest job.
+func largestWallpaper(cfg *config.Config) {
+	for _, d := range cfg.Wallpapers {
+		if d.Width*d.Height > cfg.Width*cfg.Height {
+			cfg.Width, cfg.Height = d.Width, d.Height
+		}
+	}
+}
+
+// describeWallpapers lists the wallpaper devices and their sizes.
+func describeWallpapers(cfg *config.Config) string {
+	names := make([]string, len(cfg.Wallpapers))
+	for i, d := range cfg.Wallpapers {
+		names[i] = fmt.Sprintf("%s (%dx%d)", d.Name, d.Width, d.Height)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,237 @@
+package analysis
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Metrics holds the objective measurements computed for a single pattern or
+// reference image.
+type Metrics struct {
+	Width            int       `json:"width"`
+	Height           int       `json:"height"`
+	EdgeDensity      float64   `json:"edge_density"`
+	FractalDimension float64   `json:"fractal_dimension"`
+	SpectralSlope    float64   `json:"spectral_slope"`
+	SpectralCentroid float64   `json:"spectral_centroid"`
+	Spectrum         []float64 `json:"spectrum"`
+	// Contrast is the RMS contrast: the standard deviation of the Rec. 709
+	// luminance, from 0 for a flat image to 0.5 for black and white halves
+	Contrast     float64     `json:"contrast"`
+	UniqueColors int         `json:"unique_colors"`
+	ColorAreas   []ColorArea `json:"color_areas"`
+}
+
+// ColorArea is the fraction of the image covered by a single color.
+type ColorArea struct {
+	Hex   string  `json:"hex"`
+	Ratio float64 `json:"ratio"`
+}
+
+const (
+	// edgeThreshold is the minimum RGB distance between neighbouring pixels
+	// for the boundary to count as an edge.
+	edgeThreshold = 24.0
+	// maxColorAreas limits how many colors are reported individually; the
+	// remainder is folded into a single "other" entry.
+	maxColorAreas = 16
+)
+
+// Analyze computes the Metrics of img. An empty image has only its size
+// set.
+func Analyze(img image.Image) Metrics {
+	bounds := img.Bounds()
+	m := Metrics{
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+	}
+	if m.Width == 0 || m.Height == 0 {
+		return m
+	}
+
+	edges := edgeMap(img)
+	count := 0
+	for _, e := range edges {
+		if e {
+			count++
+		}
+	}
+	m.EdgeDensity = float64(count) / float64(len(edges))
+	m.FractalDimension = boxCountingDimension(edges, m.Width, m.Height)
+	m.Spectrum = radialSpectrum(img)
+	m.SpectralSlope, m.SpectralCentroid = spectrumStats(m.Spectrum)
+	m.Contrast = rmsContrast(img)
+	m.UniqueColors, m.ColorAreas = colorAreas(img)
+
+	return m
+}
+
+// rmsContrast returns the standard deviation of the luminance of every
+// pixel, in the range 0-1.
+func rmsContrast(img image.Image) float64 {
+	bounds := img.Bounds()
+	var sum, sumSq float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			l := (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 0xffff
+			sum += l
+			sumSq += l * l
+		}
+	}
+	n := float64(bounds.Dx() * bounds.Dy())
+	mean := sum / n
+	return math.Sqrt(max(sumSq/n-mean*mean, 0))
+}
+
+// edgeMap marks every pixel whose color differs noticeably from its right or
+// bottom neighbour.
+func edgeMap(img image.Image) []bool {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	edges := make([]bool, width*height)
+
+	prevRow := make([][3]float64, width)
+	row := make([][3]float64, width)
+	for y := height - 1; y >= 0; y-- {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			row[x] = [3]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
+		}
+		for x := 0; x < width; x++ {
+			if x+1 < width && rgbDistance(row[x], row[x+1]) > edgeThreshold {
+				edges[y*width+x] = true
+			}
+			if y+1 < height && rgbDistance(row[x], prevRow[x]) > edgeThreshold {
+				edges[y*width+x] = true
+			}
+		}
+		prevRow, row = row, prevRow
+	}
+
+	return edges
+}
+
+func rgbDistance(a, b [3]float64) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+// boxCountingDimension estimates the fractal dimension of the edge map by
+// counting occupied boxes at successively doubling box sizes.
+func boxCountingDimension(edges []bool, width, height int) float64 {
+	var xs, ys []float64
+	for size := 2; size <= width/2 && size <= height/2; size *= 2 {
+		cols := (width + size - 1) / size
+		rows := (height + size - 1) / size
+		occupied := make([]bool, cols*rows)
+		n := 0
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if !edges[y*width+x] {
+					continue
+				}
+				i := (y/size)*cols + x/size
+				if !occupied[i] {
+					occupied[i] = true
+					n++
+				}
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		xs = append(xs, math.Log(1/float64(size)))
+		ys = append(ys, math.Log(float64(n)))
+	}
+
+	slope, _ := linearFit(xs, ys)
+	return slope
+}
+
+// linearFit returns the least-squares slope and intercept of ys against xs.
+func linearFit(xs, ys []float64) (float64, float64) {
+	n := float64(len(xs))
+	if n < 2 {
+		return 0, 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+		sumXY += xs[i] * ys[i]
+		sumXX += xs[i] * xs[i]
+	}
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0, sumY / n
+	}
+	slope := (n*sumXY - sumX*sumY) / denom
+	return slope, (sumY - slope*sumX) / n
+}
+
+func colorAreas(img image.Image) (int, []ColorArea) {
+	bounds := img.Bounds()
+	counts := make(map[color.NRGBA]int)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				// Transparent holes count as one color
+				counts[color.NRGBA{}]++
+				continue
+			}
+			counts[c]++
+		}
+	}
+
+	type entry struct {
+		c     color.NRGBA
+		count int
+	}
+	entries := make([]entry, 0, len(counts))
+	for c, n := range counts {
+		entries = append(entries, entry{c, n})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return hex(entries[i].c) < hex(entries[j].c)
+	})
+
+	total := float64(bounds.Dx() * bounds.Dy())
+	var areas []ColorArea
+	other := 0
+	for i, e := range entries {
+		if i < maxColorAreas {
+			areas = append(areas, ColorArea{Hex: hex(e.c), Ratio: float64(e.count) / total})
+		} else {
+			other += e.count
+		}
+	}
+	if other > 0 {
+		areas = append(areas, ColorArea{Hex: "other", Ratio: float64(other) / total})
+	}
+
+	return len(counts), areas
+}
+
+func hex(c color.NRGBA) string {
+	if c.A == 0 {
+		return "none"
+	}
+	const digits = "0123456789abcdef"
+	b := []byte{
+		'#',
+		digits[c.R>>4], digits[c.R&0x0f],
+		digits[c.G>>4], digits[c.G&0x0f],
+		digits[c.B>>4], digits[c.B&0x0f],
+	}
+	if c.A != 255 {
+		b = append(b, digits[c.A>>4], digits[c.A&0x0f])
+	}
+	return string(b)
+}
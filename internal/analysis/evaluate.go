@@ -0,0 +1,251 @@
+package analysis
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Evaluation measures how well a pattern blends into a background photo.
+type Evaluation struct {
+	// HistogramDistance is the Hellinger distance between the CIELAB color
+	// histograms, from 0 for the same colors in the same shares to 1 for
+	// no colors in common
+	HistogramDistance float64 `json:"histogram_distance"`
+	// MeanColorDifference is the CIE76 ΔE between the average colors
+	MeanColorDifference float64 `json:"mean_color_difference"`
+	// PatternSlope and BackgroundSlope are the log-log spectral slopes
+	PatternSlope    float64 `json:"pattern_slope"`
+	BackgroundSlope float64 `json:"background_slope"`
+	// SpectrumCorrelation is the correlation of the log spectra, from -1
+	// to 1
+	SpectrumCorrelation float64 `json:"spectrum_correlation"`
+	// PatternEdgeDensity and BackgroundEdgeDensity are the share of
+	// pixels on a color boundary
+	PatternEdgeDensity    float64 `json:"pattern_edge_density"`
+	BackgroundEdgeDensity float64 `json:"background_edge_density"`
+	// Saliency is how much a patch of the pattern in the middle of the
+	// background stands out from the background around it: 1 when it is
+	// as conspicuous as its surround, more when it draws the eye
+	Saliency float64 `json:"saliency"`
+	// Scores from 0 to 100 for the colors, texture and saliency, and their
+	// mean
+	ColorScore    float64 `json:"color_score"`
+	TextureScore  float64 `json:"texture_score"`
+	SaliencyScore float64 `json:"saliency_score"`
+	Score         float64 `json:"score"`
+}
+
+const (
+	// histogramSamples is about the number of pixels sampled from each
+	// image for its color histogram.
+	histogramSamples = 1 << 16
+	// Histogram bins: L* in steps of 10 and a* and b* in steps of 12.5
+	// over -100 to 100.
+	lBins  = 10
+	abBins = 16
+	// saliencySize is the longest side of the scene the saliency is
+	// estimated on.
+	saliencySize = 256
+)
+
+// Evaluate compares pattern with background.
+func Evaluate(pattern, background image.Image) Evaluation {
+	var e Evaluation
+
+	ph, pmean := labHistogram(pattern)
+	bh, bmean := labHistogram(background)
+	var bc float64
+	for i := range ph {
+		bc += math.Sqrt(ph[i] * bh[i])
+	}
+	e.HistogramDistance = math.Sqrt(max(0, 1-bc))
+	e.MeanColorDifference = labDistance(pmean, bmean)
+
+	pm, bm := Analyze(pattern), Analyze(background)
+	e.PatternSlope, e.BackgroundSlope = pm.SpectralSlope, bm.SpectralSlope
+	e.SpectrumCorrelation = logCorrelation(pm.Spectrum, bm.Spectrum)
+	e.PatternEdgeDensity, e.BackgroundEdgeDensity = pm.EdgeDensity, bm.EdgeDensity
+
+	e.Saliency = patchSaliency(pattern, background)
+
+	e.ColorScore = 100 * (1 - e.HistogramDistance)
+	e.TextureScore = 100 * max(0, 1-math.Abs(e.PatternSlope-e.BackgroundSlope)/2)
+	e.SaliencyScore = 100 * min(1, 1/e.Saliency)
+	e.Score = (e.ColorScore + e.TextureScore + e.SaliencyScore) / 3
+	return e
+}
+
+// labHistogram returns the normalized CIELAB histogram of the visible
+// pixels of img, sampled on a grid, and their mean color.
+func labHistogram(img image.Image) ([]float64, [3]float64) {
+	b := img.Bounds()
+	step := max(1, int(math.Sqrt(float64(b.Dx()*b.Dy())/histogramSamples)))
+	hist := make([]float64, lBins*abBins*abBins)
+	var mean [3]float64
+	n := 0
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			lab := toLab(c)
+			l := min(int(lab[0]/100*lBins), lBins-1)
+			a := min(max(int((lab[1]+100)/200*abBins), 0), abBins-1)
+			bb := min(max(int((lab[2]+100)/200*abBins), 0), abBins-1)
+			hist[(max(l, 0)*abBins+a)*abBins+bb]++
+			for i := range mean {
+				mean[i] += lab[i]
+			}
+			n++
+		}
+	}
+	if n == 0 {
+		return hist, mean
+	}
+	for i := range hist {
+		hist[i] /= float64(n)
+	}
+	for i := range mean {
+		mean[i] /= float64(n)
+	}
+	return hist, mean
+}
+
+// logCorrelation returns the Pearson correlation of the logarithms of two
+// spectra.
+func logCorrelation(a, b []float64) float64 {
+	var xs, ys []float64
+	for i := range a {
+		if i < len(b) && a[i] > 0 && b[i] > 0 {
+			xs = append(xs, math.Log(a[i]))
+			ys = append(ys, math.Log(b[i]))
+		}
+	}
+	if len(xs) < 2 {
+		return 0
+	}
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= float64(len(xs))
+	my /= float64(len(ys))
+	var sxy, sxx, syy float64
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return 0
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// patchSaliency estimates how conspicuous the pattern is in front of the
+// background. The pattern covers the middle half of the background, the
+// frequency-tuned saliency of Achanta et al. (2009) is computed for every
+// pixel as the ΔE between its blurred color and the mean color of the
+// scene, and the mean saliency of the patch is divided by that of the
+// surround. Both are offset by 1 ΔE so flat backgrounds don't divide by
+// zero.
+func patchSaliency(pattern, background image.Image) float64 {
+	bb := background.Bounds()
+	scale := float64(saliencySize) / float64(max(bb.Dx(), bb.Dy()))
+	w, h := max(4, int(float64(bb.Dx())*scale)), max(4, int(float64(bb.Dy())*scale))
+	scene := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(scene, scene.Bounds(), background, bb, draw.Src, nil)
+
+	// The middle of the pattern, cropped to the shape of the patch
+	patch := image.Rect(w/4, h/4, w/4+w/2, h/4+h/2)
+	pb := pattern.Bounds()
+	sw, sh := pb.Dx(), pb.Dx()*patch.Dy()/patch.Dx()
+	if sh > pb.Dy() {
+		sw, sh = pb.Dy()*patch.Dx()/patch.Dy(), pb.Dy()
+	}
+	src := image.Rect(0, 0, max(sw, 1), max(sh, 1)).Add(pb.Min).Add(image.Pt((pb.Dx()-sw)/2, (pb.Dy()-sh)/2))
+	draw.ApproxBiLinear.Scale(scene, patch, pattern, src, draw.Over, nil)
+
+	labs := make([][3]float64, w*h)
+	var mean [3]float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			lab := toLab(scene.NRGBAAt(x, y))
+			labs[y*w+x] = lab
+			for i := range mean {
+				mean[i] += lab[i]
+			}
+		}
+	}
+	for i := range mean {
+		mean[i] /= float64(w * h)
+	}
+
+	// A 5x5 box blur stands in for the Gaussian of the original method
+	var inside, outside float64
+	var nIn, nOut int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var blur [3]float64
+			n := 0
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					sx, sy := x+dx, y+dy
+					if sx < 0 || sy < 0 || sx >= w || sy >= h {
+						continue
+					}
+					for i := range blur {
+						blur[i] += labs[sy*w+sx][i]
+					}
+					n++
+				}
+			}
+			for i := range blur {
+				blur[i] /= float64(n)
+			}
+			s := labDistance(blur, mean)
+			if image.Pt(x, y).In(patch) {
+				inside += s
+				nIn++
+			} else {
+				outside += s
+				nOut++
+			}
+		}
+	}
+	return (inside/float64(nIn) + 1) / (outside/float64(nOut) + 1)
+}
+
+func labDistance(a, b [3]float64) float64 {
+	dl, da, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return math.Sqrt(dl*dl + da*da + db*db)
+}
+
+// toLab converts an sRGB color to CIELAB with the D65 white point.
+func toLab(c color.NRGBA) [3]float64 {
+	lin := func(v uint8) float64 {
+		s := float64(v) / 255
+		if s <= 0.04045 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	f := func(t float64) float64 {
+		const delta = 6.0 / 29
+		if t > delta*delta*delta {
+			return math.Cbrt(t)
+		}
+		return t/(3*delta*delta) + 4.0/29
+	}
+	r, g, b := lin(c.R), lin(c.G), lin(c.B)
+	fx := f((0.4124564*r + 0.3575761*g + 0.1804375*b) / 0.95047)
+	fy := f(0.2126729*r + 0.7151522*g + 0.0721750*b)
+	fz := f((0.0193339*r + 0.1191920*g + 0.9503041*b) / 1.08883)
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
@@ -0,0 +1,110 @@
+package analysis
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Record pairs the metrics of a generated pattern with the parameters that
+// produced it.
+type Record struct {
+	File          string   `json:"file"`
+	Name          string   `json:"name"`
+	PatternType   string   `json:"pattern_type"`
+	Colors        []string `json:"colors"`
+	BasePixelSize int      `json:"base_pixel_size"`
+	KValue        int      `json:"k,omitempty"`
+	AddEdge       bool     `json:"edge"`
+	AddNoise      bool     `json:"noise"`
+	Metrics
+}
+
+var csvHeader = []string{
+	"file", "name", "pattern_type", "colors", "width", "height", "base_pixel_size", "k",
+	"edge", "noise", "edge_density", "fractal_dimension", "spectral_slope",
+	"spectral_centroid", "unique_colors", "color_areas", "spectrum", "contrast",
+}
+
+// WriteRecords writes the records to path as JSON if the file has a .json
+// extension, otherwise as CSV.
+func WriteRecords(path string, records []Record) error {
+	return writeReport(path, "metrics", records, func(w io.Writer) error { return writeCSV(w, records) })
+}
+
+// writeReport writes v to path as JSON if the file has a .json extension,
+// otherwise as CSV with writeCSV. what names the report in errors.
+func writeReport(path, what string, v any, writeCSV func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s file: %w", what, err)
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = writeJSON(f, v)
+	} else {
+		err = writeCSV(f)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", what, err)
+	}
+
+	return f.Close()
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		areas := make([]string, len(r.ColorAreas))
+		for i, a := range r.ColorAreas {
+			areas[i] = fmt.Sprintf("%s:%.4f", a.Hex, a.Ratio)
+		}
+		spectrum := make([]string, len(r.Spectrum))
+		for i, v := range r.Spectrum {
+			spectrum[i] = strconv.FormatFloat(v, 'f', 6, 64)
+		}
+
+		row := []string{
+			r.File,
+			r.Name,
+			r.PatternType,
+			strings.Join(r.Colors, " "),
+			strconv.Itoa(r.Width),
+			strconv.Itoa(r.Height),
+			strconv.Itoa(r.BasePixelSize),
+			strconv.Itoa(r.KValue),
+			strconv.FormatBool(r.AddEdge),
+			strconv.FormatBool(r.AddNoise),
+			strconv.FormatFloat(r.EdgeDensity, 'f', 6, 64),
+			strconv.FormatFloat(r.FractalDimension, 'f', 6, 64),
+			strconv.FormatFloat(r.SpectralSlope, 'f', 6, 64),
+			strconv.FormatFloat(r.SpectralCentroid, 'f', 6, 64),
+			strconv.Itoa(r.UniqueColors),
+			strings.Join(areas, " "),
+			strings.Join(spectrum, " "),
+			strconv.FormatFloat(r.Contrast, 'f', 6, 64),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,123 @@
+package analysis
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// naturalSlope is the log-log slope of the power spectrum of natural
+// scenes, whose power falls off as 1/f².
+const naturalSlope = -2.0
+
+// Score rates a pattern against objective criteria, each from 0 to 100.
+type Score struct {
+	// Overall is the mean of the other scores
+	Overall float64 `json:"score"`
+	// RatioScore is how closely the color coverage follows the requested
+	// color ratios, or nil for image patterns, which have none
+	RatioScore *float64 `json:"ratio_score,omitempty"`
+	// SpectrumScore is how closely the spectral slope follows that of
+	// natural scenes, so the pattern has detail at every scale like its
+	// background
+	SpectrumScore float64 `json:"spectrum_score"`
+	// RatioError is the share of the pattern covered by different colors
+	// than the ratios ask for: half the summed differences between the
+	// coverage and target shares
+	RatioError *float64 `json:"ratio_error,omitempty"`
+}
+
+// Rate scores a pattern from its metrics and, if target isn't nil, the
+// share of the pattern each palette color covers against the share it was
+// meant to cover.
+func Rate(m Metrics, coverage, target []float64) Score {
+	s := Score{SpectrumScore: 100 * max(0, 1-math.Abs(m.SpectralSlope-naturalSlope)/2)}
+	s.Overall = s.SpectrumScore
+	if target != nil && len(coverage) == len(target) {
+		var diff float64
+		for i := range target {
+			diff += math.Abs(coverage[i] - target[i])
+		}
+		ratioError := diff / 2
+		ratio := 100 * (1 - ratioError)
+		s.RatioError, s.RatioScore = &ratioError, &ratio
+		s.Overall = (s.SpectrumScore + ratio) / 2
+	}
+	return s
+}
+
+// ScoreRecord is the score of a generated pattern with the metrics and
+// color coverage it was rated on.
+type ScoreRecord struct {
+	File        string   `json:"file"`
+	Name        string   `json:"name"`
+	PatternType string   `json:"pattern_type"`
+	Colors      []string `json:"colors"`
+	// Coverage and Target are the share of the pattern each color covers
+	// and was meant to cover, in Colors order
+	Coverage []float64 `json:"coverage"`
+	Target   []float64 `json:"target,omitempty"`
+	Score
+	Metrics
+}
+
+var scoreHeader = []string{
+	"file", "name", "pattern_type", "colors", "score", "ratio_score", "spectrum_score",
+	"ratio_error", "spectral_slope", "spectral_centroid", "edge_density", "fractal_dimension",
+	"contrast", "coverage",
+}
+
+// WriteScores writes the score report to path as JSON if the file has a
+// .json extension, otherwise as CSV.
+func WriteScores(path string, records []ScoreRecord) error {
+	return writeReport(path, "score report", records, func(w io.Writer) error { return writeScoreCSV(w, records) })
+}
+
+func writeScoreCSV(w io.Writer, records []ScoreRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(scoreHeader); err != nil {
+		return err
+	}
+
+	optional := func(v *float64, prec int) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', prec, 64)
+	}
+	for _, r := range records {
+		coverage := make([]string, len(r.Coverage))
+		for i, c := range r.Coverage {
+			coverage[i] = fmt.Sprintf("#%s:%.4f", strings.TrimPrefix(r.Colors[i], "#"), c)
+			if r.Target != nil {
+				coverage[i] += fmt.Sprintf("/%.4f", r.Target[i])
+			}
+		}
+
+		row := []string{
+			r.File,
+			r.Name,
+			r.PatternType,
+			strings.Join(r.Colors, " "),
+			strconv.FormatFloat(r.Overall, 'f', 1, 64),
+			optional(r.RatioScore, 1),
+			strconv.FormatFloat(r.SpectrumScore, 'f', 1, 64),
+			optional(r.RatioError, 6),
+			strconv.FormatFloat(r.SpectralSlope, 'f', 6, 64),
+			strconv.FormatFloat(r.SpectralCentroid, 'f', 6, 64),
+			strconv.FormatFloat(r.EdgeDensity, 'f', 6, 64),
+			strconv.FormatFloat(r.FractalDimension, 'f', 6, 64),
+			strconv.FormatFloat(r.Contrast, 'f', 6, 64),
+			strings.Join(coverage, " "),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
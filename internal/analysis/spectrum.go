@@ -0,0 +1,180 @@
+package analysis
+
+import (
+	"image"
+	"math"
+	"math/cmplx"
+)
+
+const (
+	// spectrumSize is the side length of the square luminance sample the
+	// frequency spectrum is computed from. Must be a power of two.
+	spectrumSize = 256
+	// spectrumBins is the number of radial frequency bins reported.
+	spectrumBins = 32
+)
+
+// radialSpectrum returns the radially averaged power spectrum of the image
+// luminance, averaged within each ring and normalised so the bins sum to 1.
+// Bin 0 is the lowest non-DC frequency and the last bin is the Nyquist
+// frequency.
+func radialSpectrum(img image.Image) []float64 {
+	lum := sampleLuminance(img, spectrumSize)
+
+	// Remove the mean so the DC component does not swamp the spectrum
+	var mean float64
+	for _, v := range lum {
+		mean += v
+	}
+	mean /= float64(len(lum))
+
+	data := make([]complex128, len(lum))
+	for i, v := range lum {
+		data[i] = complex(v-mean, 0)
+	}
+	fft2D(data, spectrumSize)
+
+	bins := make([]float64, spectrumBins)
+	counts := make([]int, spectrumBins)
+	maxRadius := float64(spectrumSize / 2)
+	for v := 0; v < spectrumSize; v++ {
+		fy := v
+		if fy > spectrumSize/2 {
+			fy -= spectrumSize
+		}
+		for u := 0; u < spectrumSize; u++ {
+			fx := u
+			if fx > spectrumSize/2 {
+				fx -= spectrumSize
+			}
+			radius := math.Hypot(float64(fx), float64(fy))
+			if radius == 0 || radius > maxRadius {
+				continue
+			}
+			bin := int(radius / maxRadius * spectrumBins)
+			if bin >= spectrumBins {
+				bin = spectrumBins - 1
+			}
+			p := cmplx.Abs(data[v*spectrumSize+u])
+			bins[bin] += p * p
+			counts[bin]++
+		}
+	}
+
+	for i := range bins {
+		if counts[i] > 0 {
+			bins[i] /= float64(counts[i])
+		}
+	}
+
+	var total float64
+	for _, b := range bins {
+		total += b
+	}
+	if total > 0 {
+		for i := range bins {
+			bins[i] /= total
+		}
+	}
+	return bins
+}
+
+// spectrumStats returns the log-log slope of the spectrum (natural images sit
+// around -2) and its centroid as a fraction of the Nyquist frequency.
+func spectrumStats(bins []float64) (float64, float64) {
+	var xs, ys []float64
+	var centroid float64
+	for i, b := range bins {
+		f := (float64(i) + 0.5) / float64(len(bins))
+		centroid += f * b
+		if b > 0 {
+			xs = append(xs, math.Log(f))
+			ys = append(ys, math.Log(b))
+		}
+	}
+	slope, _ := linearFit(xs, ys)
+	return slope, centroid
+}
+
+// sampleLuminance box-samples the image into a size×size grid of Rec. 709
+// luminance values in the range 0-1.
+func sampleLuminance(img image.Image, size int) []float64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	sums := make([]float64, size*size)
+	counts := make([]int, size*size)
+
+	for y := 0; y < height; y++ {
+		sy := y * size / height
+		for x := 0; x < width; x++ {
+			sx := x * size / width
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			l := (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 0xffff
+			sums[sy*size+sx] += l
+			counts[sy*size+sx]++
+		}
+	}
+
+	// Images smaller than the sample grid leave gaps; fill them from the
+	// nearest source pixel instead.
+	for sy := 0; sy < size; sy++ {
+		for sx := 0; sx < size; sx++ {
+			i := sy*size + sx
+			if counts[i] > 0 {
+				sums[i] /= float64(counts[i])
+				continue
+			}
+			r, g, b, _ := img.At(bounds.Min.X+sx*width/size, bounds.Min.Y+sy*height/size).RGBA()
+			sums[i] = (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 0xffff
+		}
+	}
+
+	return sums
+}
+
+// fft2D performs an in-place 2D FFT on a size×size row-major matrix.
+func fft2D(data []complex128, size int) {
+	for y := 0; y < size; y++ {
+		fft(data[y*size : (y+1)*size])
+	}
+	col := make([]complex128, size)
+	for x := 0; x < size; x++ {
+		for y := 0; y < size; y++ {
+			col[y] = data[y*size+x]
+		}
+		fft(col)
+		for y := 0; y < size; y++ {
+			data[y*size+x] = col[y]
+		}
+	}
+}
+
+// fft is an iterative radix-2 Cooley-Tukey transform. len(a) must be a power
+// of two.
+func fft(a []complex128) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for length := 2; length <= n; length <<= 1 {
+		angle := -2 * math.Pi / float64(length)
+		wl := complex(math.Cos(angle), math.Sin(angle))
+		for i := 0; i < n; i += length {
+			w := complex(1, 0)
+			for k := 0; k < length/2; k++ {
+				u := a[i+k]
+				v := a[i+k+length/2] * w
+				a[i+k] = u + v
+				a[i+k+length/2] = u - v
+				w *= wl
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Package apng writes animated PNG files, which browsers and most image
+// viewers play like GIFs but with full color and alpha. Viewers without
+// APNG support show the first frame.
+package apng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+
+	"github.com/bradsec/gocamo/internal/utils"
+)
+
+// signature starts every PNG file.
+const signature = "\x89PNG\r\n\x1a\n"
+
+// Frame is a compressed frame, ready to be written any number of times.
+type Frame struct {
+	ihdr []byte
+	data []byte
+}
+
+// EncodeFrame compresses img as 8-bit RGB, or RGBA if opaque is false. All
+// frames of an animation must share the size and opaque setting.
+func EncodeFrame(img *image.NRGBA, opaque bool, level png.CompressionLevel) (*Frame, error) {
+	var buf bytes.Buffer
+	b := img.Bounds()
+	pw, err := utils.NewPNGStreamWriter(&buf, b.Dx(), b.Dy(), opaque, level)
+	if err != nil {
+		return nil, err
+	}
+	if err := pw.WriteRows(img); err != nil {
+		return nil, err
+	}
+	if err := pw.Close(); err != nil {
+		return nil, err
+	}
+
+	// Keep the header and join the image data of the encoded PNG
+	f := &Frame{}
+	p := buf.Bytes()[len(signature):]
+	for len(p) >= 12 {
+		n := int(binary.BigEndian.Uint32(p[:4]))
+		typ, data := string(p[4:8]), p[8:8+n]
+		switch typ {
+		case "IHDR":
+			f.ihdr = data
+		case "IDAT":
+			f.data = append(f.data, data...)
+		}
+		p = p[12+n:]
+	}
+	return f, nil
+}
+
+// Encode writes an animation that shows frames in order, each for delay
+// seconds given as a fraction num/den, and loops forever. A frame may appear
+// more than once.
+func Encode(w io.Writer, frames []*Frame, num, den uint16) error {
+	if len(frames) == 0 {
+		return errors.New("no frames to encode")
+	}
+	ihdr := frames[0].ihdr
+	for _, f := range frames[1:] {
+		if !bytes.Equal(f.ihdr, ihdr) {
+			return errors.New("frames differ in size or color type")
+		}
+	}
+	width, height := binary.BigEndian.Uint32(ihdr[0:4]), binary.BigEndian.Uint32(ihdr[4:8])
+
+	if _, err := io.WriteString(w, signature); err != nil {
+		return err
+	}
+	if err := writeChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:4], uint32(len(frames)))
+	// actl[4:8] is the number of plays, 0 for forever
+	if err := writeChunk(w, "acTL", actl); err != nil {
+		return err
+	}
+
+	// fcTL and fdAT chunks share one sequence
+	var seq uint32
+	for i, f := range frames {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:4], seq)
+		binary.BigEndian.PutUint32(fctl[4:8], width)
+		binary.BigEndian.PutUint32(fctl[8:12], height)
+		// The frame covers the canvas, at offset 0,0
+		binary.BigEndian.PutUint16(fctl[20:22], num)
+		binary.BigEndian.PutUint16(fctl[22:24], den)
+		// fctl[24] disposes of nothing and fctl[25] replaces the canvas
+		// rather than blending, so transparent pixels stay transparent
+		seq++
+		if err := writeChunk(w, "fcTL", fctl); err != nil {
+			return err
+		}
+
+		if i == 0 {
+			// The first frame is the image data, the still image
+			if err := writeChunk(w, "IDAT", f.data); err != nil {
+				return err
+			}
+			continue
+		}
+		fdat := make([]byte, 4, 4+len(f.data))
+		binary.BigEndian.PutUint32(fdat, seq)
+		seq++
+		if err := writeChunk(w, "fdAT", append(fdat, f.data...)); err != nil {
+			return fmt.Errorf("error writing frame %d: %w", i, err)
+		}
+	}
+	return writeChunk(w, "IEND", nil)
+}
+
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	buf := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(data)))
+	copy(buf[4:8], typ)
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	_, err := w.Write(buf)
+	return err
+}
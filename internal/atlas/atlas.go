@@ -0,0 +1,172 @@
+// Package atlas packs generated patterns into a single texture with a sprite
+// map of where each pattern is, for engines and web pages that want one
+// texture upload.
+package atlas
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/utils"
+)
+
+// Sprite is a pattern to pack, or a packed pattern's place in the atlas.
+type Sprite struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"w"`
+	Height int    `json:"h"`
+}
+
+// Map is the sprite map written next to the atlas image.
+type Map struct {
+	Image   string   `json:"image"`
+	Width   int      `json:"width"`
+	Height  int      `json:"height"`
+	Sprites []Sprite `json:"sprites"`
+}
+
+// Pack lays out the pattern files in rows, draws them into one image saved
+// at path, and writes the sprite map as JSON and CSS alongside it. Sprites
+// only need Name and File set; their sizes are read from the files.
+func Pack(path string, sprites []Sprite, padding int) (*Map, error) {
+	if len(sprites) == 0 {
+		return nil, fmt.Errorf("no patterns to pack")
+	}
+
+	for i := range sprites {
+		w, h, err := imageSize(sprites[i].File)
+		if err != nil {
+			return nil, err
+		}
+		sprites[i].Width, sprites[i].Height = w, h
+	}
+
+	m := &Map{Image: filepath.Base(path), Sprites: layout(sprites, padding)}
+	for _, s := range m.Sprites {
+		m.Width = max(m.Width, s.X+s.Width)
+		m.Height = max(m.Height, s.Y+s.Height)
+	}
+
+	atlas := image.NewNRGBA(image.Rect(0, 0, m.Width, m.Height))
+	for i := range m.Sprites {
+		s := &m.Sprites[i]
+		img, err := utils.LoadImage(s.File)
+		if err != nil {
+			return nil, err
+		}
+		draw.Draw(atlas, image.Rect(s.X, s.Y, s.X+s.Width, s.Y+s.Height), img, img.Bounds().Min, draw.Src)
+
+		// Record files relative to the atlas
+		if rel, err := filepath.Rel(filepath.Dir(path), s.File); err == nil {
+			s.File = filepath.ToSlash(rel)
+		}
+	}
+
+	if err := writeImage(path, atlas); err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	if err := writeJSON(base+".json", m); err != nil {
+		return nil, err
+	}
+	if err := writeCSS(base+".css", m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// layout places sprites tallest first on shelves, aiming for a roughly
+// square atlas.
+func layout(sprites []Sprite, padding int) []Sprite {
+	placed := make([]Sprite, len(sprites))
+	copy(placed, sprites)
+	sort.SliceStable(placed, func(i, j int) bool { return placed[i].Height > placed[j].Height })
+
+	area := 0
+	widest := 0
+	for _, s := range placed {
+		area += (s.Width + padding) * (s.Height + padding)
+		widest = max(widest, s.Width)
+	}
+	maxWidth := max(widest, int(math.Ceil(math.Sqrt(float64(area)))))
+
+	x, y, shelf := 0, 0, 0
+	for i := range placed {
+		s := &placed[i]
+		if x > 0 && x+s.Width > maxWidth {
+			x, y = 0, y+shelf+padding
+			shelf = 0
+		}
+		s.X, s.Y = x, y
+		x += s.Width + padding
+		shelf = max(shelf, s.Height)
+	}
+	return placed
+}
+
+// cssClass derives a class name from a pattern's file name, which is unique
+// within a run.
+func cssClass(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	class := []byte(strings.ToLower(name))
+	for i, c := range class {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			class[i] = '-'
+		}
+	}
+	return string(class)
+}
+
+func imageSize(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error opening file: %w", err)
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+func writeImage(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating atlas: %w", err)
+	}
+	defer f.Close()
+	if err := utils.SaveImage(img, f, utils.EncodeOptions{Compression: png.DefaultCompression}); err != nil {
+		return fmt.Errorf("error saving atlas: %w", err)
+	}
+	return f.Close()
+}
+
+func writeJSON(path string, m *Map) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func writeCSS(path string, m *Map) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, ".gocamo {\n  background-image: url(%q);\n  background-repeat: no-repeat;\n}\n", m.Image)
+	for _, s := range m.Sprites {
+		fmt.Fprintf(&b, "\n/* %s */\n.%s {\n  width: %dpx;\n  height: %dpx;\n  background-position: %dpx %dpx;\n}\n",
+			strings.ReplaceAll(s.Name, "*/", "* /"), cssClass(s.File), s.Width, s.Height, -s.X, -s.Y)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
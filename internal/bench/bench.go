@@ -0,0 +1,279 @@
+package bench
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// Seed is the fixed seed every benchmark case is generated with, so that
+// output hashes are comparable across runs and versions.
+const Seed = 0x6a09e667f3bcc908
+
+// Size is a named output resolution.
+type Size struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+var Sizes = []Size{
+	{"1080p", 1920, 1080},
+	{"4k", 3840, 2160},
+}
+
+var Patterns = []string{"box", "blob", "pat6", "hex", "image"}
+
+var palette = []color.NRGBA{
+	{0x46, 0x48, 0x2f, 255},
+	{0x6d, 0x68, 0x51, 255},
+	{0x9b, 0x96, 0x7f, 255},
+	{0x1e, 0x24, 0x15, 255},
+}
+
+// Result is the measurement of a single benchmark case.
+type Result struct {
+	Name        string  `json:"name"`
+	Pattern     string  `json:"pattern"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	Iterations  int     `json:"iterations"`
+	NsPerOp     int64   `json:"ns_per_op"`
+	MsPerOp     float64 `json:"ms_per_op"`
+	BytesPerOp  uint64  `json:"bytes_per_op"`
+	AllocsPerOp uint64  `json:"allocs_per_op"`
+	OutputHash  string  `json:"output_hash"`
+}
+
+// Baseline is the JSON document written by a benchmark run.
+type Baseline struct {
+	GoVersion string    `json:"go_version"`
+	GOOS      string    `json:"goos"`
+	GOARCH    string    `json:"goarch"`
+	CPUs      int       `json:"cpus"`
+	Time      time.Time `json:"time"`
+	Results   []Result  `json:"results"`
+}
+
+// Run benchmarks every pattern at every size, running each case the given
+// number of times and keeping the fastest. progress, if not nil, receives
+// each result as it completes.
+func Run(ctx context.Context, patterns []string, sizes []Size, iterations int, progress func(Result)) (*Baseline, error) {
+	refDir, err := os.MkdirTemp("", "gocamo-bench")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(refDir)
+	refPath := filepath.Join(refDir, "reference.png")
+	if err := writeReference(refPath); err != nil {
+		return nil, err
+	}
+
+	b := &Baseline{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		Time:      time.Now().UTC(),
+	}
+
+	for _, pattern := range patterns {
+		for _, size := range sizes {
+			r, err := runCase(ctx, pattern, size, iterations, refPath)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: %w", pattern, size.Name, err)
+			}
+			if progress != nil {
+				progress(r)
+			}
+			b.Results = append(b.Results, r)
+		}
+	}
+
+	return b, nil
+}
+
+// caseConfig returns the settings a pattern is benchmarked with at size.
+func caseConfig(pattern string, size Size) *config.Config {
+	return &config.Config{
+		Width:         size.Width,
+		Height:        size.Height,
+		BasePixelSize: 4,
+		PatternType:   pattern,
+		KValue:        4,
+		KMeansBatch:   1024,
+		Scaler:        "bilinear",
+		Seed:          Seed,
+	}
+}
+
+func runCase(ctx context.Context, pattern string, size Size, iterations int, refPath string) (Result, error) {
+	cfg := caseConfig(pattern, size)
+
+	r := Result{
+		Name:       pattern + "/" + size.Name,
+		Pattern:    pattern,
+		Width:      size.Width,
+		Height:     size.Height,
+		Iterations: iterations,
+	}
+
+	var best time.Duration
+	var totalBytes, totalAllocs uint64
+	for i := 0; i < iterations; i++ {
+		runtime.GC()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+
+		img, err := generate(ctx, cfg, refPath)
+		if err != nil {
+			return r, err
+		}
+
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+		totalBytes += after.TotalAlloc - before.TotalAlloc
+		totalAllocs += after.Mallocs - before.Mallocs
+		if i == 0 || elapsed < best {
+			best = elapsed
+		}
+		if i == 0 {
+			r.OutputHash = hashImage(img)
+		}
+	}
+
+	r.NsPerOp = best.Nanoseconds()
+	r.MsPerOp = float64(best.Microseconds()) / 1000
+	r.BytesPerOp = totalBytes / uint64(iterations)
+	r.AllocsPerOp = totalAllocs / uint64(iterations)
+	return r, nil
+}
+
+func generate(ctx context.Context, cfg *config.Config, refPath string) (image.Image, error) {
+	rng := rand.New(rand.NewPCG(cfg.Seed, 0))
+	switch cfg.PatternType {
+	case "box":
+		return (&generator.BoxGenerator{}).Generate(ctx, cfg, rng, palette)
+	case "blob":
+		return (&generator.BlobGenerator{}).Generate(ctx, cfg, rng, palette)
+	case "pat6":
+		return (&generator.Pat6Generator{}).Generate(ctx, cfg, rng, palette)
+	case "hex":
+		return (&generator.HexGenerator{}).Generate(ctx, cfg, rng, palette)
+	case "image":
+		img, _, err := (&generator.ImageGenerator{InputFile: refPath}).Generate(ctx, cfg, rng, nil)
+		return img, err
+	}
+	return nil, fmt.Errorf("unknown pattern type: %s", cfg.PatternType)
+}
+
+// writeReference writes a deterministic synthetic photo for the image
+// generator so the benchmark doesn't depend on files in the input directory.
+func writeReference(path string) error {
+	const width, height = 1600, 1200
+	rng := rand.New(rand.NewPCG(Seed, 1))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := img.PixOffset(x, y)
+			img.Pix[i] = uint8(x * 255 / width)
+			img.Pix[i+1] = uint8(96 + rng.IntN(64))
+			img.Pix[i+2] = uint8(y * 255 / height)
+			img.Pix[i+3] = 255
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating reference image: %w", err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		return fmt.Errorf("error writing reference image: %w", err)
+	}
+	return f.Close()
+}
+
+func hashImage(img image.Image) string {
+	h := sha256.New()
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		b := nrgba.Bounds()
+		for y := 0; y < b.Dy(); y++ {
+			h.Write(nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+b.Dx()*4])
+		}
+	} else {
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				h.Write([]byte{c.R, c.G, c.B, c.A})
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func (b *Baseline) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+func ReadBaseline(path string) (*Baseline, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening baseline: %w", err)
+	}
+	defer f.Close()
+
+	var b Baseline
+	if err := json.NewDecoder(f).Decode(&b); err != nil {
+		return nil, fmt.Errorf("error decoding baseline: %w", err)
+	}
+	return &b, nil
+}
+
+// Comparison is the change of a benchmark case against a baseline.
+type Comparison struct {
+	Name          string
+	Baseline      *Result
+	Current       Result
+	TimeDelta     float64 // relative change in ns/op, e.g. -0.25 for 25% faster
+	OutputChanged bool
+}
+
+func Compare(baseline *Baseline, current *Baseline) []Comparison {
+	byName := make(map[string]*Result, len(baseline.Results))
+	for i := range baseline.Results {
+		byName[baseline.Results[i].Name] = &baseline.Results[i]
+	}
+
+	comparisons := make([]Comparison, 0, len(current.Results))
+	for _, r := range current.Results {
+		c := Comparison{Name: r.Name, Current: r}
+		if old, ok := byName[r.Name]; ok {
+			c.Baseline = old
+			if old.NsPerOp > 0 {
+				c.TimeDelta = float64(r.NsPerOp-old.NsPerOp) / float64(old.NsPerOp)
+			}
+			c.OutputChanged = old.OutputHash != r.OutputHash
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
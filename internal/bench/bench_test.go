@@ -0,0 +1,31 @@
+package bench
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkGenerate runs every generator at every size with the fixed seed
+// of the bench subcommand, for use with go test -bench and benchstat.
+func BenchmarkGenerate(b *testing.B) {
+	refPath := filepath.Join(b.TempDir(), "reference.png")
+	if err := writeReference(refPath); err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, pattern := range Patterns {
+		for _, size := range Sizes {
+			cfg := caseConfig(pattern, size)
+			b.Run(pattern+"/"+size.Name, func(b *testing.B) {
+				b.ReportAllocs()
+				for range b.N {
+					if _, err := generate(ctx, cfg, refPath); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
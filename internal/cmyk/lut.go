@@ -0,0 +1,342 @@
+package cmyk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+var errTruncated = fmt.Errorf("truncated lut table")
+
+// curve maps one channel through a sampled table or parametric function.
+type curve func(float64) float64
+
+type curveStage []curve
+
+func (s curveStage) apply(in []float64) []float64 {
+	for i := range in {
+		if i < len(s) {
+			in[i] = clamp01(s[i](in[i]))
+		}
+	}
+	return in
+}
+
+// matrixStage is a 3x3 matrix with an optional offset.
+type matrixStage [12]float64
+
+func (m matrixStage) apply(in []float64) []float64 {
+	x, y, z := in[0], in[1], in[2]
+	for i := 0; i < 3; i++ {
+		in[i] = clamp01(m[i*3]*x + m[i*3+1]*y + m[i*3+2]*z + m[9+i])
+	}
+	return in
+}
+
+// clutStage is a multidimensional lookup table, interpolated linearly along
+// every input dimension. The first input varies slowest.
+type clutStage struct {
+	grid []int
+	outs int
+	data []float64
+}
+
+func (c *clutStage) apply(in []float64) []float64 {
+	n := len(c.grid)
+	base := make([]int, n)
+	frac := make([]float64, n)
+	strides := make([]int, n)
+	stride := c.outs
+	for i := n - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= c.grid[i]
+
+		pos := clamp01(in[i]) * float64(c.grid[i]-1)
+		base[i] = min(int(pos), c.grid[i]-2)
+		if c.grid[i] == 1 {
+			base[i] = 0
+		}
+		frac[i] = pos - float64(base[i])
+	}
+
+	out := make([]float64, c.outs)
+	for corner := 0; corner < 1<<n; corner++ {
+		weight := 1.0
+		offset := 0
+		for i := 0; i < n; i++ {
+			idx := base[i]
+			if corner&(1<<i) != 0 {
+				if c.grid[i] == 1 {
+					weight = 0
+					break
+				}
+				idx++
+				weight *= frac[i]
+			} else {
+				weight *= 1 - frac[i]
+			}
+			offset += idx * strides[i]
+		}
+		if weight == 0 {
+			continue
+		}
+		for o := range out {
+			out[o] += weight * c.data[offset+o]
+		}
+	}
+	return out
+}
+
+// parseLut reads an ICC v2 lut8Type (size 1) or lut16Type (size 2) table.
+// The matrix only applies when the connection space is XYZ.
+func parseLut(t []byte, size int, xyz bool) ([]stage, error) {
+	if len(t) < 52 {
+		return nil, errTruncated
+	}
+	ins, outs, gridPoints := int(t[8]), int(t[9]), int(t[10])
+	if ins != 3 || outs < 4 || gridPoints < 2 {
+		return nil, fmt.Errorf("unsupported lut shape: %d inputs, %d outputs, %d grid points", ins, outs, gridPoints)
+	}
+
+	var stages []stage
+	if xyz {
+		var m matrixStage
+		for i := 0; i < 9; i++ {
+			m[i] = s15Fixed16(t[12+i*4:])
+		}
+		stages = append(stages, m)
+	}
+
+	inEntries, outEntries, pos := 256, 256, 48
+	if size == 2 {
+		inEntries = int(binary.BigEndian.Uint16(t[48:]))
+		outEntries = int(binary.BigEndian.Uint16(t[50:]))
+		pos = 52
+	}
+
+	read := func(n int) ([]float64, error) {
+		if n < 0 || pos+n*size > len(t) {
+			return nil, errTruncated
+		}
+		v := make([]float64, n)
+		for i := range v {
+			if size == 1 {
+				v[i] = float64(t[pos+i]) / 255
+			} else {
+				v[i] = float64(binary.BigEndian.Uint16(t[pos+i*2:])) / 65535
+			}
+		}
+		pos += n * size
+		return v, nil
+	}
+
+	in := make(curveStage, ins)
+	for i := range in {
+		v, err := read(inEntries)
+		if err != nil {
+			return nil, err
+		}
+		in[i] = tableCurve(v)
+	}
+
+	grid := make([]int, ins)
+	cells := outs
+	for i := range grid {
+		grid[i] = gridPoints
+		cells *= gridPoints
+	}
+	data, err := read(cells)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(curveStage, outs)
+	for i := range out {
+		v, err := read(outEntries)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = tableCurve(v)
+	}
+
+	return append(stages, in, &clutStage{grid: grid, outs: outs, data: data}, out), nil
+}
+
+// parseLutBToA reads an ICC v4 lutBtoAType table, applied as B curves,
+// matrix, M curves, CLUT and A curves. Only the B curves are required.
+func parseLutBToA(t []byte) ([]stage, error) {
+	if len(t) < 32 {
+		return nil, errTruncated
+	}
+	ins, outs := int(t[8]), int(t[9])
+	if ins != 3 || outs < 4 {
+		return nil, fmt.Errorf("unsupported lut shape: %d inputs, %d outputs", ins, outs)
+	}
+	offset := func(i int) int { return int(binary.BigEndian.Uint32(t[12+i*4:])) }
+	bOff, matOff, mOff, clutOff, aOff := offset(0), offset(1), offset(2), offset(3), offset(4)
+
+	var stages []stage
+	b, err := parseCurves(t, bOff, ins)
+	if err != nil {
+		return nil, err
+	}
+	stages = append(stages, b)
+
+	if matOff != 0 {
+		if matOff+48 > len(t) {
+			return nil, errTruncated
+		}
+		var m matrixStage
+		for i := range m {
+			m[i] = s15Fixed16(t[matOff+i*4:])
+		}
+		stages = append(stages, m)
+	}
+	if mOff != 0 {
+		m, err := parseCurves(t, mOff, ins)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, m)
+	}
+
+	if clutOff == 0 {
+		return nil, fmt.Errorf("lut has no CLUT to map %d inputs to %d outputs", ins, outs)
+	}
+	if clutOff+20 > len(t) {
+		return nil, errTruncated
+	}
+	grid := make([]int, ins)
+	cells := outs
+	for i := range grid {
+		grid[i] = int(t[clutOff+i])
+		if grid[i] < 1 {
+			return nil, fmt.Errorf("invalid CLUT grid size %d", grid[i])
+		}
+		cells *= grid[i]
+	}
+	precision := int(t[clutOff+16])
+	if precision != 1 && precision != 2 {
+		return nil, fmt.Errorf("invalid CLUT precision %d", precision)
+	}
+	pos := clutOff + 20
+	if pos+cells*precision > len(t) {
+		return nil, errTruncated
+	}
+	data := make([]float64, cells)
+	for i := range data {
+		if precision == 1 {
+			data[i] = float64(t[pos+i]) / 255
+		} else {
+			data[i] = float64(binary.BigEndian.Uint16(t[pos+i*2:])) / 65535
+		}
+	}
+	stages = append(stages, &clutStage{grid: grid, outs: outs, data: data})
+
+	if aOff != 0 {
+		a, err := parseCurves(t, aOff, outs)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, a)
+	}
+	return stages, nil
+}
+
+// parseCurves reads n consecutive curveType or parametricCurveType
+// elements, each padded to a four byte boundary.
+func parseCurves(t []byte, pos, n int) (curveStage, error) {
+	curves := make(curveStage, n)
+	for i := range curves {
+		if pos <= 0 || pos+12 > len(t) {
+			return nil, errTruncated
+		}
+		var size int
+		switch string(t[pos : pos+4]) {
+		case "curv":
+			count := int(binary.BigEndian.Uint32(t[pos+8:]))
+			size = 12 + count*2
+			if pos+size > len(t) {
+				return nil, errTruncated
+			}
+			switch count {
+			case 0:
+				curves[i] = func(x float64) float64 { return x }
+			case 1:
+				gamma := float64(binary.BigEndian.Uint16(t[pos+12:])) / 256
+				curves[i] = func(x float64) float64 { return math.Pow(x, gamma) }
+			default:
+				v := make([]float64, count)
+				for j := range v {
+					v[j] = float64(binary.BigEndian.Uint16(t[pos+12+j*2:])) / 65535
+				}
+				curves[i] = tableCurve(v)
+			}
+		case "para":
+			fn := int(binary.BigEndian.Uint16(t[pos+8:]))
+			counts := []int{1, 3, 4, 5, 7}
+			if fn >= len(counts) {
+				return nil, fmt.Errorf("unknown parametric curve type %d", fn)
+			}
+			size = 12 + counts[fn]*4
+			if pos+size > len(t) {
+				return nil, errTruncated
+			}
+			var p [7]float64
+			for j := 0; j < counts[fn]; j++ {
+				p[j] = s15Fixed16(t[pos+12+j*4:])
+			}
+			curves[i] = parametricCurve(fn, p)
+		default:
+			return nil, fmt.Errorf("unknown curve type %q", t[pos:pos+4])
+		}
+		pos += (size + 3) &^ 3
+	}
+	return curves, nil
+}
+
+func tableCurve(v []float64) curve {
+	if len(v) == 1 {
+		return func(float64) float64 { return v[0] }
+	}
+	return func(x float64) float64 {
+		pos := clamp01(x) * float64(len(v)-1)
+		i := min(int(pos), len(v)-2)
+		f := pos - float64(i)
+		return v[i]*(1-f) + v[i+1]*f
+	}
+}
+
+func parametricCurve(fn int, p [7]float64) curve {
+	g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+	return func(x float64) float64 {
+		switch fn {
+		case 0:
+			return math.Pow(x, g)
+		case 1:
+			if a != 0 && x >= -b/a {
+				return math.Pow(a*x+b, g)
+			}
+			return 0
+		case 2:
+			if a != 0 && x >= -b/a {
+				return math.Pow(a*x+b, g) + c
+			}
+			return c
+		case 3:
+			if x >= d {
+				return math.Pow(a*x+b, g)
+			}
+			return c * x
+		default:
+			if x >= d {
+				return math.Pow(a*x+b, g) + e
+			}
+			return c*x + f
+		}
+	}
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
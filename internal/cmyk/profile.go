@@ -0,0 +1,186 @@
+// Package cmyk converts patterns to CMYK for print and writes them as TIFF
+// files, optionally through an ICC output profile.
+package cmyk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+)
+
+// Profile is an ICC output profile that converts sRGB colors to the CMYK
+// of a particular press or printer.
+type Profile struct {
+	data   []byte
+	stages []stage
+	lab    bool
+	// legacyLab is set for 16-bit lut tables, which use the ICC v2 Lab encoding
+	legacyLab bool
+}
+
+// stage is one step of a profile's BToA pipeline, working on values
+// normalized to [0, 1].
+type stage interface {
+	apply(in []float64) []float64
+}
+
+// LoadProfile reads an ICC output profile for a CMYK device. Its
+// perceptual BToA table is used, or the relative colorimetric one if the
+// profile has no perceptual table.
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ICC profile: %w", err)
+	}
+	p, err := parseProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ICC profile %s: %w", path, err)
+	}
+	return p, nil
+}
+
+func parseProfile(data []byte) (*Profile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("not an ICC profile")
+	}
+	if space := string(data[16:20]); space != "CMYK" {
+		return nil, fmt.Errorf("profile is for %q data, not CMYK", space)
+	}
+	p := &Profile{data: data}
+	switch pcs := string(data[20:24]); pcs {
+	case "Lab ":
+		p.lab = true
+	case "XYZ ":
+	default:
+		return nil, fmt.Errorf("unsupported connection space %q", pcs)
+	}
+
+	table := findTag(data, "B2A0")
+	if table == nil {
+		table = findTag(data, "B2A1")
+	}
+	if table == nil {
+		return nil, fmt.Errorf("no BToA table; an output profile is required")
+	}
+
+	var err error
+	switch string(table[:min(4, len(table))]) {
+	case "mft1":
+		p.stages, err = parseLut(table, 1, !p.lab)
+	case "mft2":
+		p.stages, err = parseLut(table, 2, !p.lab)
+		p.legacyLab = true
+	case "mBA ":
+		p.stages, err = parseLutBToA(table)
+	default:
+		return nil, fmt.Errorf("unsupported BToA table type %q", table[:min(4, len(table))])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Data returns the raw profile, for embedding in the output file.
+func (p *Profile) Data() []byte {
+	return p.data
+}
+
+// Convert returns the CMYK color for an sRGB color. A nil profile uses a
+// plain device-independent conversion.
+func (p *Profile) Convert(r, g, b uint8) color.CMYK {
+	if p == nil {
+		c, m, y, k := color.RGBToCMYK(r, g, b)
+		return color.CMYK{C: c, M: m, Y: y, K: k}
+	}
+
+	x, yy, z := srgbToXYZ(r, g, b)
+	var v []float64
+	if p.lab {
+		l, a, bb := xyzToLab(x, yy, z)
+		if p.legacyLab {
+			v = []float64{l * 652.8 / 65535, (a + 128) * 256 / 65535, (bb + 128) * 256 / 65535}
+		} else {
+			v = []float64{l / 100, (a + 128) / 255, (bb + 128) / 255}
+		}
+	} else {
+		// u1Fixed15 encoding, where 1.0 is 0x8000
+		v = []float64{x * 32768 / 65535, yy * 32768 / 65535, z * 32768 / 65535}
+	}
+	for i := range v {
+		v[i] = clamp01(v[i])
+	}
+
+	for _, s := range p.stages {
+		v = s.apply(v)
+	}
+	if len(v) < 4 {
+		return color.CMYK{}
+	}
+	return color.CMYK{C: to8(v[0]), M: to8(v[1]), Y: to8(v[2]), K: to8(v[3])}
+}
+
+func findTag(data []byte, sig string) []byte {
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(data) {
+			return nil
+		}
+		if string(data[entry:entry+4]) != sig {
+			continue
+		}
+		off := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if off < 0 || size < 8 || off+size > len(data) {
+			return nil
+		}
+		return data[off : off+size]
+	}
+	return nil
+}
+
+// srgbToXYZ converts to D50 XYZ, the ICC connection space white point,
+// using the Bradford adapted sRGB matrix.
+func srgbToXYZ(r, g, b uint8) (x, y, z float64) {
+	lr, lg, lb := linear(r), linear(g), linear(b)
+	x = 0.4360747*lr + 0.3850649*lg + 0.1430804*lb
+	y = 0.2225045*lr + 0.7168786*lg + 0.0606169*lb
+	z = 0.0139322*lr + 0.0971045*lg + 0.7141733*lb
+	return x, y, z
+}
+
+func linear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func xyzToLab(x, y, z float64) (l, a, b float64) {
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x/0.9642), f(y/1.0), f(z/0.8249)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+func clamp01(v float64) float64 {
+	if v < 0 || math.IsNaN(v) {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+func to8(v float64) uint8 {
+	return uint8(math.Round(clamp01(v) * 255))
+}
@@ -0,0 +1,188 @@
+package cmyk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// TIFF tags written by Encode, in the ascending order the format requires.
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagXResolution     = 282
+	tagYResolution     = 283
+	tagPlanarConfig    = 284
+	tagResolutionUnit  = 296
+	tagInkSet          = 332
+	tagICCProfile      = 34675
+)
+
+const (
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+
+	// stripBytes is roughly how much pixel data goes in each strip
+	stripBytes = 256 << 10
+)
+
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte // stored inline if it fits in four bytes
+}
+
+// Encode writes img as an uncompressed 8-bit CMYK TIFF, converting each
+// pixel through p (nil for a plain conversion) and embedding p's ICC data.
+// Transparent pixels are composited over white paper first. dpi sets the
+// print resolution recorded in the file.
+func Encode(w io.Writer, img image.Image, p *Profile, dpi float64) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	rowBytes := width * 4
+	dataSize := uint64(rowBytes) * uint64(height)
+	if dataSize > math.MaxUint32-(64<<20) {
+		return fmt.Errorf("image is too large for a TIFF file: %dx%d", width, height)
+	}
+
+	rowsPerStrip := max(1, stripBytes/rowBytes)
+	strips := (height + rowsPerStrip - 1) / rowsPerStrip
+
+	le := binary.LittleEndian
+	short := func(v ...uint16) []byte {
+		out := make([]byte, 2*len(v))
+		for i, x := range v {
+			le.PutUint16(out[i*2:], x)
+		}
+		return out
+	}
+	long := func(v ...uint32) []byte {
+		out := make([]byte, 4*len(v))
+		for i, x := range v {
+			le.PutUint32(out[i*4:], x)
+		}
+		return out
+	}
+
+	offsets := make([]uint32, strips)
+	counts := make([]uint32, strips)
+	for i := range offsets {
+		offsets[i] = 8 + uint32(i*rowsPerStrip*rowBytes)
+		counts[i] = uint32(min(rowsPerStrip, height-i*rowsPerStrip) * rowBytes)
+	}
+	res := long(uint32(math.Round(dpi*100)), 100)
+
+	entries := []ifdEntry{
+		{tagImageWidth, typeLong, 1, long(uint32(width))},
+		{tagImageLength, typeLong, 1, long(uint32(height))},
+		{tagBitsPerSample, typeShort, 4, short(8, 8, 8, 8)},
+		{tagCompression, typeShort, 1, short(1)},
+		{tagPhotometric, typeShort, 1, short(5)}, // separated
+		{tagStripOffsets, typeLong, uint32(strips), long(offsets...)},
+		{tagSamplesPerPixel, typeShort, 1, short(4)},
+		{tagRowsPerStrip, typeLong, 1, long(uint32(rowsPerStrip))},
+		{tagStripByteCounts, typeLong, uint32(strips), long(counts...)},
+		{tagXResolution, typeRational, 1, res},
+		{tagYResolution, typeRational, 1, res},
+		{tagPlanarConfig, typeShort, 1, short(1)},
+		{tagResolutionUnit, typeShort, 1, short(2)}, // inch
+		{tagInkSet, typeShort, 1, short(1)},         // CMYK
+	}
+	if p != nil {
+		entries = append(entries, ifdEntry{tagICCProfile, typeUndefined, uint32(len(p.data)), p.data})
+	}
+
+	// Pixel data follows the header, then the IFD, then any entry data too
+	// large to store inline
+	ifdOffset := 8 + uint32(dataSize)
+	ifdOffset += ifdOffset & 1
+	extra := ifdOffset + 2 + uint32(len(entries))*12 + 4
+
+	bw := bufio.NewWriterSize(w, 1<<16)
+	bw.Write([]byte("II*\x00"))
+	bw.Write(long(ifdOffset))
+
+	convert := converter(p)
+	nrgba, _ := img.(*image.NRGBA)
+	row := make([]byte, rowBytes)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := 0; x < width; x++ {
+			var n color.NRGBA
+			if nrgba != nil {
+				i := nrgba.PixOffset(b.Min.X+x, y)
+				n = color.NRGBA{nrgba.Pix[i], nrgba.Pix[i+1], nrgba.Pix[i+2], nrgba.Pix[i+3]}
+			} else {
+				n = color.NRGBAModel.Convert(img.At(b.Min.X+x, y)).(color.NRGBA)
+			}
+			c := convert(n)
+			row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = c.C, c.M, c.Y, c.K
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	if dataSize&1 != 0 {
+		bw.WriteByte(0)
+	}
+
+	bw.Write(short(uint16(len(entries))))
+	var tail []byte
+	for _, e := range entries {
+		bw.Write(short(e.tag, e.typ))
+		bw.Write(long(e.count))
+		if len(e.data) <= 4 {
+			field := make([]byte, 4)
+			copy(field, e.data)
+			bw.Write(field)
+			continue
+		}
+		bw.Write(long(extra + uint32(len(tail))))
+		tail = append(tail, e.data...)
+		if len(tail)&1 != 0 {
+			tail = append(tail, 0)
+		}
+	}
+	bw.Write(long(0)) // no further IFDs
+	bw.Write(tail)
+	return bw.Flush()
+}
+
+// maxCached bounds the conversion cache for images with many colors.
+const maxCached = 1 << 16
+
+// converter returns a cached conversion from image colors to CMYK, as
+// patterns repeat a small number of colors many times.
+func converter(p *Profile) func(color.NRGBA) color.CMYK {
+	cache := make(map[uint32]color.CMYK)
+	return func(n color.NRGBA) color.CMYK {
+		if n.A < 255 {
+			// Composite over white paper
+			a := uint32(n.A)
+			n.R = uint8((uint32(n.R)*a + 255*(255-a) + 127) / 255)
+			n.G = uint8((uint32(n.G)*a + 255*(255-a) + 127) / 255)
+			n.B = uint8((uint32(n.B)*a + 255*(255-a) + 127) / 255)
+		}
+		key := uint32(n.R)<<16 | uint32(n.G)<<8 | uint32(n.B)
+		if v, ok := cache[key]; ok {
+			return v
+		}
+		v := p.Convert(n.R, n.G, n.B)
+		if len(cache) < maxCached {
+			cache[key] = v
+		}
+		return v
+	}
+}
@@ -0,0 +1,267 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/apng"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// evolveChance is the chance that a cell on the edge of a color region
+// takes the color of a neighbor in each animation step. evolveSteps is the
+// number of steps between frames.
+const (
+	evolveChance = 0.3
+	evolveSteps  = 2
+	smoothVotes  = 6
+)
+
+// AnimationPath returns the file the animation of the output at filePath is
+// saved to.
+func AnimationPath(cfg *config.Config, filePath string) string {
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	if cfg.AnimateFormat == "apng" {
+		return base + "_anim.png"
+	}
+	return base + "_anim.gif"
+}
+
+// saveAnimation saves cfg.Animate frames of the pattern of the job at index
+// as an animated GIF or APNG next to filePath. The first frame is the
+// pattern itself; after it the edges of the color regions drift a little
+// from frame to frame. The animation then plays back to the start, so it
+// loops without a jump.
+func saveAnimation(ctx context.Context, cfg *config.Config, b gridBuilder, colors []color.NRGBA, index int, filePath string) error {
+	rng := jobRand(cfg, index)
+	g, err := b.buildGrid(ctx, cfg, rng, colors)
+	if err != nil {
+		return err
+	}
+	defer g.release()
+	var background *image.NRGBA
+	if cfg.Mask != "" {
+		// The dark areas of the mask keep a still pattern
+		if background, err = maskBackground(ctx, cfg, rng, colors); err != nil {
+			return err
+		}
+		if background != nil {
+			defer putNRGBA(background)
+		}
+	}
+
+	// Frame i shows state min(i, n-i), so states 0 to n/2 are rendered once
+	// each and played forward then back
+	n := cfg.Animate
+	states := n/2 + 1
+	order := make([]int, n)
+	for i := range order {
+		order[i] = min(i, n-i)
+	}
+
+	opaque := !utils.HasTransparent(g.colors) && cfg.UVTemplate == "" && (cfg.Mask == "" || background != nil)
+	var pngFrames []*apng.Frame
+	var gifFrames []*image.Paletted
+	var gifPalette color.Palette
+
+	next := newIndexGrid(g.cells.cols, g.cells.rows)
+	defer next.release()
+	counts := make([]int, len(g.colors))
+	for s := 0; s < states; s++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if s > 0 {
+			for range evolveSteps {
+				evolveGrid(rng, g.cells, next, counts)
+			}
+		}
+		img, err := renderAnimationFrame(ctx, cfg, g, background)
+		if err != nil {
+			return err
+		}
+
+		if cfg.AnimateFormat == "apng" {
+			f, err := apng.EncodeFrame(img, opaque, cfg.Compression)
+			putNRGBA(img)
+			if err != nil {
+				return fmt.Errorf("error encoding animation frame: %w", err)
+			}
+			pngFrames = append(pngFrames, f)
+			continue
+		}
+		if gifPalette == nil {
+			gifPalette = gifColors(img, g.colors)
+		}
+		gifFrames = append(gifFrames, toPaletted(img, gifPalette))
+		putNRGBA(img)
+	}
+	f, err := os.Create(utils.LongPath(AnimationPath(cfg, filePath)))
+	if err != nil {
+		return fmt.Errorf("error creating animation file: %w", err)
+	}
+	defer f.Close()
+
+	if cfg.AnimateFormat == "apng" {
+		frames := make([]*apng.Frame, n)
+		for i, s := range order {
+			frames[i] = pngFrames[s]
+		}
+		if err := apng.Encode(f, frames, 1, uint16(cfg.AnimateFPS)); err != nil {
+			return fmt.Errorf("error saving animation: %w", err)
+		}
+	} else {
+		anim := &gif.GIF{}
+		// GIF delays are in hundredths of a second, and browsers slow
+		// down anything under 2
+		delay := max(2, (100+cfg.AnimateFPS/2)/cfg.AnimateFPS)
+		for _, s := range order {
+			anim.Image = append(anim.Image, gifFrames[s])
+			anim.Delay = append(anim.Delay, delay)
+			anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+		}
+		if err := gif.EncodeAll(f, anim); err != nil {
+			return fmt.Errorf("error saving animation: %w", err)
+		}
+	}
+	return f.Close()
+}
+
+// renderAnimationFrame renders the current state of g as a full pattern,
+// with the distortion, QR code, mask and UV layout of the still image.
+// background fills the dark areas of the mask.
+func renderAnimationFrame(ctx context.Context, cfg *config.Config, g *cellGrid, background *image.NRGBA) (*image.NRGBA, error) {
+	frame := *g
+	frame.cells = newIndexGrid(g.cells.cols, g.cells.rows)
+	defer frame.release()
+	copy(frame.cells.cells, g.cells.cells)
+	if err := finishGrid(ctx, cfg, &frame); err != nil {
+		return nil, err
+	}
+
+	img := getNRGBA(cfg.Width, cfg.Height)
+	frame.renderBand(cfg, img, 0)
+	if cfg.Mask != "" {
+		if err := applyMask(cfg, img, background); err != nil {
+			putNRGBA(img)
+			return nil, err
+		}
+	}
+	if cfg.UVTemplate == "" {
+		return img, nil
+	}
+	laid, err := applyUVLayout(cfg, img)
+	if err != nil {
+		putNRGBA(img)
+		return nil, err
+	}
+	return toNRGBA(laid), nil
+}
+
+// evolveGrid moves g one animation step on, using next as scratch space:
+// each cell on the edge of a color region takes the color of one of its
+// four neighbors with evolveChance, then a pass of the cellular automaton
+// rounds the edges off again. Region edges wander while the color shares
+// stay about the same.
+func evolveGrid(rng *rand.Rand, g, next *indexGrid, counts []int) {
+	var neighbors [4]int
+	for y := 0; y < g.rows; y++ {
+		up, row, down := g.row((y+g.rows-1)%g.rows), g.row(y), g.row((y+1)%g.rows)
+		out := next.row(y)
+		for x, c := range row {
+			out[x] = c
+			neighbors = [4]int{row[(x+g.cols-1)%g.cols], row[(x+1)%g.cols], up[x], down[x]}
+			if neighbors != [4]int{c, c, c, c} && rng.Float64() < evolveChance {
+				out[x] = neighbors[rng.IntN(4)]
+			}
+		}
+	}
+	for y := 0; y < g.rows; y++ {
+		for x := 0; x < g.cols; x++ {
+			c := next.at(x, y)
+			if m := next.mostCommonNeighbor(rng, x, y, 1, counts, nil); counts[m] >= smoothVotes {
+				c = m
+			}
+			g.set(x, y, c)
+		}
+	}
+}
+
+// gifColors returns the palette of a GIF animation: the pattern colors, then
+// the most common other colors of img, such as those blended by noise and
+// edge details, up to the 256 a GIF holds. Transparent pixels share one
+// entry.
+func gifColors(img *image.NRGBA, colors []color.NRGBA) color.Palette {
+	counts := make(map[color.NRGBA]int)
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+		if c.A == 0 {
+			c = color.NRGBA{}
+		}
+		counts[c]++
+	}
+
+	palette := make(color.Palette, 0, 256)
+	seen := make(map[color.NRGBA]bool)
+	for _, c := range colors {
+		if c.A == 0 {
+			c = color.NRGBA{}
+		}
+		if !seen[c] {
+			seen[c] = true
+			palette = append(palette, c)
+		}
+	}
+	others := make([]color.NRGBA, 0, len(counts))
+	for c := range counts {
+		if !seen[c] {
+			others = append(others, c)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if counts[others[i]] != counts[others[j]] {
+			return counts[others[i]] > counts[others[j]]
+		}
+		a, b := others[i], others[j]
+		return uint32(a.R)<<24|uint32(a.G)<<16|uint32(a.B)<<8|uint32(a.A) < uint32(b.R)<<24|uint32(b.G)<<16|uint32(b.B)<<8|uint32(b.A)
+	})
+	for _, c := range others {
+		if len(palette) == 256 {
+			break
+		}
+		palette = append(palette, c)
+	}
+	return palette
+}
+
+// toPaletted maps img to the closest colors of palette.
+func toPaletted(img *image.NRGBA, palette color.Palette) *image.Paletted {
+	b := img.Bounds()
+	dst := image.NewPaletted(b, palette)
+	index := make(map[color.NRGBA]uint8, len(palette))
+	for i, c := range palette {
+		index[c.(color.NRGBA)] = uint8(i)
+	}
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+		if c.A == 0 {
+			c = color.NRGBA{}
+		}
+		idx, ok := index[c]
+		if !ok {
+			idx = uint8(palette.Index(c))
+			index[c] = idx
+		}
+		dst.Pix[i/4] = idx
+	}
+	return dst
+}
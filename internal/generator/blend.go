@@ -0,0 +1,147 @@
+package generator
+
+import (
+	"image"
+	"math"
+	"sync"
+)
+
+// featherTable converts sRGB bytes to 16 bit intensities for averaging and
+// back. The inverse maps every intensity to the nearest byte, so a run of
+// one color averages back to exactly that color.
+type featherTable struct {
+	fwd [256]uint16
+	inv [65536]uint8
+}
+
+func newFeatherTable(decode func(v uint8) float64) *featherTable {
+	t := &featherTable{}
+	for i := range t.fwd {
+		t.fwd[i] = uint16(math.Round(decode(uint8(i)) * 65535))
+	}
+	v := 0
+	for x := range t.inv {
+		for v < 255 && x-int(t.fwd[v]) > int(t.fwd[v+1])-x {
+			v++
+		}
+		t.inv[x] = uint8(v)
+	}
+	return t
+}
+
+// The tables are built on first use, after the linear light tables.
+var (
+	linearFeather = sync.OnceValue(func() *featherTable {
+		return newFeatherTable(func(v uint8) float64 { return float64(srgbToLinear[v]) })
+	})
+	gammaFeather = sync.OnceValue(func() *featherTable {
+		return newFeatherTable(func(v uint8) float64 { return float64(v) / 255 })
+	})
+)
+
+// featherSum accumulates the alpha weighted intensities of the pixels in a
+// window.
+type featherSum struct {
+	r, g, b, a uint64
+	n          uint64
+}
+
+func (s *featherSum) add(t *featherTable, p []uint8) {
+	a := uint64(p[3])
+	s.r += uint64(t.fwd[p[0]]) * a
+	s.g += uint64(t.fwd[p[1]]) * a
+	s.b += uint64(t.fwd[p[2]]) * a
+	s.a += a
+	s.n++
+}
+
+func (s *featherSum) sub(t *featherTable, p []uint8) {
+	a := uint64(p[3])
+	s.r -= uint64(t.fwd[p[0]]) * a
+	s.g -= uint64(t.fwd[p[1]]) * a
+	s.b -= uint64(t.fwd[p[2]]) * a
+	s.a -= a
+	s.n--
+}
+
+// put writes the average of the window to p. Transparent pixels don't
+// count towards the color, so holes don't darken the colors around them.
+func (s *featherSum) put(t *featherTable, p []uint8) {
+	if s.a == 0 {
+		clear(p[:4])
+		return
+	}
+	p[0] = t.inv[(s.r+s.a/2)/s.a]
+	p[1] = t.inv[(s.g+s.a/2)/s.a]
+	p[2] = t.inv[(s.b+s.a/2)/s.a]
+	p[3] = uint8((s.a + s.n/2) / s.n)
+}
+
+// featherEdges softens the boundaries between colors into gradients by
+// averaging every pixel with the pixels up to radius away, a box blur done
+// as a horizontal and a vertical pass. Flat areas keep their exact color.
+// Colors are averaged in linear light unless legacy is set.
+func featherEdges(img *image.NRGBA, radius int, legacy bool) {
+	t := linearFeather()
+	if legacy {
+		t = gammaFeather()
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	tmp := getNRGBA(width, height)
+	defer putNRGBA(tmp)
+
+	// Horizontal pass into tmp, sliding the window along each row
+	parallelRows(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			src := img.Pix[y*img.Stride : y*img.Stride+width*4]
+			dst := tmp.Pix[y*tmp.Stride:]
+			var s featherSum
+			for x := 0; x <= radius && x < width; x++ {
+				s.add(t, src[x*4:])
+			}
+			for x := 0; x < width; x++ {
+				s.put(t, dst[x*4:])
+				if x-radius >= 0 {
+					s.sub(t, src[(x-radius)*4:])
+				}
+				if x+radius+1 < width {
+					s.add(t, src[(x+radius+1)*4:])
+				}
+			}
+		}
+	})
+
+	// Vertical pass back into img, sliding a window per column down each
+	// band of rows so rows are read in order
+	parallelRows(height, func(y0, y1 int) {
+		sums := make([]featherSum, width)
+		addRow := func(y int) {
+			row := tmp.Pix[y*tmp.Stride:]
+			for x := range sums {
+				sums[x].add(t, row[x*4:])
+			}
+		}
+		subRow := func(y int) {
+			row := tmp.Pix[y*tmp.Stride:]
+			for x := range sums {
+				sums[x].sub(t, row[x*4:])
+			}
+		}
+		for y := max(0, y0-radius); y <= y0+radius && y < height; y++ {
+			addRow(y)
+		}
+		for y := y0; y < y1; y++ {
+			dst := img.Pix[y*img.Stride:]
+			for x := range sums {
+				sums[x].put(t, dst[x*4:])
+			}
+			if y-radius >= 0 {
+				subRow(y - radius)
+			}
+			if y+radius+1 < height {
+				addRow(y + radius + 1)
+			}
+		}
+	})
+}
@@ -2,85 +2,91 @@ package generator
 
 import (
 	"context"
+	"fmt"
 	"image"
 	"image/color"
-	"math/rand"
+	"math/rand/v2"
 
 	"github.com/bradsec/gocamo/pkg/config"
 )
 
-type BlobGenerator struct{}
+// blobScale is the size of blob cells in base pixels.
+const blobScale = 2
 
-func (bg *BlobGenerator) Generate(ctx context.Context, cfg *config.Config, colors []color.RGBA) (image.Image, error) {
+type BlobGenerator struct{}
 
-	// Shuffle the colors
-	shuffledColors := shuffleColors(colors)
+func (bg *BlobGenerator) Generate(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (image.Image, error) {
+	return renderGridPattern(ctx, cfg, rng, bg, colors)
+}
 
-	// Adjust base pixel size to fit perfectly within the dimensions
-	adjustedBasePixelSize := cfg.BasePixelSize
-	for cfg.Width%adjustedBasePixelSize != 0 || cfg.Height%adjustedBasePixelSize != 0 {
-		adjustedBasePixelSize--
+func (bg *BlobGenerator) buildGrid(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (*cellGrid, error) {
+	if err := checkDimensions(cfg); err != nil {
+		return nil, err
 	}
 
-	img := image.NewNRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	// Shuffle the colors, keeping each color's ratios
+	shuffledColors, perm := shufflePalette(rng, colors)
+	pickers, err := newLayerPickers(cfg.ColorRatios, len(colors), perm)
+	if err != nil {
+		return nil, err
+	}
+	balance := pickers.balance
 
 	// Adjust the scale factor to create smaller blobs
-	scaleFactor := 2
+	scaleFactor := blobScale
+
+	// Adjust base pixel size to fit within the dimensions
+	adjustedBasePixelSize, _ := fitPixelSize(cfg, scaleFactor)
 
 	// Create the pattern grid with smaller cells
-	patternWidth, patternHeight := cfg.Width/(adjustedBasePixelSize*scaleFactor), cfg.Height/(adjustedBasePixelSize*scaleFactor)
-	pattern := make([][]int, patternHeight)
-	for y := range pattern {
-		pattern[y] = make([]int, patternWidth)
-		for x := range pattern[y] {
-			pattern[y][x] = rand.Intn(len(shuffledColors))
-		}
+	cellSize := adjustedBasePixelSize * scaleFactor
+	patternWidth, patternHeight := cellsAcross(cfg.Width, cellSize), cellsAcross(cfg.Height, cellSize)
+	pattern := newIndexGrid(patternWidth, patternHeight)
+	for i := range pattern.cells {
+		pattern.cells[i] = pickers.macro.pick(rng, len(shuffledColors))
 	}
+	layers := layersFrom(ctx)
+	layers.grid("base", pattern, cellSize, shuffledColors)
 
-	// Apply cellular automata to create clustered blob regions
-	iterations := 3
+	// Apply cellular automata to create clustered blob regions, swapping
+	// between two buffers rather than allocating a new grid every pass
+	iterations := cfg.AutomatonPasses()
+	// Wider neighborhoods grow larger blobs from the same cells
+	radius := cfg.AutomatonRadius("blob") * cfg.StructureScale()
+	chance := cfg.AutomatonChance("blob")
+	next := newIndexGrid(patternWidth, patternHeight)
+	counts := make([]int, len(shuffledColors))
 	for i := 0; i < iterations; i++ {
-		newPattern := make([][]int, patternHeight)
-		for y := range newPattern {
-			newPattern[y] = make([]int, patternWidth)
-			for x := range newPattern[y] {
-				colorCounts := make(map[int]int)
-				for dy := -1; dy <= 1; dy++ {
-					for dx := -1; dx <= 1; dx++ {
-						ny, nx := (y+dy+patternHeight)%patternHeight, (x+dx+patternWidth)%patternWidth
-						colorCounts[pattern[ny][nx]]++
-					}
+		for attempt := 0; ; attempt++ {
+			for y := 0; y < patternHeight; y++ {
+				if err := ctx.Err(); err != nil {
+					pattern.release()
+					next.release()
+					return nil, err
 				}
-				maxCount, dominantColor := 0, pattern[y][x]
-				for color, count := range colorCounts {
-					if count > maxCount || (count == maxCount && rand.Float32() < 0.3) {
-						maxCount, dominantColor = count, color
+				for x := 0; x < patternWidth; x++ {
+					c := pattern.mostCommonNeighbor(rng, x, y, radius, counts, balance.weights())
+					if chance < 1 && rng.Float64() >= chance {
+						c = pattern.at(x, y)
 					}
+					next.set(x, y, c)
 				}
-				newPattern[y][x] = dominantColor
+			}
+			if balance.settled(next, attempt) {
+				break
 			}
 		}
-		pattern = newPattern
-	}
-
-	// Draw the pattern
-	for y := 0; y < cfg.Height; y++ {
-		for x := 0; x < cfg.Width; x++ {
-			patternY := (y / (adjustedBasePixelSize * scaleFactor)) % patternHeight
-			patternX := (x / (adjustedBasePixelSize * scaleFactor)) % patternWidth
-			colorIndex := pattern[patternY][patternX]
-			c := shuffledColors[colorIndex]
-			img.Set(x, y, c)
-		}
-	}
-
-	if cfg.AddNoise {
-		addNoiseNRGBA(img, shuffledColors)
-	}
-
-	if cfg.AddEdge {
-		addEdgeDetailsNRGBA(img, adjustedBasePixelSize)
+		pattern, next = next, pattern
+		layers.grid(fmt.Sprintf("pass%d", i+1), pattern, cellSize, shuffledColors)
 	}
+	next.release()
 
-	return img, nil
+	return &cellGrid{
+		cells:         pattern,
+		cellSize:      cellSize,
+		basePixelSize: adjustedBasePixelSize,
+		colors:        shuffledColors,
+		detail:        pickers.detail,
+		effectSeed:    rng.Uint64(),
+	}, nil
 }
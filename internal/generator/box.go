@@ -2,127 +2,139 @@ package generator
 
 import (
 	"context"
+	"fmt"
 	"image"
 	"image/color"
-	"math/rand"
+	"math/rand/v2"
 
 	"github.com/bradsec/gocamo/pkg/config"
 )
 
 type BoxGenerator struct{}
 
-func (bg *BoxGenerator) Generate(ctx context.Context, cfg *config.Config, colors []color.RGBA) (image.Image, error) {
-	// Shuffle the colors
-	shuffledColors := shuffleColors(colors)
+func (bg *BoxGenerator) Generate(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (image.Image, error) {
+	return renderGridPattern(ctx, cfg, rng, bg, colors)
+}
+
+func (bg *BoxGenerator) buildGrid(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (*cellGrid, error) {
+	if err := checkDimensions(cfg); err != nil {
+		return nil, err
+	}
 
-	// Adjust base pixel size to fit perfectly within the dimensions
-	adjustedBasePixelSize := cfg.BasePixelSize
-	for cfg.Width%adjustedBasePixelSize != 0 || cfg.Height%adjustedBasePixelSize != 0 {
-		adjustedBasePixelSize--
+	// Shuffle the colors, keeping each color's ratios
+	shuffledColors, perm := shufflePalette(rng, colors)
+	pickers, err := newLayerPickers(cfg.ColorRatios, len(colors), perm)
+	if err != nil {
+		return nil, err
 	}
+	balance := pickers.balance
 
-	img := image.NewNRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	// Adjust base pixel size to fit within the dimensions
+	adjustedBasePixelSize, _ := fitPixelSize(cfg, 1)
 
 	// Calculate the number of cells based on the image dimensions and adjusted base pixel size
-	cellWidth := cfg.Width / adjustedBasePixelSize
-	cellHeight := cfg.Height / adjustedBasePixelSize
+	cellWidth := cellsAcross(cfg.Width, adjustedBasePixelSize)
+	cellHeight := cellsAcross(cfg.Height, adjustedBasePixelSize)
 
 	// Create a grid to store color indices
-	grid := make([][]int, cellHeight)
-	for i := range grid {
-		grid[i] = make([]int, cellWidth)
-	}
+	grid := newIndexGrid(cellWidth, cellHeight)
 
 	// Generate initial random color assignment
-	for y := 0; y < cellHeight; y++ {
-		for x := 0; x < cellWidth; x++ {
-			grid[y][x] = rand.Intn(len(shuffledColors))
-		}
+	for i := range grid.cells {
+		grid.cells[i] = pickers.macro.pick(rng, len(shuffledColors))
 	}
-
-	// Apply cellular automaton rules to create clusters
-	for i := 0; i < 3; i++ {
-		newGrid := make([][]int, cellHeight)
-		for y := range newGrid {
-			newGrid[y] = make([]int, cellWidth)
-			copy(newGrid[y], grid[y])
-		}
-
-		for y := 0; y < cellHeight; y++ {
-			for x := 0; x < cellWidth; x++ {
-				// Count neighboring colors with variable neighborhood size
-				neighborhoodSize := rand.Intn(2) + 1 // 1 or 2
-				colorCount := make(map[int]int)
-				for dy := -neighborhoodSize; dy <= neighborhoodSize; dy++ {
-					for dx := -neighborhoodSize; dx <= neighborhoodSize; dx++ {
-						ny, nx := (y+dy+cellHeight)%cellHeight, (x+dx+cellWidth)%cellWidth
-						colorCount[grid[ny][nx]]++
-					}
+	layers := layersFrom(ctx)
+	layers.grid("base", grid, adjustedBasePixelSize, shuffledColors)
+
+	// Apply cellular automaton rules to create clusters, swapping between
+	// two buffers rather than allocating a new grid every pass
+	next := newIndexGrid(cellWidth, cellHeight)
+	counts := make([]int, len(shuffledColors))
+	structure := cfg.StructureScale()
+	radius := cfg.AutomatonRadius("box")
+	// Without -ca-radius each cell votes over a radius picked at random
+	randomRadius := cfg.CARadius == 0
+	chance := float32(cfg.AutomatonChance("box"))
+	for i := 0; i < cfg.AutomatonPasses(); i++ {
+		for attempt := 0; ; attempt++ {
+			for y := 0; y < cellHeight; y++ {
+				if err := ctx.Err(); err != nil {
+					grid.release()
+					next.release()
+					return nil, err
 				}
-
-				// Find the most common color
-				maxCount, maxColor := 0, grid[y][x]
-				for color, count := range colorCount {
-					if count > maxCount || (count == maxCount && rand.Float32() < 0.3) {
-						maxCount, maxColor = count, color
+				for x := 0; x < cellWidth; x++ {
+					// Find the most common neighboring color, over a neighborhood
+					// of 1 to radius cells unless -ca-radius fixes it
+					neighborhoodSize := radius
+					if randomRadius {
+						neighborhoodSize = rng.IntN(radius) + 1
+					}
+					neighborhoodSize *= structure
+					maxColor := grid.mostCommonNeighbor(rng, x, y, neighborhoodSize, counts, balance.weights())
+
+					// Apply the most common color with a probability
+					if rng.Float32() < chance {
+						next.set(x, y, maxColor)
+					} else {
+						next.set(x, y, grid.at(x, y))
 					}
 				}
-
-				// Apply the most common color with a probability
-				if rand.Float32() < 0.7 {
-					newGrid[y][x] = maxColor
-				}
+			}
+			if balance.settled(next, attempt) {
+				break
 			}
 		}
 
-		grid = newGrid
+		grid, next = next, grid
+		layers.grid(fmt.Sprintf("pass%d", i+1), grid, adjustedBasePixelSize, shuffledColors)
 	}
+	next.release()
 
 	// Create larger squares and rectangles
-	maxSize := 8 // Maximum size of larger shapes
+	maxSize := cfg.ShapeSize // Maximum size of larger shapes
+	if maxSize < 2 {
+		maxSize = config.DefaultShapeSize
+	}
 	for y := 0; y < cellHeight; y += maxSize / 2 {
 		for x := 0; x < cellWidth; x += maxSize / 2 {
-			if rand.Float32() < 0.3 { // 30% chance to create a larger shape
-				shapeType := rand.Intn(3) // 0: square, 1: horizontal rectangle, 2: vertical rectangle
-				width := rand.Intn(maxSize) + 1
-				height := rand.Intn(maxSize) + 1
+			if rng.Float32() < 0.3 { // 30% chance to create a larger shape
+				shapeType := rng.IntN(3) // 0: square, 1: horizontal rectangle, 2: vertical rectangle
+				width := rng.IntN(maxSize) + 1
+				height := rng.IntN(maxSize) + 1
 
 				if shapeType == 1 {
-					width = rand.Intn(maxSize) + maxSize/2 // Wider
-					height = rand.Intn(maxSize/2) + 1      // Shorter
+					width = rng.IntN(maxSize) + maxSize/2 // Wider
+					height = rng.IntN(maxSize/2) + 1      // Shorter
 				} else if shapeType == 2 {
-					width = rand.Intn(maxSize/2) + 1        // Narrower
-					height = rand.Intn(maxSize) + maxSize/2 // Taller
+					width = rng.IntN(maxSize/2) + 1        // Narrower
+					height = rng.IntN(maxSize) + maxSize/2 // Taller
 				}
 
-				color := grid[y][x]
+				// Medium shapes take the underlying color unless the medium
+				// layer has its own ratios
+				color := grid.at(x, y)
+				if pickers.medium != nil {
+					color = pickers.medium.pick(rng, len(shuffledColors))
+				}
 				for dy := 0; dy < height && y+dy < cellHeight; dy++ {
+					row := grid.row(y + dy)
 					for dx := 0; dx < width && x+dx < cellWidth; dx++ {
-						grid[y+dy][x+dx] = color
+						row[x+dx] = color
 					}
 				}
 			}
 		}
 	}
 
-	// Draw the pattern
-	for y := 0; y < cfg.Height; y++ {
-		for x := 0; x < cfg.Width; x++ {
-			cellY := y / adjustedBasePixelSize
-			cellX := x / adjustedBasePixelSize
-			if cellY < cellHeight && cellX < cellWidth {
-				img.Set(x, y, shuffledColors[grid[cellY][cellX]])
-			}
-		}
-	}
-
-	if cfg.AddNoise {
-		addNoiseNRGBA(img, shuffledColors)
-	}
-
-	if cfg.AddEdge {
-		addEdgeDetailsNRGBA(img, adjustedBasePixelSize)
-	}
+	layers.grid("shapes", grid, adjustedBasePixelSize, shuffledColors)
 
-	return img, nil
+	return &cellGrid{
+		cells:         grid,
+		cellSize:      adjustedBasePixelSize,
+		basePixelSize: adjustedBasePixelSize,
+		colors:        shuffledColors,
+		detail:        pickers.detail,
+		effectSeed:    rng.Uint64(),
+	}, nil
 }
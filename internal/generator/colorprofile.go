@@ -0,0 +1,48 @@
+package generator
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bradsec/gocamo/internal/icc"
+	"github.com/bradsec/gocamo/internal/pngmeta"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// SRGBProfile is the -color-profile value that embeds the built-in sRGB
+// profile.
+const SRGBProfile = "srgb"
+
+// colorProfiles caches the profiles embedded in PNG outputs by
+// -color-profile value.
+var colorProfiles sync.Map
+
+// LoadColorProfile returns the ICC profile -color-profile embeds in PNG
+// outputs: the built-in sRGB profile for "srgb", or the RGB profile read
+// from the file at value. An empty value returns nil, which embeds none.
+func LoadColorProfile(value string) (*pngmeta.Profile, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if p, ok := colorProfiles.Load(value); ok {
+		return p.(*pngmeta.Profile), nil
+	}
+	p := &pngmeta.Profile{Name: "sRGB", Data: icc.SRGB()}
+	if value != SRGBProfile {
+		data, err := icc.Load(value)
+		if err != nil {
+			return nil, err
+		}
+		p = &pngmeta.Profile{Name: strings.TrimSuffix(filepath.Base(value), filepath.Ext(value)), Data: data}
+	}
+	actual, _ := colorProfiles.LoadOrStore(value, p)
+	return actual.(*pngmeta.Profile), nil
+}
+
+// colorProfile returns the profile of the outputs of cfg, which the run
+// loaded before generating anything.
+func colorProfile(cfg *config.Config) *pngmeta.Profile {
+	p, _ := LoadColorProfile(cfg.ColorProfile)
+	return p
+}
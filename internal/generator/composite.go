@@ -0,0 +1,149 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// maskSeed separates the noise of the composite layer mask from the other
+// noise fields.
+const maskSeed = 0x6d61736b
+
+// maskScale is the size of the patches of the composite layer mask in base
+// pixels.
+const maskScale = 32
+
+// CompositeGenerator layers two pattern types, such as large blobs under a
+// box micro texture. The top layer shows through organic patches covering
+// -layer-opacity percent of the pattern.
+type CompositeGenerator struct{}
+
+func (cg *CompositeGenerator) Generate(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (image.Image, error) {
+	return renderGridPattern(ctx, cfg, rng, cg, colors)
+}
+
+func (cg *CompositeGenerator) buildGrid(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (*cellGrid, error) {
+	if err := checkDimensions(cfg); err != nil {
+		return nil, err
+	}
+
+	// Both layers are built from the one random source, bottom first
+	var grids [2]*cellGrid
+	release := func() {
+		for _, g := range grids {
+			if g != nil {
+				g.release()
+			}
+		}
+	}
+	layers := layersFrom(ctx)
+	for i, patternType := range cfg.Layers {
+		gen, err := newGenerator(patternType)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		b, ok := gen.(gridBuilder)
+		if !ok || patternType == "composite" {
+			release()
+			return nil, fmt.Errorf("pattern type %s cannot be a composite layer", patternType)
+		}
+		layers.setPrefix([]string{"bottom-", "top-"}[i])
+		grids[i], err = b.buildGrid(ctx, cfg, rng, colors)
+		layers.setPrefix("")
+		if err != nil {
+			release()
+			return nil, err
+		}
+	}
+	defer release()
+	bottom, top := grids[0], grids[1]
+
+	// Each layer shuffles the palette its own way, so the top layer's
+	// indices are mapped to the bottom layer's colors
+	remap := make([]int, len(top.colors))
+	for i, c := range top.colors {
+		remap[i] = max(slices.Index(bottom.colors, c), 0)
+	}
+
+	// The combined grid is fine enough to hold the cells of both layers
+	cellSize := gcd(bottom.cellSize, top.cellSize)
+	basePixelSize := min(bottom.basePixelSize, top.basePixelSize)
+	cells := newIndexGrid(cellsAcross(cfg.Width, cellSize), cellsAcross(cfg.Height, cellSize))
+	mask := compositeMask(cfg, rng.Uint64(), cells.cols, cells.rows, cellSize, basePixelSize)
+	parallelRows(cells.rows, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			py := y*cellSize + cellSize/2
+			row := cells.row(y)
+			for x := range row {
+				px := x*cellSize + cellSize/2
+				if mask[y*cells.cols+x] {
+					row[x] = remap[layerAt(top, px, py)]
+				} else {
+					row[x] = layerAt(bottom, px, py)
+				}
+			}
+		}
+	})
+	layers.grid("composite", cells, cellSize, bottom.colors)
+
+	return &cellGrid{
+		cells:         cells,
+		cellSize:      cellSize,
+		basePixelSize: basePixelSize,
+		colors:        bottom.colors,
+		detail:        bottom.detail,
+		effectSeed:    rng.Uint64(),
+	}, nil
+}
+
+// compositeMask returns which cells of a cols by rows grid show the top
+// layer: those where two octaves of smooth noise are highest, making up
+// -layer-opacity percent of the cells.
+func compositeMask(cfg *config.Config, seed uint64, cols, rows, cellSize, basePixelSize int) []bool {
+	mask := make([]bool, cols*rows)
+	n := len(mask) * cfg.LayerOpacity / 100
+	if n == 0 {
+		return mask
+	}
+
+	noise := newPerlin(seed^maskSeed, 0)
+	frequency := float64(cellSize) / float64(maskScale*basePixelSize*cfg.StructureScale())
+	values := make([]float64, len(mask))
+	parallelRows(rows, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < cols; x++ {
+				fx, fy := float64(x)*frequency, float64(y)*frequency
+				values[y*cols+x] = noise.at(fx, fy) + 0.5*noise.at(2*fx, 2*fy)
+			}
+		}
+	})
+
+	// The threshold is the value that the wanted share of cells reach
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	threshold := sorted[len(sorted)-n]
+	for i, v := range values {
+		mask[i] = v >= threshold
+	}
+	return mask
+}
+
+// layerAt returns the palette index of the cell of g under the pixel at
+// (px, py).
+func layerAt(g *cellGrid, px, py int) int {
+	return g.cells.at(min(px/g.cellSize, g.cells.cols-1), min(py/g.cellSize, g.cells.rows-1))
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
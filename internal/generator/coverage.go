@@ -0,0 +1,109 @@
+package generator
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// colorCoverage returns the share of the image covered by each palette
+// color. Every pixel counts towards its nearest palette color, so pixels
+// blended by noise and edge details still count towards the color they came
+// from. Fully transparent pixels count towards a transparent palette color
+// and are otherwise left out.
+func colorCoverage(img image.Image, colors []color.NRGBA) []float64 {
+	counts := make([]int, len(colors))
+	transparent := -1
+	for i, c := range colors {
+		if c.A == 0 {
+			transparent = i
+			break
+		}
+	}
+
+	src := toNRGBA(img)
+	b := src.Bounds()
+	nearest := make(map[color.NRGBA]int)
+	last, lastIndex := color.NRGBA{}, -1
+	total := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := src.Pix[(y-b.Min.Y)*src.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			p := color.NRGBA{row[4*x], row[4*x+1], row[4*x+2], row[4*x+3]}
+			if p.A == 0 {
+				if transparent >= 0 {
+					counts[transparent]++
+					total++
+				}
+				continue
+			}
+			if p != last || lastIndex < 0 {
+				i, ok := nearest[p]
+				if !ok {
+					i = nearestColor(p, colors)
+					nearest[p] = i
+				}
+				last, lastIndex = p, i
+			}
+			counts[lastIndex]++
+			total++
+		}
+	}
+
+	coverage := make([]float64, len(colors))
+	if total == 0 {
+		return coverage
+	}
+	for i, n := range counts {
+		coverage[i] = float64(n) / float64(total)
+	}
+	return coverage
+}
+
+// nearestColor returns the index of the visible palette color closest to p.
+func nearestColor(p color.NRGBA, colors []color.NRGBA) int {
+	best, bestDist := 0, -1
+	for i, c := range colors {
+		if c.A == 0 {
+			continue
+		}
+		dr, dg, db, da := int(p.R)-int(c.R), int(p.G)-int(c.G), int(p.B)-int(c.B), int(p.A)-int(c.A)
+		if d := dr*dr + dg*dg + db*db + da*da; bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// targetCoverage returns the share of the pattern each palette color is
+// meant to cover, from the macro layer ratios or in equal parts.
+func targetCoverage(cfg *config.Config, n int) []float64 {
+	shares, err := RatioShares(cfg.ColorRatios.Macro, n)
+	if err != nil || shares == nil {
+		shares = make([]float64, n)
+		for i := range shares {
+			shares[i] = 1 / float64(n)
+		}
+	}
+	return shares
+}
+
+// RatioShares returns the share of a layer each of n palette colors gets
+// from the ratios, adding up to 1, or nil if the colors are used in equal
+// proportions.
+func RatioShares(r config.ColorRatios, n int) ([]float64, error) {
+	weights, err := ratioWeights(r, n)
+	if err != nil || weights == nil {
+		return nil, err
+	}
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	shares := make([]float64, n)
+	for i, w := range weights {
+		shares[i] = w / sum
+	}
+	return shares, nil
+}
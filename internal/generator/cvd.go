@@ -0,0 +1,118 @@
+package generator
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// cvdSimulation is a color vision deficiency and the matrix that simulates
+// it on linear RGB, from Machado, Oliveira and Fernandes (2009) at full
+// severity.
+type cvdSimulation struct {
+	name   string
+	matrix [3][3]float32
+}
+
+var cvdSimulations = []cvdSimulation{
+	{"protanopia", [3][3]float32{
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	}},
+	{"deuteranopia", [3][3]float32{
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	}},
+	{"tritanopia", [3][3]float32{
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	}},
+}
+
+// Layout of the -cvd comparison: panels are halved until they fit in
+// cvdPanelSize, and each has a label strip above it.
+const (
+	cvdPanelSize = 1024
+	cvdMargin    = 8
+	cvdLabel     = 20
+)
+
+var cvdBackground = color.NRGBA{0x20, 0x20, 0x20, 0xff}
+
+// saveCVD writes img next to filePath as a _cvd PNG with the pattern as
+// seen with protanopia, deuteranopia and tritanopia beside it, to check
+// that its colors stay apart for color blind viewers.
+func saveCVD(cfg *config.Config, img image.Image, filePath string) error {
+	panel := toNRGBA(img)
+	for panel.Bounds().Dx() > cvdPanelSize || panel.Bounds().Dy() > cvdPanelSize {
+		panel = downsample(panel, !cfg.LegacyBlend)
+	}
+	pw, ph := panel.Bounds().Dx(), panel.Bounds().Dy()
+
+	n := len(cvdSimulations) + 1
+	dst := image.NewNRGBA(image.Rect(0, 0, n*pw+(n+1)*cvdMargin, ph+cvdLabel+2*cvdMargin))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(cvdBackground), image.Point{}, draw.Src)
+
+	for i := 0; i < n; i++ {
+		name, src := "original", panel
+		if i > 0 {
+			name, src = cvdSimulations[i-1].name, simulateCVD(panel, cvdSimulations[i-1].matrix)
+		}
+		x := cvdMargin + i*(pw+cvdMargin)
+		drawCVDLabel(dst, x, cvdMargin, name)
+		r := image.Rect(x, cvdMargin+cvdLabel, x+pw, cvdMargin+cvdLabel+ph)
+		draw.Draw(dst, r, src, image.Point{}, draw.Over)
+	}
+
+	path := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + "_cvd.png"
+	if err := saveImageToFile(dst, path, cfg.Force, pngOptions(cfg)); err != nil {
+		return fmt.Errorf("error saving color blindness simulation: %w", err)
+	}
+	return nil
+}
+
+// simulateCVD returns img as seen with the deficiency of matrix, applied in
+// linear light. Alpha is kept.
+func simulateCVD(img *image.NRGBA, m [3][3]float32) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(b)
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			src := img.Pix[y*img.Stride:]
+			out := dst.Pix[y*dst.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				p := src[x*4 : x*4+4]
+				r, g, bl := srgbToLinear[p[0]], srgbToLinear[p[1]], srgbToLinear[p[2]]
+				out[x*4] = toSRGB(m[0][0]*r + m[0][1]*g + m[0][2]*bl)
+				out[x*4+1] = toSRGB(m[1][0]*r + m[1][1]*g + m[1][2]*bl)
+				out[x*4+2] = toSRGB(m[2][0]*r + m[2][1]*g + m[2][2]*bl)
+				out[x*4+3] = p[3]
+			}
+		}
+	})
+	return dst
+}
+
+// drawCVDLabel draws the name of a panel with its top left corner at x, y.
+func drawCVDLabel(dst *image.NRGBA, x, y int, name string) {
+	face := basicfont.Face7x13
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(color.NRGBA{0xe8, 0xe8, 0xe8, 0xff}),
+		Face: face,
+		Dot:  fixed.P(x, y+face.Ascent),
+	}
+	d.DrawString(name)
+}
@@ -0,0 +1,131 @@
+package generator
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// perlin is two dimensional gradient noise, about -1 to 1, with a
+// permutation drawn from a seed.
+type perlin struct {
+	perm [512]uint8
+}
+
+func newPerlin(seed, stream uint64) *perlin {
+	p := &perlin{}
+	for i, v := range rand.New(rand.NewPCG(seed, stream)).Perm(256) {
+		p.perm[i] = uint8(v)
+		p.perm[i+256] = uint8(v)
+	}
+	return p
+}
+
+func (p *perlin) at(x, y float64) float64 {
+	fx, fy := math.Floor(x), math.Floor(y)
+	xi, yi := int(fx)&255, int(fy)&255
+	x, y = x-fx, y-fy
+	u, v := fade(x), fade(y)
+
+	aa := p.perm[int(p.perm[xi])+yi]
+	ab := p.perm[int(p.perm[xi])+yi+1]
+	ba := p.perm[int(p.perm[xi+1])+yi]
+	bb := p.perm[int(p.perm[xi+1])+yi+1]
+	n := lerp(v,
+		lerp(u, gradient(aa, x, y), gradient(ba, x-1, y)),
+		lerp(u, gradient(ab, x, y-1), gradient(bb, x-1, y-1)))
+	// Gradient noise in 2D stays within ±√½
+	return n * math.Sqrt2
+}
+
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerp(t, a, b float64) float64 {
+	return a + t*(b-a)
+}
+
+// gradient returns the dot product of (x, y) with one of eight unit
+// directions picked by the hash.
+func gradient(hash uint8, x, y float64) float64 {
+	const d = math.Sqrt2 / 2
+	switch hash & 7 {
+	case 0:
+		return x
+	case 1:
+		return -x
+	case 2:
+		return y
+	case 3:
+		return -y
+	case 4:
+		return d * (x + y)
+	case 5:
+		return d * (x - y)
+	case 6:
+		return d * (-x + y)
+	}
+	return d * (-x - y)
+}
+
+// distortion is a smooth field of displacements, in cells, for warping a
+// cell grid.
+type distortion struct {
+	dx, dy    *perlin
+	amplitude float64
+	frequency float64
+}
+
+// distortSeed separates the distortion noise from the noise and edge
+// passes, which share the effect seed.
+const distortSeed = 0x64697374
+
+// newDistortion returns the -distort displacement field, or nil if it is
+// off. The features of the field are several times wider than the largest
+// displacement so that shapes bend rather than break apart.
+func newDistortion(cfg *config.Config, seed uint64) *distortion {
+	if cfg.Distort <= 0 {
+		return nil
+	}
+	amplitude := float64(cfg.Distort)
+	return &distortion{
+		dx:        newPerlin(seed^distortSeed, 0),
+		dy:        newPerlin(seed^distortSeed, 1),
+		amplitude: amplitude,
+		frequency: 1 / max(8, 4*amplitude),
+	}
+}
+
+// offset returns the displacement of the cell at (x, y), the sum of two
+// octaves of noise.
+func (d *distortion) offset(x, y int) (dx, dy int) {
+	fx, fy := float64(x)*d.frequency, float64(y)*d.frequency
+	nx := (d.dx.at(fx, fy) + 0.5*d.dx.at(2*fx, 2*fy)) / 1.5
+	ny := (d.dy.at(fx, fy) + 0.5*d.dy.at(2*fx, 2*fy)) / 1.5
+	return int(math.Round(nx * d.amplitude)), int(math.Round(ny * d.amplitude))
+}
+
+// distortGrid warps the cells of g with the -distort displacement field.
+// Cells are only moved, never recolored, so the palette ratios are kept on
+// average. The grid wraps at its edges like the rendered pattern.
+func distortGrid(cfg *config.Config, g *cellGrid) {
+	d := newDistortion(cfg, g.effectSeed)
+	if d == nil {
+		return
+	}
+	src := g.cells
+	dst := newIndexGrid(src.cols, src.rows)
+	parallelRows(src.rows, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := dst.row(y)
+			for x := range row {
+				dx, dy := d.offset(x, y)
+				row[x] = src.at(((x+dx)%src.cols+src.cols)%src.cols, ((y+dy)%src.rows+src.rows)%src.rows)
+			}
+		}
+	})
+	src.release()
+	g.cells = dst
+}
@@ -0,0 +1,267 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+const (
+	// maxNameLength is the longest palette or image name used in a filename.
+	maxNameLength = 64
+	// maxFileColors is the most palette colors listed in a filename.
+	maxFileColors = 8
+)
+
+// SanitizeName makes a palette or image name safe to use in a filename.
+// Anything other than letters, digits, dots, dashes and underscores becomes
+// an underscore.
+func SanitizeName(name string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range name {
+		ok := r < 128 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-')
+		if ok {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+
+	s := strings.Trim(b.String(), "_.")
+	if len(s) > maxNameLength {
+		s = strings.TrimRight(s[:maxNameLength], "_.")
+	}
+	if s == "" {
+		return "unnamed"
+	}
+	return s
+}
+
+// colorList joins color codes for a filename, listing at most maxFileColors
+// and counting the rest. With short names the list is replaced by a hash of
+// all the colors.
+func colorList(cfg *config.Config, codes []string) string {
+	if cfg.ShortNames {
+		sum := sha256.Sum256([]byte(strings.ToLower(strings.Join(codes, ","))))
+		return hex.EncodeToString(sum[:4])
+	}
+	if len(codes) <= maxFileColors {
+		return strings.Join(codes, "_")
+	}
+	return fmt.Sprintf("%s_%dmore", strings.Join(codes[:maxFileColors], "_"), len(codes)-maxFileColors)
+}
+
+// PatternPath returns the path in dir that the output of the palette job
+// at index is saved to.
+func PatternPath(cfg *config.Config, camo config.CamoColors, index int, dir string) (string, error) {
+	colorCodes := make([]string, len(camo.Colors))
+	for i, hex := range camo.Colors {
+		colorCodes[i] = strings.TrimPrefix(hex, "#")
+	}
+	fields := FileNameFields{
+		Index:  fmt.Sprintf("%03d", index),
+		Name:   SanitizeName(camo.Name),
+		Colors: colorList(cfg, colorCodes),
+		Type:   cfg.PatternType,
+		W:      cfg.Width,
+		H:      cfg.Height,
+		Seed:   fmt.Sprintf("%016x", cfg.Seed),
+	}
+	fileName := fmt.Sprintf("gocamo_%s_%s_%s_%s_w%dx%d_s%s",
+		fields.Index, fields.Name, fields.Colors, fields.Type, fields.W, fields.H, fields.Seed)
+	return outputPath(cfg, dir, fileName, fields, outputFormat(cfg))
+}
+
+// ImagePatternPath returns the path in dir that the output of the image job
+// at index is saved to, given the main colors found in the reference image
+// at imagePath as hex codes without #. If colors is nil they aren't known
+// yet and are shown as a *.
+func ImagePatternPath(cfg *config.Config, imagePath string, colors []string, index int, dir string) (string, error) {
+	list := "*"
+	if colors != nil {
+		list = colorList(cfg, colors)
+	}
+	baseName := filepath.Base(imagePath)
+	fields := FileNameFields{
+		Index:  fmt.Sprintf("%03d", index),
+		Name:   SanitizeName(strings.TrimSuffix(baseName, filepath.Ext(baseName))),
+		Colors: list,
+		Type:   "image",
+		W:      cfg.Width,
+		H:      cfg.Height,
+		Seed:   fmt.Sprintf("%016x", cfg.Seed),
+	}
+	fileName := fmt.Sprintf("gocamo_from_image_%s_%s_%s_k%d_w%dx%d_s%s",
+		fields.Name, fields.Index, fields.Colors, cfg.KValue, fields.W, fields.H, fields.Seed)
+	return outputPath(cfg, dir, fileName, fields, "png")
+}
+
+// ErrOutputExists is returned with the path of an output that already
+// exists when -skip-existing is set.
+var ErrOutputExists = errors.New("output file already exists")
+
+// outputPath returns the path in dir for an output named by the
+// -name-template, or fileName if there is none. An existing file there is
+// kept by moving to a free path, unless -force overwrites it or
+// -skip-existing skips the job, in which case the path is returned with
+// ErrOutputExists.
+func outputPath(cfg *config.Config, dir, fileName string, fields FileNameFields, ext string) (string, error) {
+	if cfg.NameTemplate != "" {
+		name, err := templateName(cfg.NameTemplate, fields)
+		if err != nil {
+			return "", err
+		}
+		fileName = name
+	}
+	filePath := filepath.Join(dir, fileName+"."+ext)
+	switch {
+	case cfg.Force:
+		return filePath, nil
+	case cfg.SkipExisting:
+		if outputExists(filePath) || !reserve(filePath) {
+			return filePath, ErrOutputExists
+		}
+		return filePath, nil
+	}
+	return uniquePath(filePath), nil
+}
+
+// outputExists reports whether a file exists at filePath. A * in the name,
+// which stands for image colors not known yet, matches any colors.
+func outputExists(filePath string) bool {
+	if name := filepath.Base(filePath); strings.Contains(name, "*") {
+		entries, _ := os.ReadDir(utils.LongPath(filepath.Dir(filePath)))
+		for _, e := range entries {
+			if ok, _ := filepath.Match(name, e.Name()); ok {
+				return true
+			}
+		}
+		return false
+	}
+	_, err := os.Lstat(utils.LongPath(filePath))
+	return err == nil
+}
+
+// reserved holds the paths uniquePath has handed out. Outputs are only
+// written once rendered, so without it jobs whose names collide would all
+// find the same path free.
+var reserved = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// reserve reserves filePath for the caller, reporting false if another job
+// has it.
+func reserve(filePath string) bool {
+	reserved.Lock()
+	defer reserved.Unlock()
+	if reserved.paths[filePath] {
+		return false
+	}
+	reserved.paths[filePath] = true
+	return true
+}
+
+// ReleasePath gives up the reservation of filePath by a job that failed to
+// write it, so the job can take the same path when it is tried again.
+func ReleasePath(filePath string) {
+	reserved.Lock()
+	defer reserved.Unlock()
+	delete(reserved.paths, filePath)
+}
+
+// uniquePath returns filePath, or if a file already exists there or another
+// job has the path, the first free path with a numeric suffix added before
+// the extension, so earlier results are never overwritten. The path is
+// reserved for the caller.
+func uniquePath(filePath string) string {
+	reserved.Lock()
+	defer reserved.Unlock()
+	ext := filepath.Ext(filePath)
+	base := strings.TrimSuffix(filePath, ext)
+	candidate := filePath
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(utils.LongPath(candidate)); os.IsNotExist(err) && !reserved.paths[candidate] {
+			reserved.paths[candidate] = true
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%d%s", base, n, ext)
+	}
+}
+
+// fileNamePattern matches the name RenderPattern gives an output: job
+// number, palette name and colors, pattern type, size and seed, with the
+// suffix uniquePath adds to keep earlier results.
+var fileNamePattern = regexp.MustCompile(`^gocamo_(\d{3,})_(.+)_(box|blob|pat6|hex|composite)_w(\d+)x(\d+)_s([0-9a-f]{16})(?:_\d+)?\.[a-z]+$`)
+
+// hexCode matches a color code as listed in a filename: any length the
+// palette accepts, short forms and RRGGBBAA codes included.
+var hexCode = regexp.MustCompile(`^(?:[0-9a-fA-F]{3,4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+// ParseFileName recovers what it can of the metadata of an output from its
+// file name, for outputs without embedded metadata such as those of older
+// versions or in other formats. Only the pattern type, size, palette, seed
+// and job number are in the name, so other settings are left at their
+// defaults. It fails for image patterns, whose reference image isn't
+// recorded, for composite patterns, whose layers aren't, and for names
+// that don't list every palette color.
+func ParseFileName(name string) (*Metadata, error) {
+	m := fileNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		if strings.HasPrefix(name, "gocamo_from_image_") {
+			return nil, fmt.Errorf("%s is an image pattern, which needs its reference image from the embedded metadata", name)
+		}
+		return nil, fmt.Errorf("%s does not follow the gocamo file name format", name)
+	}
+	if m[3] == "composite" {
+		return nil, fmt.Errorf("%s is a composite pattern, which needs its layers from the embedded metadata", name)
+	}
+	index, _ := strconv.Atoi(m[1])
+	seed, _ := strconv.ParseUint(m[6], 16, 64)
+
+	// The palette name may contain underscores, so the colors are the
+	// codes at the end
+	parts := strings.Split(m[2], "_")
+	start := len(parts)
+	for start > 1 && (hexCode.MatchString(parts[start-1]) || utils.IsTransparent(parts[start-1])) {
+		start--
+	}
+
+	// A palette has at least two colors, and a -short-names hash can look
+	// like a single RRGGBBAA code
+	if len(parts)-start < 2 {
+		if len(parts) > 1 && strings.HasSuffix(parts[len(parts)-1], "more") {
+			return nil, fmt.Errorf("%s lists only the first %d palette colors", name, maxFileColors)
+		}
+		return nil, fmt.Errorf("%s does not list its palette colors (made with -short-names?)", name)
+	}
+	colors := make([]string, 0, len(parts)-start)
+	for _, code := range parts[start:] {
+		if !utils.IsTransparent(code) {
+			code = "#" + strings.ToLower(code)
+		}
+		colors = append(colors, code)
+	}
+
+	return &Metadata{
+		Name:    strings.Join(parts[:start], "_"),
+		Pattern: m[3],
+		Colors:  colors,
+		Args:    []string{"-t", m[3], "-w", m[4], "-h", m[5], "-c", strings.Join(colors, ",")},
+		Seed:    seed,
+		Index:   index,
+	}, nil
+}
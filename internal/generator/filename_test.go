@@ -0,0 +1,122 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+func TestParseFileNameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		camo     config.CamoColors
+		pattern  string
+		index    int
+		existing bool
+		want     Metadata
+	}{
+		{
+			name:    "plain",
+			camo:    config.CamoColors{Name: "woodland", Colors: []string{"#5e8553", "#5c4f42", "#333330"}},
+			pattern: "box",
+			want:    Metadata{Name: "woodland", Colors: []string{"#5e8553", "#5c4f42", "#333330"}},
+		},
+		{
+			name:    "underscores in the name",
+			camo:    config.CamoColors{Name: "desert_night ops", Colors: []string{"#C2B280", "#8B7D6B"}},
+			pattern: "blob",
+			index:   12,
+			want:    Metadata{Name: "desert_night_ops", Colors: []string{"#c2b280", "#8b7d6b"}},
+		},
+		{
+			name:    "short and alpha colors",
+			camo:    config.CamoColors{Name: "night", Colors: []string{"#abc", "#1234", "#11223344", "none"}},
+			pattern: "hex",
+			index:   1234,
+			want:    Metadata{Name: "night", Colors: []string{"#abc", "#1234", "#11223344", "none"}},
+		},
+		{
+			name:     "suffix for an existing file",
+			camo:     config.CamoColors{Name: "urban", Colors: []string{"#808080", "#404040"}},
+			pattern:  "pat6",
+			index:    3,
+			existing: true,
+			want:     Metadata{Name: "urban", Colors: []string{"#808080", "#404040"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{PatternType: tt.pattern, Width: 640, Height: 480, Seed: 0x4f2a9c1e7b3d5086}
+			dir := t.TempDir()
+			if tt.existing {
+				first, err := PatternPath(cfg, tt.camo, tt.index, dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(first, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path, err := PatternPath(cfg, tt.camo, tt.index, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := filepath.Base(path)
+			if tt.existing && !strings.HasSuffix(name, "_2.png") {
+				t.Fatalf("PatternPath() = %s, want a _2 suffix", name)
+			}
+
+			got, err := ParseFileName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			want.Pattern = tt.pattern
+			want.Seed = cfg.Seed
+			want.Index = tt.index
+			want.Args = []string{"-t", tt.pattern, "-w", "640", "-h", "480", "-c", strings.Join(want.Colors, ",")}
+			if !reflect.DeepEqual(*got, want) {
+				t.Fatalf("ParseFileName(%s) = %+v, want %+v", name, *got, want)
+			}
+
+			// Regenerating from the parsed metadata names the output the same
+			regen := &config.Config{PatternType: got.Pattern, Seed: got.Seed}
+			regen.Width, _ = strconv.Atoi(got.Args[3])
+			regen.Height, _ = strconv.Atoi(got.Args[5])
+			again, err := PatternPath(regen, config.CamoColors{Name: got.Name, Colors: got.Colors}, got.Index, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantName := strings.Replace(name, "_2.png", ".png", 1)
+			if !strings.EqualFold(filepath.Base(again), wantName) {
+				t.Errorf("regenerated name %s, want %s", filepath.Base(again), wantName)
+			}
+		})
+	}
+}
+
+func TestParseFileNameErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr string
+	}{
+		{"photo.png", "does not follow the gocamo file name format"},
+		{"gocamo_001_woodland_5e8553_5c4f42_box_w640x480_s4f2a9c1e.png", "does not follow the gocamo file name format"},
+		{"gocamo_from_image_forest_001_5e8553_5c4f42_k4_w640x480_s4f2a9c1e7b3d5086.png", "is an image pattern"},
+		{"gocamo_001_woodland_5e8553_5c4f42_composite_w640x480_s4f2a9c1e7b3d5086.png", "is a composite pattern"},
+		{"gocamo_001_woodland_0a1b2c3d_box_w640x480_s4f2a9c1e7b3d5086.png", "does not list its palette colors"},
+		{"gocamo_001_many_000001_000002_000003_000004_000005_000006_000007_000008_2more_box_w640x480_s4f2a9c1e7b3d5086.png",
+			"lists only the first 8 palette colors"},
+	}
+	for _, tt := range tests {
+		_, err := ParseFileName(tt.name)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseFileName(%s) = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package generator
+
+import (
+	"image/color"
+	"math"
+)
+
+// Lookup tables for converting between sRGB bytes and linear light, so that
+// colors are mixed in linear light rather than on gamma encoded values,
+// which makes blends come out too dark.
+var (
+	srgbToLinear [256]float32
+	linearToSRGB [4096]uint8
+)
+
+func init() {
+	for i := range srgbToLinear {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		srgbToLinear[i] = float32(v)
+	}
+	for i := range linearToSRGB {
+		v := float64(i) / float64(len(linearToSRGB)-1)
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		linearToSRGB[i] = uint8(math.Round(v * 255))
+	}
+}
+
+// toSRGB encodes a linear light value, clamping it to [0, 1].
+func toSRGB(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	return linearToSRGB[int(v*float32(len(linearToSRGB)-1)+0.5)]
+}
+
+// mixLinear returns the average of two sRGB values mixed in linear light.
+func mixLinear(a, b uint8) uint8 {
+	return toSRGB((srgbToLinear[a] + srgbToLinear[b]) / 2)
+}
+
+// edgeStops is the exposure change in stops for each step of edge
+// variation. The default ±20 step range gives about ±0.46 stops, the same
+// spread as ±20 sRGB levels around mid grey, but proportional to the
+// brightness of the pixel so dark colors are not washed out.
+const edgeStops = 0.0233
+
+// varyLinear brightens or darkens an sRGB value in linear light by the given
+// number of variation steps.
+func varyLinear(v uint8, steps int) uint8 {
+	return toSRGB(srgbToLinear[v] * edgeFactors[steps+100])
+}
+
+// edgeFactors holds the linear light multiplier for each step from -100 to
+// 100, the range of -edge-strength.
+var edgeFactors = func() (f [201]float32) {
+	for i := range f {
+		f[i] = float32(math.Exp2(float64(i-100) * edgeStops))
+	}
+	return f
+}()
+
+// luminance returns the relative luminance of c.
+func luminance(c color.NRGBA) float64 {
+	return 0.2126*float64(srgbToLinear[c.R]) + 0.7152*float64(srgbToLinear[c.G]) + 0.0722*float64(srgbToLinear[c.B])
+}
+
+// ExtremeColors returns the palette indices of the darkest and lightest
+// opaque colors. ok is false if the palette has no opaque colors.
+func ExtremeColors(colors []color.NRGBA) (dark, light int, ok bool) {
+	dark, light = -1, -1
+	for i, c := range colors {
+		if c.A != 255 {
+			continue
+		}
+		if dark < 0 || luminance(c) < luminance(colors[dark]) {
+			dark = i
+		}
+		if light < 0 || luminance(c) > luminance(colors[light]) {
+			light = i
+		}
+	}
+	return dark, light, dark >= 0
+}
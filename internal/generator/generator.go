@@ -5,118 +5,581 @@ import (
 	"fmt"
 	"image"
 	"image/color"
-	"math/rand"
+	"io"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/bradsec/gocamo/internal/analysis"
+	"github.com/bradsec/gocamo/internal/pngmeta"
+	"github.com/bradsec/gocamo/internal/stego"
 	"github.com/bradsec/gocamo/internal/utils"
 	"github.com/bradsec/gocamo/pkg/config"
 )
 
 type Generator interface {
-	Generate(ctx context.Context, cfg *config.Config, colors []color.RGBA) (image.Image, error)
+	Generate(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (image.Image, error)
 }
 
-func GeneratePattern(ctx context.Context, cfg *config.Config, camo config.CamoColors, index int, outputPath string) error {
-	if len(camo.Colors) == 0 {
-		return fmt.Errorf("no colors provided in color palette")
+// Output describes a pattern image saved by one of the generate functions.
+type Output struct {
+	FilePath string
+	Name     string
+	Colors   []string
+	// Pattern is the pattern type
+	Pattern string
+	// Width and Height are the image size in pixels
+	Width, Height int
+	// PixelSize is the base pixel size the pattern was rendered with, after
+	// any adjustment to fit the image
+	PixelSize int
+	Metrics   *analysis.Metrics
+	// Coverage is the share of the output covered by each color in Colors,
+	// measured when the frame is saved with -verbose, -score or a manifest.
+	// Target is the share each color was meant to cover, or nil for image
+	// patterns.
+	Coverage []float64
+	Target   []float64
+	// Metadata is embedded in the PNG to explain or regenerate it
+	Metadata *Metadata
+}
+
+// Frame is a rendered pattern waiting to be encoded. Rendering and encoding
+// are separate steps so that encoding one frame can overlap rendering the
+// next.
+type Frame struct {
+	Image  image.Image
+	Output *Output
+	// layers holds the layers recorded for -debug-layers and -ora
+	layers *layerRecorder
+	// palette is the colors the pattern was drawn with, in Output.Colors order
+	palette []color.NRGBA
+}
+
+// Save encodes the frame to its output file and releases the image buffer.
+// Frames rendered by the banded pipeline are already written. If an extra
+// output fails the output file is removed again, so the job can be run
+// again under the same name.
+func (f *Frame) Save(cfg *config.Config) (*Output, error) {
+	if f.Image == nil {
+		return f.Output, nil
 	}
+	defer f.Release()
 
-	colors, err := utils.HexToRGBA(camo.Colors)
+	if err := saveImageToFile(f.Image, f.Output.FilePath, cfg.Force, encodeOptions(cfg), f.Output.Metadata.texts()...); err != nil {
+		return nil, fmt.Errorf("error saving image %s: %w", f.Output.FilePath, err)
+	}
+	if err := f.saveExtras(cfg); err != nil {
+		os.Remove(utils.LongPath(f.Output.FilePath))
+		return nil, err
+	}
 
-	if err != nil {
-		return fmt.Errorf("error converting hex to RGBA: %w", err)
+	if cfg.MetricsFile != "" || cfg.ScoreFile != "" {
+		metrics := analysis.Analyze(f.Image)
+		f.Output.Metrics = &metrics
 	}
 
-	var gen Generator
-	switch cfg.PatternType {
-	case "blob":
-		gen = &BlobGenerator{}
-	case "box":
-		gen = &BoxGenerator{}
-	default:
-		return fmt.Errorf("unknown pattern type: %s", cfg.PatternType)
+	if (cfg.Verbose || cfg.ScoreFile != "" || cfg.Manifest) && f.palette != nil {
+		f.Output.Coverage = colorCoverage(f.Image, f.palette)
+	}
+	return f.Output, nil
+}
+
+// Write encodes the frame to w in place of its output file, for -o -. The
+// extra outputs made by Save don't apply.
+func (f *Frame) Write(cfg *config.Config, w io.Writer) error {
+	if f.Image == nil {
+		return fmt.Errorf("%s was saved to %s instead of being kept in memory", f.Output.Name, f.Output.FilePath)
+	}
+	defer f.Release()
+	return writeImage(w, f.Image, encodeOptions(cfg), f.Output.Metadata.texts()...)
+}
+
+// saveExtras writes the outputs made from the frame besides the pattern
+// itself.
+func (f *Frame) saveExtras(cfg *config.Config) error {
+	if cfg.Mipmaps {
+		if err := saveMipmaps(cfg, f.Image, f.Output.FilePath); err != nil {
+			return err
+		}
 	}
 
-	img, err := gen.Generate(ctx, cfg, colors)
+	if f.layers != nil {
+		if err := f.layers.save(cfg, f.Image, f.Output.FilePath); err != nil {
+			return err
+		}
+	}
+
+	if cfg.CMYK {
+		if err := saveCMYK(cfg, f.Image, f.Output.FilePath); err != nil {
+			return err
+		}
+	}
+
+	if cfg.CVD {
+		if err := saveCVD(cfg, f.Image, f.Output.FilePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release returns the frame's image buffer for reuse without saving it.
+func (f *Frame) Release() {
+	if f.Image != nil {
+		putNRGBA(f.Image)
+		f.Image = nil
+	}
+}
+
+// embedPayload hides cfg.Payload in the image's least significant bits.
+func embedPayload(cfg *config.Config, img image.Image) (image.Image, error) {
+	dst := toNRGBA(img)
+	if err := stego.Embed(dst, []byte(cfg.Payload)); err != nil {
+		return nil, fmt.Errorf("error embedding payload: %w", err)
+	}
+	return dst, nil
+}
+
+func RenderPattern(ctx context.Context, cfg *config.Config, camo config.CamoColors, index int, outputPath string) (_ *Frame, err error) {
+	colors, err := paletteColors(cfg, camo)
 	if err != nil {
-		return fmt.Errorf("error generating pattern: %w", err)
+		return nil, err
+	}
+	gen, err := newGenerator(cfg.PatternType)
+	if err != nil {
+		return nil, err
 	}
 
 	colorCodes := make([]string, len(camo.Colors))
 	for i, hex := range camo.Colors {
 		colorCodes[i] = strings.TrimPrefix(hex, "#")
 	}
-	colorCodesStr := strings.Join(colorCodes, "_")
+	filePath, err := PatternPath(cfg, camo, index, outputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			ReleasePath(filePath)
+		}
+	}()
+
+	pixelSize, _ := PixelSize(cfg)
+	out := &Output{
+		FilePath:  filePath,
+		Name:      camo.Name,
+		Colors:    colorCodes,
+		Pattern:   cfg.PatternType,
+		Width:     cfg.Width,
+		Height:    cfg.Height,
+		PixelSize: pixelSize,
+		Target:    targetCoverage(cfg, len(colors)),
+		Metadata:  newMetadata(cfg, camo.Name, camo.Colors, index),
+	}
+
+	if cfg.Format == "svg" {
+		b, ok := gen.(gridBuilder)
+		if !ok {
+			return nil, fmt.Errorf("pattern type %s does not support SVG output", cfg.PatternType)
+		}
+		if err := generateSVG(ctx, cfg, jobRand(cfg, index), b, colors, filePath); err != nil {
+			return nil, err
+		}
+		return &Frame{Output: out}, nil
+	}
 
-	fileName := fmt.Sprintf("gocamo_%03d_%s_%s_%s_w%dx%d.png",
-		index, camo.Name, colorCodesStr, cfg.PatternType, cfg.Width, cfg.Height)
-	filePath := filepath.Join(outputPath, fileName)
+	if cfg.Banded {
+		b, ok := gen.(gridBuilder)
+		if !ok {
+			return nil, fmt.Errorf("pattern type %s does not support banded generation", cfg.PatternType)
+		}
+		if err := generateBanded(ctx, cfg, jobRand(cfg, index), b, colors, filePath, out.Metadata); err != nil {
+			return nil, err
+		}
+		return &Frame{Output: out}, nil
+	}
 
-	return saveImageToFile(img, filePath)
+	layers := newLayerRecorder(cfg)
+	if layers != nil {
+		ctx = withLayerRecorder(ctx, layers)
+	}
+
+	img, err := generatePattern(ctx, cfg, gen, colors, index)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Animate > 0 {
+		b, ok := gen.(gridBuilder)
+		if !ok {
+			putNRGBA(img)
+			return nil, fmt.Errorf("pattern type %s does not support animation", cfg.PatternType)
+		}
+		if err := saveAnimation(ctx, cfg, b, colors, index, filePath); err != nil {
+			putNRGBA(img)
+			return nil, err
+		}
+	}
+	return &Frame{Image: img, Output: out, layers: layers, palette: colors}, nil
 }
 
-func GenerateFromImage(ctx context.Context, cfg *config.Config, imagePath string, index int, outputPath string) error {
-	gen := &ImageGenerator{InputFile: imagePath}
+// Render generates the pattern of the job at index in memory, without
+// saving it. Banded generation and the extra outputs made by Frame.Save
+// don't apply.
+func Render(ctx context.Context, cfg *config.Config, camo config.CamoColors, index int) (image.Image, error) {
+	colors, err := paletteColors(cfg, camo)
+	if err != nil {
+		return nil, err
+	}
+	gen, err := newGenerator(cfg.PatternType)
+	if err != nil {
+		return nil, err
+	}
+	return generatePattern(ctx, cfg, gen, colors, index)
+}
+
+// outputFormat returns the file extension of the outputs.
+func outputFormat(cfg *config.Config) string {
+	switch cfg.Format {
+	case "":
+		return "png"
+	case "jpeg":
+		return "jpg"
+	}
+	return cfg.Format
+}
 
-	img, mainColors, err := gen.Generate(ctx, cfg, nil)
+// encodeOptions returns the encoder settings of the outputs.
+func encodeOptions(cfg *config.Config) utils.EncodeOptions {
+	return utils.EncodeOptions{Format: cfg.Format, Compression: cfg.Compression, Quality: cfg.Quality, ColorProfile: colorProfile(cfg)}
+}
 
-	// Sort the main colors
-	sortColors(mainColors)
+// pngOptions returns the encoder settings of extra PNG outputs such as
+// mipmaps and debug layers.
+func pngOptions(cfg *config.Config) utils.EncodeOptions {
+	return utils.EncodeOptions{Compression: cfg.Compression, ColorProfile: colorProfile(cfg)}
+}
+
+// CheckPalette returns the error RenderPattern would fail with for the
+// colors of camo, if any.
+func CheckPalette(cfg *config.Config, camo config.CamoColors) error {
+	_, err := paletteColors(cfg, camo)
+	return err
+}
+
+// paletteColors parses the palette, clearing the -alpha-color.
+func paletteColors(cfg *config.Config, camo config.CamoColors) ([]color.NRGBA, error) {
+	if len(camo.Colors) == 0 {
+		return nil, fmt.Errorf("no colors provided in color palette")
+	}
+
+	colors, err := utils.HexToRGBA(camo.Colors)
+	if err != nil {
+		return nil, fmt.Errorf("error converting hex to RGBA: %w", err)
+	}
+
+	if cfg.AlphaColor != "" {
+		if err := makeTransparent(colors, cfg.AlphaColor); err != nil {
+			return nil, err
+		}
+	}
+	return colors, nil
+}
+
+// PatternTypes are the palette based pattern types, in the order -t all
+// makes them.
+var PatternTypes = []string{"box", "blob", "pat6", "hex"}
+
+func newGenerator(patternType string) (Generator, error) {
+	switch patternType {
+	case "blob":
+		return &BlobGenerator{}, nil
+	case "box":
+		return &BoxGenerator{}, nil
+	case "pat6":
+		return &Pat6Generator{}, nil
+	case "hex":
+		return &HexGenerator{}, nil
+	case "composite":
+		return &CompositeGenerator{}, nil
+	}
+	return nil, fmt.Errorf("unknown pattern type: %s", patternType)
+}
+
+// generatePattern generates the pattern and applies the mask, UV layout and
+// payload.
+func generatePattern(ctx context.Context, cfg *config.Config, gen Generator, colors []color.NRGBA, index int) (image.Image, error) {
+	rng := jobRand(cfg, index)
+	img, err := gen.Generate(ctx, cfg, rng, colors)
+	if err != nil {
+		return nil, fmt.Errorf("error generating pattern: %w", err)
+	}
+	if cfg.Mask != "" {
+		if img, err = maskPattern(ctx, cfg, rng, colors, img); err != nil {
+			return nil, err
+		}
+	}
+	return finishImage(cfg, img)
+}
+
+// finishImage applies the UV layout and embeds the payload.
+func finishImage(cfg *config.Config, img image.Image) (image.Image, error) {
+	var err error
+	if cfg.UVTemplate != "" {
+		if img, err = applyUVLayout(cfg, img); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Payload != "" {
+		if img, err = embedPayload(cfg, img); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+func RenderFromImage(ctx context.Context, cfg *config.Config, imagePath string, index int, outputPath string) (*Frame, error) {
+	// The colors in the filename are only known after rendering, so a job
+	// is skipped if an output exists with any colors
+	if cfg.SkipExisting {
+		anyColors, err := ImagePatternPath(cfg, imagePath, nil, index, outputPath)
+		if err != nil {
+			return nil, err
+		}
+		ReleasePath(anyColors)
+	}
+
+	img, mainColors, err := RenderImage(ctx, cfg, imagePath, index)
+	if err != nil {
+		return nil, err
+	}
 
 	// Convert main colors to hex for filename
 	hexColors := make([]string, len(mainColors))
 	for i, c := range mainColors {
 		hexColors[i] = fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
 	}
-	colorCodesStr := strings.Join(hexColors, "_")
 
+	baseName := filepath.Base(imagePath)
+	filePath, err := ImagePatternPath(cfg, imagePath, hexColors, index, outputPath)
 	if err != nil {
-		return fmt.Errorf("error generating pattern from image %s: %w", imagePath, err)
+		putNRGBA(img)
+		return nil, err
 	}
 
-	baseName := filepath.Base(imagePath)
-	fileName := fmt.Sprintf("gocamo_from_image_%s_%03d_%s_k%d_w%dx%d.png",
-		strings.TrimSuffix(baseName, filepath.Ext(baseName)),
-		index, colorCodesStr, cfg.KValue, cfg.Width, cfg.Height)
-	filePath := filepath.Join(outputPath, fileName)
+	meta := newMetadata(cfg, baseName, nil, index)
+	meta.Source = imagePath
+	meta.Colors = hexColors
+	pixelSize, _ := PixelSize(cfg)
 
-	if err := saveImageToFile(img, filePath); err != nil {
-		return fmt.Errorf("error saving image %s: %w", filePath, err)
+	return &Frame{
+		Image: img,
+		Output: &Output{FilePath: filePath, Name: baseName, Colors: hexColors, Pattern: "image",
+			Width: cfg.Width, Height: cfg.Height, PixelSize: pixelSize, Metadata: meta},
+		palette: mainColors,
+	}, nil
+}
+
+// RenderImage generates the pattern of the job at index from a reference
+// image in memory, without saving it. It returns the main colors found in
+// the image, sorted.
+func RenderImage(ctx context.Context, cfg *config.Config, imagePath string, index int) (image.Image, []color.NRGBA, error) {
+	gen := &ImageGenerator{InputFile: imagePath}
+	img, mainColors, err := gen.Generate(ctx, cfg, jobRand(cfg, index), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating pattern from image %s: %w", imagePath, err)
 	}
+	sortColors(mainColors)
 
-	return nil
+	if img, err = finishImage(cfg, img); err != nil {
+		return nil, nil, err
+	}
+	return img, mainColors, nil
+}
+
+// ExtractColors finds the main colors of img the way -t image does, at the
+// configured size and base pixel size, without rendering a pattern. The
+// colors are sorted and returned with the share of the image closest to
+// each.
+func ExtractColors(ctx context.Context, cfg *config.Config, img image.Image, index int) ([]color.NRGBA, []float64, error) {
+	if err := checkDimensions(cfg); err != nil {
+		return nil, nil, err
+	}
+	pixelSize, _ := fitPixelSize(cfg, 1)
+	cells, mainColors, err := clusterImage(ctx, cfg, jobRand(cfg, index), img, pixelSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	sortColors(mainColors)
+	return mainColors, colorShares(cells.pix, mainColors), nil
+}
+
+// environmentSize is the longest side, in pixels, ExtractEnvironmentColors
+// scales each image to.
+const environmentSize = 1024
+
+// ExtractEnvironmentColors finds the main colors of a set of images taken
+// together, such as photos of one environment, with the -k, -b,
+// -kmeans-batch, -scaler and -seed settings of cfg. Each image is scaled to
+// the same size first, so every photo counts the same whatever its
+// resolution, and only one image is held in memory at a time. The colors
+// are sorted and returned with the share of all the images closest to each.
+func ExtractEnvironmentColors(ctx context.Context, cfg *config.Config, paths []string) ([]color.NRGBA, []float64, error) {
+	scaler, ok := Scalers[cfg.Scaler]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown scaler: %s", cfg.Scaler)
+	}
+	var points [][3]float64
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		img, err := utils.LoadImage(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load %s: %w", path, err)
+		}
+		b := img.Bounds()
+		w, h := environmentSize, environmentSize
+		if b.Dx() > b.Dy() {
+			h = max(1, b.Dy()*environmentSize/b.Dx())
+		} else {
+			w = max(1, b.Dx()*environmentSize/b.Dy())
+		}
+		points = append(points, imageCells(scaleImage(img, w, h, scaler), cfg.BasePixelSize).pix...)
+	}
+
+	mainColors, err := clusterCells(ctx, cfg, jobRand(cfg, 0), points)
+	if err != nil {
+		return nil, nil, err
+	}
+	sortColors(mainColors)
+	return mainColors, colorShares(points, mainColors), nil
+}
+
+// colorShares returns the share of points closest to each of colors.
+func colorShares(points [][3]float64, colors []color.NRGBA) []float64 {
+	labs := labColors(colors)
+	shares := make([]float64, len(colors))
+	for _, lab := range points {
+		shares[closestLab(lab, labs)]++
+	}
+	for i := range shares {
+		shares[i] /= float64(len(points))
+	}
+	return shares
 }
 
-func saveImageToFile(img image.Image, filePath string) error {
-	f, err := os.Create(filePath)
+// bandBytes is the approximate size of each strip rendered by the banded
+// pipeline.
+const bandBytes = 32 << 20
+
+// generateBanded renders and encodes the pattern one horizontal strip at a
+// time so that peak memory is bounded by the strip size rather than the full
+// image. With -blend each strip is rendered with the rows within the blend
+// radius above and below it, so the feathering matches that of the whole
+// image across the seams.
+func generateBanded(ctx context.Context, cfg *config.Config, rng *rand.Rand, b gridBuilder, colors []color.NRGBA, filePath string, meta *Metadata) error {
+	g, err := b.buildGrid(ctx, cfg, rng, colors)
+	if err != nil {
+		return fmt.Errorf("error generating pattern: %w", err)
+	}
+	defer g.release()
+
+	if err := finishGrid(ctx, cfg, g); err != nil {
+		return err
+	}
+
+	f, err := utils.CreateAtomic(filePath, cfg.Force)
 	if err != nil {
 		return fmt.Errorf("error creating file: %w", err)
 	}
 	defer f.Close()
 
-	if err := utils.SaveImage(img, f); err != nil {
+	pw, err := utils.NewPNGStreamWriter(pngmeta.NewProfileWriter(f, colorProfile(cfg), meta.texts()...), cfg.Width, cfg.Height, !utils.HasTransparent(colors), cfg.Compression)
+	if err != nil {
+		return fmt.Errorf("error saving image: %w", err)
+	}
+
+	bandRows := max(1, min(cfg.Height, bandBytes/(cfg.Width*4)))
+	halo := max(cfg.Blend, 0)
+	strip := getNRGBA(cfg.Width, min(cfg.Height, bandRows+2*halo))
+	defer putNRGBA(strip)
+
+	for y0 := 0; y0 < cfg.Height; y0 += bandRows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rows := min(bandRows, cfg.Height-y0)
+		top, bottom := max(0, y0-halo), min(cfg.Height, y0+rows+halo)
+		g.renderBand(cfg, strip.SubImage(image.Rect(0, 0, cfg.Width, bottom-top)).(*image.NRGBA), top)
+		band := strip.SubImage(image.Rect(0, y0-top, cfg.Width, y0-top+rows)).(*image.NRGBA)
+		if err := pw.WriteRows(band); err != nil {
+			return fmt.Errorf("error saving image: %w", err)
+		}
+	}
+
+	if err := pw.Close(); err != nil {
 		return fmt.Errorf("error saving image: %w", err)
 	}
+	return f.Commit()
+}
 
+// jobRand returns the random source for the job at index. Each job draws from
+// its own PCG stream derived from the run seed, so jobs need no shared lock
+// and produce the same pattern regardless of which worker runs them.
+func jobRand(cfg *config.Config, index int) *rand.Rand {
+	return rand.New(rand.NewPCG(cfg.Seed, uint64(index)))
+}
+
+// makeTransparent clears every palette color equal to hex to full
+// transparency.
+func makeTransparent(colors []color.NRGBA, hex string) error {
+	alpha, err := utils.ParseColor(hex)
+	if err != nil {
+		return fmt.Errorf("invalid alpha color: %w", err)
+	}
+	for i, c := range colors {
+		if c == alpha {
+			colors[i] = color.NRGBA{}
+		}
+	}
 	return nil
 }
 
-func sortColors(colors []color.RGBA) {
+// saveImageToFile saves img in the format opts select. Text chunks are
+// only written to PNG files.
+func saveImageToFile(img image.Image, filePath string, overwrite bool, opts utils.EncodeOptions, texts ...pngmeta.Text) error {
+	f, err := utils.CreateAtomic(filePath, overwrite)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	defer f.Close()
+
+	if err := writeImage(f, img, opts, texts...); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// writeImage encodes img to w, with texts as PNG text chunks and the color
+// profile of opts.
+func writeImage(w io.Writer, img image.Image, opts utils.EncodeOptions, texts ...pngmeta.Text) error {
+	if opts.Format == "png" || opts.Format == "" {
+		w = pngmeta.NewProfileWriter(w, opts.ColorProfile, texts...)
+	}
+	if err := utils.SaveImage(img, w, opts); err != nil {
+		return fmt.Errorf("error saving image: %w", err)
+	}
+	return nil
+}
+
+func sortColors(colors []color.NRGBA) {
 	sort.Slice(colors, func(i, j int) bool {
 		iSum := int(colors[i].R) + int(colors[i].G) + int(colors[i].B)
 		jSum := int(colors[j].R) + int(colors[j].G) + int(colors[j].B)
 		return iSum < jSum
 	})
 }
-
-func shuffleColors(colors []color.RGBA) []color.RGBA {
-	shuffled := make([]color.RGBA, len(colors))
-	copy(shuffled, colors)
-	rand.Shuffle(len(shuffled), func(i, j int) {
-		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
-	})
-	return shuffled
-}
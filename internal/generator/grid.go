@@ -0,0 +1,308 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// MinDimension is the smallest supported image width or height in pixels.
+const MinDimension = 8
+
+// checkDimensions rejects canvases too small to hold a pattern.
+func checkDimensions(cfg *config.Config) error {
+	if cfg.Width < MinDimension || cfg.Height < MinDimension {
+		return fmt.Errorf("image dimensions %dx%d are too small (minimum is %dx%d)",
+			cfg.Width, cfg.Height, MinDimension, MinDimension)
+	}
+	return nil
+}
+
+// PixelSize returns the base pixel size the configured pattern is rendered
+// with, and a note describing any fallback from the requested size.
+func PixelSize(cfg *config.Config) (int, string) {
+	if cfg.PatternType == "blob" {
+		return fitPixelSize(cfg, blobScale)
+	}
+	return fitPixelSize(cfg, 1)
+}
+
+// GridSize returns the cell size in pixels and the number of cell columns
+// and rows the configured pattern is built on, counting cropped edge cells.
+func GridSize(cfg *config.Config) (cellSize, cols, rows int) {
+	pixelSize, _ := PixelSize(cfg)
+	cellSize = pixelSize
+	if cfg.PatternType == "blob" {
+		cellSize *= blobScale
+	}
+	return cellSize, cellsAcross(cfg.Width, cellSize), cellsAcross(cfg.Height, cellSize)
+}
+
+// fitPixelSize adjusts the base pixel size so that cells tile the image
+// exactly. Cells are scale times the base pixel size. Awkward dimensions
+// (e.g. primes) would force the size all the way down to 1 and give huge
+// grids, so the size is never reduced below half the requested size;
+// instead the requested size is kept and the cells at the right and bottom
+// edges are cropped. The size is also capped so that at least two cells fit
+// across the smaller dimension.
+func fitPixelSize(cfg *config.Config, scale int) (int, string) {
+	requested := max(cfg.BasePixelSize, 1)
+	size := requested
+	var note string
+
+	if limit := max(min(cfg.Width, cfg.Height)/(2*scale), 1); size > limit {
+		size = limit
+		note = fmt.Sprintf("base pixel size reduced from %d to %d to fit %dx%d", requested, size, cfg.Width, cfg.Height)
+	}
+
+	for s := size; s >= max(size/2, 1); s-- {
+		cell := s * scale
+		if cfg.Width%cell == 0 && cfg.Height%cell == 0 {
+			if s != size && note == "" {
+				note = fmt.Sprintf("base pixel size adjusted from %d to %d to divide %dx%d", requested, s, cfg.Width, cfg.Height)
+			}
+			return s, note
+		}
+	}
+
+	if note != "" {
+		return size, note + " with edge cells cropped"
+	}
+	return size, fmt.Sprintf("no base pixel size near %d divides %dx%d, edge cells are cropped", size, cfg.Width, cfg.Height)
+}
+
+// cellsAcross returns the number of cells of the given size needed to cover
+// length pixels, counting a cropped cell at the end.
+func cellsAcross(length, cell int) int {
+	return (length + cell - 1) / cell
+}
+
+// indexGrid is a flat row-major grid of palette indices.
+type indexGrid struct {
+	cols, rows int
+	cells      []int
+}
+
+// newIndexGrid returns a grid from the pool. Its cells are not cleared.
+func newIndexGrid(cols, rows int) *indexGrid {
+	return &indexGrid{cols: cols, rows: rows, cells: getCells(cols * rows)}
+}
+
+func (g *indexGrid) at(x, y int) int {
+	return g.cells[y*g.cols+x]
+}
+
+func (g *indexGrid) set(x, y, v int) {
+	g.cells[y*g.cols+x] = v
+}
+
+func (g *indexGrid) row(y int) []int {
+	return g.cells[y*g.cols : (y+1)*g.cols]
+}
+
+func (g *indexGrid) release() {
+	putCells(g.cells)
+	g.cells = nil
+}
+
+// mostCommonNeighbor returns the most frequent index within radius cells of
+// (x, y), wrapping at the grid edges. Ties are broken randomly. counts is
+// scratch space with one entry per palette color. If bias is not nil each
+// color's count is scaled by its bias.
+func (g *indexGrid) mostCommonNeighbor(rng *rand.Rand, x, y, radius int, counts []int, bias []float64) int {
+	clear(counts)
+	for dy := -radius; dy <= radius; dy++ {
+		row := g.row((y + dy + g.rows) % g.rows)
+		for dx := -radius; dx <= radius; dx++ {
+			counts[row[(x+dx+g.cols)%g.cols]]++
+		}
+	}
+	return mostCommon(rng, counts, bias, g.at(x, y))
+}
+
+// mostCommon returns the color with the highest count, scaled by its bias
+// if bias is not nil, breaking ties randomly. It returns current if every
+// count is zero.
+func mostCommon(rng *rand.Rand, counts []int, bias []float64, current int) int {
+	// Scan from a random starting color so ties don't favor low indices
+	n := len(counts)
+	start := rng.IntN(n)
+	maxScore, maxColor := 0.0, current
+	for i := 0; i < n; i++ {
+		color := (start + i) % n
+		if counts[color] == 0 {
+			continue
+		}
+		score := float64(counts[color])
+		if bias != nil {
+			score *= bias[color]
+		}
+		if score > maxScore || (score == maxScore && rng.Float32() < 0.3) {
+			maxScore, maxColor = score, color
+		}
+	}
+	return maxColor
+}
+
+// ratioBalance steers the cellular automaton towards the target color
+// ratios. Majority rules grow common colors at the expense of rare ones, so
+// each pass is repeated with every color's vote reweighted until the color
+// shares of the result are close to the target. A nil ratioBalance leaves
+// the votes unweighted and accepts every pass.
+type ratioBalance struct {
+	target []float64
+	bias   []float64
+	counts []int
+}
+
+const (
+	// balanceAttempts is the most times a pass is run to match the ratios.
+	balanceAttempts = 8
+	// balanceTolerance is how far a color's share may be from its target.
+	balanceTolerance = 0.01
+)
+
+func newRatioBalance(weights []float64) *ratioBalance {
+	if weights == nil {
+		return nil
+	}
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	b := &ratioBalance{
+		target: make([]float64, len(weights)),
+		bias:   make([]float64, len(weights)),
+		counts: make([]int, len(weights)),
+	}
+	for i, w := range weights {
+		b.target[i] = w / total
+		b.bias[i] = 1
+	}
+	return b
+}
+
+// weights returns the current vote multipliers, or nil for plain counts.
+func (b *ratioBalance) weights() []float64 {
+	if b == nil {
+		return nil
+	}
+	return b.bias
+}
+
+// settled reports whether the result g of a pass is close enough to the
+// target ratios to keep. Otherwise the vote multipliers are adjusted for the
+// pass to be run again.
+func (b *ratioBalance) settled(g *indexGrid, attempt int) bool {
+	if b == nil || attempt >= balanceAttempts-1 {
+		return true
+	}
+	clear(b.counts)
+	for _, c := range g.cells {
+		b.counts[c]++
+	}
+
+	done := true
+	for i, target := range b.target {
+		share := float64(b.counts[i]) / float64(len(g.cells))
+		if math.Abs(share-target) > balanceTolerance {
+			done = false
+		}
+	}
+	if done {
+		return true
+	}
+
+	for i, target := range b.target {
+		share := max(float64(b.counts[i])/float64(len(g.cells)), target/4)
+		if target == 0 {
+			b.bias[i] = 0
+		} else {
+			b.bias[i] *= math.Sqrt(target / share)
+		}
+	}
+	return false
+}
+
+// cellGrid is the intermediate result of the grid based generators: a grid
+// of palette indices rendered as cellSize×cellSize blocks. Keeping the grid
+// separate from rendering lets any band of rows be drawn on demand.
+type cellGrid struct {
+	cells         *indexGrid
+	cellSize      int
+	basePixelSize int
+	colors        []color.NRGBA
+	detail        *colorPicker
+	// effectSeed seeds the noise and edge passes so that they produce the
+	// same pixels however the image is split into bands.
+	effectSeed uint64
+}
+
+type gridBuilder interface {
+	buildGrid(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (*cellGrid, error)
+}
+
+// renderBand draws the rows of the pattern starting at y0 into img, which
+// holds a horizontal strip of the full image, and applies the post effects.
+// Feathering with -blend reads pixels up to the blend radius away, so banded
+// generation renders that many halo rows above and below each band and
+// writes only the rows in between, which then match the whole image.
+func (g *cellGrid) renderBand(cfg *config.Config, img *image.NRGBA, y0 int) {
+	renderGrid(img, y0, g.cells, g.cellSize, g.colors)
+
+	if cfg.Blend > 0 {
+		featherEdges(img, cfg.Blend, cfg.LegacyBlend)
+	}
+
+	if cfg.AddNoise {
+		addNoiseNRGBA(img, y0, g.effectSeed, g.colors, g.detail, cfg.NoiseChance(), cfg.LegacyBlend)
+	}
+
+	if cfg.AddEdge {
+		addEdgeDetailsNRGBA(img, y0, g.effectSeed, g.basePixelSize, cfg.EdgeSteps(), cfg.LegacyBlend)
+	}
+}
+
+func (g *cellGrid) release() {
+	g.cells.release()
+}
+
+// finishGrid warps a built grid with -distort and places the -qr code,
+// last so the distortion can't make it unreadable.
+func finishGrid(ctx context.Context, cfg *config.Config, g *cellGrid) error {
+	if cfg.Distort > 0 {
+		distortGrid(cfg, g)
+		layersFrom(ctx).grid("distort", g.cells, g.cellSize, g.colors)
+	}
+	if cfg.QRText != "" {
+		if err := placeQR(cfg, g); err != nil {
+			return err
+		}
+		layersFrom(ctx).grid("qr", g.cells, g.cellSize, g.colors)
+	}
+	return nil
+}
+
+func renderGridPattern(ctx context.Context, cfg *config.Config, rng *rand.Rand, b gridBuilder, colors []color.NRGBA) (image.Image, error) {
+	g, err := b.buildGrid(ctx, cfg, rng, colors)
+	if err != nil {
+		return nil, err
+	}
+	defer g.release()
+
+	if err := finishGrid(ctx, cfg, g); err != nil {
+		return nil, err
+	}
+
+	layersFrom(ctx).effects(cfg, g)
+
+	img := getNRGBA(cfg.Width, cfg.Height)
+	g.renderBand(cfg, img, 0)
+
+	return img, nil
+}
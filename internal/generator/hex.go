@@ -0,0 +1,165 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// hexScale is the radius of hex cells, center to corner, in base pixels.
+const hexScale = 3
+
+// hexNeighbors are the column and row offsets of the six neighbors of a hex
+// cell in even and odd rows. Odd rows are shifted right by half a cell.
+var hexNeighbors = [2][6][2]int{
+	{{1, 0}, {-1, 0}, {0, -1}, {-1, -1}, {0, 1}, {-1, 1}},
+	{{1, 0}, {-1, 0}, {1, -1}, {0, -1}, {1, 1}, {0, 1}},
+}
+
+// HexGenerator makes hex pixel patterns: clusters of pointy-top hexagonal
+// cells, drawn with square base pixels.
+type HexGenerator struct{}
+
+func (hg *HexGenerator) Generate(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (image.Image, error) {
+	return renderGridPattern(ctx, cfg, rng, hg, colors)
+}
+
+func (hg *HexGenerator) buildGrid(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (*cellGrid, error) {
+	if err := checkDimensions(cfg); err != nil {
+		return nil, err
+	}
+
+	// Shuffle the colors, keeping each color's ratios
+	shuffledColors, perm := shufflePalette(rng, colors)
+	pickers, err := newLayerPickers(cfg.ColorRatios, len(colors), perm)
+	if err != nil {
+		return nil, err
+	}
+	balance := pickers.balance
+
+	adjustedBasePixelSize, _ := fitPixelSize(cfg, 1)
+	radius, hexCols, hexRows := HexGrid(cfg)
+
+	hexes := newIndexGrid(hexCols, hexRows)
+	for i := range hexes.cells {
+		hexes.cells[i] = pickers.macro.pick(rng, len(shuffledColors))
+	}
+	layers := layersFrom(ctx)
+	record := func(name string) {
+		if layers == nil {
+			return
+		}
+		g := rasterizeHexes(cfg, hexes, radius, adjustedBasePixelSize)
+		layers.grid(name, g, adjustedBasePixelSize, shuffledColors)
+		g.release()
+	}
+	record("base")
+
+	// Cluster with the cellular automaton rules of the blob pattern, voting
+	// over each hex cell and its six neighbors
+	next := newIndexGrid(hexCols, hexRows)
+	counts := make([]int, len(shuffledColors))
+	chance := cfg.AutomatonChance("hex")
+	for i := 0; i < cfg.AutomatonPasses(); i++ {
+		for attempt := 0; ; attempt++ {
+			for y := 0; y < hexRows; y++ {
+				if err := ctx.Err(); err != nil {
+					hexes.release()
+					next.release()
+					return nil, err
+				}
+				for x := 0; x < hexCols; x++ {
+					c := hexes.mostCommonHexNeighbor(rng, x, y, counts, balance.weights())
+					if chance < 1 && rng.Float64() >= chance {
+						c = hexes.at(x, y)
+					}
+					next.set(x, y, c)
+				}
+			}
+			if balance.settled(next, attempt) {
+				break
+			}
+		}
+		hexes, next = next, hexes
+		record(fmt.Sprintf("pass%d", i+1))
+	}
+	next.release()
+
+	cells := rasterizeHexes(cfg, hexes, radius, adjustedBasePixelSize)
+	hexes.release()
+
+	return &cellGrid{
+		cells:         cells,
+		cellSize:      adjustedBasePixelSize,
+		basePixelSize: adjustedBasePixelSize,
+		colors:        shuffledColors,
+		detail:        pickers.detail,
+		effectSeed:    rng.Uint64(),
+	}, nil
+}
+
+// HexGrid returns the radius in pixels and the number of columns and rows
+// of the hex cells of the configured pattern. The row count is even so the
+// offset rows wrap at the grid edges.
+func HexGrid(cfg *config.Config) (radius float64, cols, rows int) {
+	pixelSize, _ := fitPixelSize(cfg, 1)
+	radius = float64(hexScale * pixelSize * cfg.StructureScale())
+	cols = int(math.Ceil(float64(cfg.Width)/(math.Sqrt(3)*radius))) + 1
+	rows = int(math.Ceil(float64(cfg.Height)/(1.5*radius))) + 1
+	return radius, cols, rows + rows%2
+}
+
+// mostCommonHexNeighbor returns the most frequent index among the hex cell
+// at (x, y) and its six neighbors, wrapping at the grid edges, like
+// mostCommonNeighbor.
+func (g *indexGrid) mostCommonHexNeighbor(rng *rand.Rand, x, y int, counts []int, bias []float64) int {
+	clear(counts)
+	counts[g.at(x, y)]++
+	for _, d := range hexNeighbors[y%2] {
+		counts[g.at((x+d[0]+g.cols)%g.cols, (y+d[1]+g.rows)%g.rows)]++
+	}
+	return mostCommon(rng, counts, bias, g.at(x, y))
+}
+
+// rasterizeHexes draws the hex cells onto a grid of square cells of
+// cellSize pixels, giving each square the color of the hex under its
+// center.
+func rasterizeHexes(cfg *config.Config, hexes *indexGrid, radius float64, cellSize int) *indexGrid {
+	cells := newIndexGrid(cellsAcross(cfg.Width, cellSize), cellsAcross(cfg.Height, cellSize))
+	parallelRows(cells.rows, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := cells.row(y)
+			py := (float64(y) + 0.5) * float64(cellSize)
+			for x := range row {
+				col, hr := hexAt((float64(x)+0.5)*float64(cellSize), py, radius)
+				row[x] = hexes.at((col%hexes.cols+hexes.cols)%hexes.cols, (hr%hexes.rows+hexes.rows)%hexes.rows)
+			}
+		}
+	})
+	return cells
+}
+
+// hexAt returns the column and row of the pointy-top hex cell of the given
+// radius containing the pixel position (px, py). The cell at column 0, row
+// 0 is centered on the origin and odd rows are shifted right by half a cell.
+func hexAt(px, py, radius float64) (col, row int) {
+	// Fractional cube coordinates, rounded to the nearest cell
+	q := (math.Sqrt(3)/3*px - py/3) / radius
+	r := 2.0 / 3 * py / radius
+	s := -q - r
+	rq, rr, rs := math.Round(q), math.Round(r), math.Round(s)
+	dq, dr, ds := math.Abs(rq-q), math.Abs(rr-r), math.Abs(rs-s)
+	if dq > dr && dq > ds {
+		rq = -rr - rs
+	} else if dr > ds {
+		rr = -rq - rs
+	}
+
+	row = int(rr)
+	return int(rq) + (row-(row&1))/2, row
+}
@@ -6,7 +6,7 @@ import (
 	"image"
 	"image/color"
 	"math"
-	"math/rand"
+	"math/rand/v2"
 
 	"github.com/bradsec/gocamo/internal/utils"
 	"github.com/bradsec/gocamo/pkg/config"
@@ -17,140 +17,213 @@ type ImageGenerator struct {
 	InputFile string
 }
 
-func (ig *ImageGenerator) Generate(ctx context.Context, cfg *config.Config, _ []color.RGBA) (image.Image, []color.RGBA, error) {
-	// Adjust base pixel size to fit perfectly within the dimensions
-	adjustedBasePixelSize := cfg.BasePixelSize
-	for cfg.Width%adjustedBasePixelSize != 0 || cfg.Height%adjustedBasePixelSize != 0 {
-		adjustedBasePixelSize--
+func (ig *ImageGenerator) Generate(ctx context.Context, cfg *config.Config, rng *rand.Rand, _ []color.NRGBA) (image.Image, []color.NRGBA, error) {
+	if err := checkDimensions(cfg); err != nil {
+		return nil, nil, err
 	}
 
+	// Adjust base pixel size to fit within the dimensions
+	adjustedBasePixelSize, _ := fitPixelSize(cfg, 1)
+
 	inputImg, err := utils.LoadImage(ig.InputFile)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error loading image: %w", err)
 	}
-	resized := resizeAndCropImage(inputImg, cfg.Width, cfg.Height)
-	pooled := maxPooling(resized, adjustedBasePixelSize)
-	enhanced := laplacianFilter(pooled)
-	bounds := enhanced.Bounds()
-	pixels := make([]color.Color, 0, bounds.Dx()*bounds.Dy())
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			pixels = append(pixels, enhanced.At(x, y))
-		}
+	cells, mainColors, err := clusterImage(ctx, cfg, rng, inputImg, adjustedBasePixelSize)
+	if err != nil {
+		return nil, nil, err
 	}
-	mainColors := kMeansClustering(pixels, cfg.KValue, 100)
-	result := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
-	for y := 0; y < cfg.Height; y++ {
-		for x := 0; x < cfg.Width; x++ {
-			enhancedX := x * bounds.Dx() / cfg.Width
-			enhancedY := y * bounds.Dy() / cfg.Height
-			pixel := enhanced.At(enhancedX, enhancedY)
-
-			closestColor := mainColors[0]
-			minDistance := colorDistance(pixel, closestColor)
-			for _, color := range mainColors[1:] {
-				d := colorDistance(pixel, color)
-				if d < minDistance {
-					minDistance = d
-					closestColor = color
+	labs := labColors(mainColors)
+	effectSeed := rng.Uint64()
+	// -distort warps the pooled cells the colors are looked up from
+	distort := newDistortion(cfg, effectSeed)
+	result := getNRGBA(cfg.Width, cfg.Height)
+	parallelRows(cfg.Height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := result.Pix[y*result.Stride:]
+			sy := y * cells.height / cfg.Height
+			for x := 0; x < cfg.Width; x++ {
+				sx := x * cells.width / cfg.Width
+				lab := cells.at(sx, sy)
+				if distort != nil {
+					dx, dy := distort.offset(sx, sy)
+					lab = cells.at(clamp(sx+dx, 0, cells.width-1), clamp(sy+dy, 0, cells.height-1))
 				}
+
+				closestColor := mainColors[closestLab(lab, labs)]
+				i := x * 4
+				row[i], row[i+1], row[i+2], row[i+3] = closestColor.R, closestColor.G, closestColor.B, closestColor.A
 			}
-			result.Set(x, y, closestColor)
 		}
+	})
+
+	if cfg.Blend > 0 {
+		featherEdges(result, cfg.Blend, cfg.LegacyBlend)
 	}
 
 	if cfg.AddNoise {
-		addNoiseRGBA(result, mainColors)
+		addNoiseNRGBA(result, 0, effectSeed, mainColors, nil, cfg.NoiseChance(), cfg.LegacyBlend)
 	}
 
 	if cfg.AddEdge {
-		addEdgeDetailsRGBA(result, adjustedBasePixelSize)
+		addEdgeDetailsNRGBA(result, 0, effectSeed, adjustedBasePixelSize, cfg.EdgeSteps(), cfg.LegacyBlend)
 	}
 	return result, mainColors, nil
 }
 
-func maxPooling(img image.Image, poolSize int) image.Image {
+// labCells holds the L*a*b* color of each pooled cell of a reference image.
+type labCells struct {
+	width, height int
+	pix           [][3]float64
+}
+
+func (c *labCells) at(x, y int) [3]float64 {
+	return c.pix[y*c.width+x]
+}
+
+// clusterImage runs the clustering half of the image pipeline: it resizes
+// img to the configured size, pools it into cells of pixelSize, sharpens
+// the cells and finds the cfg.KValue main colors among them. Clustering is
+// done in CIELAB so that the colors are told apart as the eye does.
+func clusterImage(ctx context.Context, cfg *config.Config, rng *rand.Rand, img image.Image, pixelSize int) (*labCells, []color.NRGBA, error) {
+	scaler, ok := Scalers[cfg.Scaler]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown scaler: %s", cfg.Scaler)
+	}
+	cells := imageCells(resizeAndCropImage(img, cfg.Width, cfg.Height, scaler), pixelSize)
+	mainColors, err := clusterCells(ctx, cfg, rng, cells.pix)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cells, mainColors, nil
+}
+
+// imageCells pools img into cells of pixelSize, sharpens them and returns
+// their CIELAB colors.
+func imageCells(img *image.RGBA, pixelSize int) *labCells {
+	pooled := maxPooling(img, pixelSize)
+	enhanced := laplacianFilter(pooled)
+	bounds := enhanced.Bounds()
+	cells := &labCells{width: bounds.Dx(), height: bounds.Dy(), pix: make([][3]float64, bounds.Dx()*bounds.Dy())}
+	parallelRows(cells.height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := enhanced.Pix[y*enhanced.Stride:]
+			for x := 0; x < cells.width; x++ {
+				p := row[x*4:]
+				cells.pix[y*cells.width+x] = toLab(p[0], p[1], p[2])
+			}
+		}
+	})
+	return cells
+}
+
+// clusterCells finds the cfg.KValue main colors among the CIELAB colors of
+// points with k-means, or mini-batch k-means if cfg.KMeansBatch is smaller
+// than the number of points.
+func clusterCells(ctx context.Context, cfg *config.Config, rng *rand.Rand, points [][3]float64) ([]color.NRGBA, error) {
+	var centroids [][3]float64
+	var err error
+	if cfg.KMeansBatch > 0 && cfg.KMeansBatch < len(points) {
+		centroids, err = miniBatchKMeans(ctx, rng, points, cfg.KValue, cfg.KMeansBatch, 100)
+	} else {
+		centroids, err = kMeansClustering(ctx, rng, points, cfg.KValue, 100)
+	}
+	if err != nil {
+		return nil, err
+	}
+	mainColors := make([]color.NRGBA, len(centroids))
+	for i, c := range centroids {
+		mainColors[i] = fromLab(c)
+	}
+	return mainColors, nil
+}
+
+// maxPooling reduces img to one pixel per poolSize×poolSize block, taking
+// the brightest value of each channel in the block.
+func maxPooling(img *image.RGBA, poolSize int) *image.RGBA {
 	bounds := img.Bounds()
 	width, height := bounds.Max.X, bounds.Max.Y
 	newWidth, newHeight := (width+poolSize-1)/poolSize, (height+poolSize-1)/poolSize
 
 	result := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
 
-	for y := 0; y < newHeight; y++ {
-		for x := 0; x < newWidth; x++ {
-			var maxR, maxG, maxB uint32
-			maxR, maxG, maxB = 0, 0, 0
-
-			for py := 0; py < poolSize && y*poolSize+py < height; py++ {
-				for px := 0; px < poolSize && x*poolSize+px < width; px++ {
-					r, g, b, _ := img.At(x*poolSize+px, y*poolSize+py).RGBA()
-					maxR = maxU(maxR, r)
-					maxG = maxU(maxG, g)
-					maxB = maxU(maxB, b)
+	parallelRows(newHeight, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := result.Pix[y*result.Stride:]
+			for x := 0; x < newWidth; x++ {
+				var maxR, maxG, maxB uint8
+
+				for py := 0; py < poolSize && y*poolSize+py < height; py++ {
+					src := img.Pix[(y*poolSize+py)*img.Stride:]
+					for px := 0; px < poolSize && x*poolSize+px < width; px++ {
+						p := src[(x*poolSize+px)*4:]
+						maxR = max(maxR, p[0])
+						maxG = max(maxG, p[1])
+						maxB = max(maxB, p[2])
+					}
 				}
-			}
 
-			result.Set(x, y, color.RGBA{
-				R: uint8(maxR >> 8),
-				G: uint8(maxG >> 8),
-				B: uint8(maxB >> 8),
-				A: 255,
-			})
+				i := x * 4
+				row[i], row[i+1], row[i+2], row[i+3] = maxR, maxG, maxB, 255
+			}
 		}
-	}
+	})
 
 	return result
 }
 
-func laplacianFilter(img image.Image) image.Image {
+// laplacianFilter sharpens img by subtracting its Laplacian, emphasizing
+// edges before the main colors are picked.
+func laplacianFilter(img *image.RGBA) *image.RGBA {
 	bounds := img.Bounds()
 	width, height := bounds.Max.X, bounds.Max.Y
 
 	result := image.NewRGBA(bounds)
 
-	kernel := [][]int{
+	kernel := [3][3]int32{
 		{0, 1, 0},
 		{1, -4, 1},
 		{0, 1, 0},
 	}
 
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			var sumR, sumG, sumB int32
+	parallelRows(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := result.Pix[y*result.Stride:]
+			for x := 0; x < width; x++ {
+				var sumR, sumG, sumB int32
 
-			for ky := -1; ky <= 1; ky++ {
-				for kx := -1; kx <= 1; kx++ {
-					nx, ny := x+kx, y+ky
-					if nx >= 0 && nx < width && ny >= 0 && ny < height {
-						r, g, b, _ := img.At(nx, ny).RGBA()
+				for ky := -1; ky <= 1; ky++ {
+					ny := y + ky
+					if ny < 0 || ny >= height {
+						continue
+					}
+					src := img.Pix[ny*img.Stride:]
+					for kx := -1; kx <= 1; kx++ {
+						nx := x + kx
+						if nx < 0 || nx >= width {
+							continue
+						}
+						p := src[nx*4:]
 						k := kernel[ky+1][kx+1]
-						sumR += int32(r>>8) * int32(k)
-						sumG += int32(g>>8) * int32(k)
-						sumB += int32(b>>8) * int32(k)
+						sumR += int32(p[0]) * k
+						sumG += int32(p[1]) * k
+						sumB += int32(p[2]) * k
 					}
 				}
-			}
 
-			r, g, b, _ := img.At(x, y).RGBA()
-			result.Set(x, y, color.RGBA{
-				R: uint8(clampLap(int32(r>>8) - sumR)),
-				G: uint8(clampLap(int32(g>>8) - sumG)),
-				B: uint8(clampLap(int32(b>>8) - sumB)),
-				A: 255,
-			})
+				p := img.Pix[y*img.Stride+x*4:]
+				i := x * 4
+				row[i] = clampLap(int32(p[0]) - sumR)
+				row[i+1] = clampLap(int32(p[1]) - sumG)
+				row[i+2] = clampLap(int32(p[2]) - sumB)
+				row[i+3] = 255
+			}
 		}
-	}
+	})
 
 	return result
 }
 
-func maxU(a, b uint32) uint32 {
-	if a > b {
-		return a
-	}
-	return b
-}
-
 func clampLap(v int32) uint8 {
 	if v < 0 {
 		return 0
@@ -161,21 +234,16 @@ func clampLap(v int32) uint8 {
 	return uint8(v)
 }
 
-func kMeansClustering(pixels []color.Color, k int, maxIterations int) []color.RGBA {
-	// Convert pixels to a slice of [3]float64 for easier computation
-	points := make([][3]float64, len(pixels))
-	for i, p := range pixels {
-		r, g, b, _ := p.RGBA()
-		points[i] = [3]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
-	}
-
-	// Initialize centroids randomly
-	centroids := make([][3]float64, k)
-	for i := range centroids {
-		centroids[i] = points[rand.Intn(len(points))]
-	}
+// kMeansClustering finds k main colors by k-means, stopping once the
+// centroids settle, or early with the context's error if it is canceled.
+func kMeansClustering(ctx context.Context, rng *rand.Rand, points [][3]float64, k int, maxIterations int) ([][3]float64, error) {
+	centroids := seedCentroids(rng, points, k)
 
 	for iteration := 0; iteration < maxIterations; iteration++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		// Assign points to clusters
 		clusters := make([][][3]float64, k)
 		for _, point := range points {
@@ -191,7 +259,8 @@ func kMeansClustering(pixels []color.Color, k int, maxIterations int) []color.RG
 			clusters[closestCentroid] = append(clusters[closestCentroid], point)
 		}
 
-		// Update centroids
+		// Update centroids, stopping once none of them moves
+		shift := 0.0
 		for i, cluster := range clusters {
 			if len(cluster) == 0 {
 				continue
@@ -202,109 +271,174 @@ func kMeansClustering(pixels []color.Color, k int, maxIterations int) []color.RG
 				sumG += point[1]
 				sumB += point[2]
 			}
-			centroids[i] = [3]float64{
+			mean := [3]float64{
 				sumR / float64(len(cluster)),
 				sumG / float64(len(cluster)),
 				sumB / float64(len(cluster)),
 			}
+			shift = max(shift, distance(mean, centroids[i]))
+			centroids[i] = mean
 		}
-	}
-
-	// Convert centroids to color.RGBA
-	result := make([]color.RGBA, k)
-	for i, centroid := range centroids {
-		result[i] = color.RGBA{
-			R: uint8(centroid[0]),
-			G: uint8(centroid[1]),
-			B: uint8(centroid[2]),
-			A: 255,
+		if shift < convergedShift {
+			break
 		}
 	}
-	return result
-}
 
-func distance(a, b [3]float64) float64 {
-	return math.Sqrt(math.Pow(a[0]-b[0], 2) + math.Pow(a[1]-b[1], 2) + math.Pow(a[2]-b[2], 2))
+	return centroids, nil
 }
 
-func colorDistance(c1, c2 color.Color) float64 {
-	r1, g1, b1, _ := c1.RGBA()
-	r2, g2, b2, _ := c2.RGBA()
-	return math.Sqrt(math.Pow(float64(r1>>8)-float64(r2>>8), 2) +
-		math.Pow(float64(g1>>8)-float64(g2>>8), 2) +
-		math.Pow(float64(b1>>8)-float64(b2>>8), 2))
-}
+// miniBatchKMeans approximates k-means by updating the centroids from a
+// random sample of batchSize points per iteration, using a per-centroid
+// learning rate that decays with the number of points assigned to it. Like
+// kMeansClustering it stops once the centroids settle or the context is
+// canceled.
+func miniBatchKMeans(ctx context.Context, rng *rand.Rand, points [][3]float64, k, batchSize, maxIterations int) ([][3]float64, error) {
+	centroids := seedCentroids(rng, points, k)
+	counts := make([]int, k)
+	previous := make([][3]float64, k)
+	batch := make([][3]float64, batchSize)
+	assigned := make([]int, batchSize)
 
-// BilinearScale performs bilinear interpolation to resize an image
-func BilinearScale(src image.Image, dstWidth, dstHeight int) *image.RGBA {
-	srcBounds := src.Bounds()
-	srcWidth := srcBounds.Dx()
-	srcHeight := srcBounds.Dy()
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			batch[i] = points[rng.IntN(len(points))]
+		}
+		copy(previous, centroids)
 
-	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+		// Assign the whole batch before moving any centroid
+		for i, point := range batch {
+			closestCentroid := 0
+			minDistance := distance(point, centroids[0])
+			for j := 1; j < k; j++ {
+				d := distance(point, centroids[j])
+				if d < minDistance {
+					minDistance = d
+					closestCentroid = j
+				}
+			}
+			assigned[i] = closestCentroid
+		}
+
+		for i, point := range batch {
+			c := assigned[i]
+			counts[c]++
+			eta := 1 / float64(counts[c])
+			for ch := 0; ch < 3; ch++ {
+				centroids[c][ch] += eta * (point[ch] - centroids[c][ch])
+			}
+		}
+
+		// The learning rates decay, so the centroids settle once the
+		// batches stop pulling them anywhere new
+		shift := 0.0
+		for i := range centroids {
+			shift = max(shift, distance(centroids[i], previous[i]))
+		}
+		if shift < convergedShift {
+			break
+		}
+	}
 
-	for dy := 0; dy < dstHeight; dy++ {
-		for dx := 0; dx < dstWidth; dx++ {
-			// Calculate the corresponding position in the source image
-			sx := (float64(dx) / float64(dstWidth)) * float64(srcWidth)
-			sy := (float64(dy) / float64(dstHeight)) * float64(srcHeight)
-
-			// Determine the four nearest pixels
-			x0 := int(math.Floor(sx))
-			y0 := int(math.Floor(sy))
-			x1 := int(math.Ceil(sx))
-			y1 := int(math.Ceil(sy))
-
-			// Clamp coordinates to ensure they are within bounds
-			if x1 >= srcWidth {
-				x1 = srcWidth - 1
+	// Finish with a single full pass so the centroids are true cluster means
+	sums := make([][3]float64, k)
+	sizes := make([]int, k)
+	for _, point := range points {
+		closestCentroid := 0
+		minDistance := distance(point, centroids[0])
+		for j := 1; j < k; j++ {
+			d := distance(point, centroids[j])
+			if d < minDistance {
+				minDistance = d
+				closestCentroid = j
 			}
-			if y1 >= srcHeight {
-				y1 = srcHeight - 1
+		}
+		for ch := 0; ch < 3; ch++ {
+			sums[closestCentroid][ch] += point[ch]
+		}
+		sizes[closestCentroid]++
+	}
+	for i := range centroids {
+		if sizes[i] > 0 {
+			for ch := 0; ch < 3; ch++ {
+				centroids[i][ch] = sums[i][ch] / float64(sizes[i])
 			}
+		}
+	}
+
+	return centroids, nil
+}
 
-			// Calculate interpolation weights
-			tx := sx - float64(x0)
-			ty := sy - float64(y0)
-
-			// Get the colors of the four surrounding pixels
-			c00 := src.At(x0, y0)
-			c01 := src.At(x0, y1)
-			c10 := src.At(x1, y0)
-			c11 := src.At(x1, y1)
-
-			// Compute the interpolated color
-			r00, g00, b00, a00 := c00.RGBA()
-			r01, g01, b01, a01 := c01.RGBA()
-			r10, g10, b10, a10 := c10.RGBA()
-			r11, g11, b11, a11 := c11.RGBA()
-
-			r := uint8((float64(r00)*(1-tx)*(1-ty) +
-				float64(r01)*(1-tx)*ty +
-				float64(r10)*tx*(1-ty) +
-				float64(r11)*tx*ty) / 256)
-			g := uint8((float64(g00)*(1-tx)*(1-ty) +
-				float64(g01)*(1-tx)*ty +
-				float64(g10)*tx*(1-ty) +
-				float64(g11)*tx*ty) / 256)
-			b := uint8((float64(b00)*(1-tx)*(1-ty) +
-				float64(b01)*(1-tx)*ty +
-				float64(b10)*tx*(1-ty) +
-				float64(b11)*tx*ty) / 256)
-			a := uint8((float64(a00)*(1-tx)*(1-ty) +
-				float64(a01)*(1-tx)*ty +
-				float64(a10)*tx*(1-ty) +
-				float64(a11)*tx*ty) / 256)
-
-			dst.Set(dx, dy, color.RGBA{R: r, G: g, B: b, A: a})
+// convergedShift is how far, in CIELAB units, the centroids may still move
+// in an iteration for k-means to count as converged. A difference of 1 is
+// about the smallest the eye can see.
+const convergedShift = 0.5
+
+// seedCentroids picks k starting centroids from points by k-means++: the
+// first uniformly, each next one with probability proportional to its
+// squared distance from the nearest centroid already picked. Spreading the
+// starting colors out avoids two centroids splitting one color while
+// another color gets none, and k-means converges in fewer iterations.
+func seedCentroids(rng *rand.Rand, points [][3]float64, k int) [][3]float64 {
+	centroids := make([][3]float64, 0, k)
+	centroids = append(centroids, points[rng.IntN(len(points))])
+	nearest := make([]float64, len(points))
+	total := 0.0
+	for i, point := range points {
+		nearest[i] = squaredDistance(point, centroids[0])
+		total += nearest[i]
+	}
+	for len(centroids) < k {
+		next := points[rng.IntN(len(points))]
+		// Every point lies on a centroid when the image has fewer colors
+		// than k, so any point will do
+		if total > 0 {
+			target := rng.Float64() * total
+			for i, d := range nearest {
+				if target -= d; target < 0 {
+					next = points[i]
+					break
+				}
+			}
+		}
+		centroids = append(centroids, next)
+		total = 0
+		for i, point := range points {
+			nearest[i] = min(nearest[i], squaredDistance(point, next))
+			total += nearest[i]
 		}
 	}
+	return centroids
+}
+
+func squaredDistance(a, b [3]float64) float64 {
+	d0, d1, d2 := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return d0*d0 + d1*d1 + d2*d2
+}
+
+func distance(a, b [3]float64) float64 {
+	return math.Sqrt(math.Pow(a[0]-b[0], 2) + math.Pow(a[1]-b[1], 2) + math.Pow(a[2]-b[2], 2))
+}
 
+// Scalers maps the -scaler flag values to the resampling kernels used when
+// resizing reference images, from fastest to highest quality.
+var Scalers = map[string]draw.Scaler{
+	"nearest":    draw.NearestNeighbor,
+	"approx":     draw.ApproxBiLinear,
+	"bilinear":   draw.BiLinear,
+	"catmullrom": draw.CatmullRom,
+}
+
+func scaleImage(src image.Image, dstWidth, dstHeight int, scaler draw.Scaler) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	scaler.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
 	return dst
 }
 
-// resizeAndCropImage uses BilinearScale to resize the image and then crops it
-func resizeAndCropImage(img image.Image, targetWidth, targetHeight int) image.Image {
+// resizeAndCropImage uses the scaler to resize the image and then crops it
+func resizeAndCropImage(img image.Image, targetWidth, targetHeight int, scaler draw.Scaler) *image.RGBA {
 	const smallestSide = 256
 
 	srcBounds := img.Bounds()
@@ -322,7 +456,7 @@ func resizeAndCropImage(img image.Image, targetWidth, targetHeight int) image.Im
 	newHeight := int(float64(srcHeight) * scaleFactor)
 
 	// Scale down the image
-	scaledDown := BilinearScale(img, newWidth, newHeight)
+	scaledDown := scaleImage(img, newWidth, newHeight, scaler)
 
 	widthRatio := float64(targetWidth) / float64(newWidth)
 	heightRatio := float64(targetHeight) / float64(newHeight)
@@ -343,7 +477,7 @@ func resizeAndCropImage(img image.Image, targetWidth, targetHeight int) image.Im
 	}
 
 	// Resize the scaled-down image to fill the target dimensions
-	resized := BilinearScale(scaledDown, resizeWidth, resizeHeight)
+	resized := scaleImage(scaledDown, resizeWidth, resizeHeight, scaler)
 
 	// Calculate cropping bounds
 	cropX := (resizeWidth - targetWidth) / 2
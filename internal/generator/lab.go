@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"image/color"
+	"math"
+)
+
+// CIELAB conversion for image mode, which clusters and matches colors by
+// perceived difference rather than by distance in sRGB, where dark greens a
+// photo tells apart easily sit closer together than light colors that look
+// alike. The white point is D65, as for sRGB.
+
+// labWhite is the D65 white point in XYZ.
+var labWhite = [3]float64{0.95047, 1, 1.08883}
+
+// labDelta is the point where the CIELAB curve switches from a cube root to
+// a straight line near black.
+const labDelta = 6.0 / 29
+
+// toLab converts an sRGB color to L*a*b*.
+func toLab(r, g, b uint8) [3]float64 {
+	lr, lg, lb := float64(srgbToLinear[r]), float64(srgbToLinear[g]), float64(srgbToLinear[b])
+	fx := labF((0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / labWhite[0])
+	fy := labF((0.2126729*lr + 0.7151522*lg + 0.0721750*lb) / labWhite[1])
+	fz := labF((0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / labWhite[2])
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// fromLab converts L*a*b* back to an opaque sRGB color, clamping colors
+// outside the sRGB gamut.
+func fromLab(lab [3]float64) color.NRGBA {
+	fy := (lab[0] + 16) / 116
+	x := labWhite[0] * labFInv(fy+lab[1]/500)
+	y := labWhite[1] * labFInv(fy)
+	z := labWhite[2] * labFInv(fy-lab[2]/200)
+	return color.NRGBA{
+		R: encodeSRGB(3.2404542*x - 1.5371385*y - 0.4985314*z),
+		G: encodeSRGB(-0.9692660*x + 1.8760108*y + 0.0415560*z),
+		B: encodeSRGB(0.0556434*x - 0.2040259*y + 1.0572252*z),
+		A: 255,
+	}
+}
+
+func labF(t float64) float64 {
+	if t > labDelta*labDelta*labDelta {
+		return math.Cbrt(t)
+	}
+	return t/(3*labDelta*labDelta) + 4.0/29
+}
+
+func labFInv(t float64) float64 {
+	if t > labDelta {
+		return t * t * t
+	}
+	return 3 * labDelta * labDelta * (t - 4.0/29)
+}
+
+// encodeSRGB encodes a linear light value exactly, unlike toSRGB, so that a
+// color converted to L*a*b* and back comes out unchanged.
+func encodeSRGB(v float64) uint8 {
+	v = min(max(v, 0), 1)
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(v * 255))
+}
+
+// labColors converts the colors of a palette to L*a*b*.
+func labColors(colors []color.NRGBA) [][3]float64 {
+	labs := make([][3]float64, len(colors))
+	for i, c := range colors {
+		labs[i] = toLab(c.R, c.G, c.B)
+	}
+	return labs
+}
+
+// closestLab returns the index of the color in labs closest to lab.
+func closestLab(lab [3]float64, labs [][3]float64) int {
+	best, minDistance := 0, squaredDistance(lab, labs[0])
+	for i, c := range labs[1:] {
+		if d := squaredDistance(lab, c); d < minDistance {
+			best, minDistance = i+1, d
+		}
+	}
+	return best
+}
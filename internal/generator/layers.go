@@ -0,0 +1,234 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/ora"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// debugLayer is one intermediate stage of a pattern, kept as an indexed
+// image so the palette index of every pixel can be inspected.
+type debugLayer struct {
+	name string
+	img  *image.Paletted
+}
+
+// layerRecorder collects the layers of a pattern: the indexed intermediate
+// stages for -debug-layers and the painted color and effect layers for
+// -ora. A nil recorder records nothing, so generators can call it
+// unconditionally.
+type layerRecorder struct {
+	width, height int
+	indexed       bool
+	painted       bool
+	prefix        string
+	layers        []debugLayer
+	paintedLayers []ora.Layer
+}
+
+func newLayerRecorder(cfg *config.Config) *layerRecorder {
+	if !cfg.DebugLayers && !cfg.ORA {
+		return nil
+	}
+	return &layerRecorder{width: cfg.Width, height: cfg.Height, indexed: cfg.DebugLayers, painted: cfg.ORA}
+}
+
+type layersKey struct{}
+
+func withLayerRecorder(ctx context.Context, r *layerRecorder) context.Context {
+	return context.WithValue(ctx, layersKey{}, r)
+}
+
+func layersFrom(ctx context.Context) *layerRecorder {
+	r, _ := ctx.Value(layersKey{}).(*layerRecorder)
+	return r
+}
+
+// grid records g as it would be rendered at full size, without effects.
+// Palettes too large for an indexed image are not recorded.
+func (r *layerRecorder) grid(name string, g *indexGrid, cellSize int, colors []color.NRGBA) {
+	if r == nil || !r.indexed || len(colors) > 255 {
+		return
+	}
+	img := image.NewPaletted(image.Rect(0, 0, r.width, r.height), layerPalette(colors))
+	for y := 0; y < r.height; y++ {
+		cells := g.row((y / cellSize) % g.rows)
+		row := img.Pix[y*img.Stride : y*img.Stride+r.width]
+		for x := range row {
+			row[x] = uint8(cells[(x/cellSize)%g.cols])
+		}
+	}
+	r.layers = append(r.layers, debugLayer{r.prefix + name, img})
+}
+
+// setPrefix starts the names of the grids recorded from now on with prefix,
+// to tell apart the layers of a composite pattern.
+func (r *layerRecorder) setPrefix(prefix string) {
+	if r != nil {
+		r.prefix = prefix
+	}
+}
+
+// effects records the finished grid g and its post effects.
+func (r *layerRecorder) effects(cfg *config.Config, g *cellGrid) {
+	if r == nil {
+		return
+	}
+	if r.indexed && len(g.colors) <= 255 {
+		r.indexedEffects(cfg, g)
+	}
+	if r.painted {
+		r.paint(cfg, g)
+	}
+}
+
+// indexedEffects records the palette index of every noise pixel as the
+// detail layer, and the pixels changed by edge details.
+func (r *layerRecorder) indexedEffects(cfg *config.Config, g *cellGrid) {
+
+	if cfg.AddNoise {
+		// The transparent entry after the palette marks untouched pixels
+		clearIndex := uint8(len(g.colors))
+		palette := append(layerPalette(g.colors), color.Transparent)
+		img := image.NewPaletted(image.Rect(0, 0, r.width, r.height), palette)
+		for i := range img.Pix {
+			img.Pix[i] = clearIndex
+		}
+		forEachNoise(r.width, r.height, 0, g.effectSeed, len(g.colors), g.detail, cfg.NoiseChance(), func(x, y, idx int) {
+			img.Pix[y*img.Stride+x] = uint8(idx)
+		})
+		r.layers = append(r.layers, debugLayer{"detail", img})
+	}
+
+	if cfg.AddEdge {
+		base := image.NewNRGBA(image.Rect(0, 0, r.width, r.height))
+		noEdge := *cfg
+		noEdge.AddEdge = false
+		g.renderBand(&noEdge, base, 0)
+		before := slices.Clone(base.Pix)
+		addEdgeDetailsNRGBA(base, 0, g.effectSeed, g.basePixelSize, cfg.EdgeSteps(), cfg.LegacyBlend)
+
+		img := image.NewPaletted(image.Rect(0, 0, r.width, r.height), color.Palette{color.Transparent, color.White})
+		for i := range img.Pix {
+			p := i * 4
+			if string(base.Pix[p:p+4]) != string(before[p:p+4]) {
+				img.Pix[i] = 1
+			}
+		}
+		r.layers = append(r.layers, debugLayer{"edges", img})
+	}
+}
+
+// paint records one layer per palette color holding that color's cells,
+// and one layer per effect pass holding the pixels it changed, so that the
+// layers stacked in order give the finished pattern.
+func (r *layerRecorder) paint(cfg *config.Config, g *cellGrid) {
+	bounds := image.Rect(0, 0, r.width, r.height)
+	plain := *cfg
+	plain.AddNoise, plain.AddEdge = false, false
+	img := image.NewNRGBA(bounds)
+	g.renderBand(&plain, img, 0)
+
+	for i, c := range g.colors {
+		if c.A == 0 {
+			continue
+		}
+		layer := image.NewNRGBA(bounds)
+		for y := 0; y < r.height; y++ {
+			cells := g.cells.row((y / g.cellSize) % g.cells.rows)
+			for x := 0; x < r.width; x++ {
+				if cells[(x/g.cellSize)%g.cells.cols] == i {
+					p := y*img.Stride + x*4
+					copy(layer.Pix[p:p+4], img.Pix[p:p+4])
+				}
+			}
+		}
+		r.paintedLayers = append(r.paintedLayers, ora.Layer{
+			Name:  fmt.Sprintf("Color %d #%02x%02x%02x", i+1, c.R, c.G, c.B),
+			Image: layer,
+		})
+	}
+
+	// Each effect layer holds only the pixels its pass changed
+	changed := func(name string, apply func()) {
+		before := slices.Clone(img.Pix)
+		apply()
+		layer := image.NewNRGBA(bounds)
+		for p := 0; p < len(img.Pix); p += 4 {
+			if string(img.Pix[p:p+4]) != string(before[p:p+4]) {
+				copy(layer.Pix[p:p+4], img.Pix[p:p+4])
+			}
+		}
+		r.paintedLayers = append(r.paintedLayers, ora.Layer{Name: name, Image: layer})
+	}
+	if cfg.AddNoise {
+		changed("Noise", func() { addNoiseNRGBA(img, 0, g.effectSeed, g.colors, g.detail, cfg.NoiseChance(), cfg.LegacyBlend) })
+	}
+	if cfg.AddEdge {
+		changed("Edge details", func() { addEdgeDetailsNRGBA(img, 0, g.effectSeed, g.basePixelSize, cfg.EdgeSteps(), cfg.LegacyBlend) })
+	}
+}
+
+func layerPalette(colors []color.NRGBA) color.Palette {
+	palette := make(color.Palette, len(colors))
+	for i, c := range colors {
+		palette[i] = c
+	}
+	return palette
+}
+
+// save writes the recorded layers next to filePath. merged is the finished
+// pattern.
+func (r *layerRecorder) save(cfg *config.Config, merged image.Image, filePath string) error {
+	if len(r.layers) > 0 {
+		if err := saveLayers(cfg, r.layers, filePath); err != nil {
+			return err
+		}
+	}
+	if len(r.paintedLayers) > 0 {
+		if err := saveORA(r.paintedLayers, merged, filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveORA writes the painted layers as an OpenRaster file next to filePath.
+func saveORA(layers []ora.Layer, merged image.Image, filePath string) error {
+	path := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".ora"
+	file, err := os.Create(utils.LongPath(path))
+	if err != nil {
+		return fmt.Errorf("error creating layered file: %w", err)
+	}
+	defer file.Close()
+
+	if err := ora.Encode(file, layers, merged); err != nil {
+		return fmt.Errorf("error saving layered file %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// saveLayers writes each layer as a numbered PNG in a _layers directory
+// next to filePath.
+func saveLayers(cfg *config.Config, layers []debugLayer, filePath string) error {
+	dir := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + "_layers"
+	if err := os.MkdirAll(utils.LongPath(dir), 0755); err != nil {
+		return fmt.Errorf("error creating layers directory: %w", err)
+	}
+	for i, l := range layers {
+		path := filepath.Join(dir, fmt.Sprintf("%02d_%s.png", i+1, l.name))
+		if err := saveImageToFile(l.img, path, cfg.Force, pngOptions(cfg)); err != nil {
+			return fmt.Errorf("error saving layer %s: %w", l.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// shapeMask marks the light pixels of a -mask image, which the pattern
+// fills.
+type shapeMask struct {
+	width, height int
+	light         []bool
+}
+
+// shapeMasks caches decoded masks by path, as every job uses the same one.
+var shapeMasks sync.Map
+
+// loadShapeMask reads a -mask image. Pixels at least half as bright as
+// white and at least half opaque are light; everything else, including
+// transparent pixels, is dark.
+func loadShapeMask(path string) (*shapeMask, error) {
+	if m, ok := shapeMasks.Load(path); ok {
+		return m.(*shapeMask), nil
+	}
+
+	img, err := utils.LoadImage(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading mask: %w", err)
+	}
+	b := img.Bounds()
+	m := &shapeMask{width: b.Dx(), height: b.Dy(), light: make([]bool, b.Dx()*b.Dy())}
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			gray := color.GrayModel.Convert(color.RGBA{c.R, c.G, c.B, 0xff}).(color.Gray)
+			m.light[y*m.width+x] = gray.Y >= 0x80 && c.A >= 0x80
+		}
+	}
+
+	actual, _ := shapeMasks.LoadOrStore(path, m)
+	return actual.(*shapeMask), nil
+}
+
+// MaskSize returns the dimensions of a -mask image.
+func MaskSize(path string) (int, int, error) {
+	m, err := loadShapeMask(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return m.width, m.height, nil
+}
+
+// maskBackground returns the -mask-pattern pattern shown in the dark areas
+// of the mask, drawn from rng after the main pattern, or nil if they are
+// left transparent.
+func maskBackground(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (*image.NRGBA, error) {
+	if cfg.MaskPattern == "" {
+		return nil, nil
+	}
+	gen, err := newGenerator(cfg.MaskPattern)
+	if err != nil {
+		return nil, err
+	}
+	img, err := gen.Generate(ctx, cfg, rng, colors)
+	if err != nil {
+		return nil, fmt.Errorf("error generating mask pattern: %w", err)
+	}
+	return toNRGBA(img), nil
+}
+
+// applyMask keeps img in the light areas of the -mask image, scaled to the
+// size of img, and fills the dark areas from background, or clears them to
+// transparent if background is nil.
+func applyMask(cfg *config.Config, img, background *image.NRGBA) error {
+	m, err := loadShapeMask(cfg.Mask)
+	if err != nil {
+		return err
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	parallelRows(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			my := y * m.height / height
+			light := m.light[my*m.width : (my+1)*m.width]
+			row := img.Pix[y*img.Stride : y*img.Stride+width*4]
+			for x := 0; x < width; x++ {
+				if light[x*m.width/width] {
+					continue
+				}
+				if background == nil {
+					clear(row[x*4 : x*4+4])
+				} else {
+					copy(row[x*4:x*4+4], background.Pix[y*background.Stride+x*4:])
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// maskPattern applies the -mask to the pattern img of the job whose random
+// source is rng.
+func maskPattern(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA, img image.Image) (image.Image, error) {
+	background, err := maskBackground(ctx, cfg, rng, colors)
+	if err != nil {
+		return nil, err
+	}
+	dst := toNRGBA(img)
+	err = applyMask(cfg, dst, background)
+	if background != nil {
+		putNRGBA(background)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
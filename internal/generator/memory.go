@@ -0,0 +1,72 @@
+package generator
+
+import (
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// EstimateMemory returns the approximate peak number of bytes a single job
+// needs for the configured dimensions and pattern type.
+func EstimateMemory(cfg *config.Config) uint64 {
+	pixels := uint64(cfg.Width) * uint64(cfg.Height)
+	frame := pixels * 4
+
+	// Grid cells are at most one per pixel at base pixel size 1, and the
+	// cellular automaton holds two grids at a time.
+	cellSize := uint64(max(1, cfg.BasePixelSize))
+	grids := 2 * 8 * pixels / (cellSize * cellSize)
+	if cfg.PatternType == "composite" {
+		// The finished bottom layer is kept while the top layer is built,
+		// and both while they are combined
+		grids += 2 * 8 * pixels / (cellSize * cellSize)
+	}
+
+	var total uint64
+	switch {
+	case cfg.PatternType == "image":
+		// Resized, cropped and final frames plus the pooled pixel list
+		total = 3*frame + 16*pixels/(cellSize*cellSize)
+	case cfg.Banded:
+		total = min(frame, bandBytes) + grids
+		if cfg.Blend > 0 {
+			// The rows around each strip
+			total += 2 * uint64(cfg.Blend) * uint64(cfg.Width) * 4
+		}
+	default:
+		total = frame + grids
+	}
+
+	if cfg.Blend > 0 {
+		// Intermediate frame of the feathering passes, or of each strip
+		// and the rows around it
+		if cfg.Banded {
+			total += min(frame, bandBytes) + 2*uint64(cfg.Blend)*uint64(cfg.Width)*4
+		} else {
+			total += frame
+		}
+	}
+
+	if cfg.Animate > 0 {
+		// Distinct frames are kept until the animation is written, as
+		// indexed GIF frames or compressed APNG frames
+		frames := uint64(cfg.Animate/2 + 1)
+		if cfg.AnimateFormat == "apng" {
+			total += frames * frame / 4
+		} else {
+			total += frames * pixels
+		}
+		total += frame + grids
+	}
+
+	if cfg.MaskPattern != "" {
+		// The pattern in the dark areas of the mask
+		total += frame + grids
+	}
+
+	if cfg.MetricsFile != "" && !cfg.Banded {
+		// Edge map used by the analysis
+		total += pixels
+	}
+
+	// Encoder buffers and general overhead
+	return total + total/8 + 8<<20
+}
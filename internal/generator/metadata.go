@@ -0,0 +1,104 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bradsec/gocamo/internal/pngmeta"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// metadataKeyword is the PNG text chunk keyword generation metadata is
+// stored under. The other gocamo: chunks repeat parts of it as plain text
+// for image viewers and tools such as exiftool.
+const metadataKeyword = "gocamo"
+
+// ErrNoMetadata is returned by ReadMetadata for PNG files without gocamo
+// metadata, such as outputs of older versions.
+var ErrNoMetadata = errors.New("no gocamo metadata found")
+
+// Metadata records how an output was generated. It is embedded in every
+// PNG so the pattern can be explained or regenerated later.
+type Metadata struct {
+	// Version is the gocamo version that generated the output
+	Version string `json:"version,omitempty"`
+	// Name is the palette name, or the reference image file name
+	Name string `json:"name"`
+	// Pattern is the pattern type and Colors the palette, or the main
+	// colors of the reference image, as hex codes
+	Pattern string   `json:"pattern,omitempty"`
+	Colors  []string `json:"colors,omitempty"`
+	// Args are the pattern flags, including -c with the palette colors
+	Args []string `json:"args"`
+	// Source is the reference image of image patterns
+	Source string `json:"source,omitempty"`
+	// Seed and Index select the job's random stream
+	Seed  uint64 `json:"seed"`
+	Index int    `json:"index"`
+}
+
+func newMetadata(cfg *config.Config, name string, colors []string, index int) *Metadata {
+	args := cfg.PatternArgs()
+	if colors != nil {
+		args = append(args, "-c", strings.Join(colors, ","))
+	}
+	// Golden outputs must not change from one build to the next
+	version := utils.Version()
+	if cfg.Golden {
+		version = ""
+	}
+	return &Metadata{
+		Version: version,
+		Name:    name,
+		Pattern: cfg.PatternType,
+		Colors:  colors,
+		Args:    args,
+		Seed:    cfg.Seed,
+		Index:   index,
+	}
+}
+
+// texts returns the PNG text chunks holding m.
+func (m *Metadata) texts() []pngmeta.Text {
+	if m == nil {
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	colors := make([]string, len(m.Colors))
+	for i, c := range m.Colors {
+		if !utils.IsTransparent(c) {
+			c = "#" + strings.TrimPrefix(c, "#")
+		}
+		colors[i] = c
+	}
+	return []pngmeta.Text{
+		{Keyword: metadataKeyword, Value: string(data)},
+		{Keyword: "Software", Value: strings.TrimSpace("gocamo " + m.Version)},
+		{Keyword: "gocamo:pattern", Value: m.Pattern},
+		{Keyword: "gocamo:colors", Value: strings.Join(colors, " ")},
+		{Keyword: "gocamo:seed", Value: fmt.Sprintf("%#016x", m.Seed)},
+	}
+}
+
+// ReadMetadata returns the generation metadata embedded in a PNG file.
+func ReadMetadata(path string) (*Metadata, error) {
+	texts, err := pngmeta.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := texts[metadataKeyword]
+	if !ok {
+		return nil, ErrNoMetadata
+	}
+	var m Metadata
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, fmt.Errorf("invalid gocamo metadata: %w", err)
+	}
+	return &m, nil
+}
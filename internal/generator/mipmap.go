@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"strings"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// saveMipmaps writes each level of the mip chain below img as its own PNG
+// next to filePath, named with a _mipN suffix. Level 0 is the image itself.
+func saveMipmaps(cfg *config.Config, img image.Image, filePath string) error {
+	level := toNRGBA(img)
+	ext := filepath.Ext(filePath)
+	base := strings.TrimSuffix(filePath, ext)
+
+	for n := 1; level.Bounds().Dx() > 1 || level.Bounds().Dy() > 1; n++ {
+		level = downsample(level, !cfg.LegacyBlend)
+		path := fmt.Sprintf("%s_mip%d%s", base, n, ext)
+		if err := saveImageToFile(level, path, cfg.Force, pngOptions(cfg)); err != nil {
+			return fmt.Errorf("error saving mipmap level %d: %w", n, err)
+		}
+	}
+	return nil
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+	return nrgba
+}
+
+// downsample halves the image (rounding down, to at least one pixel) with a
+// box filter. Every source pixel contributes to exactly one destination
+// pixel, so odd sizes are handled without dropping rows or columns. Colors
+// are weighted by alpha so transparent areas don't darken their edges, and
+// averaged in linear light if linear is set.
+func downsample(src *image.NRGBA, linear bool) *image.NRGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := max(sw/2, 1), max(sh/2, 1)
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	parallelRows(dh, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			sy0, sy1 := y*sh/dh, (y+1)*sh/dh
+			out := dst.Pix[y*dst.Stride:]
+			for x := 0; x < dw; x++ {
+				sx0, sx1 := x*sw/dw, (x+1)*sw/dw
+				var r, g, b, a float32
+				n := 0
+				for sy := sy0; sy < sy1; sy++ {
+					row := src.Pix[sy*src.Stride:]
+					for sx := sx0; sx < sx1; sx++ {
+						p := row[sx*4 : sx*4+4]
+						alpha := float32(p[3]) / 255
+						r += channel(p[0], linear) * alpha
+						g += channel(p[1], linear) * alpha
+						b += channel(p[2], linear) * alpha
+						a += alpha
+						n++
+					}
+				}
+				c := average(r, g, b, a, n, linear)
+				out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = c.R, c.G, c.B, c.A
+			}
+		}
+	})
+	return dst
+}
+
+func channel(v uint8, linear bool) float32 {
+	if linear {
+		return srgbToLinear[v]
+	}
+	return float32(v) / 255
+}
+
+func average(r, g, b, a float32, n int, linear bool) color.NRGBA {
+	if a == 0 {
+		return color.NRGBA{}
+	}
+	encode := func(v float32) uint8 {
+		if linear {
+			return toSRGB(v / a)
+		}
+		return uint8(min(v/a*255+0.5, 255))
+	}
+	return color.NRGBA{R: encode(r), G: encode(g), B: encode(b), A: uint8(a/float32(n)*255 + 0.5)}
+}
@@ -0,0 +1,103 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// FileNameFields are the values a -name-template can use, such as
+// {{.Name}}, or {name} for short.
+type FileNameFields struct {
+	// Index is the job number, zero padded to three digits.
+	Index string
+	// Name is the palette or reference image name, made safe for filenames.
+	Name string
+	// Colors are the color codes as listed in default filenames.
+	Colors string
+	// Type is the pattern type.
+	Type string
+	// W and H are the image size in pixels.
+	W, H int
+	// Seed is the run seed as 16 hex digits.
+	Seed string
+}
+
+// nameTokens maps the short {token} forms to template fields.
+var nameTokens = map[string]string{
+	"index":  "Index",
+	"name":   "Name",
+	"colors": "Colors",
+	"type":   "Type",
+	"w":      "W",
+	"h":      "H",
+	"seed":   "Seed",
+}
+
+// nameToken matches a short token.
+var nameToken = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// nameTemplates caches parsed templates by their text, as every job uses
+// the same one.
+var nameTemplates sync.Map
+
+// parseNameTemplate parses a -name-template, rewriting short tokens such as
+// {index} to template actions first.
+func parseNameTemplate(text string) (*template.Template, error) {
+	if t, ok := nameTemplates.Load(text); ok {
+		return t.(*template.Template), nil
+	}
+
+	var unknown string
+	rewritten := nameToken.ReplaceAllStringFunc(text, func(token string) string {
+		field, ok := nameTokens[token[1:len(token)-1]]
+		if !ok {
+			unknown = token
+			return token
+		}
+		return "{{." + field + "}}"
+	})
+	if unknown != "" {
+		return nil, fmt.Errorf("unknown token %s in name template (use {index}, {name}, {colors}, {type}, {w}, {h} or {seed})", unknown)
+	}
+	t, err := template.New("name").Parse(rewritten)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+
+	actual, _ := nameTemplates.LoadOrStore(text, t)
+	return actual.(*template.Template), nil
+}
+
+// CheckNameTemplate reports whether a -name-template parses and runs.
+func CheckNameTemplate(text string) error {
+	_, err := templateName(text, FileNameFields{Index: "000", Name: "name", Colors: "000000", Type: "box", W: 1, H: 1, Seed: "0000000000000000"})
+	return err
+}
+
+// templateName returns the filename, without extension, that the template
+// gives for the fields. Characters that can't appear in filenames become
+// underscores.
+func templateName(text string, fields FileNameFields) (string, error) {
+	t, err := parseNameTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, fields); err != nil {
+		return "", fmt.Errorf("invalid name template: %w", err)
+	}
+	name := strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, b.String())
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return "", fmt.Errorf("name template %q gives an empty filename", text)
+	}
+	return name, nil
+}
@@ -0,0 +1,28 @@
+package generator
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is a recovered panic together with the stack of the goroutine
+// that panicked.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+// NewPanicError wraps a value returned by recover. A PanicError passed on
+// from another goroutine keeps its original stack.
+func NewPanicError(v any) *PanicError {
+	if pe, ok := v.(*PanicError); ok {
+		return pe
+	}
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// Error returns the panic value only, so failures can be reported on one
+// line. The stack is written to the failure report.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
@@ -0,0 +1,267 @@
+package generator
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// Stroke sizes of pat6 tiger stripe patterns in cells.
+const (
+	StrokeMinLength    = 40
+	StrokeMaxLength    = 160
+	StrokeMinThickness = 3
+	StrokeMaxThickness = 10
+)
+
+// Pat6Generator makes tiger stripe patterns: brush strokes with jagged
+// edges over a background color, some with thin bands of another color
+// interlocking along their edges. Strokes run horizontally, or at
+// -flow-angle.
+type Pat6Generator struct{}
+
+func (pg *Pat6Generator) Generate(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (image.Image, error) {
+	return renderGridPattern(ctx, cfg, rng, pg, colors)
+}
+
+func (pg *Pat6Generator) buildGrid(ctx context.Context, cfg *config.Config, rng *rand.Rand, colors []color.NRGBA) (*cellGrid, error) {
+	if err := checkDimensions(cfg); err != nil {
+		return nil, err
+	}
+
+	// Shuffle the colors, keeping each color's ratios
+	shuffledColors, perm := shufflePalette(rng, colors)
+	pickers, err := newLayerPickers(cfg.ColorRatios, len(colors), perm)
+	if err != nil {
+		return nil, err
+	}
+	target := make([]float64, len(colors))
+	for i := range target {
+		target[i] = 1 / float64(len(colors))
+	}
+	if pickers.balance != nil {
+		target = pickers.balance.target
+	}
+
+	adjustedBasePixelSize, _ := fitPixelSize(cfg, 1)
+	cols := cellsAcross(cfg.Width, adjustedBasePixelSize)
+	rows := cellsAcross(cfg.Height, adjustedBasePixelSize)
+	angle := config.NormalizeAngle(cfg.FlowAngle)
+	if math.Mod(angle, 180) == 90 {
+		// Strokes are laid horizontally, so vertical ones are laid on the
+		// grid turned on its side, which then turns back exactly
+		cols, rows = rows, cols
+	}
+
+	// The most common color is the background the strokes are laid on
+	background := 0
+	for i, t := range target {
+		if t > target[background] {
+			background = i
+		}
+	}
+	s := &strokeCanvas{grid: newIndexGrid(cols, rows), counts: make([]int, len(colors)), scale: cfg.StructureScale()}
+	for i := range s.grid.cells {
+		s.grid.cells[i] = background
+	}
+	s.counts[background] = len(s.grid.cells)
+	layers := layersFrom(ctx)
+	layers.grid("base", s.grid, adjustedBasePixelSize, shuffledColors)
+
+	// Lay strokes of the color furthest below its share until every color
+	// has reached it. Strokes overlap, so the number needed is capped at a
+	// generous multiple of the cells to cover.
+	total := float64(len(s.grid.cells))
+	maxStrokes := 16 + 8*len(s.grid.cells)/(StrokeMinLength*StrokeMinThickness*s.scale*s.scale)
+	for n := 0; n < maxStrokes; n++ {
+		if n%64 == 0 {
+			if err := ctx.Err(); err != nil {
+				s.grid.release()
+				return nil, err
+			}
+		}
+		c, shortfall := s.mostNeeded(target, total, background, -1)
+		if shortfall <= 0 {
+			break
+		}
+		path := s.stroke(rng, c, shortfall)
+
+		// Interlock a thin band of another color along one edge
+		if rng.Float32() < 0.5 {
+			band := -1
+			if pickers.medium != nil {
+				band = pickers.medium.pick(rng, len(shuffledColors))
+			} else if b, short := s.mostNeeded(target, total, background, c); short > float64(len(path)) {
+				band = b
+			}
+			if band >= 0 && band != c {
+				s.band(rng, path, band)
+			}
+		}
+	}
+	layers.grid("strokes", s.grid, adjustedBasePixelSize, shuffledColors)
+	if angle != 0 {
+		rotated := rotateGrid(s.grid, angle, cellsAcross(cfg.Width, adjustedBasePixelSize), cellsAcross(cfg.Height, adjustedBasePixelSize))
+		s.grid.release()
+		s.grid = rotated
+		layers.grid("rotate", s.grid, adjustedBasePixelSize, shuffledColors)
+	}
+
+	return &cellGrid{
+		cells:         s.grid,
+		cellSize:      adjustedBasePixelSize,
+		basePixelSize: adjustedBasePixelSize,
+		colors:        shuffledColors,
+		detail:        pickers.detail,
+		effectSeed:    rng.Uint64(),
+	}, nil
+}
+
+// rotateGrid returns a grid of cols by rows cells with the contents of g
+// turned angle degrees counterclockwise about their centers. Cells are
+// sampled from g wrapped at its edges, so the result is filled, but it only
+// tiles seamlessly when the angle is a multiple of 90 degrees.
+func rotateGrid(g *indexGrid, angle float64, cols, rows int) *indexGrid {
+	out := newIndexGrid(cols, rows)
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	// Snap exact quarter turns so they map cell to cell
+	sin, cos = math.Round(sin*1e12)/1e12, math.Round(cos*1e12)/1e12
+	for y := 0; y < rows; y++ {
+		row := out.row(y)
+		dy := float64(y) + 0.5 - float64(rows)/2
+		for x := range row {
+			dx := float64(x) + 0.5 - float64(cols)/2
+			// Image rows run downwards, so counterclockwise on screen is
+			// clockwise in grid coordinates
+			sx := int(math.Floor(cos*dx - sin*dy + float64(g.cols)/2))
+			sy := int(math.Floor(sin*dx + cos*dy + float64(g.rows)/2))
+			row[x] = g.at((sx%g.cols+g.cols)%g.cols, (sy%g.rows+g.rows)%g.rows)
+		}
+	}
+	return out
+}
+
+// strokeCanvas is a grid being painted with strokes, wrapping at the edges
+// so the pattern tiles, with a running count of the cells of each color.
+// Strokes are scale times their usual length and thickness.
+type strokeCanvas struct {
+	grid   *indexGrid
+	counts []int
+	scale  int
+}
+
+// strokeColumn is the span of rows a stroke covers in one column.
+type strokeColumn struct {
+	x, top, bottom int
+}
+
+func (s *strokeCanvas) paint(x, y, c int) {
+	x = (x%s.grid.cols + s.grid.cols) % s.grid.cols
+	y = (y%s.grid.rows + s.grid.rows) % s.grid.rows
+	s.counts[s.grid.at(x, y)]--
+	s.counts[c]++
+	s.grid.set(x, y, c)
+}
+
+// mostNeeded returns the color other than background and skip that is
+// furthest below its target share, and by how many cells.
+func (s *strokeCanvas) mostNeeded(target []float64, total float64, background, skip int) (int, float64) {
+	best, shortfall := -1, math.Inf(-1)
+	for i, t := range target {
+		if i == background || i == skip {
+			continue
+		}
+		if d := t*total - float64(s.counts[i]); d > shortfall {
+			best, shortfall = i, d
+		}
+	}
+	return best, shortfall
+}
+
+// stroke paints a horizontal brush stroke of color c that drifts up and
+// down, tapers at both ends and has ragged, toothed edges. Strokes are
+// made smaller to cover about budget cells, so the last strokes of a color
+// don't overshoot its share. It returns the rows covered in each column.
+func (s *strokeCanvas) stroke(rng *rand.Rand, c int, budget float64) []strokeColumn {
+	// Small budgets get thinner strokes too, keeping them at least four
+	// times as long as they are thick
+	thickness := float64(s.scale * (StrokeMinThickness + rng.IntN(StrokeMaxThickness-StrokeMinThickness+1)))
+	thickness = min(thickness, max(StrokeMinThickness, math.Floor(math.Sqrt(budget/4))))
+	length := s.scale * (StrokeMinLength + rng.IntN(StrokeMaxLength-StrokeMinLength+1))
+	length = min(length, max(int(budget/thickness)+1, int(4*thickness)), s.grid.cols)
+	x0 := rng.IntN(s.grid.cols)
+	y := rng.Float64() * float64(s.grid.rows)
+	slope := (rng.Float64()*2 - 1) * 0.15
+
+	var top, bottom jaggedEdge
+	path := make([]strokeColumn, length)
+	for i := range path {
+		// Square root of a sine gives blunt brush ends rather than points
+		t := (float64(i) + 0.5) / float64(length)
+		half := thickness / 2 * math.Sqrt(math.Sin(math.Pi*t))
+
+		y += slope
+		slope = math.Max(-0.3, math.Min(0.3, slope+(rng.Float64()*2-1)*0.03))
+
+		col := strokeColumn{
+			x:      x0 + i,
+			top:    int(math.Round(y-half)) - top.next(rng),
+			bottom: int(math.Round(y+half)) + bottom.next(rng),
+		}
+		for row := col.top; row <= col.bottom; row++ {
+			s.paint(col.x, row, c)
+		}
+		path[i] = col
+	}
+	return path
+}
+
+// band paints a thin band of color c along the top or bottom edge of part
+// of a stroke. Its inner edge is jagged too, so teeth of the band cut into
+// the stroke and the two interlock.
+func (s *strokeCanvas) band(rng *rand.Rand, path []strokeColumn, c int) {
+	start := rng.IntN(len(path)/2 + 1)
+	end := min(len(path), start+len(path)/3+rng.IntN(len(path)/2+1))
+	width := s.scale * (1 + rng.IntN(3))
+	above := rng.IntN(2) == 0
+
+	var outer, inner jaggedEdge
+	for i := start; i < end; i++ {
+		col := path[i]
+		t := (float64(i-start) + 0.5) / float64(end-start)
+		w := int(math.Round(float64(width) * math.Sqrt(math.Sin(math.Pi*t))))
+		out, in := w+outer.next(rng), inner.next(rng)
+		if above {
+			for row := col.top - out; row <= col.top+in; row++ {
+				s.paint(col.x, row, c)
+			}
+		} else {
+			for row := col.bottom - in; row <= col.bottom+out; row++ {
+				s.paint(col.x, row, c)
+			}
+		}
+	}
+}
+
+// jaggedEdge offsets the edge of a stroke column by column: a slow random
+// walk for raggedness plus occasional short teeth.
+type jaggedEdge struct {
+	walk, tooth int
+}
+
+func (e *jaggedEdge) next(rng *rand.Rand) int {
+	e.walk = clamp(e.walk+rng.IntN(3)-1, -1, 2)
+	if e.tooth > 0 {
+		e.tooth--
+		return e.walk + 2
+	}
+	if rng.Float32() < 0.08 {
+		e.tooth = rng.IntN(3)
+		return e.walk + 2 + rng.IntN(2)
+	}
+	return e.walk
+}
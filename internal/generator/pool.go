@@ -0,0 +1,50 @@
+package generator
+
+import (
+	"image"
+	"sync"
+)
+
+// Image buffers and grid cells are pooled per size so that batches of
+// same-sized jobs reuse memory instead of allocating fresh buffers for every
+// pattern. Pooled buffers are not cleared; callers overwrite every element.
+var (
+	imagePools sync.Map // image.Point -> *sync.Pool of *image.NRGBA
+	cellPools  sync.Map // image.Point{n, 1} -> *sync.Pool of []int
+)
+
+func poolFor(pools *sync.Map, size image.Point) *sync.Pool {
+	if p, ok := pools.Load(size); ok {
+		return p.(*sync.Pool)
+	}
+	p, _ := pools.LoadOrStore(size, &sync.Pool{})
+	return p.(*sync.Pool)
+}
+
+func getNRGBA(width, height int) *image.NRGBA {
+	if img, ok := poolFor(&imagePools, image.Pt(width, height)).Get().(*image.NRGBA); ok {
+		return img
+	}
+	return image.NewNRGBA(image.Rect(0, 0, width, height))
+}
+
+// putNRGBA returns an image to its pool. The image must not be used
+// afterwards.
+func putNRGBA(img image.Image) {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		poolFor(&imagePools, nrgba.Rect.Size()).Put(nrgba)
+	}
+}
+
+func getCells(n int) []int {
+	if cells, ok := poolFor(&cellPools, image.Pt(n, 1)).Get().([]int); ok {
+		return cells
+	}
+	return make([]int, n)
+}
+
+func putCells(cells []int) {
+	if len(cells) > 0 {
+		poolFor(&cellPools, image.Pt(len(cells), 1)).Put(cells)
+	}
+}
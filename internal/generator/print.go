@@ -0,0 +1,54 @@
+package generator
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bradsec/gocamo/internal/cmyk"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// cmykProfiles caches parsed ICC profiles by path.
+var cmykProfiles sync.Map
+
+// LoadCMYKProfile reads the ICC output profile used for CMYK conversion. An
+// empty path returns a nil profile, which selects the plain conversion.
+func LoadCMYKProfile(path string) (*cmyk.Profile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if p, ok := cmykProfiles.Load(path); ok {
+		return p.(*cmyk.Profile), nil
+	}
+	p, err := cmyk.LoadProfile(path)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := cmykProfiles.LoadOrStore(path, p)
+	return actual.(*cmyk.Profile), nil
+}
+
+// saveCMYK writes img as a CMYK TIFF next to filePath.
+func saveCMYK(cfg *config.Config, img image.Image, filePath string) error {
+	profile, err := LoadCMYKProfile(cfg.ICCProfile)
+	if err != nil {
+		return err
+	}
+
+	path := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".tif"
+	file, err := os.Create(utils.LongPath(path))
+	if err != nil {
+		return fmt.Errorf("error creating CMYK file: %w", err)
+	}
+	defer file.Close()
+
+	if err := cmyk.Encode(file, img, profile, float64(max(cfg.DPI, 1))); err != nil {
+		return fmt.Errorf("error saving CMYK image %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/bradsec/gocamo/internal/qr"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// qrQuietZone is the light border, in modules, scanners need around a code.
+const qrQuietZone = 4
+
+// minQRContrast is the lowest contrast ratio between the dark and light
+// colors that phone cameras reliably read.
+const minQRContrast = 3
+
+// placeQR paints cfg.QRText as a QR code into the middle of the grid, one
+// or more cells per module, using the darkest and lightest palette colors
+// so the code reads as part of the pattern. The code takes up about half
+// the smaller grid dimension.
+func placeQR(cfg *config.Config, g *cellGrid) error {
+	code, err := qr.Encode([]byte(cfg.QRText))
+	if err != nil {
+		return err
+	}
+	dark, light, err := qrColors(g.colors)
+	if err != nil {
+		return err
+	}
+
+	cols, rows := g.cells.cols, g.cells.rows
+	span := code.Size + 2*qrQuietZone
+	if span > min(cols, rows) {
+		return fmt.Errorf("pattern is too small for the QR code: it needs %d cells of %d pixels across, the pattern has %dx%d",
+			span, g.cellSize, cols, rows)
+	}
+	scale := max(min(cols, rows)/2/span, 1)
+	x0, y0 := (cols-span*scale)/2, (rows-span*scale)/2
+
+	for y := 0; y < span*scale; y++ {
+		row := g.cells.row(y0 + y)
+		my := y/scale - qrQuietZone
+		for x := 0; x < span*scale; x++ {
+			mx := x/scale - qrQuietZone
+			inside := mx >= 0 && my >= 0 && mx < code.Size && my < code.Size
+			if inside && code.Dark(mx, my) {
+				row[x0+x] = dark
+			} else {
+				row[x0+x] = light
+			}
+		}
+	}
+	return nil
+}
+
+// qrColors returns the palette indices of the darkest and lightest opaque
+// colors, or an error if they don't contrast enough to scan.
+func qrColors(colors []color.NRGBA) (dark, light int, err error) {
+	dark, light, ok := ExtremeColors(colors)
+	if !ok {
+		return 0, 0, fmt.Errorf("palette has no opaque colors for the QR code")
+	}
+	contrast := (luminance(colors[light]) + 0.05) / (luminance(colors[dark]) + 0.05)
+	if contrast < minQRContrast {
+		return 0, 0, fmt.Errorf("palette contrast %.1f:1 is too low for a scannable QR code (at least %d:1 is needed between its darkest and lightest colors)",
+			contrast, minQRContrast)
+	}
+	return dark, light, nil
+}
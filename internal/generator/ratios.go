@@ -0,0 +1,119 @@
+package generator
+
+import (
+	"fmt"
+	"image/color"
+	"math/rand/v2"
+	"sort"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// ratioWeights returns the weight of each of n palette colors, or nil if the
+// colors are used in equal proportions.
+func ratioWeights(r config.ColorRatios, n int) ([]float64, error) {
+	switch {
+	case r.Scheme == "marpat":
+		return marpatWeights(n), nil
+	case r.Scheme != "":
+		return nil, fmt.Errorf("unknown ratio scheme: %s", r.Scheme)
+	case r.Weights == nil:
+		return nil, nil
+	case len(r.Weights) != n:
+		return nil, fmt.Errorf("%d color ratio(s) given for a palette of %d colors", len(r.Weights), n)
+	}
+	return r.Weights, nil
+}
+
+// marpatWeights gives the first palette colors the largest share, falling
+// off linearly so the last color still covers about half as much as the
+// first. For 4 colors this is 6:5:4:3, close to the MARPAT proportions, and
+// the same shape scales to any palette size.
+func marpatWeights(n int) []float64 {
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = float64(n-i) + float64(n)/2
+	}
+	return weights
+}
+
+// colorPicker draws palette indices in proportion to their weights. A nil
+// picker draws every color with equal probability.
+type colorPicker struct {
+	cumulative []float64
+}
+
+func newColorPicker(weights []float64) *colorPicker {
+	if weights == nil {
+		return nil
+	}
+	p := &colorPicker{cumulative: make([]float64, len(weights))}
+	total := 0.0
+	for i, w := range weights {
+		total += w
+		p.cumulative[i] = total
+	}
+	return p
+}
+
+func (p *colorPicker) pick(rng *rand.Rand, n int) int {
+	if p == nil {
+		return rng.IntN(n)
+	}
+	target := rng.Float64() * p.cumulative[len(p.cumulative)-1]
+	return sort.Search(len(p.cumulative), func(i int) bool { return p.cumulative[i] > target })
+}
+
+// layerPickers draws palette indices for each pattern layer.
+type layerPickers struct {
+	macro, medium, detail *colorPicker
+	// balance keeps the macro layer at its ratios through the cellular
+	// automaton passes
+	balance *ratioBalance
+}
+
+// newLayerPickers returns the pickers for a palette of n colors shuffled by
+// perm, where perm[i] is the original index of shuffled color i.
+func newLayerPickers(r config.LayerRatios, n int, perm []int) (*layerPickers, error) {
+	weights := make(map[string][]float64, 3)
+	for name, ratios := range r.Layers() {
+		w, err := ratioWeights(ratios, n)
+		if err != nil {
+			return nil, fmt.Errorf("%s layer: %w", name, err)
+		}
+		weights[name] = permute(w, perm)
+	}
+	return &layerPickers{
+		macro:   newColorPicker(weights["macro"]),
+		medium:  newColorPicker(weights["medium"]),
+		detail:  newColorPicker(weights["detail"]),
+		balance: newRatioBalance(weights["macro"]),
+	}, nil
+}
+
+func permute(weights []float64, perm []int) []float64 {
+	if weights == nil {
+		return nil
+	}
+	permuted := make([]float64, len(perm))
+	for i, j := range perm {
+		permuted[i] = weights[j]
+	}
+	return permuted
+}
+
+// shufflePalette shuffles the colors and returns the original index of each
+// shuffled color, so that per-color settings can follow the shuffle.
+func shufflePalette(rng *rand.Rand, colors []color.NRGBA) ([]color.NRGBA, []int) {
+	shuffled := make([]color.NRGBA, len(colors))
+	copy(shuffled, colors)
+	perm := make([]int, len(colors))
+	for i := range perm {
+		perm[i] = i
+	}
+	rng.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		perm[i], perm[j] = perm[j], perm[i]
+	})
+	return shuffled, perm
+}
@@ -0,0 +1,108 @@
+package generator
+
+import (
+	"context"
+	"time"
+
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+const (
+	// minTimeout keeps small jobs failing fast while allowing for process
+	// start-up noise such as a cold disk or a busy machine.
+	minTimeout = 15 * time.Second
+	// timeoutFactor is how much slower than the estimate a job may run
+	// before it is considered stuck. It covers slower machines and several
+	// jobs sharing the CPUs.
+	timeoutFactor = 10
+)
+
+// Approximate costs on a single modern core, used to scale the timeout.
+const (
+	encodeNsPerPixel = 20  // PNG encoding of flat regions
+	effectNsPerPixel = 30  // each of noise and edge details, which also compress poorly
+	imageNsPerPixel  = 500 // resizing, pooling, filtering and color mapping
+	cellNs           = 300 // cellular automaton passes per grid cell
+)
+
+// EstimateDuration returns the approximate time a single job takes to render
+// and encode on one core.
+func EstimateDuration(cfg *config.Config) time.Duration {
+	pixels := float64(cfg.Width) * float64(cfg.Height)
+
+	ns := pixels * encodeNsPerPixel
+	if cfg.AddNoise {
+		ns += pixels * effectNsPerPixel
+	}
+	if cfg.AddEdge {
+		ns += pixels * effectNsPerPixel
+	}
+
+	if cfg.PatternType == "image" {
+		ns += pixels * imageNsPerPixel
+	} else {
+		patterns := []string{cfg.PatternType}
+		if cfg.PatternType == "composite" {
+			// Both layers are built in full
+			patterns = cfg.Layers[:]
+		}
+		size, _ := PixelSize(cfg)
+		for _, patternType := range patterns {
+			cell := float64(size)
+			if patternType == "blob" {
+				cell *= blobScale
+			}
+			cellCost := float64(cellNs)
+			if patternType != "pat6" {
+				cellCost *= float64(cfg.AutomatonPasses()) / config.DefaultCAPasses
+			}
+			if k := float64(cfg.StructureScale()); patternType != "hex" && patternType != "pat6" {
+				// Votes are counted over neighborhoods k times as wide,
+				// and wider again with -ca-radius
+				usual := config.DefaultBlobRadius
+				if patternType == "box" {
+					usual = config.DefaultBoxRadius
+				}
+				r := float64(cfg.AutomatonRadius(patternType)) / float64(usual)
+				cellCost *= k * k * r * r
+			}
+			if !cfg.ColorRatios.Macro.IsZero() {
+				// Passes are repeated to match the color ratios
+				cellCost *= balanceAttempts / 2
+			}
+			ns += pixels / (cell * cell) * cellCost
+		}
+	}
+
+	if cfg.Animate > 0 {
+		// Each distinct frame is rendered and encoded like the pattern,
+		// with two steps of the cellular automaton before it
+		frames := float64(cfg.Animate/2 + 1)
+		ns += frames * (ns + pixels*effectNsPerPixel)
+	}
+
+	return time.Duration(ns)
+}
+
+// JobTimeout returns how long a job may run before it is abandoned, or 0
+// for no limit. Unless -timeout sets it, it grows with the estimated
+// duration, so very large renders are not cut off while small jobs still
+// fail fast.
+func JobTimeout(cfg *config.Config) time.Duration {
+	switch {
+	case cfg.Timeout == config.NoTimeout:
+		return 0
+	case cfg.Timeout > 0:
+		return cfg.Timeout
+	}
+	return max(minTimeout, timeoutFactor*EstimateDuration(cfg))
+}
+
+// JobContext returns a context for rendering a job, cancelled once the job
+// runs longer than JobTimeout.
+func JobContext(parent context.Context, cfg *config.Config) (context.Context, context.CancelFunc) {
+	if timeout := JobTimeout(cfg); timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
@@ -3,79 +3,170 @@ package generator
 import (
 	"image"
 	"image/color"
-	"math/rand"
+	"math/rand/v2"
+	"runtime"
+	"sync"
 )
 
-func addNoiseRGBA(img *image.RGBA, colors []color.RGBA) {
-	bounds := img.Bounds()
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			if rand.Float32() < 0.05 { // 5% chance to add noise
-				noiseColor := colors[rand.Intn(len(colors))]
-				currentColor := img.RGBAAt(x, y)
+// minBandRows is the smallest band of rows worth handing to its own goroutine.
+const minBandRows = 64
 
-				// Blend the current color with the noise color
-				r := uint8((int(currentColor.R) + int(noiseColor.R)) / 2)
-				g := uint8((int(currentColor.G) + int(noiseColor.G)) / 2)
-				b := uint8((int(currentColor.B) + int(noiseColor.B)) / 2)
+// parallelRows splits [0, height) into horizontal bands and calls fn for each
+// band concurrently, returning once all bands are done. A panic in any band
+// is re-raised in the caller as a *PanicError holding the band's stack.
+func parallelRows(height int, fn func(y0, y1 int)) {
+	bands := runtime.GOMAXPROCS(0)
+	if maxBands := height / minBandRows; bands > maxBands {
+		bands = maxBands
+	}
+	if bands <= 1 {
+		fn(0, height)
+		return
+	}
 
-				img.Set(x, y, color.RGBA{r, g, b, 255})
-			}
-		}
+	var wg sync.WaitGroup
+	var panicOnce sync.Once
+	var panicked *PanicError
+	bandHeight := (height + bands - 1) / bands
+	for y0 := 0; y0 < height; y0 += bandHeight {
+		y1 := min(y0+bandHeight, height)
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			defer func() {
+				if v := recover(); v != nil {
+					panicOnce.Do(func() { panicked = NewPanicError(v) })
+				}
+			}()
+			fn(y0, y1)
+		}(y0, y1)
+	}
+	wg.Wait()
+
+	if panicked != nil {
+		panic(panicked)
 	}
 }
 
-func addNoiseNRGBA(img *image.NRGBA, colors []color.RGBA) {
+// rowRand reseeds src for row y of the full image and returns a generator
+// drawing from it. Seeding per row keeps random post effects identical no
+// matter how the image is split into bands.
+func rowRand(src *rand.PCG, seed uint64, y int) *rand.Rand {
+	src.Seed(seed, uint64(y))
+	return rand.New(src)
+}
+
+// renderGrid paints every grid cell as a cellSize×cellSize block of its
+// palette color, writing straight into the pixel buffer. Each pixel row is
+// filled once per cell row and copied to the rows below it. The grid wraps
+// if the image extends beyond it. Rows are rendered in parallel bands. img
+// may hold a strip of the full image, in which case y0 is the row of the
+// full image its first row corresponds to.
+func renderGrid(img *image.NRGBA, y0 int, grid *indexGrid, cellSize int, colors []color.NRGBA) {
 	bounds := img.Bounds()
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			if rand.Float32() < 0.05 { // 5% chance to add noise
-				noiseColor := colors[rand.Intn(len(colors))]
-				currentColor := img.NRGBAAt(x, y)
+	width, height := bounds.Dx(), bounds.Dy()
+	rows, cols := grid.rows, grid.cols
+	rowBytes := width * 4
 
-				// Blend the current color with the noise color
-				r := uint8((int(currentColor.R) + int(noiseColor.R)) / 2)
-				g := uint8((int(currentColor.G) + int(noiseColor.G)) / 2)
-				b := uint8((int(currentColor.B) + int(noiseColor.B)) / 2)
+	parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			dst := img.Pix[y*img.Stride : y*img.Stride+rowBytes]
+			if (y0+y)%cellSize != 0 && y > start {
+				copy(dst, img.Pix[(y-1)*img.Stride:(y-1)*img.Stride+rowBytes])
+				continue
+			}
 
-				img.Set(x, y, color.RGBA{r, g, b, 255})
+			cells := grid.row(((y0 + y) / cellSize) % rows)
+			for x := 0; x < width; {
+				c := colors[cells[(x/cellSize)%cols]]
+				end := min((x/cellSize+1)*cellSize, width)
+				for ; x < end; x++ {
+					i := x * 4
+					dst[i], dst[i+1], dst[i+2], dst[i+3] = c.R, c.G, c.B, c.A
+				}
 			}
 		}
-	}
+	})
 }
 
-func addEdgeDetailsRGBA(img *image.RGBA, basePixelSize int) {
+// addNoiseNRGBA blends random palette colors into the fraction chance of
+// the pixels. y0 is the row of the full image the first row of img
+// corresponds to. Noise colors are drawn with picker and mixed in linear
+// light unless legacy is set.
+func addNoiseNRGBA(img *image.NRGBA, y0 int, seed uint64, colors []color.NRGBA, picker *colorPicker, chance float32, legacy bool) {
 	bounds := img.Bounds()
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			if x%basePixelSize == 0 || y%basePixelSize == 0 {
-				if rand.Float32() < 0.4 { // 40% chance for edge details
-					currentColor := img.RGBAAt(x, y)
-					r := uint8(clamp(int(currentColor.R)+rand.Intn(41)-20, 0, 255))
-					g := uint8(clamp(int(currentColor.G)+rand.Intn(41)-20, 0, 255))
-					b := uint8(clamp(int(currentColor.B)+rand.Intn(41)-20, 0, 255))
-					img.Set(x, y, color.RGBA{r, g, b, 255})
+	forEachNoise(bounds.Dx(), bounds.Dy(), y0, seed, len(colors), picker, chance, func(x, y, idx int) {
+		noiseColor := colors[idx]
+		p := img.Pix[y*img.Stride+x*4 : y*img.Stride+x*4+4]
+
+		// Transparent holes stay clear, and transparent noise adds nothing
+		if p[3] == 0 || noiseColor.A == 0 {
+			return
+		}
+
+		// Blend the current color with the noise color
+		if legacy {
+			p[0] = uint8((int(p[0]) + int(noiseColor.R)) / 2)
+			p[1] = uint8((int(p[1]) + int(noiseColor.G)) / 2)
+			p[2] = uint8((int(p[2]) + int(noiseColor.B)) / 2)
+		} else {
+			p[0] = mixLinear(p[0], noiseColor.R)
+			p[1] = mixLinear(p[1], noiseColor.G)
+			p[2] = mixLinear(p[2], noiseColor.B)
+		}
+	})
+}
+
+// forEachNoise calls fn with the position and palette index of every noise
+// pixel in a width×height strip starting at row y0 of the full image. Rows
+// are visited in parallel bands, so fn must only touch its own pixel.
+func forEachNoise(width, height, y0 int, seed uint64, n int, picker *colorPicker, chance float32, fn func(x, y, idx int)) {
+	parallelRows(height, func(start, end int) {
+		src := &rand.PCG{}
+		for y := start; y < end; y++ {
+			rng := rowRand(src, seed, y0+y)
+			for x := 0; x < width; x++ {
+				if rng.Float32() < chance {
+					fn(x, y, picker.pick(rng, n))
 				}
 			}
 		}
-	}
+	})
 }
 
-func addEdgeDetailsNRGBA(img *image.NRGBA, basePixelSize int) {
-	bounds := img.Bounds()
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			if x%basePixelSize == 0 || y%basePixelSize == 0 {
-				if rand.Float32() < 0.4 { // 40% chance for edge details
-					currentColor := img.NRGBAAt(x, y)
-					r := uint8(clamp(int(currentColor.R)+rand.Intn(41)-20, 0, 255))
-					g := uint8(clamp(int(currentColor.G)+rand.Intn(41)-20, 0, 255))
-					b := uint8(clamp(int(currentColor.B)+rand.Intn(41)-20, 0, 255))
-					img.Set(x, y, color.RGBA{r, g, b, 255})
+// addEdgeDetailsNRGBA varies the color of pixels along the base pixel grid
+// by up to strength steps. y0 is the row of the full image the first row of
+// img corresponds to. The variation is applied in linear light unless
+// legacy is set.
+func addEdgeDetailsNRGBA(img *image.NRGBA, y0 int, seed uint64, basePixelSize, strength int, legacy bool) {
+	width := img.Bounds().Dx()
+	// Use a different stream from the noise pass
+	seed = ^seed
+	parallelRows(img.Bounds().Dy(), func(start, end int) {
+		src := &rand.PCG{}
+		for y := start; y < end; y++ {
+			rng := rowRand(src, seed, y0+y)
+			row := img.Pix[y*img.Stride:]
+			for x := 0; x < width; x++ {
+				if x%basePixelSize == 0 || (y0+y)%basePixelSize == 0 {
+					if rng.Float32() < 0.4 { // 40% chance for edge details
+						p := row[x*4 : x*4+4]
+						if p[3] == 0 {
+							continue
+						}
+						for c := 0; c < 3; c++ {
+							steps := rng.IntN(2*strength+1) - strength
+							if legacy {
+								p[c] = uint8(clamp(int(p[c])+steps, 0, 255))
+							} else {
+								p[c] = varyLinear(p[c], steps)
+							}
+						}
+					}
 				}
 			}
 		}
-	}
+	})
 }
 
 func clamp(value, min, max int) int {
@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// uvMask marks which pixels of a UV layout template belong to an island.
+type uvMask struct {
+	width, height int
+	island        []bool
+}
+
+// uvMasks caches decoded templates by path, as every job uses the same one.
+var uvMasks sync.Map
+
+// loadUVMask reads a UV layout template. The top-left pixel is taken as the
+// background (transparent or a solid color) and every pixel that differs
+// from it is part of an island.
+func loadUVMask(path string) (*uvMask, error) {
+	if m, ok := uvMasks.Load(path); ok {
+		return m.(*uvMask), nil
+	}
+
+	img, err := utils.LoadImage(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading UV template: %w", err)
+	}
+	b := img.Bounds()
+	m := &uvMask{width: b.Dx(), height: b.Dy(), island: make([]bool, b.Dx()*b.Dy())}
+
+	br, bg, bb, ba := img.At(b.Min.X, b.Min.Y).RGBA()
+	islands := 0
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			r, g, b2, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			var background bool
+			if ba < 0x8000 {
+				// Transparent background: islands are the opaque areas
+				background = a < 0x8000
+			} else {
+				background = r == br && g == bg && b2 == bb && a == ba
+			}
+			if !background {
+				m.island[y*m.width+x] = true
+				islands++
+			}
+		}
+	}
+	if islands == 0 {
+		return nil, fmt.Errorf("UV template %s has no islands (every pixel matches the background)", path)
+	}
+
+	actual, _ := uvMasks.LoadOrStore(path, m)
+	return actual.(*uvMask), nil
+}
+
+// UVTemplateSize returns the dimensions of a UV layout template.
+func UVTemplateSize(path string) (int, int, error) {
+	m, err := loadUVMask(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return m.width, m.height, nil
+}
+
+// applyUVLayout keeps the pattern inside the template's islands and clears
+// everything else to transparent. Island edges are extended outwards by
+// cfg.UVBleed pixels with their nearest edge color, so texture filtering
+// and mipmapping don't pull background into the islands and show seams.
+func applyUVLayout(cfg *config.Config, img image.Image) (image.Image, error) {
+	m, err := loadUVMask(cfg.UVTemplate)
+	if err != nil {
+		return nil, err
+	}
+	dst := toNRGBA(img)
+	if dst.Bounds().Dx() != m.width || dst.Bounds().Dy() != m.height {
+		return nil, fmt.Errorf("pattern is %dx%d but UV template is %dx%d",
+			dst.Bounds().Dx(), dst.Bounds().Dy(), m.width, m.height)
+	}
+
+	filled := make([]bool, len(m.island))
+	copy(filled, m.island)
+	for i, inside := range m.island {
+		if !inside {
+			clear(dst.Pix[i*4 : i*4+4])
+		}
+	}
+
+	// Grow the islands one pixel per step, copying a filled neighbor
+	next := make([]bool, len(filled))
+	for step := 0; step < cfg.UVBleed; step++ {
+		copy(next, filled)
+		grown := false
+		for y := 0; y < m.height; y++ {
+			for x := 0; x < m.width; x++ {
+				i := y*m.width + x
+				if filled[i] {
+					continue
+				}
+				for _, n := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+					nx, ny := n[0], n[1]
+					if nx < 0 || ny < 0 || nx >= m.width || ny >= m.height || !filled[ny*m.width+nx] {
+						continue
+					}
+					j := ny*m.width + nx
+					copy(dst.Pix[i*4:i*4+4], dst.Pix[j*4:j*4+4])
+					next[i] = true
+					grown = true
+					break
+				}
+			}
+		}
+		filled, next = next, filled
+		if !grown {
+			break
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,46 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"math/rand/v2"
+
+	"github.com/bradsec/gocamo/internal/svg"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// generateSVG builds the cell grid and writes it as vector shapes instead of
+// rendering pixels.
+func generateSVG(ctx context.Context, cfg *config.Config, rng *rand.Rand, b gridBuilder, colors []color.NRGBA, filePath string) error {
+	g, err := b.buildGrid(ctx, cfg, rng, colors)
+	if err != nil {
+		return fmt.Errorf("error generating pattern: %w", err)
+	}
+	defer g.release()
+
+	if err := finishGrid(ctx, cfg, g); err != nil {
+		return err
+	}
+
+	f, err := utils.CreateAtomic(filePath, cfg.Force)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	defer f.Close()
+
+	err = svg.Encode(f, svg.Grid{
+		Cols:     g.cells.cols,
+		Rows:     g.cells.rows,
+		CellSize: g.cellSize,
+		Width:    cfg.Width,
+		Height:   cfg.Height,
+		At:       g.cells.at,
+		Colors:   g.colors,
+	})
+	if err != nil {
+		return fmt.Errorf("error saving SVG: %w", err)
+	}
+	return f.Commit()
+}
@@ -0,0 +1,181 @@
+// Package golden renders a fixed set of small patterns in golden mode and
+// compares them with committed reference PNGs, so generator refactors can be
+// checked for unintended output changes.
+package golden
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// Case is one golden image.
+type Case struct {
+	Name        string
+	PatternType string
+	AddNoise    bool
+	AddEdge     bool
+	Banded      bool
+}
+
+var Cases = []Case{
+	{Name: "box", PatternType: "box"},
+	{Name: "box_noise_edge", PatternType: "box", AddNoise: true, AddEdge: true},
+	{Name: "box_banded", PatternType: "box", Banded: true},
+	{Name: "blob", PatternType: "blob"},
+	{Name: "blob_noise_edge", PatternType: "blob", AddNoise: true, AddEdge: true},
+	{Name: "pat6", PatternType: "pat6"},
+	{Name: "hex", PatternType: "hex"},
+	{Name: "image", PatternType: "image"},
+}
+
+var palette = config.CamoColors{
+	Name:   "golden",
+	Colors: []string{"#46482f", "#6d6851", "#9b967f", "#1e2415"},
+}
+
+// ReferenceImage is the input used by the image case, relative to the
+// repository root.
+const ReferenceImage = "input/photo_jungle.jpg"
+
+// Config returns the golden mode configuration for c.
+func (c Case) Config() *config.Config {
+	return &config.Config{
+		Width:         256,
+		Height:        192,
+		BasePixelSize: 4,
+		Cores:         1,
+		PatternType:   c.PatternType,
+		AddNoise:      c.AddNoise,
+		AddEdge:       c.AddEdge,
+		Banded:        c.Banded,
+		KValue:        4,
+		KMeansBatch:   1024,
+		Scaler:        "bilinear",
+		Seed:          config.GoldenSeed,
+		Golden:        true,
+	}
+}
+
+// Render generates c and returns the encoded PNG.
+func Render(ctx context.Context, c Case) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "gocamo-golden")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := c.Config()
+	var frame *generator.Frame
+	if c.PatternType == "image" {
+		frame, err = generator.RenderFromImage(ctx, cfg, ReferenceImage, 0, dir)
+	} else {
+		frame, err = generator.RenderPattern(ctx, cfg, palette, 0, dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := frame.Save(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out.FilePath)
+}
+
+// Result is the outcome of comparing one case with its golden file.
+type Result struct {
+	Case Case
+	// DiffPixels is the number of differing pixels, or -1 if the golden
+	// file is missing. Zero with Match false means the pixels agree but
+	// the encoded bytes differ.
+	DiffPixels int
+	Match      bool
+}
+
+// Compare renders every case and compares it with the golden files in dir.
+func Compare(ctx context.Context, dir string) ([]Result, error) {
+	results := make([]Result, 0, len(Cases))
+	for _, c := range Cases {
+		got, err := Render(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Name, err)
+		}
+
+		want, err := os.ReadFile(Path(dir, c))
+		if os.IsNotExist(err) {
+			results = append(results, Result{Case: c, DiffPixels: -1})
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Name, err)
+		}
+
+		r := Result{Case: c, Match: bytes.Equal(got, want)}
+		if !r.Match {
+			if r.DiffPixels, err = diffPixels(got, want); err != nil {
+				return nil, fmt.Errorf("%s: %w", c.Name, err)
+			}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// Update renders every case and overwrites its golden file in dir.
+func Update(ctx context.Context, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating golden directory: %w", err)
+	}
+	for _, c := range Cases {
+		data, err := Render(ctx, c)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+		if err := os.WriteFile(Path(dir, c), data, 0644); err != nil {
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+func Path(dir string, c Case) string {
+	return filepath.Join(dir, c.Name+".png")
+}
+
+func diffPixels(a, b []byte) (int, error) {
+	imgA, err := png.Decode(bytes.NewReader(a))
+	if err != nil {
+		return 0, err
+	}
+	imgB, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	if imgA.Bounds() != imgB.Bounds() {
+		return imgA.Bounds().Dx() * imgA.Bounds().Dy(), nil
+	}
+
+	n := 0
+	bounds := imgA.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !samePixel(imgA, imgB, x, y) {
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+func samePixel(a, b image.Image, x, y int) bool {
+	r1, g1, b1, a1 := a.At(x, y).RGBA()
+	r2, g2, b2, a2 := b.At(x, y).RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
@@ -0,0 +1,41 @@
+package golden
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGolden renders every case and compares it with the golden files
+// checked in under testdata/golden. If a change in output is intended,
+// regenerate them with gocamo golden -update.
+func TestGolden(t *testing.T) {
+	// The golden files and ReferenceImage are relative to the repository
+	// root
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join("..", "..")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	dir := filepath.Join("testdata", "golden")
+	results, err := Compare(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		switch {
+		case r.Match:
+		case r.DiffPixels < 0:
+			t.Errorf("%s: missing %s", r.Case.Name, Path(dir, r.Case))
+		case r.DiffPixels == 0:
+			t.Errorf("%s: pixels match but encoded bytes differ", r.Case.Name)
+		default:
+			t.Errorf("%s: %d pixel(s) differ", r.Case.Name, r.DiffPixels)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package logging sets up the leveled logger that pattern generation
+// reports on: plain messages for a terminal by default, or one JSON object
+// per line for pipelines.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Options select how much is logged and in what form.
+type Options struct {
+	// Quiet logs errors only
+	Quiet bool
+	// Verbose adds debug messages such as per-job timing
+	Verbose bool
+	// JSON logs JSON objects instead of text
+	JSON bool
+	// Stderr logs everything to standard error, leaving standard output
+	// for the generated image
+	Stderr bool
+}
+
+// Setup makes the logger for opts the default slog logger and returns the
+// writer progress bars should go to, which discards them when they would
+// get in the way of the log.
+func Setup(opts Options) io.Writer {
+	level := slog.LevelInfo
+	switch {
+	case opts.Quiet:
+		level = slog.LevelError
+	case opts.Verbose:
+		level = slog.LevelDebug
+	}
+
+	out := os.Stdout
+	if opts.Stderr {
+		out = os.Stderr
+	}
+
+	if opts.JSON {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})))
+		return io.Discard
+	}
+	slog.SetDefault(slog.New(&textHandler{level: level, out: out, errOut: os.Stderr, mu: &sync.Mutex{}}))
+	// Per-job debug messages would break up the progress bar line
+	if opts.Quiet || opts.Verbose || opts.Stderr {
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+// textHandler writes each message on a line of its own, with errors on
+// standard error. Text messages carry their own details, so attributes only
+// appear in the JSON log.
+type textHandler struct {
+	level       slog.Level
+	out, errOut io.Writer
+	mu          *sync.Mutex
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var sb strings.Builder
+	out := h.out
+	switch {
+	case r.Level >= slog.LevelError:
+		sb.WriteString("Error: ")
+		out = h.errOut
+	case r.Level >= slog.LevelWarn:
+		sb.WriteString("Warning: ")
+	}
+	sb.WriteString(r.Message)
+	sb.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *textHandler) WithGroup(string) slog.Handler { return h }
@@ -0,0 +1,104 @@
+// Package ora writes layered images in the OpenRaster format, which Krita,
+// GIMP and MyPaint open with their layers intact.
+package ora
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// thumbnailSize is the largest thumbnail dimension allowed by the format.
+const thumbnailSize = 256
+
+// Layer is one layer of the stack, drawn over the layers before it.
+type Layer struct {
+	Name  string
+	Image image.Image
+}
+
+// Encode writes the layers, bottom first, as an OpenRaster file. merged is
+// the flattened image, stored for viewers that don't read layers.
+func Encode(w io.Writer, layers []Layer, merged image.Image) error {
+	zw := zip.NewWriter(w)
+
+	// The mimetype must come first and be stored uncompressed
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mw, "image/openraster"); err != nil {
+		return err
+	}
+
+	b := merged.Bounds()
+	var stack strings.Builder
+	fmt.Fprintf(&stack, "<?xml version='1.0' encoding='UTF-8'?>\n<image version=\"0.0.5\" w=\"%d\" h=\"%d\">\n <stack>\n", b.Dx(), b.Dy())
+	// The stack lists the top layer first
+	for i := len(layers) - 1; i >= 0; i-- {
+		var name strings.Builder
+		xml.EscapeText(&name, []byte(layers[i].Name))
+		fmt.Fprintf(&stack, "  <layer name=\"%s\" src=\"data/layer%d.png\" x=\"0\" y=\"0\" opacity=\"1.0\" visibility=\"visible\" composite-op=\"svg:src-over\"/>\n",
+			name.String(), i)
+	}
+	stack.WriteString(" </stack>\n</image>\n")
+
+	if err := writeFile(zw, "stack.xml", func(w io.Writer) error {
+		_, err := io.WriteString(w, stack.String())
+		return err
+	}); err != nil {
+		return err
+	}
+
+	for i, l := range layers {
+		if err := writePNG(zw, fmt.Sprintf("data/layer%d.png", i), l.Image); err != nil {
+			return fmt.Errorf("error writing layer %s: %w", l.Name, err)
+		}
+	}
+	if err := writePNG(zw, "mergedimage.png", merged); err != nil {
+		return err
+	}
+	if err := writePNG(zw, "Thumbnails/thumbnail.png", thumbnail(merged)); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeFile(zw *zip.Writer, name string, write func(io.Writer) error) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	return write(w)
+}
+
+// writePNG stores img uncompressed in the archive, as PNG data is already
+// compressed.
+func writePNG(zw *zip.Writer, name string, img image.Image) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+// thumbnail scales img to fit within thumbnailSize, keeping its aspect ratio.
+func thumbnail(img image.Image) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= thumbnailSize && b.Dy() <= thumbnailSize {
+		return img
+	}
+	w, h := thumbnailSize, b.Dy()*thumbnailSize/b.Dx()
+	if b.Dy() > b.Dx() {
+		w, h = b.Dx()*thumbnailSize/b.Dy(), thumbnailSize
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,295 @@
+// Package pngmeta adds text chunks to PNG files as they are written and
+// reads them back, so outputs can carry a description of how they were
+// made. It can also embed an ICC color profile.
+package pngmeta
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+// signature starts every PNG file.
+const signature = "\x89PNG\r\n\x1a\n"
+
+// headerLen is the length of the signature and the IHDR chunk, which must
+// come first. Text chunks are inserted right after it.
+const headerLen = len(signature) + 8 + 13 + 4
+
+// Text is a keyword and UTF-8 text. Plain ASCII text is stored in a tEXt
+// chunk, which more tools show, and anything else in an iTXt chunk.
+type Text struct {
+	Keyword string
+	Value   string
+}
+
+// Profile is an ICC color profile, embedded in an iCCP chunk.
+type Profile struct {
+	// Name identifies the profile; it is made to fit the 1-79 Latin-1
+	// characters a PNG allows
+	Name string
+	Data []byte
+}
+
+// writer inserts an iCCP chunk and text chunks after the IHDR chunk of a
+// PNG stream.
+type writer struct {
+	w       io.Writer
+	profile *Profile
+	texts   []Text
+	header  []byte
+	done    bool
+}
+
+// NewWriter returns a writer that passes a PNG stream through to w, adding
+// a text chunk for each text after the header.
+func NewWriter(w io.Writer, texts ...Text) io.Writer {
+	return NewProfileWriter(w, nil, texts...)
+}
+
+// NewProfileWriter is like NewWriter, and also embeds profile unless it is
+// nil. The stream must not have an sRGB or iCCP chunk of its own.
+func NewProfileWriter(w io.Writer, profile *Profile, texts ...Text) io.Writer {
+	return &writer{w: w, profile: profile, texts: texts, done: profile == nil && len(texts) == 0}
+}
+
+func (tw *writer) Write(p []byte) (int, error) {
+	if tw.done {
+		return tw.w.Write(p)
+	}
+
+	n := min(headerLen-len(tw.header), len(p))
+	tw.header = append(tw.header, p[:n]...)
+	if len(tw.header) < headerLen {
+		return len(p), nil
+	}
+	if string(tw.header[:len(signature)]) != signature || string(tw.header[len(signature)+4:len(signature)+8]) != "IHDR" {
+		return 0, errors.New("not a PNG stream")
+	}
+
+	tw.done = true
+	if _, err := tw.w.Write(tw.header); err != nil {
+		return 0, err
+	}
+	if tw.profile != nil {
+		data, err := iCCP(tw.profile)
+		if err != nil {
+			return 0, err
+		}
+		if err := writeChunk(tw.w, "iCCP", data); err != nil {
+			return 0, err
+		}
+	}
+	for _, t := range tw.texts {
+		typ, data := "iTXt", iTXt(t)
+		if isASCII(t.Value) {
+			typ, data = "tEXt", tEXt(t)
+		}
+		if err := writeChunk(tw.w, typ, data); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tw.w.Write(p[n:]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// iCCP encodes an iCCP chunk, which holds the profile compressed.
+func iCCP(p *Profile) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(profileName(p.Name))
+	// The name ends with a null byte, and compression method 0 is zlib
+	buf.Write([]byte{0, 0})
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(p.Data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// profileName makes name a valid profile name: printable Latin-1 without
+// leading, trailing or consecutive spaces, at most 79 characters long.
+func profileName(name string) string {
+	var b []byte
+	for _, r := range name {
+		switch {
+		case r == ' ' && (len(b) == 0 || b[len(b)-1] == ' '):
+		case r >= 0x20 && r <= 0x7e || r >= 0xa1 && r <= 0xff:
+			b = append(b, byte(r))
+		}
+		if len(b) == 79 {
+			break
+		}
+	}
+	if s := strings.TrimRight(string(b), " "); s != "" {
+		return s
+	}
+	return "ICC profile"
+}
+
+// tEXt encodes a tEXt chunk.
+func tEXt(t Text) []byte {
+	data := make([]byte, 0, len(t.Keyword)+len(t.Value)+1)
+	data = append(data, t.Keyword...)
+	data = append(data, 0)
+	return append(data, t.Value...)
+}
+
+// isASCII reports whether s is printable ASCII text, which reads the same
+// as the ISO 8859-1 of a tEXt chunk.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c != '\n' && (c < 0x20 || c > 0x7e) {
+			return false
+		}
+	}
+	return true
+}
+
+// iTXt encodes an uncompressed iTXt chunk without a language tag.
+func iTXt(t Text) []byte {
+	data := make([]byte, 0, len(t.Keyword)+len(t.Value)+5)
+	data = append(data, t.Keyword...)
+	data = append(data, 0, 0, 0, 0, 0)
+	return append(data, t.Value...)
+}
+
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	buf := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(data)))
+	copy(buf[4:8], typ)
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	_, err := w.Write(buf)
+	return err
+}
+
+// Read returns the tEXt, zTXt and iTXt chunks of a PNG stream by keyword.
+// Reading stops at the image data, which text chunks written by NewWriter
+// come before.
+func Read(r io.Reader) (map[string]string, error) {
+	sig := make([]byte, len(signature))
+	if _, err := io.ReadFull(r, sig); err != nil || string(sig) != signature {
+		return nil, errors.New("not a PNG file")
+	}
+
+	texts := make(map[string]string)
+	var head [8]byte
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return texts, nil
+			}
+			return nil, fmt.Errorf("error reading chunk: %w", err)
+		}
+		length, typ := binary.BigEndian.Uint32(head[:4]), string(head[4:8])
+		if typ == "IDAT" || typ == "IEND" {
+			return texts, nil
+		}
+
+		parse, ok := parsers[typ]
+		if !ok || length > maxTextLen {
+			if _, err := io.CopyN(io.Discard, r, int64(length)+4); err != nil {
+				return nil, fmt.Errorf("error reading %s chunk: %w", typ, err)
+			}
+			continue
+		}
+
+		data := make([]byte, int(length)+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("error reading %s chunk: %w", typ, err)
+		}
+		keyword, value, err := parse(data[:length])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s chunk: %w", typ, err)
+		}
+		texts[keyword] = value
+	}
+}
+
+// maxTextLen is the largest text chunk read. Larger ones are skipped.
+const maxTextLen = 1 << 20
+
+var parsers = map[string]func([]byte) (string, string, error){
+	"tEXt": parseText,
+	"zTXt": parseZText,
+	"iTXt": parseIText,
+}
+
+// ReadFile returns the text chunks of the PNG file at path.
+func ReadFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func parseText(data []byte) (string, string, error) {
+	keyword, text, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return "", "", errors.New("missing keyword")
+	}
+	return string(keyword), latin1(text), nil
+}
+
+func parseZText(data []byte) (string, string, error) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 1 {
+		return "", "", errors.New("missing keyword")
+	}
+	text, err := inflate(rest[1:])
+	if err != nil {
+		return "", "", err
+	}
+	return string(keyword), latin1(text), nil
+}
+
+func parseIText(data []byte) (string, string, error) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 2 {
+		return "", "", errors.New("missing keyword")
+	}
+	compressed := rest[0] == 1
+	// Skip the language tag and translated keyword
+	rest = rest[2:]
+	for range 2 {
+		if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+			return "", "", errors.New("truncated header")
+		}
+	}
+	if compressed {
+		text, err := inflate(rest)
+		return string(keyword), string(text), err
+	}
+	return string(keyword), string(rest), nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// latin1 converts ISO 8859-1 text, which tEXt and zTXt chunks use, to UTF-8.
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
@@ -0,0 +1,194 @@
+// Package qr encodes text as a QR code matrix (ISO/IEC 18004, byte mode,
+// versions 1 to 10).
+package qr
+
+import (
+	"fmt"
+)
+
+// Level is an error correction level.
+type Level int
+
+const (
+	L Level = iota // recovers about 7% of codewords
+	M              // 15%
+	Q              // 25%
+	H              // 30%
+)
+
+func (l Level) String() string {
+	return [...]string{"L", "M", "Q", "H"}[l]
+}
+
+// formatBits are the error correction bits written in the format information.
+var formatBits = [...]int{L: 1, M: 0, Q: 3, H: 2}
+
+// blockSpec describes the error correction blocks of one version and level:
+// ecc codewords per block, then the number of blocks and data codewords
+// per block in each of up to two groups.
+type blockSpec struct {
+	ecc            int
+	blocks1, data1 int
+	blocks2, data2 int
+}
+
+// blockSpecs is indexed by version-1 and level.
+var blockSpecs = [10][4]blockSpec{
+	{{7, 1, 19, 0, 0}, {10, 1, 16, 0, 0}, {13, 1, 13, 0, 0}, {17, 1, 9, 0, 0}},
+	{{10, 1, 34, 0, 0}, {16, 1, 28, 0, 0}, {22, 1, 22, 0, 0}, {28, 1, 16, 0, 0}},
+	{{15, 1, 55, 0, 0}, {26, 1, 44, 0, 0}, {18, 2, 17, 0, 0}, {22, 2, 13, 0, 0}},
+	{{20, 1, 80, 0, 0}, {18, 2, 32, 0, 0}, {26, 2, 24, 0, 0}, {16, 4, 9, 0, 0}},
+	{{26, 1, 108, 0, 0}, {24, 2, 43, 0, 0}, {18, 2, 15, 2, 16}, {22, 2, 11, 2, 12}},
+	{{18, 2, 68, 0, 0}, {16, 4, 27, 0, 0}, {24, 4, 19, 0, 0}, {28, 4, 15, 0, 0}},
+	{{20, 2, 78, 0, 0}, {18, 4, 31, 0, 0}, {18, 2, 14, 4, 15}, {26, 4, 13, 1, 14}},
+	{{24, 2, 97, 0, 0}, {22, 2, 38, 2, 39}, {22, 4, 18, 2, 19}, {26, 4, 14, 2, 15}},
+	{{30, 2, 116, 0, 0}, {22, 3, 36, 2, 37}, {20, 4, 16, 4, 17}, {24, 4, 12, 4, 13}},
+	{{18, 2, 68, 2, 69}, {26, 4, 43, 1, 44}, {24, 6, 19, 2, 20}, {28, 6, 15, 2, 16}},
+}
+
+// alignmentPositions lists the alignment pattern centers of each version.
+var alignmentPositions = [10][]int{
+	nil, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+	{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50},
+}
+
+// MaxVersion is the largest version Encode produces.
+const MaxVersion = len(blockSpecs)
+
+func (s blockSpec) dataCodewords() int {
+	return s.blocks1*s.data1 + s.blocks2*s.data2
+}
+
+// Code is an encoded QR symbol without its quiet zone.
+type Code struct {
+	Version int
+	Level   Level
+	Size    int
+	modules []bool
+}
+
+// Dark reports whether the module at (x, y) is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y*c.Size+x]
+}
+
+// Encode returns the QR code for data, using the strongest error
+// correction level that fits within MaxVersion, and the smallest version
+// for that level.
+func Encode(data []byte) (*Code, error) {
+	for level := H; level >= L; level-- {
+		for version := 1; version <= MaxVersion; version++ {
+			if capacity(version, level) >= len(data) {
+				return encode(data, version, level), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("text is %d bytes, at most %d fit in a version %d QR code", len(data), capacity(MaxVersion, L), MaxVersion)
+}
+
+// capacity returns how many bytes fit in a version and level.
+func capacity(version int, level Level) int {
+	bits := blockSpecs[version-1][level].dataCodewords()*8 - 4 - countBits(version)
+	return bits / 8
+}
+
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+func encode(data []byte, version int, level Level) *Code {
+	spec := blockSpecs[version-1][level]
+	codewords := interleave(dataCodewords(data, version, spec), spec)
+
+	size := version*4 + 17
+	c := &Code{Version: version, Level: level, Size: size}
+	m := newMatrix(size)
+	m.drawFunctionPatterns(version)
+	m.drawCodewords(codewords)
+
+	// Pick the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		m.applyMask(mask)
+		m.drawFormat(level, mask)
+		if p := m.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		m.applyMask(mask) // masking twice undoes it
+	}
+	m.applyMask(best)
+	m.drawFormat(level, best)
+
+	c.modules = m.dark
+	return c
+}
+
+// dataCodewords encodes data in byte mode, padded to the capacity of spec.
+func dataCodewords(data []byte, version int, spec blockSpec) []byte {
+	var bb bitBuffer
+	bb.append(0b0100, 4) // byte mode
+	bb.append(len(data), countBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	capacityBits := spec.dataCodewords() * 8
+	bb.append(0, min(4, capacityBits-len(bb))) // terminator
+	bb.append(0, (8-len(bb)%8)%8)
+	for pad := 0xEC; len(bb) < capacityBits; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+	return bb.bytes()
+}
+
+// interleave splits data into the blocks of spec, adds each block's error
+// correction codewords and interleaves the result.
+func interleave(data []byte, spec blockSpec) []byte {
+	divisor := rsDivisor(spec.ecc)
+	var blocks, eccs [][]byte
+	for i := 0; i < spec.blocks1+spec.blocks2; i++ {
+		n := spec.data1
+		if i >= spec.blocks1 {
+			n = spec.data2
+		}
+		blocks = append(blocks, data[:n])
+		eccs = append(eccs, rsRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+
+	var out []byte
+	for i := 0; i < max(spec.data1, spec.data2); i++ {
+		for _, b := range blocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < spec.ecc; i++ {
+		for _, e := range eccs {
+			out = append(out, e[i])
+		}
+	}
+	return out
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, v>>i&1 == 1)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return out
+}
@@ -0,0 +1,270 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// decode reads the text back out of a code the way a scanner would after
+// locating it: format information, unmasking, the zigzag codeword order,
+// deinterleaving and the byte mode segment. Every block must have zero
+// Reed-Solomon syndromes, so no error correction is attempted.
+func decode(c *Code) ([]byte, Level, error) {
+	version := (c.Size - 17) / 4
+	if version < 1 || version > MaxVersion || version*4+17 != c.Size {
+		return nil, 0, fmt.Errorf("invalid size %d", c.Size)
+	}
+	if version >= 7 {
+		var bits int
+		for i := 0; i < 18; i++ {
+			if c.Dark(c.Size-11+i%3, i/3) {
+				bits |= 1 << i
+			}
+			if c.Dark(c.Size-11+i%3, i/3) != c.Dark(i/3, c.Size-11+i%3) {
+				return nil, 0, fmt.Errorf("version information copies differ at bit %d", i)
+			}
+		}
+		if bits>>12 != version {
+			return nil, 0, fmt.Errorf("version information is %d, size says %d", bits>>12, version)
+		}
+	}
+
+	// Both copies of the format information must hold the same valid code
+	var first, second int
+	for i := 0; i < 15; i++ {
+		var x, y int
+		switch {
+		case i <= 5:
+			x, y = 8, i
+		case i == 6:
+			x, y = 8, 7
+		case i == 7:
+			x, y = 8, 8
+		case i == 8:
+			x, y = 7, 8
+		default:
+			x, y = 14-i, 8
+		}
+		if c.Dark(x, y) {
+			first |= 1 << i
+		}
+		x, y = c.Size-1-i, 8
+		if i >= 8 {
+			x, y = 8, c.Size-15+i
+		}
+		if c.Dark(x, y) {
+			second |= 1 << i
+		}
+	}
+	if first != second {
+		return nil, 0, fmt.Errorf("format information copies differ: %015b and %015b", first, second)
+	}
+	format := first ^ 0x5412
+	rem := format >> 10
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	if rem != format&0x3FF {
+		return nil, 0, fmt.Errorf("invalid format information %015b", first)
+	}
+	mask := format >> 10 & 7
+	level := Level(-1)
+	for l, b := range formatBits {
+		if b == format>>13 {
+			level = Level(l)
+		}
+	}
+
+	function := newMatrix(c.Size)
+	function.drawFunctionPatterns(version)
+	var bits bitBuffer
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for x := right; x > right-2; x-- {
+				if function.function[y*c.Size+x] {
+					continue
+				}
+				bits = append(bits, c.Dark(x, y) != masked(mask, x, y))
+			}
+		}
+	}
+
+	// Deinterleave into blocks of data followed by error correction
+	spec := blockSpecs[version-1][level]
+	codewords := bits[:len(bits)/8*8].bytes() // drop the remainder bits
+	blocks := make([][]byte, spec.blocks1+spec.blocks2)
+	next := 0
+	for i := 0; i < max(spec.data1, spec.data2); i++ {
+		for b := range blocks {
+			if b < spec.blocks1 && i >= spec.data1 || b >= spec.blocks1 && i >= spec.data2 {
+				continue
+			}
+			blocks[b] = append(blocks[b], codewords[next])
+			next++
+		}
+	}
+	var data []byte
+	for _, b := range blocks {
+		data = append(data, b...)
+	}
+	for i := 0; i < spec.ecc; i++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], codewords[next])
+			next++
+		}
+	}
+	for b, block := range blocks {
+		for i := 0; i < spec.ecc; i++ {
+			// Evaluate the block at 2^i with Horner's rule
+			alpha := byte(1)
+			for range i {
+				alpha = gfMul(alpha, 2)
+			}
+			var s byte
+			for _, v := range block {
+				s = gfMul(s, alpha) ^ v
+			}
+			if s != 0 {
+				return nil, 0, fmt.Errorf("block %d has syndrome %d of %#02x", b, i, s)
+			}
+		}
+	}
+
+	read := func(pos *int, n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(data[*pos/8]>>(7-*pos%8)&1)
+			*pos++
+		}
+		return v
+	}
+	pos := 0
+	if mode := read(&pos, 4); mode != 0b0100 {
+		return nil, 0, fmt.Errorf("mode %04b, want byte mode", mode)
+	}
+	n := read(&pos, countBits(version))
+	if (pos+n*8+7)/8 > len(data) {
+		return nil, 0, fmt.Errorf("length %d overruns %d data codewords", n, len(data))
+	}
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = byte(read(&pos, 8))
+	}
+	return out, level, nil
+}
+
+// masked reports whether mask inverts the module at (x, y), per the
+// conditions of the standard.
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (y+x)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (y+x)%3 == 0
+	case 4:
+		return (y/2+x/3)%2 == 0
+	case 5:
+		return y*x%2+y*x%3 == 0
+	case 6:
+		return (y*x%2+y*x%3)%2 == 0
+	default:
+		return ((y+x)%2+y*x%3)%2 == 0
+	}
+}
+
+func text(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*37 + 11)
+	}
+	return b
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for version := 1; version <= MaxVersion; version++ {
+		for level := L; level <= H; level++ {
+			data := text(capacity(version, level))
+			c := encode(data, version, level)
+			got, gotLevel, err := decode(c)
+			if err != nil {
+				t.Errorf("version %d-%v: %v", version, level, err)
+				continue
+			}
+			if gotLevel != level || !bytes.Equal(got, data) {
+				t.Errorf("version %d-%v: decoded %d bytes at level %v, want %d bytes at level %v",
+					version, level, len(got), gotLevel, len(data), level)
+			}
+		}
+	}
+}
+
+func TestEncodeVersionBoundaries(t *testing.T) {
+	tests := []struct {
+		n       int
+		version int
+		level   Level
+	}{
+		{0, 1, H},
+		{7, 1, H},
+		{8, 2, H},
+		{14, 2, H},
+		{15, 3, H},
+		{24, 3, H},
+		{25, 4, H},
+		{34, 4, H},
+		{35, 5, H},
+		{44, 5, H},
+		{45, 6, H},
+		{58, 6, H},
+		{59, 7, H},
+		{64, 7, H},
+		{65, 8, H},
+		{84, 8, H},
+		{85, 9, H},
+		{98, 9, H},
+		{99, 10, H},
+		{119, 10, H},
+		{120, 9, Q},
+		{151, 10, Q},
+		{152, 8, M},
+		{213, 10, M},
+		{214, 9, L},
+		{271, 10, L},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.n), func(t *testing.T) {
+			data := text(tt.n)
+			c, err := Encode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Version != tt.version || c.Level != tt.level {
+				t.Errorf("Encode() = version %d-%v, want %d-%v", c.Version, c.Level, tt.version, tt.level)
+			}
+			got, _, err := decode(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("decoded %x, want %x", got, data)
+			}
+		})
+	}
+
+	if _, err := Encode(text(272)); err == nil {
+		t.Error("Encode() of 272 bytes succeeded, want an error")
+	}
+}
@@ -0,0 +1,200 @@
+// Package server renders patterns on demand over HTTP, for web apps that
+// need camouflage previews without running the command line tool.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/png"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/internal/utils"
+	"github.com/bradsec/gocamo/internal/worker"
+	"github.com/bradsec/gocamo/pkg/config"
+	"github.com/bradsec/gocamo/pkg/gocamo"
+)
+
+// Options configure a Server.
+type Options struct {
+	// Workers is the most patterns rendered at once.
+	Workers int
+	// MaxMemory bounds the estimated memory of the patterns rendered at
+	// once, in bytes. Zero disables the check.
+	MaxMemory uint64
+	// MaxDimension is the largest width or height a request may ask for.
+	MaxDimension int
+}
+
+// Server renders the patterns requested by its handler, limiting how many
+// run at once with the same limiter as the worker pool.
+type Server struct {
+	opts    Options
+	limiter *worker.Limiter
+}
+
+// New returns a server rendering with opts.
+func New(opts Options) *Server {
+	return &Server{opts: opts, limiter: worker.NewLimiter(opts.MaxMemory, max(opts.Workers, 1))}
+}
+
+// Handler returns the HTTP handler serving GET /pattern.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pattern", s.pattern)
+	return mux
+}
+
+// pattern renders the pattern described by the query parameters and
+// streams it as a PNG. The seed is returned in the X-Gocamo-Seed header so
+// a random pattern can be requested again.
+func (s *Server) pattern(w http.ResponseWriter, r *http.Request) {
+	opts, err := s.parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg := &config.Config{
+		PatternType:   string(opts.Pattern),
+		Width:         opts.Width,
+		Height:        opts.Height,
+		BasePixelSize: opts.PixelSize,
+		AddNoise:      opts.Noise,
+		AddEdge:       opts.Edge,
+	}
+	mem := generator.EstimateMemory(cfg)
+	// A client that disconnects while queued gives up its place
+	if err := s.limiter.AcquireContext(r.Context(), mem); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	start := time.Now()
+	ctx, cancel := generator.JobContext(r.Context(), cfg)
+	img, err := gocamo.Generate(ctx, opts)
+	cancel()
+	if err != nil {
+		s.limiter.Release(mem, 0, time.Since(start))
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	s.limiter.Release(mem, uint64(opts.Width)*uint64(opts.Height), time.Since(start))
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Gocamo-Seed", fmt.Sprintf("%#x", opts.Seed))
+	if err := utils.SaveImage(img, w, utils.EncodeOptions{Compression: png.BestSpeed}); err != nil {
+		// The response has started, so the client only sees a cut off image
+		slog.Error(fmt.Sprintf("Error sending pattern: %v", err), "error", err)
+	}
+}
+
+// statusClientClosedRequest is the nonstandard status, used by nginx among
+// others, of requests whose client went away before the response.
+const statusClientClosedRequest = 499
+
+// errorStatus returns the response status for a request that failed with
+// err once its options were accepted.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// parseQuery reads the pattern options from the query parameters: colors
+// (required, comma-separated hex codes with or without #), type, w, h, b,
+// r, noise, edge and seed.
+func (s *Server) parseQuery(r *http.Request) (gocamo.Options, error) {
+	q := r.URL.Query()
+	opts := gocamo.Options{
+		Pattern: gocamo.Pattern(q.Get("type")),
+		Ratios:  q.Get("r"),
+		Seed:    rand.Uint64(),
+	}
+	if opts.Pattern == "" {
+		opts.Pattern = gocamo.Box
+	}
+	if !slices.Contains(gocamo.Patterns, opts.Pattern) {
+		names := make([]string, len(gocamo.Patterns))
+		for i, p := range gocamo.Patterns {
+			names[i] = string(p)
+		}
+		return opts, fmt.Errorf("invalid type: %s (must be one of %s)", opts.Pattern, strings.Join(names, ", "))
+	}
+
+	colors := q.Get("colors")
+	if colors == "" {
+		return opts, fmt.Errorf("missing colors")
+	}
+	opts.Colors = strings.Split(colors, ",")
+	if _, err := utils.HexToRGBA(opts.Colors); err != nil {
+		return opts, fmt.Errorf("invalid colors: %w", err)
+	}
+	// Checked here so that only failures to render are server errors
+	ratios, err := config.ParseLayerRatios(opts.Ratios)
+	if err != nil {
+		return opts, fmt.Errorf("invalid r: %w", err)
+	}
+	for layer, r := range ratios.Layers() {
+		if r.Weights != nil && len(r.Weights) != len(opts.Colors) {
+			return opts, fmt.Errorf("invalid r: %d %s color ratio(s) given for %d colors", len(r.Weights), layer, len(opts.Colors))
+		}
+	}
+
+	ints := []struct {
+		name     string
+		dst      *int
+		fallback int
+	}{
+		{"w", &opts.Width, gocamo.DefaultWidth},
+		{"h", &opts.Height, gocamo.DefaultHeight},
+		{"b", &opts.PixelSize, gocamo.DefaultPixelSize},
+	}
+	for _, p := range ints {
+		*p.dst = p.fallback
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return opts, fmt.Errorf("invalid %s: %s", p.name, v)
+			}
+			*p.dst = n
+		}
+	}
+	if opts.Width < generator.MinDimension || opts.Height < generator.MinDimension ||
+		opts.Width > s.opts.MaxDimension || opts.Height > s.opts.MaxDimension {
+		return opts, fmt.Errorf("width and height must be between %d and %d pixels, got %dx%d",
+			generator.MinDimension, s.opts.MaxDimension, opts.Width, opts.Height)
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *bool
+	}{{"noise", &opts.Noise}, {"edge", &opts.Edge}} {
+		if v := q.Get(p.name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s: %s", p.name, v)
+			}
+			*p.dst = b
+		}
+	}
+
+	if v := q.Get("seed"); v != "" {
+		seed, err := strconv.ParseUint(v, 0, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid seed: %s", v)
+		}
+		opts.Seed = seed
+	}
+	return opts, nil
+}
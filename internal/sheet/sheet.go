@@ -0,0 +1,162 @@
+// Package sheet draws contact sheets: one image with a labeled preview of
+// every pattern of a run, for picking favorites at a glance.
+package sheet
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/bradsec/gocamo/internal/utils"
+)
+
+// Tile is a pattern to preview.
+type Tile struct {
+	File string
+	// Title is the first label line, such as the palette name
+	Title string
+	// Colors are the palette hex codes, drawn as swatches and listed
+	// below the title
+	Colors []string
+}
+
+// Layout of each tile: the preview fits in a thumbSize square above the
+// label, with margin pixels around both.
+const (
+	thumbSize  = 240
+	margin     = 8
+	lineHeight = 14
+	swatchSize = 10
+)
+
+var (
+	background = color.NRGBA{0x20, 0x20, 0x20, 0xff}
+	textColor  = color.NRGBA{0xe8, 0xe8, 0xe8, 0xff}
+	dimColor   = color.NRGBA{0xa0, 0xa0, 0xa0, 0xff}
+)
+
+// Write draws the tiles in a grid of about equal rows and columns and saves
+// it as a PNG at path. It returns the size of the sheet.
+func Write(path string, tiles []Tile) (image.Point, error) {
+	if len(tiles) == 0 {
+		return image.Point{}, fmt.Errorf("no patterns to preview")
+	}
+
+	cols := int(math.Ceil(math.Sqrt(float64(len(tiles)))))
+	rows := (len(tiles) + cols - 1) / cols
+	tileW := thumbSize + 2*margin
+	tileH := thumbSize + 4*margin + swatchSize + 3*lineHeight
+	sheet := image.NewNRGBA(image.Rect(0, 0, cols*tileW, rows*tileH))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	for i, t := range tiles {
+		origin := image.Pt(i%cols*tileW+margin, i/cols*tileH+margin)
+		if err := drawTile(sheet, origin, t); err != nil {
+			return image.Point{}, err
+		}
+	}
+
+	f, err := os.Create(utils.LongPath(path))
+	if err != nil {
+		return image.Point{}, fmt.Errorf("error creating contact sheet: %w", err)
+	}
+	defer f.Close()
+	if err := utils.SaveImage(sheet, f, utils.EncodeOptions{Compression: png.DefaultCompression}); err != nil {
+		return image.Point{}, fmt.Errorf("error saving contact sheet: %w", err)
+	}
+	return sheet.Bounds().Size(), f.Close()
+}
+
+// drawTile draws the preview of t with its top left corner at origin, then
+// the palette swatches and the label below it.
+func drawTile(dst *image.NRGBA, origin image.Point, t Tile) error {
+	img, err := utils.LoadImage(t.File)
+	if err != nil {
+		return err
+	}
+
+	// Scale the pattern to fit the square, centered, keeping its aspect
+	b := img.Bounds()
+	scale := min(float64(thumbSize)/float64(b.Dx()), float64(thumbSize)/float64(b.Dy()))
+	w, h := max(1, int(float64(b.Dx())*scale)), max(1, int(float64(b.Dy())*scale))
+	at := origin.Add(image.Pt((thumbSize-w)/2, (thumbSize-h)/2))
+	draw.CatmullRom.Scale(dst, image.Rect(at.X, at.Y, at.X+w, at.Y+h), img, b, draw.Over, nil)
+
+	// One swatch per palette color across the tile
+	y := origin.Y + thumbSize + margin
+	for i, hex := range t.Colors {
+		c, err := utils.ParseColor(hex)
+		if err != nil {
+			continue
+		}
+		x0 := origin.X + i*thumbSize/len(t.Colors)
+		x1 := origin.X + (i+1)*thumbSize/len(t.Colors)
+		draw.Draw(dst, image.Rect(x0, y, x1, y+swatchSize), image.NewUniform(c), image.Point{}, draw.Over)
+	}
+
+	y += swatchSize + margin
+	drawText(dst, origin.X, y, t.Title, textColor)
+	for i, line := range wrapColors(t.Colors, 2) {
+		drawText(dst, origin.X, y+(i+1)*lineHeight, line, dimColor)
+	}
+	return nil
+}
+
+var face = basicfont.Face7x13
+
+// maxChars is the number of characters that fit across a tile.
+var maxChars = thumbSize / face.Advance
+
+// drawText draws s with its top at y, cut short to fit the tile.
+func drawText(dst *image.NRGBA, x, y int, s string, c color.Color) {
+	if len(s) > maxChars {
+		s = s[:maxChars-3] + "..."
+	}
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y+face.Ascent),
+	}
+	d.DrawString(s)
+}
+
+// wrapColors lists the hex codes on at most n lines that fit across a
+// tile, ending the last line with an ellipsis if they don't all fit.
+func wrapColors(colors []string, n int) []string {
+	var lines []string
+	line := ""
+	for _, hex := range colors {
+		if !strings.HasPrefix(hex, "#") && !utils.IsTransparent(hex) {
+			hex = "#" + hex
+		}
+		if line != "" && len(line)+1+len(hex) > maxChars {
+			if len(lines) == n-1 {
+				// The rest won't fit; leave room for the ellipsis
+				for len(line)+4 > maxChars && strings.Contains(line, " ") {
+					line = line[:strings.LastIndexByte(line, ' ')]
+				}
+				return append(lines, line+" ...")
+			}
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += hex
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
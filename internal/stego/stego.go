@@ -0,0 +1,141 @@
+// Package stego hides a short payload in the least significant bits of an
+// image's color channels, for tracing where a copy of a pattern came from.
+// The change is at most one step per channel, which is invisible, but it
+// only survives lossless formats and unedited pixels.
+package stego
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+)
+
+// MaxPayload is the longest payload that can be embedded, in bytes.
+const MaxPayload = 255
+
+// magic marks an embedded payload.
+var magic = []byte("GCMO")
+
+// ErrNoPayload is returned by Extract when an image has no payload.
+var ErrNoPayload = errors.New("no payload found")
+
+// Embed writes payload into the red, green and blue least significant bits
+// of img. Bits are spread over the image in a fixed scattered order so
+// they don't form a visible band, skipping fully transparent pixels.
+func Embed(img *image.NRGBA, payload []byte) error {
+	if len(payload) > MaxPayload {
+		return fmt.Errorf("payload is %d bytes, at most %d are supported", len(payload), MaxPayload)
+	}
+	data := make([]byte, 0, len(magic)+1+len(payload)+4)
+	data = append(data, magic...)
+	data = append(data, byte(len(payload)))
+	data = append(data, payload...)
+	data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(payload))
+
+	w := newWalk(img)
+	for _, b := range data {
+		for bit := 7; bit >= 0; bit-- {
+			p, ok := w.next()
+			if !ok {
+				return fmt.Errorf("image is too small to hold a %d byte payload", len(payload))
+			}
+			*p = *p&^1 | (b>>bit)&1
+		}
+	}
+	return nil
+}
+
+// Extract returns the payload embedded in img, or ErrNoPayload.
+func Extract(img image.Image) ([]byte, error) {
+	w := newWalk(toNRGBA(img))
+	read := func(n int) ([]byte, bool) {
+		out := make([]byte, n)
+		for i := range out {
+			for bit := 0; bit < 8; bit++ {
+				p, ok := w.next()
+				if !ok {
+					return nil, false
+				}
+				out[i] = out[i]<<1 | *p&1
+			}
+		}
+		return out, true
+	}
+
+	header, ok := read(len(magic) + 1)
+	if !ok || string(header[:len(magic)]) != string(magic) {
+		return nil, ErrNoPayload
+	}
+	payload, ok := read(int(header[len(magic)]))
+	if !ok {
+		return nil, ErrNoPayload
+	}
+	sum, ok := read(4)
+	if !ok || binary.BigEndian.Uint32(sum) != crc32.ChecksumIEEE(payload) {
+		return nil, fmt.Errorf("payload is damaged (checksum mismatch)")
+	}
+	return payload, nil
+}
+
+// walk visits the color channels of every non-transparent pixel once, in a
+// scattered order. Stepping through the pixels by a stride coprime with
+// their count reaches each exactly once without storing a permutation.
+type walk struct {
+	img           *image.NRGBA
+	n, stride, at int
+	visited       int
+	channel       int
+}
+
+func newWalk(img *image.NRGBA) *walk {
+	b := img.Bounds()
+	n := b.Dx() * b.Dy()
+	stride := max(int(float64(n)*0.6180339887)|1, 1)
+	for gcd(stride, n) != 1 {
+		stride += 2
+	}
+	return &walk{img: img, n: n, stride: stride, at: n / 2, channel: 3}
+}
+
+// next returns the next channel byte to hold a bit.
+func (w *walk) next() (*uint8, bool) {
+	for w.channel == 3 {
+		if w.visited == w.n {
+			return nil, false
+		}
+		w.at = (w.at + w.stride) % w.n
+		w.visited++
+		if w.pixel()[3] != 0 {
+			w.channel = 0
+		}
+	}
+	p := &w.pixel()[w.channel]
+	w.channel++
+	return p, true
+}
+
+func (w *walk) pixel() []uint8 {
+	b := w.img.Bounds()
+	x, y := w.at%b.Dx(), w.at/b.Dx()
+	i := w.img.PixOffset(b.Min.X+x, b.Min.Y+y)
+	return w.img.Pix[i : i+4]
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		return nrgba
+	}
+	nrgba := image.NewNRGBA(img.Bounds())
+	draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return nrgba
+}
@@ -0,0 +1,99 @@
+// Package svg writes grid patterns as SVG vector images, so they can be
+// printed at any size without pixelation.
+package svg
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// Grid is a pattern of palette indices drawn as square cells.
+type Grid struct {
+	Cols, Rows int
+	// CellSize is the size of a cell in pixels
+	CellSize int
+	// Width and Height are the image size in pixels. Cells reaching past
+	// the right and bottom edges are cropped.
+	Width, Height int
+	// At returns the palette index of the cell at x, y
+	At     func(x, y int) int
+	Colors []color.NRGBA
+}
+
+// Encode writes g as an SVG image with one path per palette color.
+// Neighboring cells of the same color are merged into rectangles to keep the
+// file small. Fully transparent colors are left out and partly transparent
+// ones get a fill opacity.
+func Encode(w io.Writer, g Grid) error {
+	bw := bufio.NewWriterSize(w, 1<<16)
+	fmt.Fprintf(bw, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		g.Width, g.Height, g.Width, g.Height)
+	fmt.Fprintf(bw, `<g transform="scale(%d)">`+"\n", g.CellSize)
+
+	rects := mergeCells(g)
+	for i, c := range g.Colors {
+		if c.A == 0 || len(rects[i]) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, `<path fill="#%02x%02x%02x"`, c.R, c.G, c.B)
+		if c.A != 255 {
+			fmt.Fprintf(bw, ` fill-opacity="%.3g"`, float64(c.A)/255)
+		}
+		bw.WriteString(` d="`)
+		for _, r := range rects[i] {
+			fmt.Fprintf(bw, "M%d %dh%dv%dh-%dz", r.x, r.y, r.w, r.h, r.w)
+		}
+		bw.WriteString("\"/>\n")
+	}
+
+	bw.WriteString("</g>\n</svg>\n")
+	return bw.Flush()
+}
+
+type rect struct {
+	x, y, w, h int
+}
+
+// mergeCells covers the grid with rectangles of one color each, grouped by
+// palette index. Each rectangle grows greedily right and then down from the
+// first uncovered cell in reading order.
+func mergeCells(g Grid) [][]rect {
+	rects := make([][]rect, len(g.Colors))
+	done := make([]bool, g.Cols*g.Rows)
+	for y := 0; y < g.Rows; y++ {
+		for x := 0; x < g.Cols; x++ {
+			if done[y*g.Cols+x] {
+				continue
+			}
+			c := g.At(x, y)
+
+			w := 1
+			for x+w < g.Cols && !done[y*g.Cols+x+w] && g.At(x+w, y) == c {
+				w++
+			}
+			h := 1
+		grow:
+			for y+h < g.Rows {
+				for dx := 0; dx < w; dx++ {
+					if done[(y+h)*g.Cols+x+dx] || g.At(x+dx, y+h) != c {
+						break grow
+					}
+				}
+				h++
+			}
+
+			for dy := 0; dy < h; dy++ {
+				for dx := 0; dx < w; dx++ {
+					done[(y+dy)*g.Cols+x+dx] = true
+				}
+			}
+			if c >= 0 && c < len(rects) {
+				rects[c] = append(rects[c], rect{x, y, w, h})
+			}
+		}
+	}
+	return rects
+}
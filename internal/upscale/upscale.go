@@ -0,0 +1,94 @@
+// Package upscale enlarges existing patterns. Digital patterns are found
+// to be drawn on a grid of cells and are enlarged cell by cell, so they
+// stay crisp; anything else is resampled smoothly.
+package upscale
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// CellSize returns the width and height of the cells a digital pattern is
+// drawn in: the largest sizes whose multiples every change of color along
+// the rows and columns falls on. Patterns without a grid, such as organic
+// patterns or those with noise, give 1. An image of one color is a single
+// cell.
+func CellSize(img image.Image) (w, h int) {
+	src := toNRGBA(img)
+	b := src.Bounds()
+	for y := 0; y < b.Dy() && w != 1; y++ {
+		for x := 1; x < b.Dx(); x++ {
+			if src.NRGBAAt(x, y) != src.NRGBAAt(x-1, y) {
+				if w = gcd(w, x); w == 1 {
+					break
+				}
+			}
+		}
+	}
+	for x := 0; x < b.Dx() && h != 1; x++ {
+		for y := 1; y < b.Dy(); y++ {
+			if src.NRGBAAt(x, y) != src.NRGBAAt(x, y-1) {
+				if h = gcd(h, y); h == 1 {
+					break
+				}
+			}
+		}
+	}
+	if w == 0 {
+		w = b.Dx()
+	}
+	if h == 0 {
+		h = b.Dy()
+	}
+	return w, h
+}
+
+// Cells enlarges a pattern of cellW x cellH cells so that each cell becomes
+// newW x newH pixels of its color. Every whole cell comes out the same
+// size, with no blur, and partial cells at the right and bottom edges are
+// enlarged in proportion.
+func Cells(img image.Image, cellW, cellH, newW, newH int) *image.NRGBA {
+	src := toNRGBA(img)
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, scaled(b.Dx(), newW, cellW), scaled(b.Dy(), newH, cellH)))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		// Every pixel of a cell has its color, so take the first
+		sy := y / newH * cellH
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			dst.SetNRGBA(x, y, src.NRGBAAt(x/newW*cellW, sy))
+		}
+	}
+	return dst
+}
+
+// Smooth enlarges img to width x height with Catmull-Rom resampling, the
+// highest quality kernel available, for patterns without a grid of cells.
+func Smooth(img image.Image, width, height int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// scaled returns n scaled by num/den, rounded.
+func scaled(n, num, den int) int {
+	return max(1, int(math.Round(float64(n)*float64(num)/float64(den))))
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+	return nrgba
+}
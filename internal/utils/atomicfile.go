@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// AtomicFile is written to a temporary file next to its path and only
+// appears at the path when committed, so an interrupted or failed write
+// never leaves a truncated file behind.
+type AtomicFile struct {
+	*os.File
+	path      string
+	overwrite bool
+	committed bool
+}
+
+// CreateAtomic starts writing the file at path. Commit fails if a file has
+// appeared at path in the meantime, unless overwrite is set.
+func CreateAtomic(path string, overwrite bool) (*AtomicFile, error) {
+	f, err := os.CreateTemp(LongPath(filepath.Dir(path)), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// Temporary files are private, but outputs get the permissions of
+	// every other file gocamo writes
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &AtomicFile{File: f, path: path, overwrite: overwrite}, nil
+}
+
+// Commit closes the file and moves it to its path.
+func (f *AtomicFile) Commit() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	if f.overwrite {
+		if err := os.Rename(f.Name(), LongPath(f.path)); err != nil {
+			return err
+		}
+	} else if err := moveNoReplace(f.Name(), LongPath(f.path)); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s was created by something else while it was being written", f.path)
+		}
+		return err
+	}
+	f.committed = true
+	return nil
+}
+
+// Close discards the file unless it was committed. It is safe to call after
+// Commit, so it can be deferred.
+func (f *AtomicFile) Close() error {
+	if f.committed {
+		return nil
+	}
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
+// moveNoReplace moves the file at from to to, failing with fs.ErrExist
+// rather than replacing a file there. A hard link checks and moves in one
+// step; on filesystems without hard links the check comes just before the
+// rename.
+func moveNoReplace(from, to string) error {
+	err := os.Link(from, to)
+	switch {
+	case err == nil:
+		return os.Remove(from)
+	case errors.Is(err, fs.ErrExist):
+		return err
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return &fs.PathError{Op: "rename", Path: to, Err: fs.ErrExist}
+	}
+	return os.Rename(from, to)
+}
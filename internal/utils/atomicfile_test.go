@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAtomicFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  bool
+		overwrite bool
+		wantErr   bool
+		want      string
+	}{
+		{"new file", false, false, false, "new"},
+		{"existing file kept", true, false, true, "old"},
+		{"existing file overwritten", true, true, false, "new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "out.png")
+			f, err := CreateAtomic(path, tt.overwrite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.WriteString("new"); err != nil {
+				t.Fatal(err)
+			}
+			// Another job writes the path first
+			if tt.existing {
+				if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := f.Commit(); (err != nil) != tt.wantErr {
+				t.Fatalf("Commit() = %v, want error %v", err, tt.wantErr)
+			}
+			if err := f.Close(); err != nil && !tt.wantErr {
+				t.Errorf("Close() after Commit() = %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("file holds %q, want %q", data, tt.want)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 {
+				t.Errorf("got %d files in the directory, want 1 with no temporary file left", len(entries))
+			}
+		})
+	}
+}
+
+func TestAtomicFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not kept on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "out.png")
+	f, err := CreateAtomic(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Errorf("committed file has mode %v, want %v", mode, fs.FileMode(0644))
+	}
+}
@@ -6,45 +6,72 @@ import (
 	"strings"
 )
 
-func HexToRGBA(hexColors []string) ([]color.RGBA, error) {
+// IsTransparent reports whether a palette entry is one of the keywords for a
+// fully transparent color.
+func IsTransparent(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s == "none" || s == "transparent"
+}
+
+// HexToRGBA parses palette entries. The transparent keywords become a fully
+// transparent color.
+func HexToRGBA(hexColors []string) ([]color.NRGBA, error) {
 	if len(hexColors) < 2 {
 		return nil, fmt.Errorf("at least 2 colors are required, got %d", len(hexColors))
 	}
 
-	rgbaColors := make([]color.RGBA, len(hexColors))
+	rgbaColors := make([]color.NRGBA, len(hexColors))
 	for i, hex := range hexColors {
-		hex = strings.TrimSpace(hex)
-		r, g, b, err := hexToRGB(hex)
+		c, err := ParseColor(hex)
 		if err != nil {
-			return nil, fmt.Errorf("invalid hex color %s: %w", hex, err)
+			return nil, err
 		}
-		rgbaColors[i] = color.RGBA{R: r, G: g, B: b, A: 255}
+		rgbaColors[i] = c
 	}
 	return rgbaColors, nil
 }
 
-func hexToRGB(hex string) (uint8, uint8, uint8, error) {
+// ParseColor parses a single palette entry, #RGB, #RGBA, #RRGGBB or
+// #RRGGBBAA. Colors are kept unpremultiplied, as NRGBA images store them, so
+// the alpha channel doesn't cost the color any precision.
+func ParseColor(hex string) (color.NRGBA, error) {
+	hex = strings.TrimSpace(hex)
+	if IsTransparent(hex) {
+		return color.NRGBA{}, nil
+	}
+	r, g, b, a, err := hexToRGBA(hex)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid hex color %s: %w", hex, err)
+	}
+	return color.NRGBA{R: r, G: g, B: b, A: a}, nil
+}
+
+func hexToRGBA(hex string) (uint8, uint8, uint8, uint8, error) {
 	hex = stripHash(strings.TrimSpace(hex))
 
-	// Handle short form (#RGB)
-	if len(hex) == 3 {
-		r := hex[0]
-		g := hex[1]
-		b := hex[2]
-		// Expand short form to full form (#RGB -> #RRGGBB)
-		hex = string([]byte{r, r, g, g, b, b})
+	// Expand short forms to full form (#RGB -> #RRGGBB, #RGBA -> #RRGGBBAA)
+	if len(hex) == 3 || len(hex) == 4 {
+		full := make([]byte, 0, 2*len(hex))
+		for i := 0; i < len(hex); i++ {
+			full = append(full, hex[i], hex[i])
+		}
+		hex = string(full)
+	}
+	// Colors without an alpha channel are opaque
+	if len(hex) == 6 {
+		hex += "ff"
 	}
 
-	if len(hex) != 6 {
-		return 0, 0, 0, fmt.Errorf("invalid hex color length: %s (should be 6 characters or 3 for short form)", hex)
+	if len(hex) != 8 {
+		return 0, 0, 0, 0, fmt.Errorf("invalid hex color length: %s (should be 6 or 8 characters, or 3 or 4 for short form)", hex)
 	}
 
-	var r, g, b uint8
-	_, err := fmt.Sscanf(hex, "%02x%02x%02x", &r, &g, &b)
+	var r, g, b, a uint8
+	_, err := fmt.Sscanf(hex, "%02x%02x%02x%02x", &r, &g, &b, &a)
 	if err != nil {
-		return 0, 0, 0, fmt.Errorf("invalid hex color format: %s", hex)
+		return 0, 0, 0, 0, fmt.Errorf("invalid hex color format: %s", hex)
 	}
-	return r, g, b, nil
+	return r, g, b, a, nil
 }
 
 func stripHash(hex string) string {
@@ -53,3 +80,13 @@ func stripHash(hex string) string {
 	}
 	return hex
 }
+
+// HasTransparent reports whether any of the colors is not fully opaque.
+func HasTransparent(colors []color.NRGBA) bool {
+	for _, c := range colors {
+		if c.A != 255 {
+			return true
+		}
+	}
+	return false
+}
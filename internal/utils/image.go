@@ -1,62 +1,167 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
 	"image"
+	"image/draw"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+
+	"github.com/bradsec/gocamo/internal/pngmeta"
+	"github.com/bradsec/gocamo/internal/webp"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	xwebp "golang.org/x/image/webp"
 )
 
+// imageDecoders decode the image formats that can be read, by file
+// extension. Animated GIFs give their first frame.
+var imageDecoders = map[string]func(io.Reader) (image.Image, error){
+	".jpg":  jpeg.Decode,
+	".jpeg": jpeg.Decode,
+	".png":  png.Decode,
+	".gif":  gif.Decode,
+	".bmp":  bmp.Decode,
+	".tif":  tiff.Decode,
+	".tiff": tiff.Decode,
+	".webp": xwebp.Decode,
+}
+
+// LoadImage decodes a JPEG, PNG, GIF, BMP, TIFF or WebP image, picking the
+// format from the file extension. Photos with an EXIF orientation, such as
+// those taken with a phone held sideways, are turned upright.
 func LoadImage(filename string) (image.Image, error) {
-	file, err := os.Open(filename)
+	decode, ok := imageDecoders[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return nil, fmt.Errorf("unsupported image format: %s", filepath.Ext(filename))
+	}
+
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("error opening file: %w", err)
 	}
-	defer file.Close()
 
-	// Get the file extension
-	ext := strings.ToLower(filepath.Ext(filename))
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
 
-	var img image.Image
+	return orient(img, imageOrientation(data)), nil
+}
 
-	switch ext {
-	case ".jpg", ".jpeg":
-		img, err = jpeg.Decode(file)
-	case ".png":
-		img, err = png.Decode(file)
-	default:
-		return nil, fmt.Errorf("unsupported image format: %s", ext)
-	}
+// EncodeOptions select the format images are saved in.
+type EncodeOptions struct {
+	// Format is png, jpeg or webp; empty means png
+	Format      string
+	Compression png.CompressionLevel
+	// Quality is the JPEG quality, from 1 to 100
+	Quality int
+	// ColorProfile is embedded in PNG outputs by the writers that add
+	// metadata; nil embeds none
+	ColorProfile *pngmeta.Profile
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("error decoding image: %w", err)
+// SaveImage encodes img to w. JPEG has no transparency, so transparent
+// areas are flattened onto white.
+func SaveImage(img image.Image, w io.Writer, opts EncodeOptions) error {
+	switch opts.Format {
+	case "", "png":
+		enc := png.Encoder{CompressionLevel: opts.Compression}
+		return enc.Encode(w, img)
+	case "jpeg":
+		return jpeg.Encode(w, flattenOnWhite(img), &jpeg.Options{Quality: opts.Quality})
+	case "webp":
+		return webp.Encode(w, img)
 	}
+	return fmt.Errorf("unsupported image format: %s", opts.Format)
+}
 
-	return img, nil
+// flattenOnWhite composites img over white if it has any transparency.
+func flattenOnWhite(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
 }
-func SaveImage(img image.Image, w io.Writer) error {
-	return png.Encode(w, img)
+
+// ImageFilter selects the images GetImageFiles returns. Globs are matched
+// without regard to case against file and directory names, or against the
+// path relative to the directory if they contain a slash, so "*.jpg" also
+// takes IMG_0001.JPG.
+type ImageFilter struct {
+	// Include lists globs one of which an image must match; none takes
+	// every image
+	Include []string
+	// Exclude lists globs of images and subdirectories to skip
+	Exclude []string
+	// NoRecursive skips the subdirectories
+	NoRecursive bool
 }
 
-func GetImageFiles(dir string) ([]string, error) {
+// GetImageFiles returns the images in dir and its subdirectories that
+// LoadImage can read and filter selects.
+func GetImageFiles(dir string, filter ImageFilter) ([]string, error) {
 	var images []string
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && isImageFile(path) {
-			images = append(images, path)
+		if path == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if filter.NoRecursive || matchGlobs(filter.Exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isImageFile(path) || matchGlobs(filter.Exclude, rel) {
+			return nil
 		}
+		if len(filter.Include) > 0 && !matchGlobs(filter.Include, rel) {
+			return nil
+		}
+		images = append(images, path)
 		return nil
 	})
 	return images, err
 }
 
+// matchGlobs reports whether the slash separated relative path rel matches
+// any of globs, ignoring case.
+func matchGlobs(globs []string, rel string) bool {
+	rel = strings.ToLower(rel)
+	name := rel[strings.LastIndex(rel, "/")+1:]
+	for _, g := range globs {
+		target := name
+		if strings.Contains(g, "/") {
+			target = rel
+		}
+		// Globs are checked when the flags are parsed
+		if ok, _ := path.Match(strings.ToLower(g), target); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func isImageFile(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	return ext == ".jpg" || ext == ".jpeg" || ext == ".png"
+	_, ok := imageDecoders[strings.ToLower(filepath.Ext(path))]
+	return ok
 }
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is the Windows MAX_PATH limit, including the terminating NUL.
+const maxPath = 260
+
+// LongPath returns a form of path that can be opened even if it is longer
+// than MAX_PATH, by using the \\?\ extended-length prefix.
+func LongPath(path string) string {
+	if len(path) < maxPath-1 || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		// UNC path: \\server\share becomes \\?\UNC\server\share
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
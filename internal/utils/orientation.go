@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// exifOrientationTag is the EXIF tag giving how a photo must be rotated
+// or flipped to be shown upright.
+const exifOrientationTag = 0x0112
+
+// imageOrientation returns the EXIF orientation, 1 to 8, of the JPEG, PNG,
+// TIFF or WebP file in data, or 1 if it has none.
+func imageOrientation(data []byte) int {
+	tiff := exifData(data)
+	if tiff == nil {
+		return 1
+	}
+	o := tiffOrientation(tiff)
+	if o < 1 || o > 8 {
+		return 1
+	}
+	return o
+}
+
+// exifData returns the TIFF structure holding the EXIF tags of data, or nil.
+func exifData(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		// JPEG: an APP1 segment starting with "Exif\0\0"
+		for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+			marker := data[i+1]
+			if marker == 0xd9 || marker == 0xda {
+				break
+			}
+			n := int(binary.BigEndian.Uint16(data[i+2:]))
+			end := min(i+2+n, len(data))
+			if marker == 0xe1 && bytes.HasPrefix(data[i+4:end], []byte("Exif\x00\x00")) {
+				return data[i+10 : end]
+			}
+			i = end
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		// PNG: an eXIf chunk
+		for i := 8; i+8 <= len(data); {
+			n := int(binary.BigEndian.Uint32(data[i:]))
+			end := min(i+8+n, len(data))
+			if string(data[i+4:i+8]) == "eXIf" {
+				return data[i+8 : end]
+			}
+			i = end + 4
+		}
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return data
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		// WebP: an EXIF chunk, which some writers start with "Exif\0\0"
+		for i := 12; i+8 <= len(data); {
+			n := int(binary.LittleEndian.Uint32(data[i+4:]))
+			end := min(i+8+n, len(data))
+			if string(data[i:i+4]) == "EXIF" {
+				return bytes.TrimPrefix(data[i+8:end], []byte("Exif\x00\x00"))
+			}
+			i = end + n%2
+		}
+	}
+	return nil
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of a TIFF
+// structure, or returns 0.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[e:]) == exifOrientationTag {
+			return int(order.Uint16(tiff[e+8:]))
+		}
+	}
+	return 0
+}
+
+// orient rotates and flips img as the EXIF orientation o says, so the
+// image is upright.
+func orient(img image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src, ok := img.(*image.NRGBA)
+	if !ok {
+		src = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		// Orientations 5 to 8 swap the width and height
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2: // Mirrored
+				sx, sy = w-1-x, y
+			case 3: // Rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // Flipped
+				sx, sy = x, h-1-y
+			case 5: // Mirrored and rotated 270° clockwise
+				sx, sy = y, x
+			case 6: // Rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // Mirrored and rotated 90° clockwise
+				sx, sy = w-1-y, h-1-x
+			case 8: // Rotated 270° clockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:])
+		}
+	}
+	return dst
+}
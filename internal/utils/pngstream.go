@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+// PNGStreamWriter encodes a PNG image incrementally, a band of rows at a
+// time, so the full frame never has to be held in memory. Rows are filtered
+// with the same minimum-sum-of-absolute-differences heuristic as image/png.
+type PNGStreamWriter struct {
+	w        io.Writer
+	width    int
+	height   int
+	bpp      int
+	written  int
+	zw       *zlib.Writer
+	idat     *bufio.Writer
+	prev     []byte
+	cur      []byte
+	filtered [5][]byte
+}
+
+// NewPNGStreamWriter writes the PNG header for an 8-bit RGB image, or RGBA
+// if opaque is false, and returns a writer expecting exactly height rows.
+func NewPNGStreamWriter(w io.Writer, width, height int, opaque bool, level png.CompressionLevel) (*PNGStreamWriter, error) {
+	pw := &PNGStreamWriter{w: w, width: width, height: height, bpp: 4}
+	colorType := byte(6)
+	if opaque {
+		pw.bpp = 3
+		colorType = 2
+	}
+
+	if _, err := io.WriteString(w, "\x89PNG\r\n\x1a\n"); err != nil {
+		return nil, err
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(height))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = colorType
+	if err := writeChunk(w, "IHDR", ihdr); err != nil {
+		return nil, err
+	}
+
+	rowLen := width*pw.bpp + 1
+	pw.prev = make([]byte, rowLen)
+	pw.cur = make([]byte, rowLen)
+	for i := range pw.filtered {
+		pw.filtered[i] = make([]byte, rowLen)
+	}
+	pw.idat = bufio.NewWriterSize(chunkWriter{w}, 1<<16)
+	zw, err := zlib.NewWriterLevel(pw.idat, zlibLevel(level))
+	if err != nil {
+		return nil, err
+	}
+	pw.zw = zw
+
+	return pw, nil
+}
+
+// WriteRows encodes every row of img, which must be as wide as the image.
+func (pw *PNGStreamWriter) WriteRows(img *image.NRGBA) error {
+	bounds := img.Bounds()
+	if bounds.Dx() != pw.width {
+		return fmt.Errorf("band width %d does not match image width %d", bounds.Dx(), pw.width)
+	}
+	if pw.written+bounds.Dy() > pw.height {
+		return fmt.Errorf("too many rows written: %d of %d", pw.written+bounds.Dy(), pw.height)
+	}
+
+	for y := 0; y < bounds.Dy(); y++ {
+		src := img.Pix[y*img.Stride : y*img.Stride+pw.width*4]
+		cur := pw.cur[1:]
+		if pw.bpp == 4 {
+			copy(cur, src)
+		} else {
+			for x := 0; x < pw.width; x++ {
+				copy(cur[x*3:x*3+3], src[x*4:x*4+3])
+			}
+		}
+
+		if _, err := pw.zw.Write(pw.filter()); err != nil {
+			return err
+		}
+		pw.prev, pw.cur = pw.cur, pw.prev
+		pw.written++
+	}
+
+	return nil
+}
+
+// Close flushes the compressed data and writes the trailing chunk.
+func (pw *PNGStreamWriter) Close() error {
+	if pw.written != pw.height {
+		return fmt.Errorf("image incomplete: %d of %d rows written", pw.written, pw.height)
+	}
+	if err := pw.zw.Close(); err != nil {
+		return err
+	}
+	if err := pw.idat.Flush(); err != nil {
+		return err
+	}
+	return writeChunk(pw.w, "IEND", nil)
+}
+
+// filter returns the filtered current row, prefixed with its filter type.
+func (pw *PNGStreamWriter) filter() []byte {
+	cur, prev, bpp := pw.cur[1:], pw.prev[1:], pw.bpp
+	n := len(cur)
+
+	none := pw.filtered[0]
+	copy(none[1:], cur)
+	best, bestSum := 0, sumAbs(none[1:])
+
+	sub := pw.filtered[1][1:]
+	for i := 0; i < n; i++ {
+		var left byte
+		if i >= bpp {
+			left = cur[i-bpp]
+		}
+		sub[i] = cur[i] - left
+	}
+	if s := sumAbs(sub); s < bestSum {
+		best, bestSum = 1, s
+	}
+
+	up := pw.filtered[2][1:]
+	for i := 0; i < n; i++ {
+		up[i] = cur[i] - prev[i]
+	}
+	if s := sumAbs(up); s < bestSum {
+		best, bestSum = 2, s
+	}
+
+	avg := pw.filtered[3][1:]
+	for i := 0; i < n; i++ {
+		var left int
+		if i >= bpp {
+			left = int(cur[i-bpp])
+		}
+		avg[i] = cur[i] - uint8((left+int(prev[i]))/2)
+	}
+	if s := sumAbs(avg); s < bestSum {
+		best, bestSum = 3, s
+	}
+
+	paeth := pw.filtered[4][1:]
+	for i := 0; i < n; i++ {
+		var a, c uint8
+		if i >= bpp {
+			a, c = cur[i-bpp], prev[i-bpp]
+		}
+		paeth[i] = cur[i] - paethPredictor(a, prev[i], c)
+	}
+	if s := sumAbs(paeth); s < bestSum {
+		best = 4
+	}
+
+	out := pw.filtered[best]
+	out[0] = byte(best)
+	return out
+}
+
+func sumAbs(b []byte) int {
+	sum := 0
+	for _, v := range b {
+		if v < 128 {
+			sum += int(v)
+		} else {
+			sum += 256 - int(v)
+		}
+	}
+	return sum
+}
+
+func paethPredictor(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// chunkWriter wraps every write in its own IDAT chunk.
+type chunkWriter struct {
+	w io.Writer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	if err := writeChunk(cw.w, "IDAT", p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func writeChunk(w io.Writer, name string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(data)))
+	copy(header[4:8], name)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:8])
+	crc.Write(data)
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, crc.Sum32())
+
+	for _, b := range [][]byte{header, data, footer} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zlibLevel matches the zlib level image/png uses for each compression level.
+func zlibLevel(level png.CompressionLevel) int {
+	switch level {
+	case png.NoCompression:
+		return zlib.NoCompression
+	case png.BestSpeed:
+		return zlib.BestSpeed
+	case png.BestCompression:
+		return zlib.BestCompression
+	default:
+		return zlib.DefaultCompression
+	}
+}
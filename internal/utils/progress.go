@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -13,31 +15,54 @@ func PrintBanner() {
 	fmt.Println(banner)
 }
 
-func TrackProgress(results <-chan error, total int, done chan<- bool) {
-	completed := 0
-	errors := 0
-	for result := range results {
-		if result != nil {
-			errors++
+// ProgressSummary is the final tally of a tracked run.
+type ProgressSummary struct {
+	Total     int
+	Completed int
+	Failed    int
+	// Cancelled is set if tracking stopped because the context was done
+	// before every result arrived.
+	Cancelled bool
+}
+
+// Succeeded returns the number of jobs that completed without error.
+func (s ProgressSummary) Succeeded() int {
+	return s.Completed - s.Failed
+}
+
+// TrackProgress draws a progress bar on w for each result received until
+// results is closed or ctx is done, and returns the summary. It returns
+// however few results arrive, so a run with no jobs or one whose results
+// are closed early still finishes cleanly.
+func TrackProgress(ctx context.Context, w io.Writer, results <-chan error, total int) ProgressSummary {
+	summary := ProgressSummary{Total: total}
+	defer func() {
+		if summary.Completed > 0 {
+			fmt.Fprintln(w) // End the progress bar line
 		}
-		completed++
-		printProgressBar(completed, total, 50)
-		if completed == total {
-			fmt.Println() // Print a newline after the progress bar is complete
-			done <- true
-			return
+	}()
+
+	for {
+		select {
+		case err, ok := <-results:
+			if !ok {
+				return summary
+			}
+			if err != nil {
+				summary.Failed++
+			}
+			summary.Completed++
+			printProgressBar(w, summary.Completed, max(total, summary.Completed), 50)
+		case <-ctx.Done():
+			summary.Cancelled = true
+			return summary
 		}
 	}
-	if errors > 0 {
-		fmt.Printf("\n%d out of %d jobs failed.\n", errors, total)
-	} else {
-		fmt.Println()
-	}
 }
 
-func printProgressBar(done, total, width int) {
+func printProgressBar(w io.Writer, done, total, width int) {
 	percent := float64(done) / float64(total)
 	filled := int(percent * float64(width))
 	bar := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
-	fmt.Printf("\r[%s] %.1f%% (%d/%d)", bar, percent*100, done, total)
+	fmt.Fprintf(w, "\r[%s] %.1f%% (%d/%d)", bar, percent*100, done, total)
 }
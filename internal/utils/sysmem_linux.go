@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// AvailableMemory returns the memory available for new allocations in bytes,
+// or 0 if it cannot be determined.
+func AvailableMemory() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package utils
+
+// AvailableMemory returns the memory available for new allocations in bytes,
+// or 0 if it cannot be determined.
+func AvailableMemory() uint64 {
+	return 0
+}
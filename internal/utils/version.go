@@ -0,0 +1,19 @@
+package utils
+
+import "runtime/debug"
+
+// version is set at build time with
+// -ldflags "-X github.com/bradsec/gocamo/internal/utils.version=v1.2.3".
+var version string
+
+// Version returns the gocamo version: the one set at build time, else the
+// module version go install recorded, else "devel".
+func Version() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "devel"
+}
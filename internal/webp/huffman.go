@@ -0,0 +1,238 @@
+package webp
+
+import "sort"
+
+const (
+	maxCodeLength = 15
+	// Code lengths are themselves coded with a prefix code of at most 7
+	// bits over 19 symbols: lengths 0-15 and three repeat codes
+	maxCodeLengthCodeLength = 7
+	numCodeLengthCodes      = 19
+	repeatPrevious          = 16
+	repeatZeros             = 17
+	repeatManyZeros         = 18
+)
+
+// codeLengthCodeOrder is the order code length code lengths are written in.
+var codeLengthCodeOrder = [numCodeLengthCodes]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// prefixCode is a canonical prefix code. Codes are stored bit reversed,
+// ready to be written least significant bit first.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint32
+	// simple holds the one or two symbols of codes written in the short
+	// form, or is nil
+	simple []int
+	// silent codes have a single symbol, which is written with no bits
+	silent bool
+}
+
+// newPrefixCode builds a code for the symbol counts with no code longer
+// than maxLen.
+func newPrefixCode(counts []int, maxLen int) prefixCode {
+	var used []int
+	for s, n := range counts {
+		if n > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+
+	// One or two symbols below 256 have a short form; a single symbol takes
+	// no bits at all
+	if len(used) <= 2 && used[len(used)-1] < numLiterals {
+		c := prefixCode{lengths: make([]uint8, len(counts)), codes: make([]uint32, len(counts)), simple: used}
+		if len(used) == 2 {
+			c.lengths[used[0]], c.lengths[used[1]] = 1, 1
+			c.codes[used[1]] = 1
+		}
+		c.silent = len(used) == 1
+		return c
+	}
+	return canonicalCode(codeLengths(counts, maxLen))
+}
+
+// canonicalCode returns the canonical code with the given lengths.
+func canonicalCode(lengths []uint8) prefixCode {
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			used++
+		}
+	}
+	return prefixCode{lengths: lengths, codes: canonicalCodes(lengths), silent: used == 1}
+}
+
+// codeLengths returns Huffman code lengths for the counts, flattening the
+// counts until no code is longer than maxLen. A single used symbol gets a
+// length of 1, which decoders read as a code of no bits.
+func codeLengths(counts []int, maxLen int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	weights := make([]int, len(counts))
+	copy(weights, counts)
+
+	for {
+		type node struct {
+			weight      int
+			symbol      int
+			left, right int
+		}
+		var nodes []node
+		var queue []int
+		for s, w := range weights {
+			if w > 0 {
+				nodes = append(nodes, node{weight: w, symbol: s, left: -1, right: -1})
+				queue = append(queue, len(nodes)-1)
+			}
+		}
+		if len(queue) == 1 {
+			lengths[nodes[0].symbol] = 1
+			return lengths
+		}
+
+		for len(queue) > 1 {
+			sort.SliceStable(queue, func(i, j int) bool { return nodes[queue[i]].weight < nodes[queue[j]].weight })
+			a, b := queue[0], queue[1]
+			nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, symbol: -1, left: a, right: b})
+			queue = append(queue[2:], len(nodes)-1)
+		}
+
+		longest := 0
+		var walk func(n, depth int)
+		walk = func(n, depth int) {
+			if nodes[n].symbol >= 0 {
+				lengths[nodes[n].symbol] = uint8(depth)
+				longest = max(longest, depth)
+				return
+			}
+			walk(nodes[n].left, depth+1)
+			walk(nodes[n].right, depth+1)
+		}
+		walk(queue[0], 0)
+		if longest <= maxLen {
+			return lengths
+		}
+
+		for s, w := range weights {
+			if w > 0 {
+				weights[s] = w>>1 | 1
+			}
+		}
+	}
+}
+
+// canonicalCodes assigns canonical codes to the lengths, bit reversed.
+func canonicalCodes(lengths []uint8) []uint32 {
+	var count [maxCodeLength + 1]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [maxCodeLength + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		var rev uint32
+		for i := uint8(0); i < l; i++ {
+			rev = rev<<1 | c>>i&1
+		}
+		codes[s] = rev
+	}
+	return codes
+}
+
+func (c *prefixCode) writeSymbol(bw *bitWriter, s int) {
+	if !c.silent {
+		bw.write(c.codes[s], int(c.lengths[s]))
+	}
+}
+
+// writeTo writes the code's description.
+func (c *prefixCode) writeTo(bw *bitWriter) {
+	if c.simple != nil {
+		bw.write(1, 1)
+		bw.write(uint32(len(c.simple)-1), 1)
+		if c.simple[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(c.simple[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(c.simple[0]), 8)
+		}
+		if len(c.simple) == 2 {
+			bw.write(uint32(c.simple[1]), 8)
+		}
+		return
+	}
+
+	// Run length code the lengths, then code the result
+	var symbols, extras []int
+	for i := 0; i < len(c.lengths); {
+		l := c.lengths[i]
+		run := 1
+		for i+run < len(c.lengths) && c.lengths[i+run] == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				symbols, extras = append(symbols, repeatManyZeros), append(extras, n-11)
+				run -= n
+			}
+			if run >= 3 {
+				symbols, extras = append(symbols, repeatZeros), append(extras, run-3)
+				run = 0
+			}
+		} else {
+			symbols, extras = append(symbols, int(l)), append(extras, 0)
+			run--
+			for run >= 3 {
+				n := min(run, 6)
+				symbols, extras = append(symbols, repeatPrevious), append(extras, n-3)
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			symbols, extras = append(symbols, int(l)), append(extras, 0)
+		}
+	}
+
+	counts := make([]int, numCodeLengthCodes)
+	for _, s := range symbols {
+		counts[s]++
+	}
+	lengthCode := canonicalCode(codeLengths(counts, maxCodeLengthCodeLength))
+
+	n := numCodeLengthCodes
+	for n > 4 && lengthCode.lengths[codeLengthCodeOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(0, 1)
+	bw.write(uint32(n-4), 4)
+	for _, s := range codeLengthCodeOrder[:n] {
+		bw.write(uint32(lengthCode.lengths[s]), 3)
+	}
+	bw.write(0, 1) // every symbol has a length
+
+	extraBits := map[int]int{repeatPrevious: 2, repeatZeros: 3, repeatManyZeros: 7}
+	for i, s := range symbols {
+		lengthCode.writeSymbol(bw, s)
+		if n, ok := extraBits[s]; ok {
+			bw.write(uint32(extras[i]), n)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Package webp encodes images as lossless WebP (VP8L). The encoder keeps to
+// the parts of the format that suit camouflage patterns: backward references
+// to the pixel on the left and the pixel above cover the flat color regions,
+// and a single set of prefix codes covers the rest.
+package webp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// MaxDimension is the largest width or height a WebP image can have.
+const MaxDimension = 1 << 14
+
+const (
+	numLiterals  = 256
+	numLengths   = 24
+	numDistances = 40
+	maxLength    = 4096
+	// minMatch is the shortest backward reference worth coding
+	minMatch = 3
+)
+
+// Distance codes for the pixel above and the pixel on the left, the first
+// two entries of the format's two-dimensional distance table
+const (
+	distAbove = 1
+	distLeft  = 2
+	// distOffset is added to distances that are not in the table
+	distOffset = 120
+)
+
+// Encode writes img to w as a lossless WebP image.
+func Encode(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > MaxDimension || height > MaxDimension {
+		return fmt.Errorf("webp: image size %dx%d is not supported (1 to %d pixels each way)", width, height, MaxDimension)
+	}
+
+	src, ok := img.(*image.NRGBA)
+	if !ok || src.Rect.Min != (image.Point{}) || src.Stride != 4*width {
+		src = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+	pix := make([]uint32, width*height)
+	opaque := true
+	for i := range pix {
+		p := src.Pix[4*i : 4*i+4]
+		pix[i] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+		opaque = opaque && p[3] == 0xff
+	}
+
+	// The first pass counts symbols to build the prefix codes, the second
+	// writes them
+	var hist histograms
+	tokenize(pix, width, hist.add)
+	codes := hist.codes()
+
+	var bw bitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if opaque {
+		bw.write(0, 1)
+	} else {
+		bw.write(1, 1)
+	}
+	bw.write(0, 3) // version
+	bw.write(0, 1) // no transforms
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // a single group of prefix codes
+	for i := range codes {
+		codes[i].writeTo(&bw)
+	}
+	tokenize(pix, width, func(t token) { codes.writeToken(&bw, t) })
+	data := bw.flush()
+
+	chunkSize := len(data)
+	padded := chunkSize + chunkSize&1
+	out := bufio.NewWriter(w)
+	header := make([]byte, 0, 20)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(4+8+padded))
+	header = append(header, "WEBPVP8L"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(chunkSize))
+	out.Write(header)
+	out.Write(data)
+	if padded != chunkSize {
+		out.WriteByte(0)
+	}
+	return out.Flush()
+}
+
+// token is a literal ARGB pixel, or a backward reference when length is
+// non-zero.
+type token struct {
+	argb     uint32
+	length   int
+	distCode int
+}
+
+// tokenize splits the pixels into literals and backward references to the
+// pixel on the left or the pixel above, taking the longer match.
+func tokenize(pix []uint32, width int, emit func(token)) {
+	for i := 0; i < len(pix); {
+		left, above := 0, 0
+		if i >= 1 {
+			left = matchLength(pix, i, 1)
+		}
+		if i >= width {
+			above = matchLength(pix, i, width)
+		}
+		switch {
+		case above >= minMatch && above >= left:
+			emit(token{length: above, distCode: distAbove})
+			i += above
+		case left >= minMatch:
+			emit(token{length: left, distCode: distLeft})
+			i += left
+		default:
+			emit(token{argb: pix[i]})
+			i++
+		}
+	}
+}
+
+// matchLength returns how many pixels from i repeat the pixels dist back.
+func matchLength(pix []uint32, i, dist int) int {
+	n := 0
+	for n < maxLength && i+n < len(pix) && pix[i+n] == pix[i+n-dist] {
+		n++
+	}
+	return n
+}
+
+// prefixEncode splits a length or distance code into its prefix symbol and
+// extra bits.
+func prefixEncode(v int) (symbol, extraBits int, extra uint32) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	high := 31
+	for d>>high == 0 {
+		high--
+	}
+	second := (d >> (high - 1)) & 1
+	extraBits = high - 1
+	return 2*high + second, extraBits, uint32(d) & (1<<extraBits - 1)
+}
+
+// histograms counts the symbols of the five prefix codes: green and
+// backward reference lengths, red, blue, alpha and distances.
+type histograms [5][]int
+
+func (h *histograms) add(t token) {
+	if h[0] == nil {
+		for i, n := range []int{numLiterals + numLengths, numLiterals, numLiterals, numLiterals, numDistances} {
+			h[i] = make([]int, n)
+		}
+	}
+	if t.length > 0 {
+		lengthSym, _, _ := prefixEncode(t.length)
+		distSym, _, _ := prefixEncode(t.distCode)
+		h[0][numLiterals+lengthSym]++
+		h[4][distSym]++
+		return
+	}
+	h[0][t.argb>>8&0xff]++
+	h[1][t.argb>>16&0xff]++
+	h[2][t.argb&0xff]++
+	h[3][t.argb>>24]++
+}
+
+func (h *histograms) codes() codeSet {
+	var codes codeSet
+	for i := range h {
+		codes[i] = newPrefixCode(h[i], maxCodeLength)
+	}
+	return codes
+}
+
+// codeSet holds the green, red, blue, alpha and distance prefix codes.
+type codeSet [5]prefixCode
+
+func (c *codeSet) writeToken(bw *bitWriter, t token) {
+	if t.length > 0 {
+		sym, n, extra := prefixEncode(t.length)
+		c[0].writeSymbol(bw, numLiterals+sym)
+		bw.write(extra, n)
+		sym, n, extra = prefixEncode(t.distCode)
+		c[4].writeSymbol(bw, sym)
+		bw.write(extra, n)
+		return
+	}
+	c[0].writeSymbol(bw, int(t.argb>>8&0xff))
+	c[1].writeSymbol(bw, int(t.argb>>16&0xff))
+	c[2].writeSymbol(bw, int(t.argb&0xff))
+	c[3].writeSymbol(bw, int(t.argb>>24))
+}
+
+// bitWriter packs bits least significant first.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits int
+}
+
+func (bw *bitWriter) write(v uint32, n int) {
+	bw.acc |= uint64(v) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nbits -= 8
+	}
+}
+
+func (bw *bitWriter) flush() []byte {
+	if bw.nbits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.nbits = 0, 0
+	}
+	return bw.buf
+}
@@ -0,0 +1,113 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand/v2"
+	"testing"
+
+	xwebp "golang.org/x/image/webp"
+)
+
+// blocks returns an image of flat rectangles from colors, like a box
+// pattern, with a few single pixels mixed in.
+func blocks(w, h int, colors ...color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rng := rand.New(rand.NewPCG(1, 2))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := colors[(x/7+y/5)%len(colors)]
+			if rng.IntN(20) == 0 {
+				c = colors[rng.IntN(len(colors))]
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// noise returns an image of random pixels, which leaves no backward
+// references to code.
+func noise(w, h int, opaque bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rng := rand.New(rand.NewPCG(3, 4))
+	for i := range img.Pix {
+		img.Pix[i] = byte(rng.Uint32())
+		if opaque && i%4 == 3 {
+			img.Pix[i] = 0xff
+		}
+	}
+	return img
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	green := color.NRGBA{0x5e, 0x85, 0x53, 0xff}
+	brown := color.NRGBA{0x5c, 0x4f, 0x42, 0xff}
+	black := color.NRGBA{0x1c, 0x1c, 0x1c, 0xff}
+	transparent := color.NRGBA{0x12, 0x34, 0x56, 0x00}
+	half := color.NRGBA{0xc2, 0xb2, 0x80, 0x80}
+
+	// A sub-image of an RGBA image takes the conversion path
+	rgba := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(rgba, rgba.Bounds(), blocks(40, 30, green, brown, black), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"1x1", blocks(1, 1, green)},
+		{"single column", blocks(1, 50, green, brown)},
+		{"single row", blocks(50, 1, green, brown)},
+		{"opaque blocks", blocks(129, 67, green, brown, black)},
+		{"one color", blocks(300, 200, black)},
+		{"opaque noise", noise(64, 48, true)},
+		{"alpha blocks", blocks(97, 61, green, transparent, half)},
+		{"alpha noise", noise(31, 17, false)},
+		{"rgba sub-image", rgba.SubImage(image.Rect(3, 5, 37, 29))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, tt.img); err != nil {
+				t.Fatal(err)
+			}
+			got, err := xwebp.Decode(&buf)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			b := tt.img.Bounds()
+			if got.Bounds().Dx() != b.Dx() || got.Bounds().Dy() != b.Dy() {
+				t.Fatalf("decoded size %v, want %v", got.Bounds().Size(), b.Size())
+			}
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tt.img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+					if c := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA); c != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, c, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeSize(t *testing.T) {
+	tests := []struct {
+		w, h    int
+		wantErr bool
+	}{
+		{0, 10, true},
+		{10, 0, true},
+		{MaxDimension + 1, 1, true},
+		{1, MaxDimension + 1, true},
+		{MaxDimension, 1, false},
+	}
+	for _, tt := range tests {
+		err := Encode(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, tt.w, tt.h)))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Encode() of %dx%d: error %v, want error %v", tt.w, tt.h, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter adaptively bounds how many jobs run at once. A job is admitted
+// only while its estimated memory fits the remaining budget and fewer jobs
+// than the current concurrency limit are running. The limit itself follows
+// observed job durations: it shrinks when jobs run much slower per pixel than
+// usual (a sign of memory or CPU contention) and grows back when they don't.
+type Limiter struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	budget     uint64
+	inUse      uint64
+	running    int
+	limit      int
+	maxLimit   int
+	nsPerPixel float64
+}
+
+// NewLimiter returns a limiter allowing up to maxWorkers concurrent jobs. A
+// budget of 0 disables the memory check.
+func NewLimiter(budget uint64, maxWorkers int) *Limiter {
+	l := &Limiter{budget: budget, limit: maxWorkers, maxLimit: maxWorkers}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// AcquireContext blocks until a job needing mem bytes may start. A job
+// larger than the whole budget is admitted once nothing else is running.
+// It gives up waiting once ctx is done and returns its error; the job then
+// doesn't run and isn't released.
+func (l *Limiter) AcquireContext(ctx context.Context, mem uint64) error {
+	// Wake the waiters when ctx is done, so this one can give up
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for !l.fits(mem) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+	l.running++
+	l.inUse += mem
+	return nil
+}
+
+func (l *Limiter) fits(mem uint64) bool {
+	if l.running == 0 {
+		return true
+	}
+	if l.running >= l.limit {
+		return false
+	}
+	return l.budget == 0 || l.inUse+mem <= l.budget
+}
+
+// Release marks a job as finished. pixels and elapsed describe the work the
+// job did and feed the concurrency adjustment; pass 0 pixels for jobs that
+// failed.
+func (l *Limiter) Release(mem, pixels uint64, elapsed time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	l.inUse -= mem
+
+	if pixels > 0 {
+		rate := float64(elapsed.Nanoseconds()) / float64(pixels)
+		switch {
+		case l.nsPerPixel == 0:
+			l.nsPerPixel = rate
+		case rate > 2*l.nsPerPixel && l.limit > 1:
+			l.limit--
+		case rate < 1.2*l.nsPerPixel && l.limit < l.maxLimit:
+			l.limit++
+		}
+		// Exponentially weighted moving average of the per-pixel time
+		l.nsPerPixel = 0.8*l.nsPerPixel + 0.2*rate
+	}
+
+	l.cond.Broadcast()
+}
@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcquireContextCancelled(t *testing.T) {
+	l := NewLimiter(0, 1)
+	if err := l.AcquireContext(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.AcquireContext(ctx, 1) }()
+	select {
+	case err := <-done:
+		t.Fatalf("AcquireContext() = %v while the only slot is taken", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("AcquireContext() = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AcquireContext() kept waiting after its context was cancelled")
+	}
+
+	// The slot is still held by the first job only
+	l.Release(1, 0, 0)
+	if err := l.AcquireContext(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+}
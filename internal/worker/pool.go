@@ -0,0 +1,206 @@
+package worker
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// DefaultRetries is the number of times a job that failed with a transient
+// error is tried again.
+const DefaultRetries = 2
+
+// PreviewPixels is the largest job, in pixels, given PreviewPriority.
+const PreviewPixels = 512 * 512
+
+// PreviewPriority is the priority of preview sized jobs, which start before
+// the full-size renders queued with them.
+const PreviewPriority = 1
+
+// retryDelay is the wait before the first retry of a job. It doubles for
+// each further retry, giving file handles or disk space time to free up.
+const retryDelay = 250 * time.Millisecond
+
+// Pool renders jobs on a set of workers and encodes the frames on as many
+// encoders, so encoding overlaps rendering of the next job. Queued jobs
+// start in order of priority, and among equal priorities the jobs estimated
+// to finish soonest start first, so small previews come out before
+// full-size renders. Jobs that fail with a transient error are retried.
+type Pool struct {
+	// Retries is the number of times a job that failed with a transient
+	// error, such as running out of file handles, is tried again
+	Retries int
+	// Timeouts are the render deadlines of jobs by pattern type, with 0 for
+	// none. Other pattern types get generator.JobTimeout.
+	Timeouts map[string]time.Duration
+
+	cfg     *config.Config
+	size    int
+	results chan JobResult
+	// frames passes rendered frames to the encoders. It is kept small so
+	// rendering doesn't run far ahead of encoding.
+	frames   chan Frame
+	workers  sync.WaitGroup
+	encoders sync.WaitGroup
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   jobQueue
+	seq     int
+	pending int
+	closed  bool
+}
+
+// NewPool returns a pool of size workers for jobs of the run cfg, whose
+// output settings the encoders use.
+func NewPool(cfg *config.Config, size int) *Pool {
+	p := &Pool{
+		Retries: DefaultRetries,
+		cfg:     cfg,
+		size:    max(size, 1),
+		results: make(chan JobResult),
+		frames:  make(chan Frame, 1),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// Start starts the workers and encoders. Once ctx is cancelled queued jobs
+// are reported as ErrCancelled without being started.
+func (p *Pool) Start(ctx context.Context) {
+	for w := 0; w < p.size; w++ {
+		p.workers.Add(1)
+		go p.work(ctx)
+		p.encoders.Add(1)
+		go p.encode()
+	}
+	go func() {
+		p.workers.Wait()
+		close(p.frames)
+		p.encoders.Wait()
+		close(p.results)
+	}()
+}
+
+// Submit queues a job.
+func (p *Pool) Submit(j Job) {
+	j.cost = generator.EstimateDuration(j.Config)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		panic("worker: Submit on a closed pool")
+	}
+	j.seq = p.seq
+	p.seq++
+	p.pending++
+	heap.Push(&p.queue, j)
+	p.cond.Signal()
+}
+
+// Close marks the end of the jobs. The results channel is closed once every
+// job has been reported.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+}
+
+// Results returns the outcome of every job, one result each.
+func (p *Pool) Results() <-chan JobResult {
+	return p.results
+}
+
+// next waits for the next job to start. It returns false once the pool is
+// closed and every job has been reported.
+func (p *Pool) next() (Job, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.queue.Len() == 0 {
+		if p.closed && p.pending == 0 {
+			return Job{}, false
+		}
+		p.cond.Wait()
+	}
+	return heap.Pop(&p.queue).(Job), true
+}
+
+// report passes on the final result of a job.
+func (p *Pool) report(r JobResult) {
+	p.results <- r
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending--
+	if p.pending == 0 {
+		p.cond.Broadcast()
+	}
+}
+
+// fail retries a job that failed with a transient error after a delay, or
+// reports it.
+func (p *Pool) fail(j Job, start time.Time, err error) {
+	if !IsTransient(err) || j.attempt >= p.Retries {
+		p.report(j.result(start, nil, err))
+		return
+	}
+	delay := retryDelay << j.attempt
+	slog.Warn(fmt.Sprintf("[%03d] %s failed: %v; retrying in %v", j.Index, j.Input(), err, delay),
+		"index", j.Index, "name", j.Input(), "error", err, "retry", j.attempt+1)
+	j.attempt++
+	time.AfterFunc(delay, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		heap.Push(&p.queue, j)
+		p.cond.Signal()
+	})
+}
+
+// timeout returns the render deadline of a job, or 0 for none.
+func (p *Pool) timeout(j Job) time.Duration {
+	if d, ok := p.Timeouts[j.Config.PatternType]; ok {
+		return d
+	}
+	return generator.JobTimeout(j.Config)
+}
+
+// Priority returns the queue priority of a job with the settings cfg, so
+// small previews come out first.
+func Priority(cfg *config.Config) int {
+	if cfg.Width > 0 && cfg.Height > 0 && cfg.Width*cfg.Height <= PreviewPixels {
+		return PreviewPriority
+	}
+	return 0
+}
+
+// jobQueue is a heap of jobs with the next to start first.
+type jobQueue []Job
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(i, j int) bool {
+	a, b := q[i], q[j]
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.cost != b.cost {
+		return a.cost < b.cost
+	}
+	return a.seq < b.seq
+}
+
+func (q jobQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *jobQueue) Push(x any) { *q = append(*q, x.(Job)) }
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	j := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return j
+}
@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// TestRetryKeepsPath fails the first save of a job with a transient error
+// and checks that the retry writes the output under its own name.
+func TestRetryKeepsPath(t *testing.T) {
+	for _, skipExisting := range []bool{false, true} {
+		t.Run(fmt.Sprintf("skip-existing=%v", skipExisting), func(t *testing.T) {
+			var saves atomic.Int32
+			saveFrame = func(cfg *config.Config, f *generator.Frame) (*generator.Output, error) {
+				if saves.Add(1) == 1 {
+					f.Release()
+					return nil, &os.PathError{Op: "open", Path: f.Output.FilePath, Err: syscall.EMFILE}
+				}
+				return save(cfg, f)
+			}
+			t.Cleanup(func() { saveFrame = save })
+
+			dir := t.TempDir()
+			cfg := &config.Config{
+				PatternType:   "box",
+				Width:         64,
+				Height:        64,
+				BasePixelSize: 4,
+				ShapeSize:     config.DefaultShapeSize,
+				Seed:          1,
+				NameTemplate:  "{name}",
+				SkipExisting:  skipExisting,
+			}
+			pool := NewPool(cfg, 1)
+			pool.Start(context.Background())
+			pool.Submit(Job{
+				Camo:       config.CamoColors{Name: "retry", Colors: []string{"#112233", "#445566"}},
+				Config:     cfg,
+				OutputPath: dir,
+			})
+			pool.Close()
+
+			var results []JobResult
+			for r := range pool.Results() {
+				results = append(results, r)
+			}
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			r := results[0]
+			if r.Err != nil {
+				t.Fatalf("job failed: %v", r.Err)
+			}
+			if r.Retries != 1 {
+				t.Errorf("got %d retries, want 1", r.Retries)
+			}
+			if want := filepath.Join(dir, "retry.png"); r.Output.FilePath != want {
+				t.Errorf("output written to %s, want %s", r.Output.FilePath, want)
+			}
+			if _, err := os.Stat(r.Output.FilePath); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
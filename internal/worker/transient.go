@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"syscall"
+)
+
+// transientErrnos are system errors that may clear up if the job is tried
+// again a moment later, such as running out of file handles while other
+// jobs hold theirs.
+var transientErrnos = []syscall.Errno{
+	syscall.EMFILE,
+	syscall.ENFILE,
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.EINTR,
+}
+
+// IsTransient reports whether a job that failed with err may succeed if it
+// is tried again.
+func IsTransient(err error) bool {
+	// A job that ran out of time would only run out of time again
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	for _, errno := range transientErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
@@ -2,8 +2,8 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/bradsec/gocamo/internal/generator"
@@ -16,30 +16,193 @@ type Job struct {
 	Index      int
 	Config     *config.Config
 	OutputPath string
+	// Priority orders the queue: jobs with a higher priority start first
+	Priority int
+	// Limiter optionally gates when the job may start; nil runs it as soon
+	// as a worker is free.
+	Limiter *Limiter
+
+	// attempt counts the earlier tries of a job that is retried
+	attempt int
+	// seq is the order the job was submitted in
+	seq int
+	// cost is the estimated duration, which orders jobs of equal priority
+	cost time.Duration
+}
+
+// JobResult is the outcome of a job, identifying its input so failures can
+// be reported by name.
+type JobResult struct {
+	Index int
+	// Name is the palette name, or the image path for image patterns
+	Name string
+	// Pattern is the pattern type of the job
+	Pattern string
+	// Config is the job's own settings, with those of its palette and
+	// variant applied
+	Config   *config.Config
+	Duration time.Duration
+	// Retries is the number of times the job was tried again after a
+	// transient error
+	Retries int
+	Output  *generator.Output
+	Err     error
 }
 
-func Work(jobs <-chan Job, results chan<- error, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for j := range jobs {
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		var err error
+// ErrCancelled is the error of jobs that were not started because the run
+// was cancelled.
+var ErrCancelled = errors.New("cancelled before it started")
+
+// Input describes the job's input for messages.
+func (j Job) Input() string {
+	if j.ImagePath != "" {
+		return j.ImagePath
+	}
+	return j.Camo.Name
+}
+
+func (j Job) result(start time.Time, out *generator.Output, err error) JobResult {
+	return JobResult{
+		Index:    j.Index,
+		Name:     j.Input(),
+		Pattern:  j.Config.PatternType,
+		Config:   j.Config,
+		Duration: time.Since(start),
+		Retries:  j.attempt,
+		Output:   out,
+		Err:      err,
+	}
+}
+
+// Frame is a rendered job handed from the generation stage to the encoding
+// stage.
+type Frame struct {
+	Index int
+	Frame *generator.Frame
+	job   Job
+	mem   uint64
+	start time.Time
+}
+
+// jobContext bounds how long a job may render. A timeout of 0 renders
+// without a deadline.
+func jobContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// work renders jobs and passes the frames on to be encoded. Failed jobs are
+// retried or reported. Once ctx is cancelled the remaining jobs are
+// reported as ErrCancelled without being started, while jobs already
+// rendering finish and are saved.
+func (p *Pool) work(ctx context.Context) {
+	defer p.workers.Done()
+	for {
+		j, ok := p.next()
+		if !ok {
+			return
+		}
+		if ctx.Err() != nil {
+			p.report(j.result(time.Now(), nil, ErrCancelled))
+			continue
+		}
+		var mem uint64
+		if j.Limiter != nil {
+			mem = generator.EstimateMemory(j.Config)
+			// A job still waiting for memory when the run is cancelled
+			// isn't started
+			if err := j.Limiter.AcquireContext(ctx, mem); err != nil {
+				p.report(j.result(time.Now(), nil, ErrCancelled))
+				continue
+			}
+		}
+		start := time.Now()
+		timeout := p.timeout(j)
+		jobCtx, cancel := jobContext(timeout)
 
-		done := make(chan error, 1)
+		type rendered struct {
+			frame *generator.Frame
+			err   error
+		}
+		done := make(chan rendered, 1)
 		go func() {
+			var r rendered
+			// A panicking generator fails only its own job
+			defer func() {
+				if v := recover(); v != nil {
+					r.frame, r.err = nil, generator.NewPanicError(v)
+				}
+				done <- r
+			}()
 			if j.Config.PatternType == "image" {
-				done <- generator.GenerateFromImage(ctx, j.Config, j.ImagePath, j.Index, j.OutputPath)
+				r.frame, r.err = generator.RenderFromImage(jobCtx, j.Config, j.ImagePath, j.Index, j.OutputPath)
 			} else {
-				done <- generator.GeneratePattern(ctx, j.Config, j.Camo, j.Index, j.OutputPath)
+				r.frame, r.err = generator.RenderPattern(jobCtx, j.Config, j.Camo, j.Index, j.OutputPath)
 			}
 		}()
 
 		select {
-		case err = <-done:
-		case <-ctx.Done():
-			err = fmt.Errorf("operation timed out")
+		case r := <-done:
+			if r.err != nil {
+				release(j, mem, false, start)
+				p.fail(j, start, r.err)
+			} else {
+				p.frames <- Frame{Index: j.Index, Frame: r.frame, job: j, mem: mem, start: start}
+			}
+		case <-jobCtx.Done():
+			// The abandoned render holds its memory until it stops, so the
+			// frame and the memory are released once it does
+			go func() {
+				if r := <-done; r.frame != nil {
+					r.frame.Release()
+				}
+				release(j, mem, false, start)
+			}()
+			p.report(j.result(start, nil, fmt.Errorf("operation timed out after %v", timeout)))
 		}
 
 		cancel()
-		results <- err
 	}
 }
+
+// encode saves rendered frames and reports the outcome of each job.
+func (p *Pool) encode() {
+	defer p.encoders.Done()
+	for f := range p.frames {
+		out, err := saveFrame(p.cfg, f.Frame)
+		release(f.job, f.mem, err == nil, f.start)
+		if err != nil {
+			// A retry may write to the same path
+			generator.ReleasePath(f.Frame.Output.FilePath)
+			p.fail(f.job, f.start, err)
+		} else {
+			p.report(f.job.result(f.start, out, nil))
+		}
+	}
+}
+
+// saveFrame is the encoding step of the encoders, replaced in tests.
+var saveFrame = save
+
+// save encodes a frame, turning a panic into an error for the job.
+func save(cfg *config.Config, f *generator.Frame) (out *generator.Output, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			out, err = nil, generator.NewPanicError(v)
+		}
+	}()
+	return f.Save(cfg)
+}
+
+func release(j Job, mem uint64, succeeded bool, start time.Time) {
+	if j.Limiter == nil {
+		return
+	}
+	var pixels uint64
+	if succeeded {
+		pixels = uint64(j.Config.Width) * uint64(j.Config.Height)
+	}
+	j.Limiter.Release(mem, pixels, time.Since(start))
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PatternArgs returns the command line flags that determine the pattern
+// cfg renders, apart from the palette and seed. Settings folded into others
+// before rendering, such as -use and -wallpapers, appear as the values they
+// produced. Output, performance and -payload settings are left out.
+func (c *Config) PatternArgs() []string {
+	args := []string{
+		"-t", c.PatternType,
+		"-w", strconv.Itoa(c.Width),
+		"-h", strconv.Itoa(c.Height),
+		"-b", strconv.Itoa(c.BasePixelSize),
+	}
+	if c.Scale != "" {
+		args = append(args, "-scale", c.Scale)
+	}
+	if c.PatternType == "composite" {
+		args = append(args, "-layers", c.Layers[0]+","+c.Layers[1], "-layer-opacity", strconv.Itoa(c.LayerOpacity))
+	}
+	if c.ShapeSize >= 2 && c.ShapeSize != DefaultShapeSize && c.UsesPattern("box") {
+		args = append(args, "-shape-size", strconv.Itoa(c.ShapeSize))
+	}
+	if c.usesAutomaton() {
+		if c.CAPasses != 0 && c.CAPasses != DefaultCAPasses {
+			args = append(args, "-ca-passes", strconv.Itoa(c.CAPasses))
+		}
+		if c.CARadius > 0 {
+			args = append(args, "-ca-radius", strconv.Itoa(c.CARadius))
+		}
+		if c.CAChance > 0 {
+			args = append(args, "-ca-chance", strconv.FormatFloat(c.CAChance, 'g', -1, 64))
+		}
+	}
+	if angle := NormalizeAngle(c.FlowAngle); angle != 0 && c.UsesPattern("pat6") {
+		args = append(args, "-flow-angle", strconv.FormatFloat(angle, 'g', -1, 64))
+	}
+	if c.AddNoise {
+		args = append(args, "-noise")
+		if level := c.NoiseLevel; level != 0 && level != DefaultNoiseLevel {
+			args = append(args, "-noise-level", strconv.Itoa(level))
+		}
+	}
+	if c.AddEdge {
+		args = append(args, "-edge")
+		if strength := c.EdgeStrength; strength != 0 && strength != DefaultEdgeStrength {
+			args = append(args, "-edge-strength", strconv.Itoa(strength))
+		}
+	}
+	if c.Distort > 0 {
+		args = append(args, "-distort", strconv.Itoa(c.Distort))
+	}
+	if c.Blend > 0 {
+		args = append(args, "-blend", strconv.Itoa(c.Blend))
+	}
+	if c.LegacyBlend {
+		args = append(args, "-legacy-blend")
+	}
+	if !c.ColorRatios.IsZero() {
+		args = append(args, "-r", c.ColorRatios.String())
+	}
+	if c.AlphaColor != "" {
+		args = append(args, "-alpha-color", c.AlphaColor)
+	}
+	if c.QRText != "" {
+		args = append(args, "-qr", c.QRText)
+	}
+	if c.UVTemplate != "" {
+		args = append(args, "-uv", c.UVTemplate, "-uv-bleed", strconv.Itoa(c.UVBleed))
+	}
+	if c.Mask != "" {
+		args = append(args, "-mask", c.Mask)
+		if c.MaskPattern != "" {
+			args = append(args, "-mask-pattern", c.MaskPattern)
+		}
+	}
+	if c.PatternType == "image" {
+		args = append(args,
+			"-k", strconv.Itoa(c.KValue),
+			"-kmeans-batch", strconv.Itoa(c.KMeansBatch),
+			"-scaler", c.Scaler)
+	}
+	return args
+}
+
+// String formats the ratios as accepted by ParseColorRatios.
+func (r ColorRatios) String() string {
+	if r.Scheme != "" {
+		return r.Scheme
+	}
+	parts := make([]string, len(r.Weights))
+	for i, w := range r.Weights {
+		parts[i] = strconv.FormatFloat(w, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// String formats the ratios as accepted by ParseLayerRatios, naming each
+// layer unless all layers share the same ratios.
+func (r LayerRatios) String() string {
+	macro, medium, detail := r.Macro.String(), r.Medium.String(), r.Detail.String()
+	if macro == medium && medium == detail {
+		return macro
+	}
+	var entries []string
+	for _, l := range []struct {
+		name  string
+		value string
+	}{{"macro", macro}, {"medium", medium}, {"detail", detail}} {
+		if l.value != "" {
+			entries = append(entries, l.name+"="+l.value)
+		}
+	}
+	return strings.Join(entries, ";")
+}
@@ -0,0 +1,58 @@
+package config
+
+import "cmp"
+
+// The box, blob and hex patterns grow their clusters with a cellular
+// automaton: in each pass every cell may take the most common color of the
+// cells around it. -ca-passes, -ca-radius and -ca-chance tune it; left at
+// zero, each pattern keeps its own settings.
+
+// DefaultCAPasses is the number of cellular automaton passes.
+const DefaultCAPasses = 3
+
+// Neighborhood radii in cells and chances of taking the most common color
+// used when -ca-radius and -ca-chance aren't set. Box cells each vote over
+// a radius picked at random up to DefaultBoxRadius, which leaves ragged
+// cluster edges; blob and hex cells always take the most common color.
+const (
+	DefaultBoxRadius  = 2
+	DefaultBlobRadius = 1
+	DefaultBoxChance  = 0.7
+)
+
+// AutomatonPasses returns the number of cellular automaton passes. A
+// CAPasses of zero selects the default.
+func (c *Config) AutomatonPasses() int {
+	return cmp.Or(c.CAPasses, DefaultCAPasses)
+}
+
+// AutomatonRadius returns the neighborhood radius in cells of a box or blob
+// pattern before the structure scale. Without -ca-radius, box cells each
+// vote over a radius picked at random up to it.
+func (c *Config) AutomatonRadius(patternType string) int {
+	if c.CARadius > 0 {
+		return c.CARadius
+	}
+	if patternType == "box" {
+		return DefaultBoxRadius
+	}
+	return DefaultBlobRadius
+}
+
+// AutomatonChance returns the chance that a cell of a box, blob or hex
+// pattern takes the most common color of its neighborhood in each pass.
+func (c *Config) AutomatonChance(patternType string) float64 {
+	if c.CAChance > 0 {
+		return c.CAChance
+	}
+	if patternType == "box" {
+		return DefaultBoxChance
+	}
+	return 1
+}
+
+// usesAutomaton reports whether the pattern is grown by the cellular
+// automaton.
+func (c *Config) usesAutomaton() bool {
+	return c.UsesPattern("box") || c.UsesPattern("blob") || c.UsesPattern("hex")
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Composite pattern defaults.
+const (
+	DefaultLayers       = "blob,box"
+	DefaultLayerOpacity = 50
+)
+
+// LayerPatterns are the pattern types a composite pattern can be built
+// from.
+var LayerPatterns = []string{"box", "blob", "pat6", "hex"}
+
+// ParseLayers parses the -layers value, two pattern types separated by a
+// comma with the bottom layer first, such as "blob,box".
+func ParseLayers(s string) ([2]string, error) {
+	var layers [2]string
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return layers, fmt.Errorf("%q must be two pattern types separated by a comma, such as %q", s, DefaultLayers)
+	}
+	for i, p := range parts {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if !slices.Contains(LayerPatterns, p) {
+			return layers, fmt.Errorf("unknown layer pattern type %q (must be %s)", p, strings.Join(LayerPatterns, ", "))
+		}
+		layers[i] = p
+	}
+	return layers, nil
+}
+
+// UsesPattern reports whether the pattern is, or for a composite pattern
+// has a layer of, the given type, so that settings of that type apply.
+func (c *Config) UsesPattern(patternType string) bool {
+	if c.PatternType == "composite" {
+		return c.Layers[0] == patternType || c.Layers[1] == patternType
+	}
+	return c.PatternType == patternType
+}
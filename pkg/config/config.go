@@ -1,11 +1,19 @@
 package config
 
 import (
+	"cmp"
 	"flag"
 	"fmt"
+	"image/png"
+	"math"
+	"math/rand/v2"
 	"os"
+	"path"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -14,18 +22,274 @@ type Config struct {
 	BasePixelSize int
 	JSONFile      string
 	OutputDir     string
+	// Stdout writes the single generated image to standard output, set by
+	// -o -
+	Stdout        bool
 	ColorsString  string
 	Cores         int
 	AddEdge       bool
 	AddNoise      bool
+	NoiseLevel    int
+	EdgeStrength  int
 	PatternType   string
 	ImageDir      string
+	ImageMode     string
+	Include       []string
+	Exclude       []string
+	NoRecursive   bool
 	KValue        int
+	KMeansBatch   int
+	Scaler        string
+	MetricsFile   string
+	ScoreFile     string
+	Banded        bool
+	MaxMemory     uint64
+	Adaptive      bool
+	Timeout       time.Duration
+	Timeouts      map[string]time.Duration
+	CPUProfile    string
+	TraceFile     string
+	Seed          uint64
+	Compression   png.CompressionLevel
+	AutoTune      bool
+	Golden        bool
+	DryRun        bool
+	NameTemplate  string
+	Force         bool
+	SkipExisting  bool
+	ColorRatios   LayerRatios
+	LegacyBlend   bool
+	Blend         int
+	Distort       int
+	AlphaColor    string
+	Strict        bool
+	ShortNames    bool
+	Verbose       bool
+	Quiet         bool
+	JSONLog       bool
+	AtlasFile     string
+	AtlasPadding  int
+	ContactSheet  string
+	Manifest      bool
+	Mipmaps       bool
+	Animate       int
+	AnimateFormat string
+	AnimateFPS    int
+	UVTemplate    string
+	UVBleed       int
+	Mask          string
+	MaskPattern   string
+	CMYK          bool
+	CVD           bool
+	ICCProfile    string
+	// ColorProfile is "srgb" or the path of an ICC profile to embed in PNG
+	// outputs, or empty for none
+	ColorProfile string
+	DPI          int
+	ShapeSize    int
+	CAPasses     int
+	CARadius     int
+	CAChance     float64
+	FlowAngle    float64
+	Layers       [2]string
+	LayerOpacity int
+	Use          string
+	Scale        string
+	Structure    int
+	DebugLayers  bool
+	ORA          bool
+	Payload      string
+	QRText       string
+	Wallpapers   []Device
+	Family       bool
+	Format       string
+	Quality      int
+	Palettes     []CamoColors
+	// PatternSettings are the settings a -profile file gives for each
+	// pattern type, applied by ForPattern
+	PatternSettings map[string][]PatternSetting
+}
+
+// ColorRatios are the relative proportions of the palette colors, in
+// palette order. Weights is nil for equal proportions unless Scheme names a
+// ratio scheme computed for the palette size.
+type ColorRatios struct {
+	Weights []float64
+	Scheme  string
+}
+
+// IsZero reports whether the colors are used in equal proportions.
+func (r ColorRatios) IsZero() bool {
+	return r.Weights == nil && r.Scheme == ""
+}
+
+// LayerRatios are the color ratios for each layer of a pattern: the large
+// base shapes (macro), the medium shapes placed on top of them and the fine
+// detail texture such as noise.
+type LayerRatios struct {
+	Macro  ColorRatios
+	Medium ColorRatios
+	Detail ColorRatios
+}
+
+// IsZero reports whether no layer has color ratios.
+func (r LayerRatios) IsZero() bool {
+	return r.Macro.IsZero() && r.Medium.IsZero() && r.Detail.IsZero()
+}
+
+// Layers returns the ratios of every layer by name.
+func (r LayerRatios) Layers() map[string]ColorRatios {
+	return map[string]ColorRatios{"macro": r.Macro, "medium": r.Medium, "detail": r.Detail}
+}
+
+// ParseLayerRatios parses semicolon-separated color ratios such as
+// "macro=5,3,1,1;detail=1,1,3,3". An entry without a layer name applies to
+// every layer not given explicitly.
+func ParseLayerRatios(s string) (LayerRatios, error) {
+	var r LayerRatios
+	var all ColorRatios
+	set := map[string]bool{}
+
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, hasName := strings.Cut(entry, "=")
+		if !hasName {
+			name, value = "", entry
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if set[name] {
+			return LayerRatios{}, fmt.Errorf("ratios for %q given more than once", cmp.Or(name, "all layers"))
+		}
+		set[name] = true
+
+		ratios, err := ParseColorRatios(value)
+		if err != nil {
+			return LayerRatios{}, err
+		}
+		switch name {
+		case "":
+			all = ratios
+		case "macro":
+			r.Macro = ratios
+		case "medium":
+			r.Medium = ratios
+		case "detail":
+			r.Detail = ratios
+		default:
+			return LayerRatios{}, fmt.Errorf("unknown layer %q (must be 'macro', 'medium', or 'detail')", name)
+		}
+	}
+
+	if !set["macro"] {
+		r.Macro = all
+	}
+	if !set["medium"] {
+		r.Medium = all
+	}
+	if !set["detail"] {
+		r.Detail = all
+	}
+	return r, nil
+}
+
+// ParseColorRatios parses a comma-separated list of weights such as
+// "5,3,1,1", or the name of a ratio scheme.
+func ParseColorRatios(s string) (ColorRatios, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ColorRatios{}, nil
+	}
+	if strings.EqualFold(s, "marpat") {
+		return ColorRatios{Scheme: "marpat"}, nil
+	}
+
+	var r ColorRatios
+	total := 0.0
+	for _, part := range strings.Split(s, ",") {
+		w, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || w < 0 {
+			return ColorRatios{}, fmt.Errorf("invalid ratio %q (must be a non-negative number)", part)
+		}
+		r.Weights = append(r.Weights, w)
+		total += w
+	}
+	if total == 0 {
+		return ColorRatios{}, fmt.Errorf("at least one ratio must be greater than zero")
+	}
+	return r, nil
+}
+
+// Default strengths of the -noise and -edge effects.
+const (
+	DefaultNoiseLevel   = 5
+	DefaultEdgeStrength = 20
+)
+
+// NoiseChance returns the fraction of pixels -noise changes. A NoiseLevel
+// of zero selects the default.
+func (c *Config) NoiseChance() float32 {
+	return float32(cmp.Or(c.NoiseLevel, DefaultNoiseLevel)) / 100
+}
+
+// NormalizeAngle returns an angle in degrees within [0, 360).
+func NormalizeAngle(degrees float64) float64 {
+	a := math.Mod(degrees, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+// EdgeSteps returns the largest brightness change of -edge details, in
+// steps. An EdgeStrength of zero selects the default.
+func (c *Config) EdgeSteps() int {
+	return cmp.Or(c.EdgeStrength, DefaultEdgeStrength)
+}
+
+// NoTimeout is the Timeout of jobs that may render for as long as they
+// take. A Timeout of 0 scales the limit to the estimated work.
+const NoTimeout time.Duration = -1
+
+// GoldenSeed is the run seed used in golden mode so that output is
+// reproducible byte for byte.
+const GoldenSeed = 0x9e3779b97f4a7c15
+
+// AllPatterns is the -t value that makes every palette based pattern type
+// from each palette.
+const AllPatterns = "all"
+
+// Ways -image-mode uses the input images.
+const (
+	// ImageShapes maps the shapes and colors of each image into an image
+	// pattern
+	ImageShapes = "shapes"
+	// ImagePaletteOnly takes only the main colors of each image, as a
+	// palette for the -t pattern type
+	ImagePaletteOnly = "palette-only"
+	// ImageEnvironment takes the main colors of all the images together,
+	// as one palette for the -t pattern type
+	ImageEnvironment = "environment"
+)
+
+// hiddenFlags are left out of the usage message.
+var hiddenFlags = map[string]bool{
+	"golden": true,
 }
 
 type CamoColors struct {
 	Name   string   `json:"name"`
 	Colors []string `json:"colors"`
+	// Pattern, Width, Height, Ratios and Seed optionally replace the
+	// command line settings for this palette; see ForPalette. Seed is a
+	// pointer so that a palette can pin a seed of 0.
+	Pattern string `json:"pattern,omitempty"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Ratios  Ratios `json:"ratios,omitempty"`
+	Seed    *Seed  `json:"seed,omitempty"`
 }
 
 func stripHash(hex string) string {
@@ -35,10 +299,20 @@ func stripHash(hex string) string {
 	return hex
 }
 
+// isTransparentKeyword reports whether a palette entry names a fully
+// transparent color.
+func isTransparentKeyword(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s == "none" || s == "transparent"
+}
+
 func validateHexColor(hex string) error {
+	if isTransparentKeyword(hex) {
+		return nil
+	}
 	hex = stripHash(strings.TrimSpace(hex))
-	if len(hex) != 3 && len(hex) != 6 {
-		return fmt.Errorf("invalid hex color length: %s (should be 6 characters or 3 for short form)", hex)
+	if len(hex) != 3 && len(hex) != 4 && len(hex) != 6 && len(hex) != 8 {
+		return fmt.Errorf("invalid hex color length: %s (should be 6 or 8 characters, or 3 or 4 for short form)", hex)
 	}
 	for _, c := range hex {
 		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')) {
@@ -71,23 +345,204 @@ func cleanColorString(colors string) (string, error) {
 	return strings.Join(cleaned, ","), nil
 }
 
+// compressionLevels maps -png-compression values to encoder levels.
+var compressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// ParseByteSize parses sizes such as "512M", "2G" or "1.5GB" into bytes. A
+// bare number is taken as bytes.
+func ParseByteSize(s string) (uint64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := float64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:n-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return uint64(value * multiplier), nil
+}
+
+// ParseTimeouts parses per pattern type job time limits such as
+// "box=2m,image=10m". A limit of 0 lets jobs of that type run for as long
+// as they take.
+func ParseTimeouts(s string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	types := append(slices.Clone(PalettePatterns), "image")
+	for _, part := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not type=duration", part)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !slices.Contains(types, key) {
+			return nil, fmt.Errorf("unknown pattern type %q (must be one of %s)", key, strings.Join(types, ", "))
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid duration %q for %s", value, key)
+		}
+		timeouts[key] = d
+	}
+	return timeouts, nil
+}
+
+// parseGlobs parses a comma separated list of file name globs such as
+// "*.jpg,IMG_*".
+func parseGlobs(s string) ([]string, error) {
+	var globs []string
+	for _, g := range strings.Split(s, ",") {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q", g)
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
 func ParseFlags() *Config {
+	return Parse(os.Args[1:])
+}
+
+// Parse parses pattern generation flags from args. Like ParseFlags it
+// registers the flags on the command line flag set, so it can only be
+// called once, and it exits on invalid values.
+func Parse(args []string) *Config {
 	cfg := &Config{}
+	var maxMem, compression, ratios, wallpapers, palettes, layers, include, exclude, profilePath, timeout, timeouts string
 
 	flag.IntVar(&cfg.Width, "w", 1500, "Set the image width")
 	flag.IntVar(&cfg.Height, "h", 1500, "Set the image height")
 	flag.IntVar(&cfg.BasePixelSize, "b", 4, "Set the base pixel size (will be adjusted if necessary)")
-	flag.StringVar(&cfg.JSONFile, "j", "", "Process a JSON file containing a list of color palettes")
-	flag.StringVar(&cfg.OutputDir, "o", "output", "The output directory for generated images")
+	flag.StringVar(&cfg.JSONFile, "j", "", "Process a JSON, YAML or TOML file containing a list of color palettes, or every such file in a directory")
+	flag.StringVar(&cfg.OutputDir, "o", "output", "The output directory for generated images, or - to write a single image to standard output")
 	flag.StringVar(&cfg.ColorsString, "c", "", "Generate a single pattern using a comma-separated list of hex colors")
+	flag.StringVar(&palettes, "p", "", "Generate patterns from built-in palettes such as multicam or flecktarn (comma separated, or 'all'); list them with 'gocamo palettes'")
 	flag.IntVar(&cfg.Cores, "cores", runtime.NumCPU(), fmt.Sprintf("Number of CPU cores to use (1-%d available)", runtime.NumCPU()))
 	flag.BoolVar(&cfg.AddEdge, "edge", false, "Add edge details to the pattern")
 	flag.BoolVar(&cfg.AddNoise, "noise", false, "Add noise to the pattern")
-	flag.StringVar(&cfg.PatternType, "t", "box", "Set the pattern type (blob, box, or image)")
+	flag.IntVar(&cfg.NoiseLevel, "noise-level", DefaultNoiseLevel, "Percentage of pixels -noise blends a palette color into (0-100, implies -noise unless 0)")
+	flag.IntVar(&cfg.EdgeStrength, "edge-strength", DefaultEdgeStrength, "Largest brightness change of -edge details, in steps of about 1/43 stop (0-100, implies -edge unless 0)")
+	flag.StringVar(&cfg.PatternType, "t", "box", "Set the pattern type (blob, box, hex, pat6 for tiger stripe, composite for two -layers, image, or all for one of each of box, blob, pat6 and hex)")
 	flag.StringVar(&cfg.ImageDir, "i", "input", "Input directory containing images for image-based camouflage")
+	flag.StringVar(&cfg.ImageMode, "image-mode", ImageShapes, "How -i images are used: 'shapes' maps the shapes and colors of each photo, 'palette-only' takes only its colors for a -t box, blob, pat6, hex or composite pattern, 'environment' makes one palette from the colors of all the images")
+	flag.StringVar(&include, "include", "", "Only use images in the -i directory whose names match these globs (comma separated, e.g. \"*.jpg,IMG_*\")")
+	flag.StringVar(&exclude, "exclude", "", "Skip images and subdirectories of the -i directory whose names match these globs (comma separated, e.g. \"thumb_*,exports\")")
+	flag.BoolVar(&cfg.NoRecursive, "no-recursive", false, "Only use the images directly in the -i directory, not those in its subdirectories")
 	flag.IntVar(&cfg.KValue, "k", 4, "Number of main colors for image-based camouflage")
+	flag.BoolVar(&cfg.Banded, "banded", false, "Generate and encode patterns in horizontal strips to bound memory use for very large images (not image patterns)")
+	flag.StringVar(&cfg.Scaler, "scaler", "bilinear", "Resampling used to resize reference images (nearest, approx, bilinear, or catmullrom)")
+	flag.StringVar(&maxMem, "max-mem", "", "Memory budget (e.g. 2G, 512M); reduces concurrency or switches to banded generation to stay under it")
+	flag.IntVar(&cfg.KMeansBatch, "kmeans-batch", 1024, "Sample size per k-means iteration for image-based camouflage (0 for full k-means: slower, more accurate)")
+	flag.BoolVar(&cfg.Adaptive, "adaptive", false, "Adapt the number of concurrent jobs (up to -cores) to memory estimates and observed job durations")
+	flag.StringVar(&timeout, "timeout", "auto", "Abandon a job that renders for longer than this duration, such as 30m (0 for no limit; 'auto' scales the limit to the estimated work)")
+	flag.StringVar(&timeouts, "timeouts", "", "Time limits by pattern type that take the place of -timeout, such as \"box=2m,image=10m\" (0 for no limit)")
+	flag.StringVar(&cfg.CPUProfile, "pprof", "", "Write a CPU profile to the given file")
+	flag.StringVar(&cfg.TraceFile, "trace", "", "Write an execution trace to the given file")
+	flag.StringVar(&cfg.MetricsFile, "metrics", "", "Write pattern metrics for every generated image to a CSV (or .json) file")
+	flag.StringVar(&cfg.ScoreFile, "score", "", "Write a report scoring every generated image on its color ratios and spatial frequency spectrum to a CSV (or .json) file")
+	flag.StringVar(&compression, "png-compression", "default", "PNG compression level (default, none, fast, or best)")
+	flag.BoolVar(&cfg.AutoTune, "auto", true, "Pick cores, PNG compression and banded generation from available memory, CPUs and image size (flags that are set explicitly are kept)")
 
-	flag.Parse()
+	flag.StringVar(&ratios, "r", "", "Relative color proportions in palette order (e.g. 5,3,1,1), or 'marpat' for a scheme that suits any palette size; set layers separately with e.g. \"macro=5,3,1,1;detail=1,1,3,3\"")
+	flag.IntVar(&cfg.Blend, "blend", 0, "Feather color boundaries into soft gradients over this radius in pixels (0 for hard edges)")
+	flag.IntVar(&cfg.Distort, "distort", 0, "Warp the cell grid by up to this many cells with low-frequency Perlin noise, bending straight cell edges (0 for none)")
+	flag.BoolVar(&cfg.LegacyBlend, "legacy-blend", false, "Blend noise, edge details and -blend gradients on gamma encoded sRGB values like older versions instead of in linear light")
+	flag.StringVar(&cfg.AlphaColor, "alpha-color", "", "Render this palette color fully transparent (or use 'none' as a palette entry)")
+	flag.IntVar(&cfg.Animate, "animate", 0, "Also save each box/blob/pat6/hex pattern as an animated loop of this many frames whose color regions slowly shift (0 for none)")
+	flag.StringVar(&cfg.AnimateFormat, "animate-format", "gif", "Format of -animate loops (gif, or apng for full color animated PNG)")
+	flag.IntVar(&cfg.AnimateFPS, "animate-fps", 12, "Frames per second of -animate loops (1-50)")
+	flag.StringVar(&cfg.AtlasFile, "atlas", "", "Also pack all generated patterns into this atlas PNG, with a JSON and CSS sprite map")
+	flag.IntVar(&cfg.AtlasPadding, "atlas-padding", 0, "Pixels of space between patterns in the atlas")
+	flag.StringVar(&cfg.ContactSheet, "contact-sheet", "", "Also save a contact sheet PNG with a labeled preview of every generated pattern")
+	flag.BoolVar(&cfg.Manifest, "manifest", true, "Write manifest.json to the output directory listing every generated file with its settings (-manifest=false to skip)")
+	flag.BoolVar(&cfg.Mipmaps, "mipmaps", false, "Also save the mip chain of each pattern, halving down to 1x1, as _mipN PNG files")
+	flag.StringVar(&cfg.UVTemplate, "uv", "", "UV layout template PNG; the pattern fills only its islands and takes its size")
+	flag.IntVar(&cfg.UVBleed, "uv-bleed", 4, "Pixels to extend island edges by with -uv, to avoid seams when the texture is filtered")
+	flag.StringVar(&cfg.Mask, "mask", "", "Mask image (PNG, JPEG, GIF, BMP, TIFF or WebP); the pattern fills its white areas and black areas are transparent or get -mask-pattern")
+	flag.StringVar(&cfg.MaskPattern, "mask-pattern", "", "Pattern type for the black areas of -mask (box, blob, pat6 or hex; default transparent)")
+	flag.BoolVar(&cfg.CMYK, "cmyk", false, "Also save each pattern as a CMYK TIFF for offset and fabric printing")
+	flag.BoolVar(&cfg.CVD, "cvd", false, "Also save a _cvd PNG of each pattern beside simulations of protanopia, deuteranopia and tritanopia, to check its colors for color blind viewers")
+	flag.StringVar(&cfg.ColorProfile, "color-profile", "", "Embed an ICC color profile in PNG outputs: srgb for the built-in sRGB profile, or the path of an RGB .icc file")
+	flag.StringVar(&cfg.ICCProfile, "icc", "", "ICC output profile for -cmyk conversion, embedded in the TIFF (default plain conversion)")
+	flag.IntVar(&cfg.DPI, "dpi", 300, "Print resolution in dots per inch, used by -use and recorded in CMYK TIFF files")
+	flag.IntVar(&cfg.ShapeSize, "shape-size", DefaultShapeSize, "Largest box macro shape in cells")
+	flag.IntVar(&cfg.CAPasses, "ca-passes", DefaultCAPasses, "Cellular automaton passes that grow the clusters of box, blob and hex patterns (1-20); more passes give larger, smoother clusters")
+	flag.IntVar(&cfg.CARadius, "ca-radius", 0, "Neighborhood radius in cells each box and blob cell takes its most common color from (1-10; default 1-2 at random for box, 1 for blob)")
+	flag.Float64Var(&cfg.CAChance, "ca-chance", 0, "Chance that a box, blob or hex cell takes the most common color around it in each pass (0-1; default 0.7 for box, 1 for blob and hex); lower leaves more ragged clusters")
+	flag.Float64Var(&cfg.FlowAngle, "flow-angle", 0, "Direction of pat6 tiger stripes in degrees counterclockwise from horizontal (e.g. 90 for vertical, 45 for diagonal)")
+	flag.StringVar(&layers, "layers", DefaultLayers, "Bottom and top pattern types of -t composite, separated by a comma (box, blob, pat6 or hex)")
+	flag.IntVar(&cfg.LayerOpacity, "layer-opacity", DefaultLayerOpacity, "Percentage of a -t composite pattern the top layer covers, in organic patches (0-100)")
+	flag.StringVar(&cfg.Scale, "scale", "", "Size the pixels, shapes and clusters of every pattern type together (micro, uniform, vehicle, or large)")
+	flag.StringVar(&cfg.Use, "use", "", "Size pattern elements for a viewing distance at the -dpi print resolution (personal, vehicle, or building)")
+	flag.BoolVar(&cfg.DebugLayers, "debug-layers", false, "Also save each generation stage of box/blob patterns as indexed PNGs in a _layers directory")
+	flag.BoolVar(&cfg.ORA, "ora", false, "Also save box/blob patterns as layered OpenRaster (.ora) files with one layer per color and effect")
+	flag.StringVar(&cfg.Payload, "payload", "", "Invisibly embed a short text (e.g. a serial number or customer ID) in each PNG; read it back with 'gocamo reveal'")
+	flag.StringVar(&cfg.QRText, "qr", "", "Work a scannable QR code of this URL or text into box/blob patterns, in the darkest and lightest palette colors")
+	flag.StringVar(&wallpapers, "wallpapers", "", "Make a lock and home screen wallpaper pair per palette for these devices (comma separated, or 'all'); -w and -h are ignored")
+	flag.BoolVar(&cfg.Family, "family", false, "Make a family per palette in its own folder: the main pattern plus inverted ratio, micro-scale and two-color accents")
+	flag.Uint64Var(&cfg.Seed, "seed", 0, "Seed for the random patterns, to reproduce a run (default random; shown after _s in filenames, give it with a 0x prefix)")
+	flag.StringVar(&cfg.Format, "format", "png", "Output file format (png, jpeg, webp for lossless WebP, or svg for box/blob patterns as scalable vector shapes)")
+	flag.IntVar(&cfg.Quality, "quality", 90, "JPEG quality with -format jpeg (1-100)")
+	flag.BoolVar(&cfg.Verbose, "verbose", false, "Print additional details such as memory and time estimates, per-job timing and color coverage")
+	flag.BoolVar(&cfg.Quiet, "quiet", false, "Print errors only, without the banner or progress bar")
+	flag.BoolVar(&cfg.JSONLog, "json-log", false, "Log one JSON object per line instead of text, for use in pipelines")
+	flag.StringVar(&cfg.NameTemplate, "name-template", "", "Name outputs with a template such as \"{index}_{name}_{type}_{w}x{h}\" or Go text/template actions such as {{.Name}} (tokens: index, name, colors, type, w, h, seed)")
+	flag.BoolVar(&cfg.Force, "force", false, "Overwrite existing output files instead of adding a numeric suffix such as _2")
+	flag.BoolVar(&cfg.SkipExisting, "skip-existing", false, "Skip jobs whose output file already exists, to resume or extend a batch run")
+	flag.BoolVar(&cfg.ShortNames, "short-names", false, "Use a short hash of the colors in filenames instead of listing them")
+	flag.BoolVar(&cfg.Strict, "strict", false, "Reject unknown fields, duplicate palette names and empty color lists in the -j palette file")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Check the inputs and print the files a run would write with an estimated runtime, without generating anything")
+	flag.StringVar(&profilePath, "profile", "", "Read flag values and per-pattern settings from a YAML profile (flags given on the command line take precedence)")
+	flag.BoolVar(&cfg.Golden, "golden", false, "Deterministic output for golden image comparisons")
+
+	flag.Usage = usage
+	flag.CommandLine.Parse(args)
+
+	if profilePath != "" {
+		if err := loadProfile(cfg, profilePath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -profile value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Every job derives its random source from the run seed
+	if !isFlagPassed("seed") {
+		cfg.Seed = rand.Uint64()
+	}
+
+	// Golden mode pins everything that could make output depend on the
+	// machine or on timing
+	if cfg.Golden {
+		cfg.Seed = GoldenSeed
+		cfg.AutoTune = false
+		cfg.Adaptive = false
+		cfg.Timeout = NoTimeout
+	}
 
 	// Validate cores
 	if cfg.Cores < 1 {
@@ -107,9 +562,229 @@ func ParseFlags() *Config {
 		cfg.BasePixelSize = 4 // default
 	}
 
-	// If -i flag is used, set pattern type to "image"
-	if isFlagPassed("i") {
-		cfg.PatternType = "image"
+	if cfg.DPI < 1 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -dpi value: %d\n", cfg.DPI)
+		os.Exit(1)
+	}
+	if cfg.KValue < 1 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -k value: %d (must be at least 1)\n", cfg.KValue)
+		os.Exit(1)
+	}
+	if cfg.ShapeSize < 2 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -shape-size value: %d (must be at least 2)\n", cfg.ShapeSize)
+		os.Exit(1)
+	}
+	if cfg.CAPasses < 1 || cfg.CAPasses > 20 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -ca-passes value: %d (must be 1-20)\n", cfg.CAPasses)
+		os.Exit(1)
+	}
+	if cfg.CARadius < 0 || cfg.CARadius > 10 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -ca-radius value: %d (must be 1-10)\n", cfg.CARadius)
+		os.Exit(1)
+	}
+	if cfg.CAChance < 0 || cfg.CAChance > 1 || math.IsNaN(cfg.CAChance) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -ca-chance value: %v (must be 0-1)\n", cfg.CAChance)
+		os.Exit(1)
+	}
+	if wallpapers != "" {
+		devices, err := parseDevices(wallpapers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -wallpapers value: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Wallpapers = devices
+	}
+
+	if palettes != "" {
+		named, err := parseNamedPalettes(palettes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -p value: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Palettes = named
+	}
+
+	var err error
+	if cfg.Include, err = parseGlobs(include); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -include value: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.Exclude, err = parseGlobs(exclude); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -exclude value: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cfg.Use != "" && cfg.Scale != "" {
+		fmt.Fprintf(os.Stderr, "Error: -use and -scale cannot be used together\n")
+		os.Exit(1)
+	}
+	if cfg.Use != "" {
+		if err := applyUsePreset(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.Scale != "" {
+		if err := applyScalePreset(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	switch cfg.ImageMode {
+	case ImageShapes:
+		// If -i flag is used, set pattern type to "image"
+		if isFlagPassed("i") {
+			cfg.PatternType = "image"
+		}
+	case ImagePaletteOnly, ImageEnvironment:
+		if cfg.ColorsString != "" || cfg.JSONFile != "" || len(cfg.Palettes) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: -image-mode %s takes its colors from the -i images and cannot be used with -c, -j or -p\n", cfg.ImageMode)
+			os.Exit(1)
+		}
+		if cfg.PatternType == "image" {
+			fmt.Fprintf(os.Stderr, "Error: -image-mode %s needs a pattern type such as -t box, blob, pat6 or hex\n", cfg.ImageMode)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid -image-mode value: %s (must be 'shapes', 'palette-only' or 'environment')\n", cfg.ImageMode)
+		os.Exit(1)
+	}
+
+	if timeout != "auto" && !cfg.Golden {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d < 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid -timeout value: %s (must be a duration such as 30m, 0 for no limit, or 'auto')\n", timeout)
+			os.Exit(1)
+		}
+		cfg.Timeout = cmp.Or(d, NoTimeout)
+	}
+	if timeouts != "" && !cfg.Golden {
+		parsed, err := ParseTimeouts(timeouts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -timeouts value: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Timeouts = parsed
+	}
+
+	if maxMem != "" {
+		size, err := ParseByteSize(maxMem)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -max-mem value: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.MaxMemory = size
+	}
+
+	cfg.Format = strings.ToLower(cfg.Format)
+	if cfg.Format == "jpg" {
+		cfg.Format = "jpeg"
+	}
+	switch cfg.Format {
+	case "png", "jpeg", "webp", "svg":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid -format value: %s (must be 'png', 'jpeg', 'webp', or 'svg')\n", cfg.Format)
+		os.Exit(1)
+	}
+	if cfg.Blend < 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -blend value: %d (must be 0 or more)\n", cfg.Blend)
+		os.Exit(1)
+	}
+	if cfg.Distort < 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -distort value: %d (must be 0 or more)\n", cfg.Distort)
+		os.Exit(1)
+	}
+	if cfg.Force && cfg.SkipExisting {
+		fmt.Fprintf(os.Stderr, "Error: -force and -skip-existing cannot be used together\n")
+		os.Exit(1)
+	}
+	if cfg.Quiet && cfg.Verbose {
+		fmt.Fprintf(os.Stderr, "Error: -quiet and -verbose cannot be used together\n")
+		os.Exit(1)
+	}
+	// Standard output carries the image, so only errors and -verbose
+	// details are logged, to standard error
+	if cfg.OutputDir == "-" {
+		cfg.Stdout = true
+		cfg.Quiet = !cfg.Verbose
+	}
+	if cfg.NoiseLevel < 0 || cfg.NoiseLevel > 100 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -noise-level value: %d (must be 0-100)\n", cfg.NoiseLevel)
+		os.Exit(1)
+	}
+	if isFlagPassed("noise-level") {
+		cfg.AddNoise = cfg.NoiseLevel > 0
+	}
+	if cfg.EdgeStrength < 0 || cfg.EdgeStrength > 100 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -edge-strength value: %d (must be 0-100)\n", cfg.EdgeStrength)
+		os.Exit(1)
+	}
+	if isFlagPassed("edge-strength") {
+		cfg.AddEdge = cfg.EdgeStrength > 0
+	}
+	parsedLayers, err := ParseLayers(layers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -layers value: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.Layers = parsedLayers
+	if cfg.LayerOpacity < 0 || cfg.LayerOpacity > 100 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -layer-opacity value: %d (must be 0-100)\n", cfg.LayerOpacity)
+		os.Exit(1)
+	}
+	cfg.MaskPattern = strings.ToLower(cfg.MaskPattern)
+	if cfg.MaskPattern != "" {
+		if cfg.Mask == "" {
+			fmt.Fprintf(os.Stderr, "Error: -mask-pattern requires -mask\n")
+			os.Exit(1)
+		}
+		if !slices.Contains(LayerPatterns, cfg.MaskPattern) {
+			fmt.Fprintf(os.Stderr, "Error: invalid -mask-pattern value: %s (must be %s)\n", cfg.MaskPattern, strings.Join(LayerPatterns, ", "))
+			os.Exit(1)
+		}
+	}
+	if math.IsNaN(cfg.FlowAngle) || math.IsInf(cfg.FlowAngle, 0) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -flow-angle value: %v\n", cfg.FlowAngle)
+		os.Exit(1)
+	}
+	if cfg.Animate < 0 || cfg.Animate == 1 || cfg.Animate > 1000 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -animate value: %d (must be 0 or 2-1000)\n", cfg.Animate)
+		os.Exit(1)
+	}
+	cfg.AnimateFormat = strings.ToLower(cfg.AnimateFormat)
+	if cfg.AnimateFormat != "gif" && cfg.AnimateFormat != "apng" {
+		fmt.Fprintf(os.Stderr, "Error: invalid -animate-format value: %s (must be 'gif' or 'apng')\n", cfg.AnimateFormat)
+		os.Exit(1)
+	}
+	if cfg.AnimateFPS < 1 || cfg.AnimateFPS > 50 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -animate-fps value: %d (must be 1-50)\n", cfg.AnimateFPS)
+		os.Exit(1)
+	}
+	if cfg.Quality < 1 || cfg.Quality > 100 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -quality value: %d (must be 1-100)\n", cfg.Quality)
+		os.Exit(1)
+	}
+
+	level, ok := compressionLevels[strings.ToLower(compression)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: invalid -png-compression value: %s (must be 'default', 'none', 'fast', or 'best')\n", compression)
+		os.Exit(1)
+	}
+	cfg.Compression = level
+
+	r, err := ParseLayerRatios(ratios)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -r value: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.ColorRatios = r
+
+	if cfg.AlphaColor != "" {
+		if err := validateHexColor(cfg.AlphaColor); err != nil || isTransparentKeyword(cfg.AlphaColor) {
+			fmt.Fprintf(os.Stderr, "Error: invalid -alpha-color value: %s\n", cfg.AlphaColor)
+			os.Exit(1)
+		}
 	}
 
 	// Clean and validate the colors string if provided
@@ -125,6 +800,26 @@ func ParseFlags() *Config {
 	return cfg
 }
 
+// usage prints the default usage message without the hidden flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	hidden := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	hidden.SetOutput(out)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			hidden.Var(f.Value, f.Name, f.Usage)
+			hidden.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	hidden.PrintDefaults()
+}
+
+// IsFlagPassed reports whether the named flag was set on the command line.
+func IsFlagPassed(name string) bool {
+	return isFlagPassed(name)
+}
+
 // Helper function to check if a flag was explicitly passed
 func isFlagPassed(name string) bool {
 	found := false
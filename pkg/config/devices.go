@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Device is a phone or tablet screen that wallpapers are made for.
+type Device struct {
+	Name          string
+	Width, Height int     // portrait, in pixels
+	Scale         float64 // pixels per point, used to scale the base pixel size
+}
+
+// Devices are the screens -wallpapers can target.
+var Devices = map[string]Device{
+	"iphone-se":         {Name: "iphone-se", Width: 750, Height: 1334, Scale: 2},
+	"iphone-15":         {Name: "iphone-15", Width: 1179, Height: 2556, Scale: 3},
+	"iphone-15-pro-max": {Name: "iphone-15-pro-max", Width: 1290, Height: 2796, Scale: 3},
+	"pixel-8":           {Name: "pixel-8", Width: 1080, Height: 2400, Scale: 2.625},
+	"galaxy-s24":        {Name: "galaxy-s24", Width: 1080, Height: 2340, Scale: 3},
+	"ipad-pro-13":       {Name: "ipad-pro-13", Width: 2064, Height: 2752, Scale: 2},
+}
+
+// deviceNames returns the device names in order.
+func deviceNames() []string {
+	names := make([]string, 0, len(Devices))
+	for name := range Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseDevices parses a comma separated list of device names, or "all".
+func parseDevices(s string) ([]Device, error) {
+	if strings.TrimSpace(strings.ToLower(s)) == "all" {
+		var devices []Device
+		for _, name := range deviceNames() {
+			devices = append(devices, Devices[name])
+		}
+		return devices, nil
+	}
+
+	var devices []Device
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		d, ok := Devices[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown device %q (must be 'all' or one of %s)", name, strings.Join(deviceNames(), ", "))
+		}
+		seen[name] = true
+		devices = append(devices, d)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no devices given")
+	}
+	return devices, nil
+}
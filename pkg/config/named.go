@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NamedPalette is a built-in palette of a well-known military pattern. The
+// colors are approximations taken from printed fabric, not official specs.
+type NamedPalette struct {
+	Name        string
+	Description string
+	Colors      []string
+}
+
+// NamedPalettes are the palettes -p can select.
+var NamedPalettes = map[string]NamedPalette{
+	"marpat-woodland": {Name: "marpat-woodland", Description: "US Marine Corps MARPAT woodland",
+		Colors: []string{"#5e6b46", "#544a36", "#a39a80", "#2f2f24"}},
+	"marpat-desert": {Name: "marpat-desert", Description: "US Marine Corps MARPAT desert",
+		Colors: []string{"#c3a98a", "#a68a6a", "#7a6548", "#d9c7a7"}},
+	"multicam": {Name: "multicam", Description: "MultiCam transitional",
+		Colors: []string{"#786d56", "#a39a82", "#5f6b48", "#ccbfa3", "#4c4a3a", "#8f7f61", "#3a3226"}},
+	"ocp": {Name: "ocp", Description: "US Army Operational Camouflage Pattern (Scorpion W2)",
+		Colors: []string{"#8f8a6f", "#bfb094", "#6e6a4e", "#9a8762", "#4d4a3a", "#3e3528"}},
+	"flecktarn": {Name: "flecktarn", Description: "German Bundeswehr five color Flecktarn",
+		Colors: []string{"#6c7a4a", "#4a5437", "#8c8a5f", "#5a4b33", "#1f1f1a"}},
+	"m90": {Name: "m90", Description: "Swedish M90 splinter",
+		Colors: []string{"#6d7a4e", "#3d4a2e", "#8f9a76", "#23271e"}},
+	"auscam": {Name: "auscam", Description: "Australian AUSCAM / DPCU",
+		Colors: []string{"#8e7c53", "#6e7148", "#3e4a2e", "#5b4530", "#8d5d3b"}},
+	"woodland": {Name: "woodland", Description: "US M81 woodland",
+		Colors: []string{"#4e5d3a", "#b39e76", "#5b4632", "#1f1c18"}},
+	"dcu": {Name: "dcu", Description: "US three color desert (DCU)",
+		Colors: []string{"#d8c6a5", "#a48e6a", "#7a6249"}},
+	"ucp": {Name: "ucp", Description: "US Army Universal Camouflage Pattern",
+		Colors: []string{"#7f8a7a", "#a9aba1", "#5d6557"}},
+	"tigerstripe": {Name: "tigerstripe", Description: "Vietnam era tiger stripe",
+		Colors: []string{"#7e7a4b", "#4f5a36", "#2a2b1f", "#1a1a14"}},
+	"urban": {Name: "urban", Description: "Grey urban",
+		Colors: []string{"#8a8a88", "#b8b8b4", "#5a5a58", "#2c2c2b"}},
+}
+
+// NamedPaletteNames returns the built-in palette names in order.
+func NamedPaletteNames() []string {
+	names := make([]string, 0, len(NamedPalettes))
+	for name := range NamedPalettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Camo returns the palette in the form the generators take.
+func (p NamedPalette) Camo() CamoColors {
+	return CamoColors{Name: p.Name, Colors: p.Colors}
+}
+
+// parseNamedPalettes parses a comma separated list of built-in palette
+// names, or "all".
+func parseNamedPalettes(s string) ([]CamoColors, error) {
+	if strings.TrimSpace(strings.ToLower(s)) == "all" {
+		var palettes []CamoColors
+		for _, name := range NamedPaletteNames() {
+			palettes = append(palettes, NamedPalettes[name].Camo())
+		}
+		return palettes, nil
+	}
+
+	var palettes []CamoColors
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		p, ok := NamedPalettes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown palette %q (must be 'all' or one of %s)", name, strings.Join(NamedPaletteNames(), ", "))
+		}
+		seen[name] = true
+		palettes = append(palettes, p.Camo())
+	}
+	if len(palettes) == 0 {
+		return nil, fmt.Errorf("no palettes given")
+	}
+	return palettes, nil
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PalettePatterns are the pattern types a palette can choose with its
+// "pattern" field.
+var PalettePatterns = []string{"box", "blob", "pat6", "hex", "composite"}
+
+// knownFields lists the palette fields for unknown field errors.
+const knownFields = `"name", "colors", "pattern", "width", "height", "ratios" or "seed"`
+
+// overrideKinds are the JSON types each override field accepts.
+var overrideKinds = map[string][]string{
+	"pattern": {"string"},
+	"width":   {"number"},
+	"height":  {"number"},
+	"ratios":  {"string", "list of numbers"},
+	"seed":    {"number", "string"},
+}
+
+// errUnknownField is returned by setField for keys other than those of
+// overrideKinds.
+var errUnknownField = errors.New("unknown field")
+
+// Ratios are the color ratios of a palette in -r syntax. Palette files may
+// also give plain weights as a list of numbers, such as [45, 30, 15, 10].
+type Ratios string
+
+func (r *Ratios) UnmarshalJSON(data []byte) error {
+	var weights []float64
+	if err := json.Unmarshal(data, &weights); err == nil {
+		*r = Ratios(joinWeights(weights))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("\"ratios\" must be a string or a list of numbers")
+	}
+	*r = Ratios(s)
+	return nil
+}
+
+// joinWeights formats a list of weights in -r syntax.
+func joinWeights(weights []float64) string {
+	parts := make([]string, len(weights))
+	for i, w := range weights {
+		parts[i] = strconv.FormatFloat(w, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// listRatios turns a [45, 30, 15, 10] list of weights in a YAML or TOML
+// file into -r syntax. Other values are returned unchanged.
+func listRatios(value string) (string, error) {
+	if !strings.HasPrefix(value, "[") {
+		return value, nil
+	}
+	if !strings.HasSuffix(value, "]") {
+		return "", fmt.Errorf("unterminated list of ratios")
+	}
+	items, err := splitList(value[1 : len(value)-1])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(items, ","), nil
+}
+
+// Seed is the "seed" of a palette. In palette files it is a number or a
+// string such as "0x4f2a9c1e7b3d5086", as -seed accepts.
+type Seed uint64
+
+func (s *Seed) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	}
+	v, err := parseSeed(text)
+	if err != nil {
+		return err
+	}
+	*s = Seed(v)
+	return nil
+}
+
+func parseSeed(s string) (uint64, error) {
+	v, err := strconv.ParseUint(strings.TrimSpace(s), 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid seed %q (use a number such as 0x4f2a9c1e7b3d5086)", s)
+	}
+	return v, nil
+}
+
+// setField sets the override field key from its text in a YAML or TOML
+// file, or returns errUnknownField.
+func (p *CamoColors) setField(key, value string) error {
+	switch key {
+	case "pattern":
+		if !slices.Contains(PalettePatterns, value) {
+			return fmt.Errorf("invalid pattern %q (must be %s)", value, strings.Join(PalettePatterns, ", "))
+		}
+		p.Pattern = value
+	case "width", "height":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("%q must be a positive whole number", key)
+		}
+		if key == "width" {
+			p.Width = n
+		} else {
+			p.Height = n
+		}
+	case "ratios":
+		value, err := listRatios(value)
+		if err != nil {
+			return fmt.Errorf("invalid \"ratios\": %w", err)
+		}
+		if _, err := ParseLayerRatios(value); err != nil {
+			return fmt.Errorf("invalid \"ratios\": %w", err)
+		}
+		p.Ratios = Ratios(value)
+	case "seed":
+		v, err := parseSeed(value)
+		if err != nil {
+			return err
+		}
+		seed := Seed(v)
+		p.Seed = &seed
+	default:
+		return errUnknownField
+	}
+	return nil
+}
+
+// HasOverrides reports whether the palette sets any of its own pattern
+// settings.
+func (p CamoColors) HasOverrides() bool {
+	return p.Pattern != "" || p.Width != 0 || p.Height != 0 || p.Ratios != "" || p.Seed != nil
+}
+
+// ForPalette returns the config for the jobs of palette p, with the pattern
+// type, size, color ratios and seed the palette sets in place of the
+// command line settings. It returns c itself if p sets none of them. With
+// -t all every pattern type is still made.
+func (c *Config) ForPalette(p CamoColors) (*Config, error) {
+	if !p.HasOverrides() {
+		return c, nil
+	}
+	pc := *c
+	if p.Pattern != "" {
+		if !slices.Contains(PalettePatterns, p.Pattern) {
+			return nil, fmt.Errorf("invalid pattern %q (must be %s)", p.Pattern, strings.Join(PalettePatterns, ", "))
+		}
+		if c.PatternType != AllPatterns {
+			pc.PatternType = p.Pattern
+		}
+	}
+	if p.Width < 0 || p.Height < 0 {
+		return nil, fmt.Errorf("invalid size %dx%d", p.Width, p.Height)
+	}
+	if p.Width > 0 {
+		pc.Width = p.Width
+	}
+	if p.Height > 0 {
+		pc.Height = p.Height
+	}
+	if p.Ratios != "" {
+		r, err := ParseLayerRatios(string(p.Ratios))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ratios: %w", err)
+		}
+		pc.ColorRatios = r
+	}
+	if p.Seed != nil {
+		pc.Seed = uint64(*p.Seed)
+	}
+	return &pc, nil
+}
@@ -0,0 +1,446 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// paletteExts are the extensions of the palette files read from a
+// directory.
+var paletteExts = []string{".json", ".yaml", ".yml", ".toml"}
+
+// LoadPalettes reads a JSON, YAML or TOML file containing a list of color
+// palettes, telling them apart by the file extension. In strict mode
+// unknown fields, duplicate fields or palette names, and palettes without
+// colors are rejected with their line and column. If path is a directory,
+// every palette file in it is read; see loadPaletteDir.
+func LoadPalettes(path string, strict bool) ([]CamoColors, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return loadPaletteDir(path, strict)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open palette file: %w", err)
+	}
+
+	var parse func([]byte, bool) ([]parsedPalette, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		parse = parseYAMLPalettes
+	case ".toml":
+		parse = parseTOMLPalettes
+	}
+	if parse != nil {
+		palettes, err := parse(data, strict)
+		if err == nil && strict {
+			err = checkParsed(palettes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", path, err)
+		}
+		if len(palettes) == 0 {
+			return nil, fmt.Errorf("no color palettes found in %s", path)
+		}
+		camoList := make([]CamoColors, len(palettes))
+		for i, p := range palettes {
+			camoList[i] = p.CamoColors
+		}
+		return camoList, nil
+	}
+
+	if strict {
+		if err := checkPalettes(data); err != nil {
+			return nil, fmt.Errorf("%s:%w", path, err)
+		}
+	}
+
+	var camoList []CamoColors
+	if err := json.Unmarshal(data, &camoList); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON in %s: %w", path, err)
+	}
+	if len(camoList) == 0 {
+		return nil, fmt.Errorf("no color palettes found in %s", path)
+	}
+	return camoList, nil
+}
+
+// loadPaletteDir reads every palette file in dir, in name order. Each
+// palette name is prefixed with the name of its file, such as "army/desert"
+// for the desert palette of army.json, so palettes of the same name in
+// different files get different output file names.
+func loadPaletteDir(dir string, strict bool) ([]CamoColors, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read palette directory: %w", err)
+	}
+	var camoList []CamoColors
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || !slices.Contains(paletteExts, ext) {
+			continue
+		}
+		palettes, err := LoadPalettes(filepath.Join(dir, e.Name()), strict)
+		if err != nil {
+			return nil, err
+		}
+		stem := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		for _, p := range palettes {
+			p.Name = stem + "/" + p.Name
+			camoList = append(camoList, p)
+		}
+	}
+	if len(camoList) == 0 {
+		return nil, fmt.Errorf("no palette files (%s) found in %s", strings.Join(paletteExts, ", "), dir)
+	}
+	return camoList, nil
+}
+
+// paletteChecker walks the JSON tokens of a palette file keeping track of
+// where each token starts.
+type paletteChecker struct {
+	data []byte
+	dec  *json.Decoder
+	// pos is the offset of the start of the last token read
+	pos int
+}
+
+// paletteError is a strict mode error at a position in the file.
+type paletteError struct {
+	line, col int
+	msg       string
+}
+
+func (e *paletteError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.line, e.col, e.msg)
+}
+
+func checkPalettes(data []byte) error {
+	c := &paletteChecker{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	c.dec.UseNumber()
+
+	if err := c.expectDelim('[', "a list of palettes"); err != nil {
+		return err
+	}
+
+	names := make(map[string]int)
+	for i := 1; c.dec.More(); i++ {
+		if err := c.expectDelim('{', "a palette object"); err != nil {
+			return err
+		}
+		start := c.pos
+
+		seen := make(map[string]bool)
+		var name string
+		colors := -1
+		for c.dec.More() {
+			tok, err := c.token()
+			if err != nil {
+				return err
+			}
+			key, _ := tok.(string)
+			if seen[key] {
+				return c.errorf(c.pos, "palette %d: duplicate field %q", i, key)
+			}
+			seen[key] = true
+
+			switch key {
+			case "name":
+				tok, err := c.token()
+				if err != nil {
+					return err
+				}
+				s, ok := tok.(string)
+				if !ok {
+					return c.errorf(c.pos, "palette %d: \"name\" must be a string", i)
+				}
+				name = s
+			case "colors":
+				if colors, err = c.colors(i); err != nil {
+					return err
+				}
+			default:
+				kinds, ok := overrideKinds[key]
+				if !ok {
+					return c.errorf(c.pos, "palette %d: unknown field %q (expected %s)", i, key, knownFields)
+				}
+				if err := c.override(i, key, kinds); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := c.token(); err != nil { // closing brace
+			return err
+		}
+
+		if colors < 0 {
+			return c.errorf(start, "palette %d: missing \"colors\"", i)
+		}
+		if colors == 0 {
+			return c.errorf(start, "palette %d: \"colors\" is empty", i)
+		}
+		if first, ok := names[name]; ok {
+			line, col := c.position(first)
+			return c.errorf(start, "palette %d: duplicate name %q (first used at %d:%d)", i, name, line, col)
+		}
+		names[name] = start
+	}
+
+	if _, err := c.token(); err != nil { // closing bracket
+		return err
+	}
+	if _, err := c.dec.Token(); err != io.EOF {
+		return c.errorf(c.skipSpace(int(c.dec.InputOffset())), "unexpected data after the list of palettes")
+	}
+	return nil
+}
+
+// override reads the value of an override field, which must be one of
+// kinds, and checks it.
+func (c *paletteChecker) override(palette int, key string, kinds []string) error {
+	tok, err := c.token()
+	if err != nil {
+		return err
+	}
+	var kind, text string
+	switch v := tok.(type) {
+	case string:
+		kind, text = "string", v
+	case json.Number:
+		kind, text = "number", v.String()
+	case json.Delim:
+		if v == '[' {
+			kind = "list of numbers"
+			start := c.pos
+			var weights []string
+			for c.dec.More() {
+				tok, err := c.token()
+				if err != nil {
+					return err
+				}
+				n, ok := tok.(json.Number)
+				if !ok {
+					return c.errorf(c.pos, "palette %d: %q must be a list of numbers", palette, key)
+				}
+				weights = append(weights, n.String())
+			}
+			if _, err := c.token(); err != nil { // closing bracket
+				return err
+			}
+			text, c.pos = strings.Join(weights, ","), start
+		}
+	}
+	if !slices.Contains(kinds, kind) {
+		return c.errorf(c.pos, "palette %d: %q must be a %s", palette, key, strings.Join(kinds, " or "))
+	}
+	var p CamoColors
+	if err := p.setField(key, text); err != nil {
+		return c.errorf(c.pos, "palette %d: %v", palette, err)
+	}
+	return nil
+}
+
+// colors reads a colors array and returns the number of entries.
+func (c *paletteChecker) colors(palette int) (int, error) {
+	if err := c.expectDelim('[', "a list of colors"); err != nil {
+		return 0, err
+	}
+	n := 0
+	for c.dec.More() {
+		tok, err := c.token()
+		if err != nil {
+			return 0, err
+		}
+		s, ok := tok.(string)
+		if !ok {
+			return 0, c.errorf(c.pos, "palette %d: colors must be strings", palette)
+		}
+		if err := validateHexColor(s); err != nil {
+			return 0, c.errorf(c.pos, "palette %d: %v", palette, err)
+		}
+		n++
+	}
+	_, err := c.token()
+	return n, err
+}
+
+func (c *paletteChecker) expectDelim(d json.Delim, what string) error {
+	tok, err := c.token()
+	if err != nil {
+		return err
+	}
+	if tok != d {
+		return c.errorf(c.pos, "expected %s", what)
+	}
+	return nil
+}
+
+// token reads the next token and records where it starts.
+func (c *paletteChecker) token() (json.Token, error) {
+	c.pos = c.skipSpace(int(c.dec.InputOffset()))
+	tok, err := c.dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil, c.errorf(len(c.data), "unexpected end of file")
+		}
+		if se, ok := err.(*json.SyntaxError); ok {
+			return nil, c.errorf(int(se.Offset), "%v", err)
+		}
+		return nil, c.errorf(c.pos, "%v", err)
+	}
+	return tok, nil
+}
+
+// skipSpace returns the offset of the next token at or after off, skipping
+// whitespace and separators.
+func (c *paletteChecker) skipSpace(off int) int {
+	for off < len(c.data) {
+		switch c.data[off] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			off++
+		default:
+			return off
+		}
+	}
+	return off
+}
+
+func (c *paletteChecker) position(off int) (line, col int) {
+	line, col = 1, 1
+	for _, b := range c.data[:min(off, len(c.data))] {
+		if b == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+func (c *paletteChecker) errorf(off int, format string, args ...any) error {
+	line, col := c.position(off)
+	return &paletteError{line: line, col: col, msg: fmt.Sprintf(format, args...)}
+}
+
+// parsedPalette is a palette read from a YAML or TOML file, with the
+// positions strict mode errors point at.
+type parsedPalette struct {
+	CamoColors
+	line, col int
+	hasColors bool
+	// colorPos holds the line and column of each color
+	colorPos [][2]int
+}
+
+func (p *parsedPalette) addColor(c string, line, col int) {
+	p.Colors = append(p.Colors, c)
+	p.colorPos = append(p.colorPos, [2]int{line, col})
+}
+
+// checkParsed applies the strict mode checks that don't depend on the file
+// format: every palette has valid colors and a name of its own.
+func checkParsed(palettes []parsedPalette) error {
+	names := make(map[string]*parsedPalette)
+	for i := range palettes {
+		p := &palettes[i]
+		if !p.hasColors {
+			return &paletteError{p.line, p.col, fmt.Sprintf("palette %d: missing \"colors\"", i+1)}
+		}
+		if len(p.Colors) == 0 {
+			return &paletteError{p.line, p.col, fmt.Sprintf("palette %d: \"colors\" is empty", i+1)}
+		}
+		for j, c := range p.Colors {
+			if err := validateHexColor(c); err != nil {
+				return &paletteError{p.colorPos[j][0], p.colorPos[j][1], fmt.Sprintf("palette %d: %v", i+1, err)}
+			}
+		}
+		if first, ok := names[p.Name]; ok {
+			return &paletteError{p.line, p.col, fmt.Sprintf("palette %d: duplicate name %q (first used at %d:%d)", i+1, p.Name, first.line, first.col)}
+		}
+		names[p.Name] = p
+	}
+	return nil
+}
+
+// stripComment removes a # comment from a line, ignoring # inside quoted
+// strings. In YAML a comment must start the line or follow whitespace, so
+// a plain #5e8553 is still a comment but a#b is not.
+func stripComment(line string, yaml bool) string {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"', '\'':
+			_, rest, err := quotedString(line[i:], yaml)
+			if err != nil {
+				return line
+			}
+			i = len(line) - len(rest) - 1
+		case '#':
+			if !yaml || i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// quotedString reads the double or single quoted string at the start of s
+// and returns its value and the rest of s. Double quoted strings take Go
+// style escapes. In single quoted strings YAML escapes a quote by doubling
+// it, while TOML has no escapes at all.
+func quotedString(s string, yaml bool) (value, rest string, err error) {
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		return "", s, fmt.Errorf("expected a quoted string")
+	}
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case s[i] == q && q == '\'' && yaml && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			rest = strings.TrimSpace(s[i+1:])
+			if q == '\'' {
+				return strings.ReplaceAll(s[1:i], "''", "'"), rest, nil
+			}
+			value, err = strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", s, fmt.Errorf("invalid string %s", s[:i+1])
+			}
+			return value, rest, nil
+		}
+	}
+	return "", s, fmt.Errorf("unterminated string")
+}
+
+// splitList splits the items of a flow list on commas outside quotes. A
+// trailing comma is allowed.
+func splitList(s string) ([]string, error) {
+	var items []string
+	for s = strings.TrimSpace(s); s != ""; {
+		item := s
+		if s[0] == '"' || s[0] == '\'' {
+			_, rest, err := quotedString(s, true)
+			if err != nil {
+				return nil, err
+			}
+			item = s[:len(s)-len(rest)]
+		} else if i := strings.IndexByte(s, ','); i >= 0 {
+			item = s[:i]
+		}
+		rest := strings.TrimSpace(s[len(item):])
+		if rest != "" && rest[0] != ',' {
+			return nil, fmt.Errorf("expected a comma after %s", strings.TrimSpace(item))
+		}
+		items = append(items, strings.TrimSpace(item))
+		s = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+	return items, nil
+}
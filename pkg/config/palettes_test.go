@@ -0,0 +1,306 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadPaletteText reads palettes from data as a file with the extension
+// ext. Errors are returned as text without the file path.
+func loadPaletteText(t *testing.T, ext, data string, strict bool) ([]CamoColors, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "palettes"+ext)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	palettes, err := LoadPalettes(path, strict)
+	if err != nil {
+		return nil, strings.TrimPrefix(err.Error(), path+":")
+	}
+	return palettes, ""
+}
+
+func seed(v uint64) *Seed {
+	s := Seed(v)
+	return &s
+}
+
+func TestLoadPalettes(t *testing.T) {
+	tests := []struct {
+		name    string
+		ext     string
+		data    string
+		strict  bool
+		want    []CamoColors
+		wantErr string
+	}{
+		{
+			name: "yaml flow colors",
+			ext:  ".yaml",
+			data: "- name: woodland\n  colors: [\"#5e8553\", '#5c4f42', 333330]\n",
+			want: []CamoColors{{Name: "woodland", Colors: []string{"#5e8553", "#5c4f42", "333330"}}},
+		},
+		{
+			name: "yaml block colors with comments",
+			ext:  ".yml",
+			data: "---\n# palettes\n-\n  name: desert # tan\n  colors:\n  - \"#c2b280\"\n  - \"#8b7d6b\"\n",
+			want: []CamoColors{{Name: "desert", Colors: []string{"#c2b280", "#8b7d6b"}}},
+		},
+		{
+			name: "yaml flow colors over several lines",
+			ext:  ".yaml",
+			data: "- name: a\n  colors: [\"#112233\",\n    \"#445566\",]\n",
+			want: []CamoColors{{Name: "a", Colors: []string{"#112233", "#445566"}}},
+		},
+		{
+			name: "yaml overrides",
+			ext:  ".yaml",
+			data: "- name: a\n  colors: [\"#112233\", \"#445566\"]\n  pattern: hex\n  width: 300\n  ratios: [3, 1]\n  seed: 0\n",
+			want: []CamoColors{{Name: "a", Colors: []string{"#112233", "#445566"}, Pattern: "hex", Width: 300, Ratios: "3,1", Seed: seed(0)}},
+		},
+		{
+			name:    "yaml invalid seed",
+			ext:     ".yaml",
+			data:    "- name: a\n  colors: [\"#112233\", \"#445566\"]\n  seed: zz\n",
+			wantErr: `3:9: palette 1: invalid seed "zz" (use a number such as 0x4f2a9c1e7b3d5086)`,
+		},
+		{
+			name:    "yaml invalid ratios",
+			ext:     ".yaml",
+			data:    "- name: a\n  colors: [\"#112233\", \"#445566\"]\n  ratios: [3, -1]\n",
+			wantErr: `3:11: palette 1: invalid "ratios": invalid ratio "-1" (must be a non-negative number)`,
+		},
+		{
+			name:    "yaml tab indentation",
+			ext:     ".yaml",
+			data:    "- name: a\n\tcolors: []\n",
+			wantErr: "2:1: tabs can't be used for indentation",
+		},
+		{
+			name:    "yaml unterminated flow list",
+			ext:     ".yaml",
+			data:    "- name: a\n  colors: [\"#112233\"\n",
+			wantErr: "2:11: palette 1: unterminated list of colors",
+		},
+		{
+			name:    "yaml not a list",
+			ext:     ".yaml",
+			data:    "name: a\n",
+			wantErr: `1:1: expected a palette starting with "- "`,
+		},
+		{
+			name:    "yaml strict unknown field",
+			ext:     ".yaml",
+			data:    "- name: a\n  colors: [\"#112233\"]\n  colour: red\n",
+			strict:  true,
+			wantErr: `3:3: palette 1: unknown field "colour" (expected "name", "colors", "pattern", "width", "height", "ratios" or "seed")`,
+		},
+		{
+			name:    "yaml strict invalid color",
+			ext:     ".yaml",
+			data:    "- name: a\n  colors:\n    - \"#112233\"\n    - \"#12345\"\n",
+			strict:  true,
+			wantErr: "4:7: palette 1: invalid hex color length: 12345 (should be 6 or 8 characters, or 3 or 4 for short form)",
+		},
+		{
+			name:    "yaml strict duplicate name",
+			ext:     ".yaml",
+			data:    "- name: a\n  colors: [\"#112233\"]\n- name: a\n  colors: [\"#445566\"]\n",
+			strict:  true,
+			wantErr: `3:1: palette 2: duplicate name "a" (first used at 1:1)`,
+		},
+		{
+			name: "toml",
+			ext:  ".toml",
+			data: "# palettes\n[[palettes]]\nname = \"woodland\"\ncolors = [\n  \"#5e8553\", # green\n  '#5c4f42',\n]\nseed = \"0x10\"\n",
+			want: []CamoColors{{Name: "woodland", Colors: []string{"#5e8553", "#5c4f42"}, Seed: seed(0x10)}},
+		},
+		{
+			name: "toml other tables skipped",
+			ext:  ".toml",
+			data: "[meta]\nauthor = \"x\"\n[[palettes]]\nname = \"a\"\ncolors = [\"#112233\"]\nheight = 200\n",
+			want: []CamoColors{{Name: "a", Colors: []string{"#112233"}, Height: 200}},
+		},
+		{
+			name:    "toml duplicate key",
+			ext:     ".toml",
+			data:    "[[palettes]]\nname = \"a\"\n  name = \"b\"\n",
+			wantErr: `3:3: palette 1: duplicate field "name"`,
+		},
+		{
+			name:    "toml colors not strings",
+			ext:     ".toml",
+			data:    "[[palettes]]\nname = \"a\"\ncolors = [112233]\n",
+			wantErr: "3:10: palette 1: colors must be strings",
+		},
+		{
+			name:    "toml unterminated array",
+			ext:     ".toml",
+			data:    "[[palettes]]\ncolors = [\"#112233\",\n",
+			wantErr: "2:10: unterminated array",
+		},
+		{
+			name:    "toml invalid width",
+			ext:     ".toml",
+			data:    "[[palettes]]\nname = \"a\"\nwidth = -3\n",
+			wantErr: `3:9: palette 1: "width" must be a positive whole number`,
+		},
+		{
+			name:    "toml strict unknown table",
+			ext:     ".toml",
+			data:    "[meta]\n",
+			strict:  true,
+			wantErr: `1:1: unknown table "meta" (expected [[palettes]])`,
+		},
+		{
+			name:    "toml strict missing colors",
+			ext:     ".toml",
+			data:    "[[palettes]]\nname = \"a\"\n",
+			strict:  true,
+			wantErr: `1:1: palette 1: missing "colors"`,
+		},
+		{
+			name: "json ratios list and string seed",
+			ext:  ".json",
+			data: `[{"name": "a", "colors": ["#112233", "#445566"], "ratios": [2.5, 1], "seed": "0"}]`,
+			want: []CamoColors{{Name: "a", Colors: []string{"#112233", "#445566"}, Ratios: "2.5,1", Seed: seed(0)}},
+		},
+		{
+			name:    "json strict wrong type",
+			ext:     ".json",
+			data:    "[\n  {\"name\": \"a\", \"colors\": [\"#112233\"], \"width\": \"wide\"}\n]",
+			strict:  true,
+			wantErr: `2:49: palette 1: "width" must be a number`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadPaletteText(t, tt.ext, tt.data, tt.strict)
+			if err != tt.wantErr {
+				t.Fatalf("got error %q, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	p, err := parseProfile([]byte("t: all\np: [multicam, 'flecktarn']\nr:\n  - 5\n  - 3\nblob:\n  ca-passes: 6\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFlags := []profileEntry{
+		{"t", "all", 1, 1, 4},
+		{"p", "multicam,flecktarn", 2, 1, 4},
+		{"r", "5,3", 3, 1, 3},
+	}
+	if !reflect.DeepEqual(p.flags, wantFlags) {
+		t.Errorf("got flags %+v, want %+v", p.flags, wantFlags)
+	}
+	wantBlob := []profileEntry{{"ca-passes", "6", 7, 3, 14}}
+	if !reflect.DeepEqual(p.patterns["blob"], wantBlob) {
+		t.Errorf("got blob settings %+v, want %+v", p.patterns["blob"], wantBlob)
+	}
+
+	bad := []struct {
+		data string
+		want string
+	}{
+		{"t: box\nt: hex\n", `2:1: duplicate key "t"`},
+		{"t: box\n  w: 10\n", "2:3: unexpected indentation"},
+		{"just text\n", `1:1: expected a "key: value" line`},
+		{"trees:\n  ca-passes: 2\n", `1:1: "trees" is not a pattern type (settings can be given for box, blob, pat6, hex, composite)`},
+		{"box:\n  ca-passes: 2\n  ca-passes: 3\n", `3:3: box: duplicate key "ca-passes"`},
+		{"p: [a, b\n", "1:4: unterminated list"},
+		{"p: [\"a\" \"b\"]\n", `1:4: expected a comma after "a"`},
+		{"c: \"#112233\n", "1:4: unterminated string"},
+	}
+	for _, tt := range bad {
+		_, err := parseProfile([]byte(tt.data))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("parseProfile(%q) = %v, want %s", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"a, b ,c", []string{"a", "b", "c"}, false},
+		{"a, b,", []string{"a", "b"}, false},
+		{`"a,b", 'c'`, []string{`"a,b"`, `'c'`}, false},
+		{`"a" b`, nil, true},
+		{`"a`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitList(tt.in)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitList(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseLayerRatios(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    LayerRatios
+		wantErr string
+	}{
+		{in: ""},
+		{
+			in:   "5,3,1,1",
+			want: LayerRatios{Macro: ColorRatios{Weights: []float64{5, 3, 1, 1}}, Medium: ColorRatios{Weights: []float64{5, 3, 1, 1}}, Detail: ColorRatios{Weights: []float64{5, 3, 1, 1}}},
+		},
+		{
+			in:   "MARPAT; detail=0,1",
+			want: LayerRatios{Macro: ColorRatios{Scheme: "marpat"}, Medium: ColorRatios{Scheme: "marpat"}, Detail: ColorRatios{Weights: []float64{0, 1}}},
+		},
+		{in: "0,0", wantErr: "at least one ratio must be greater than zero"},
+		{in: "1,,2", wantErr: `invalid ratio "" (must be a non-negative number)`},
+		{in: "macro=1;macro=2", wantErr: `ratios for "macro" given more than once`},
+		{in: "1;2", wantErr: `ratios for "all layers" given more than once`},
+		{in: "fine=1", wantErr: `unknown layer "fine" (must be 'macro', 'medium', or 'detail')`},
+	}
+	for _, tt := range tests {
+		got, err := ParseLayerRatios(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ParseLayerRatios(%q) error = %v, want %s", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLayerRatios(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestForPaletteSeed(t *testing.T) {
+	cfg := &Config{Seed: 7}
+	tests := []struct {
+		seed *Seed
+		want uint64
+	}{
+		{nil, 7},
+		{seed(0), 0},
+		{seed(0x1234), 0x1234},
+	}
+	for _, tt := range tests {
+		pc, err := cfg.ForPalette(CamoColors{Colors: []string{"#112233"}, Seed: tt.seed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pc.Seed != tt.want {
+			t.Errorf("seed %v: got %#x, want %#x", tt.seed, pc.Seed, tt.want)
+		}
+	}
+}
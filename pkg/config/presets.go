@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// DefaultShapeSize is the largest box macro shape, in cells, when no size
+// is set.
+const DefaultShapeSize = 8
+
+// arcMinute is the angle a typical eye can just resolve, in radians.
+const arcMinute = math.Pi / (180 * 60)
+
+// UsePreset describes the distances a pattern is meant to work at. The
+// base pixel is sized to the limit of visual acuity at Near, so the
+// smallest elements blend into texture beyond it, and macro shapes are
+// sized to stay resolvable at Far (two arc minutes), where they break up
+// the outline.
+type UsePreset struct {
+	Name        string
+	Description string
+	Near, Far   float64 // meters
+}
+
+// UsePresets are the viewing-distance presets selected with -use.
+var UsePresets = map[string]UsePreset{
+	"personal": {Name: "personal", Description: "clothing and personal equipment", Near: 10, Far: 50},
+	"vehicle":  {Name: "vehicle", Description: "vehicles and large equipment", Near: 50, Far: 300},
+	"building": {Name: "building", Description: "buildings and fixed structures", Near: 200, Far: 1000},
+}
+
+// ElementSizes returns the physical size of the base pixel and the largest
+// macro shape in millimeters.
+func (p UsePreset) ElementSizes() (pixel, shape float64) {
+	pixel = p.Near * 1000 * math.Tan(arcMinute)
+	shape = p.Far * 1000 * math.Tan(2*arcMinute)
+	return pixel, shape
+}
+
+// Sizes returns the base pixel size in pixels at the given print
+// resolution and the largest macro shape in cells.
+func (p UsePreset) Sizes(dpi int) (basePixelSize, shapeSize int) {
+	pixel, shape := p.ElementSizes()
+	basePixelSize = max(int(math.Round(pixel/25.4*float64(dpi))), 1)
+	shapeSize = max(int(math.Round(shape/pixel)), 2)
+	return basePixelSize, shapeSize
+}
+
+// ScalePreset sizes every level of a pattern together: the base pixel, box
+// shapes, and the structures built from cells, which grow to Structure
+// times their usual size: box and blob clusters, hex cells and pat6
+// strokes. Changing -b alone scales the whole pattern, so large structures
+// need a larger grain too.
+type ScalePreset struct {
+	Name          string
+	Description   string
+	BasePixelSize int
+	ShapeSize     int
+	Structure     int
+}
+
+// ScalePresets are the pattern scale presets selected with -scale.
+var ScalePresets = map[string]ScalePreset{
+	"micro":   {Name: "micro", Description: "fine detail for small items seen up close", BasePixelSize: 2, ShapeSize: 6, Structure: 1},
+	"uniform": {Name: "uniform", Description: "uniforms and personal equipment, the default sizes", BasePixelSize: 4, ShapeSize: DefaultShapeSize, Structure: 1},
+	"vehicle": {Name: "vehicle", Description: "vehicles, with larger clusters and shapes", BasePixelSize: 6, ShapeSize: 12, Structure: 2},
+	"large":   {Name: "large", Description: "buildings, nets and large surfaces, with the largest clusters", BasePixelSize: 8, ShapeSize: 16, Structure: 3},
+}
+
+// StructureScale returns how many times their usual size the structures of
+// the pattern are built at. A Structure of zero selects the usual size.
+func (c *Config) StructureScale() int {
+	return max(c.Structure, 1)
+}
+
+// presetNames lists the names of presets for error messages.
+func presetNames[P any](presets map[string]P) string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyUsePreset sets the base pixel and shape sizes from the named
+// preset, keeping either if it was set explicitly.
+func applyUsePreset(cfg *Config) error {
+	p, ok := UsePresets[strings.ToLower(cfg.Use)]
+	if !ok {
+		return fmt.Errorf("unknown -use preset %q (must be one of %s)", cfg.Use, presetNames(UsePresets))
+	}
+	cfg.Use = p.Name
+	base, shape := p.Sizes(cfg.DPI)
+	if !isFlagPassed("b") {
+		cfg.BasePixelSize = base
+	}
+	if !isFlagPassed("shape-size") {
+		cfg.ShapeSize = shape
+	}
+	return nil
+}
+
+// applyScalePreset sets the base pixel, shape and structure sizes from the
+// named preset, keeping the base pixel and shape sizes if they were set
+// explicitly.
+func applyScalePreset(cfg *Config) error {
+	p, ok := ScalePresets[strings.ToLower(cfg.Scale)]
+	if !ok {
+		return fmt.Errorf("unknown -scale preset %q (must be one of %s)", cfg.Scale, presetNames(ScalePresets))
+	}
+	cfg.Scale = p.Name
+	if !isFlagPassed("b") {
+		cfg.BasePixelSize = p.BasePixelSize
+	}
+	if !isFlagPassed("shape-size") {
+		cfg.ShapeSize = p.ShapeSize
+	}
+	cfg.Structure = p.Structure
+	return nil
+}
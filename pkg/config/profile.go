@@ -0,0 +1,277 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ProfilePatterns are the pattern types a -profile file can give settings
+// of their own.
+var ProfilePatterns = []string{"box", "blob", "pat6", "hex", "composite"}
+
+// PatternSettingNames are the settings a -profile file can give for one
+// pattern type, named after their flags.
+var PatternSettingNames = []string{"ca-passes", "ca-radius", "ca-chance", "shape-size", "flow-angle", "noise-level", "edge-strength"}
+
+// PatternSetting is a setting a -profile file gives for one pattern type,
+// such as "ca-passes" and "4".
+type PatternSetting struct {
+	Name  string
+	Value string
+}
+
+// profileEntry is a key: value line of a -profile file, with the positions
+// of the key and value for errors.
+type profileEntry struct {
+	key, value        string
+	line, keyCol, col int
+}
+
+// profile is a parsed -profile file: flag values, and the settings of each
+// pattern type in its sections.
+type profile struct {
+	flags    []profileEntry
+	patterns map[string][]profileEntry
+}
+
+// parseProfile reads a profile from the subset of YAML it needs: a mapping
+// of flag names to scalars or lists, which are joined with commas, and
+// mappings of settings under pattern type keys. For example:
+//
+//	t: all
+//	p: [multicam, flecktarn]
+//	noise-level: 8
+//	blob:
+//	  ca-passes: 6
+//	  ca-radius: 2
+func parseProfile(data []byte) (*profile, error) {
+	lines, err := yamlLines(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &profile{patterns: make(map[string][]profileEntry)}
+	if len(lines) == 0 {
+		return p, nil
+	}
+
+	top := lines[0].indent
+	seen := make(map[string]bool)
+	for i := 0; i < len(lines); {
+		l := lines[i]
+		if l.indent != top {
+			return nil, yamlError(l, 0, "unexpected indentation")
+		}
+		key, value, valueCol, err := yamlField(l)
+		if err != nil {
+			return nil, err
+		}
+		if seen[key] {
+			return nil, yamlError(l, 0, "duplicate key %q", key)
+		}
+		seen[key] = true
+		i++
+
+		// A section of pattern settings, a block list or a scalar
+		switch {
+		case value == "" && i < len(lines) && lines[i].indent > l.indent && !isYAMLItem(lines[i].text):
+			if !slices.Contains(ProfilePatterns, key) {
+				return nil, yamlError(l, 0, "%q is not a pattern type (settings can be given for %s)", key, strings.Join(ProfilePatterns, ", "))
+			}
+			indent := lines[i].indent
+			settings := make(map[string]bool)
+			for ; i < len(lines) && lines[i].indent > l.indent; i++ {
+				s := lines[i]
+				if s.indent != indent {
+					return nil, yamlError(s, 0, "unexpected indentation")
+				}
+				name, value, col, err := yamlField(s)
+				if err == nil {
+					value, err = yamlScalar(value)
+				}
+				if err != nil {
+					return nil, err
+				}
+				if settings[name] {
+					return nil, yamlError(s, 0, "%s: duplicate key %q", key, name)
+				}
+				settings[name] = true
+				p.patterns[key] = append(p.patterns[key], profileEntry{name, value, s.num, s.indent + 1, s.indent + col + 1})
+			}
+		case value == "":
+			var items []string
+			for ; i < len(lines) && lines[i].indent >= l.indent && isYAMLItem(lines[i].text); i++ {
+				item, err := yamlScalar(strings.TrimLeft(lines[i].text[1:], " "))
+				if err != nil {
+					return nil, yamlError(lines[i], 0, "%v", err)
+				}
+				items = append(items, item)
+			}
+			p.flags = append(p.flags, profileEntry{key, strings.Join(items, ","), l.num, l.indent + 1, l.indent + valueCol + 1})
+		case strings.HasPrefix(value, "["):
+			for !strings.HasSuffix(value, "]") {
+				if i == len(lines) || lines[i].indent <= l.indent {
+					return nil, yamlError(l, valueCol, "unterminated list")
+				}
+				value += " " + lines[i].text
+				i++
+			}
+			items, err := splitList(value[1 : len(value)-1])
+			if err != nil {
+				return nil, yamlError(l, valueCol, "%v", err)
+			}
+			for j, item := range items {
+				if items[j], err = yamlScalar(item); err != nil {
+					return nil, yamlError(l, valueCol, "%v", err)
+				}
+			}
+			p.flags = append(p.flags, profileEntry{key, strings.Join(items, ","), l.num, l.indent + 1, l.indent + valueCol + 1})
+		default:
+			if value, err = yamlScalar(value); err != nil {
+				return nil, yamlError(l, valueCol, "%v", err)
+			}
+			p.flags = append(p.flags, profileEntry{key, value, l.num, l.indent + 1, l.indent + valueCol + 1})
+		}
+	}
+	return p, nil
+}
+
+// yamlField splits the key: value line l. valueCol is the offset of the
+// value in the text of the line.
+func yamlField(l yamlLine) (key, value string, valueCol int, err error) {
+	colon := strings.Index(l.text+" ", ": ")
+	if colon < 0 || isYAMLItem(l.text) {
+		return "", "", 0, yamlError(l, 0, "expected a \"key: value\" line")
+	}
+	if key, err = yamlScalar(strings.TrimSpace(l.text[:colon])); err != nil {
+		return "", "", 0, yamlError(l, 0, "%v", err)
+	}
+	value = strings.TrimSpace(l.text[min(colon+1, len(l.text)):])
+	return key, value, len(l.text) - len(value), nil
+}
+
+// yamlError returns an error at offset off of the text of line l.
+func yamlError(l yamlLine, off int, format string, args ...any) error {
+	return &paletteError{line: l.num, col: l.indent + off + 1, msg: fmt.Sprintf(format, args...)}
+}
+
+// loadProfile sets the flags the profile at path gives, other than those
+// set on the command line, and keeps its pattern settings for ForPattern.
+// It runs before the flag values are checked, so they are checked the same
+// way wherever they came from.
+func loadProfile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	p, err := parseProfile(data)
+	if err != nil {
+		return fmt.Errorf("%s:%w", path, err)
+	}
+
+	passed := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+
+	for _, e := range p.flags {
+		switch {
+		case e.key == "profile":
+			return fmt.Errorf("%s:%d:%d: a profile can't load another profile", path, e.line, e.keyCol)
+		case flag.Lookup(e.key) == nil:
+			return fmt.Errorf("%s:%d:%d: unknown flag %q", path, e.line, e.keyCol, e.key)
+		case passed[e.key]:
+			continue
+		}
+		if err := flag.Set(e.key, e.value); err != nil {
+			return fmt.Errorf("%s:%d:%d: invalid %s value %q: %v", path, e.line, e.col, e.key, e.value, err)
+		}
+	}
+
+	for _, patternType := range ProfilePatterns {
+		for _, e := range p.patterns[patternType] {
+			// Check the setting on its own so errors point at the file
+			var scratch Config
+			if err := scratch.setPatternSetting(e.key, e.value); err != nil {
+				col := e.col
+				if !slices.Contains(PatternSettingNames, e.key) {
+					col = e.keyCol
+				}
+				return fmt.Errorf("%s:%d:%d: %s: %v", path, e.line, col, patternType, err)
+			}
+			if passed[e.key] {
+				continue
+			}
+			if cfg.PatternSettings == nil {
+				cfg.PatternSettings = make(map[string][]PatternSetting)
+			}
+			cfg.PatternSettings[patternType] = append(cfg.PatternSettings[patternType], PatternSetting{e.key, e.value})
+		}
+	}
+	return nil
+}
+
+// setPatternSetting sets the pattern setting name from its text in a
+// -profile file.
+func (c *Config) setPatternSetting(name, value string) error {
+	integer := func(lo, hi int) (int, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("invalid %s value %q (must be %d-%d)", name, value, lo, hi)
+		}
+		return n, nil
+	}
+	var err error
+	switch name {
+	case "ca-passes":
+		c.CAPasses, err = integer(1, 20)
+	case "ca-radius":
+		c.CARadius, err = integer(1, 10)
+	case "ca-chance":
+		chance, perr := strconv.ParseFloat(value, 64)
+		if perr != nil || !(chance > 0 && chance <= 1) {
+			return fmt.Errorf("invalid %s value %q (must be more than 0 and at most 1)", name, value)
+		}
+		c.CAChance = chance
+	case "shape-size":
+		n, perr := strconv.Atoi(value)
+		if perr != nil || n < 2 {
+			return fmt.Errorf("invalid %s value %q (must be at least 2)", name, value)
+		}
+		c.ShapeSize = n
+	case "flow-angle":
+		angle, perr := strconv.ParseFloat(value, 64)
+		if perr != nil || math.IsNaN(angle) || math.IsInf(angle, 0) {
+			return fmt.Errorf("invalid %s value %q", name, value)
+		}
+		c.FlowAngle = angle
+	case "noise-level":
+		c.NoiseLevel, err = integer(0, 100)
+		c.AddNoise = c.NoiseLevel > 0
+	case "edge-strength":
+		c.EdgeStrength, err = integer(0, 100)
+		c.AddEdge = c.EdgeStrength > 0
+	default:
+		return fmt.Errorf("unknown setting %q (expected %s)", name, strings.Join(PatternSettingNames, ", "))
+	}
+	return err
+}
+
+// ForPattern returns the config with the settings the -profile file gives
+// for its pattern type in place of the general ones. It returns c itself if
+// the profile gives none. The layers of a composite pattern take the
+// composite settings.
+func (c *Config) ForPattern() *Config {
+	settings := c.PatternSettings[c.PatternType]
+	if len(settings) == 0 {
+		return c
+	}
+	pc := *c
+	for _, s := range settings {
+		// Checked by loadProfile
+		pc.setPatternSetting(s.Name, s.Value)
+	}
+	return &pc
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseTOMLPalettes reads palettes from a TOML file with one [[palettes]]
+// table per palette holding name and colors keys:
+//
+//	[[palettes]]
+//	name = "woodland"
+//	colors = ["#5e8553", "#5c4f42", "#333330"]
+//
+// The optional fields of CamoColors such as width = 3000 may be given too.
+// Only strings, whole numbers and arrays of strings are understood. Other
+// tables and keys are skipped unless strict is set.
+func parseTOMLPalettes(data []byte, strict bool) ([]parsedPalette, error) {
+	var palettes []parsedPalette
+	var pal *parsedPalette
+	var seen map[string]bool
+	inOther := false
+
+	lines := strings.Split(string(data), "\n")
+	for n := 0; n < len(lines); n++ {
+		num := n + 1
+		line := strings.TrimSpace(stripComment(strings.TrimRight(lines[n], "\r"), false))
+		if line == "" {
+			continue
+		}
+		indent := strings.Index(lines[n], line[:1]) + 1
+		errorf := func(off int, format string, args ...any) error {
+			return &paletteError{line: num, col: indent + off, msg: fmt.Sprintf(format, args...)}
+		}
+
+		if strings.HasPrefix(line, "[") {
+			header := strings.TrimSpace(strings.Trim(line, "[]"))
+			if strings.HasPrefix(line, "[[") && header == "palettes" {
+				palettes = append(palettes, parsedPalette{line: num, col: indent})
+				pal = &palettes[len(palettes)-1]
+				seen = make(map[string]bool)
+				inOther = false
+				continue
+			}
+			if strict {
+				return nil, errorf(0, "unknown table %q (expected [[palettes]])", header)
+			}
+			pal, inOther = nil, true
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, errorf(0, "expected a key = value pair")
+		}
+		key := strings.TrimSpace(line[:eq])
+		if len(key) > 1 && (key[0] == '"' || key[0] == '\'') {
+			k, rest, err := quotedString(key, false)
+			if err != nil || rest != "" {
+				return nil, errorf(0, "invalid key %s", key)
+			}
+			key = k
+		}
+		value := strings.TrimSpace(line[eq+1:])
+		off := len(line) - len(value)
+
+		// Arrays may continue over the following lines
+		if strings.HasPrefix(value, "[") {
+			for !balanced(value) {
+				if n+1 == len(lines) {
+					return nil, errorf(off, "unterminated array")
+				}
+				n++
+				value += " " + strings.TrimSpace(stripComment(strings.TrimRight(lines[n], "\r"), false))
+			}
+		}
+
+		if pal == nil {
+			if strict {
+				if inOther {
+					return nil, errorf(0, "key %q outside a [[palettes]] table", key)
+				}
+				return nil, errorf(0, "unknown key %q (palettes go in [[palettes]] tables)", key)
+			}
+			continue
+		}
+		index := len(palettes)
+		if seen[key] {
+			// Repeated keys are invalid TOML, strict or not
+			return nil, errorf(0, "palette %d: duplicate field %q", index, key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "name":
+			s, rest, err := quotedString(value, false)
+			if err != nil || rest != "" {
+				return nil, errorf(off, "palette %d: \"name\" must be a string", index)
+			}
+			pal.Name = s
+		case "colors":
+			pal.hasColors = true
+			if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+				return nil, errorf(off, "palette %d: \"colors\" must be an array", index)
+			}
+			items, err := splitList(value[1 : len(value)-1])
+			if err != nil {
+				return nil, errorf(off, "palette %d: %v", index, err)
+			}
+			for _, item := range items {
+				c, rest, err := quotedString(item, false)
+				if err != nil || rest != "" {
+					return nil, errorf(off, "palette %d: colors must be strings", index)
+				}
+				pal.addColor(c, num, indent+off)
+			}
+		default:
+			if _, ok := overrideKinds[key]; ok {
+				// Strings are quoted, numbers bare
+				v := value
+				if strings.HasPrefix(v, "\"") || strings.HasPrefix(v, "'") {
+					s, rest, err := quotedString(value, false)
+					if err != nil || rest != "" {
+						return nil, errorf(off, "palette %d: invalid string %s", index, value)
+					}
+					v = s
+				}
+				if err := pal.setField(key, v); err != nil {
+					return nil, errorf(off, "palette %d: %v", index, err)
+				}
+				continue
+			}
+			if strict {
+				return nil, errorf(0, "palette %d: unknown field %q (expected %s)", index, key, knownFields)
+			}
+		}
+	}
+	return palettes, nil
+}
+
+// balanced reports whether every bracket opened in s outside strings is
+// closed.
+func balanced(s string) bool {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			_, rest, err := quotedString(s[i:], false)
+			if err != nil {
+				return false
+			}
+			i = len(s) - len(rest) - 1
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+	}
+	return depth <= 0
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// yamlLine is a non-blank line of a YAML file with its comment removed.
+type yamlLine struct {
+	num, indent int
+	text        string
+}
+
+// yamlParser reads palettes from the subset of YAML that palette files
+// need: a block sequence of mappings with name and colors keys, the colors
+// given as a block or flow sequence of scalars, and the optional scalar
+// fields of CamoColors. Comments and a leading
+// document marker are allowed.
+type yamlParser struct {
+	lines  []yamlLine
+	i      int
+	strict bool
+}
+
+func parseYAMLPalettes(data []byte, strict bool) ([]parsedPalette, error) {
+	lines, err := yamlLines(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &yamlParser{lines: lines, strict: strict}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+
+	var palettes []parsedPalette
+	top := p.lines[0].indent
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent != top || !isYAMLItem(l.text) {
+			return nil, p.errorf(l, 0, "expected a palette starting with \"- \"")
+		}
+		index := len(palettes) + 1
+		pal := parsedPalette{line: l.num, col: l.indent + 1}
+		seen := make(map[string]bool)
+
+		// The first field may follow the dash or start the next line
+		rest := strings.TrimLeft(l.text[1:], " ")
+		field := yamlLine{num: l.num, indent: l.indent + len(l.text) - len(rest), text: rest}
+		p.i++
+		if rest == "" {
+			if p.i == len(p.lines) || p.lines[p.i].indent <= l.indent {
+				return nil, p.errorf(l, 0, "palette %d: expected \"name\" and \"colors\" fields", index)
+			}
+			field = p.lines[p.i]
+			p.i++
+		}
+		for {
+			if err := p.field(&pal, field, seen, index); err != nil {
+				return nil, err
+			}
+			if p.i == len(p.lines) || p.lines[p.i].indent != field.indent {
+				break
+			}
+			field = p.lines[p.i]
+			p.i++
+		}
+		if p.i < len(p.lines) && p.lines[p.i].indent != top {
+			return nil, p.errorf(p.lines[p.i], 0, "unexpected indentation")
+		}
+		palettes = append(palettes, pal)
+	}
+	return palettes, nil
+}
+
+// yamlLines splits a YAML file into its non-blank lines, without comments
+// and a leading document marker.
+func yamlLines(data []byte) ([]yamlLine, error) {
+	var lines []yamlLine
+	for n, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(stripComment(strings.TrimRight(line, "\r"), true), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || (len(lines) == 0 && trimmed == "---") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, &paletteError{n + 1, len(text) - len(trimmed) + 1, "tabs can't be used for indentation"}
+		}
+		lines = append(lines, yamlLine{num: n + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	return lines, nil
+}
+
+// field reads the key: value line l of a palette and any lines of its
+// value that follow.
+func (p *yamlParser) field(pal *parsedPalette, l yamlLine, seen map[string]bool, index int) error {
+	colon := strings.Index(l.text+" ", ": ")
+	if colon < 0 || isYAMLItem(l.text) {
+		return p.errorf(l, 0, "palette %d: expected a \"key: value\" field", index)
+	}
+	key, err := yamlScalar(strings.TrimSpace(l.text[:colon]))
+	if err != nil {
+		return p.errorf(l, 0, "palette %d: %v", index, err)
+	}
+	value := strings.TrimSpace(l.text[min(colon+1, len(l.text)):])
+	valueCol := len(l.text) - len(value)
+	if p.strict && seen[key] {
+		return p.errorf(l, 0, "palette %d: duplicate field %q", index, key)
+	}
+	seen[key] = true
+
+	switch key {
+	case "name":
+		if pal.Name, err = yamlScalar(value); err != nil {
+			return p.errorf(l, valueCol, "palette %d: %v", index, err)
+		}
+	case "colors":
+		pal.hasColors = true
+		switch {
+		case strings.HasPrefix(value, "["):
+			return p.flowColors(pal, l, value, valueCol, index)
+		case value == "":
+			return p.blockColors(pal, l, index)
+		}
+		return p.errorf(l, valueCol, "palette %d: \"colors\" must be a list", index)
+	default:
+		if _, ok := overrideKinds[key]; ok {
+			v, err := yamlScalar(value)
+			if err == nil {
+				err = pal.setField(key, v)
+			}
+			if err != nil {
+				return p.errorf(l, valueCol, "palette %d: %v", index, err)
+			}
+			return nil
+		}
+		if p.strict {
+			return p.errorf(l, 0, "palette %d: unknown field %q (expected %s)", index, key, knownFields)
+		}
+		// Skip the value of an unknown field
+		for p.i < len(p.lines) && (p.lines[p.i].indent > l.indent || isYAMLItem(p.lines[p.i].text) && p.lines[p.i].indent == l.indent) {
+			p.i++
+		}
+	}
+	return nil
+}
+
+// flowColors reads a [a, b, c] list, which may run over several lines.
+func (p *yamlParser) flowColors(pal *parsedPalette, l yamlLine, value string, off, index int) error {
+	for !strings.HasSuffix(value, "]") {
+		if p.i == len(p.lines) || p.lines[p.i].indent <= l.indent {
+			return p.errorf(l, off, "palette %d: unterminated list of colors", index)
+		}
+		value += " " + p.lines[p.i].text
+		p.i++
+	}
+	items, err := splitList(value[1 : len(value)-1])
+	if err != nil {
+		return p.errorf(l, off, "palette %d: %v", index, err)
+	}
+	for _, item := range items {
+		c, err := yamlScalar(item)
+		if err != nil {
+			return p.errorf(l, off, "palette %d: %v", index, err)
+		}
+		pal.addColor(c, l.num, l.indent+off+1)
+	}
+	return nil
+}
+
+// blockColors reads the "- color" lines following a colors key. They may
+// be indented further than the key or line up with it.
+func (p *yamlParser) blockColors(pal *parsedPalette, l yamlLine, index int) error {
+	for p.i < len(p.lines) && p.lines[p.i].indent >= l.indent && isYAMLItem(p.lines[p.i].text) {
+		item := p.lines[p.i]
+		value := strings.TrimLeft(item.text[1:], " ")
+		if value == "" {
+			return p.errorf(item, 0, "palette %d: empty color (quote colors that start with #)", index)
+		}
+		c, err := yamlScalar(value)
+		if err != nil {
+			return p.errorf(item, 0, "palette %d: %v", index, err)
+		}
+		pal.addColor(c, item.num, item.indent+len(item.text)-len(value)+1)
+		p.i++
+	}
+	return nil
+}
+
+// errorf returns an error at offset off of the text of line l.
+func (p *yamlParser) errorf(l yamlLine, off int, format string, args ...any) error {
+	return &paletteError{line: l.num, col: l.indent + off + 1, msg: fmt.Sprintf(format, args...)}
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// yamlScalar returns the value of a plain, single quoted or double quoted
+// scalar.
+func yamlScalar(s string) (string, error) {
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		return s, nil
+	}
+	v, rest, err := quotedString(s, true)
+	if err != nil {
+		return "", err
+	}
+	if rest != "" {
+		return "", fmt.Errorf("unexpected %q after quoted value", rest)
+	}
+	return v, nil
+}
@@ -0,0 +1,187 @@
+// Package gocamo generates digital camouflage patterns for use from other Go
+// programs, without running the command line tool.
+//
+//	img, err := gocamo.Generate(ctx, gocamo.Options{
+//		Colors: []string{"#46482f", "#6d6851", "#9b967f", "#1e2415"},
+//		Noise:  true,
+//		Seed:   42,
+//	})
+//
+// Patterns are fully determined by their options: the same options and seed
+// always give the same image, and the same image as the command line tool
+// makes for the first palette of a run with that -seed.
+package gocamo
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/bradsec/gocamo/internal/generator"
+	"github.com/bradsec/gocamo/pkg/config"
+)
+
+// Pattern is a pattern type.
+type Pattern string
+
+const (
+	// Box patterns are pixelated with square and rectangular shapes.
+	Box Pattern = "box"
+	// Blob patterns have rounder, organic clusters.
+	Blob Pattern = "blob"
+	// TigerStripe patterns have horizontal brush strokes with jagged,
+	// interlocking edges.
+	TigerStripe Pattern = "pat6"
+	// Hex patterns are clusters of hexagonal cells.
+	Hex Pattern = "hex"
+	// Composite patterns layer two of the other pattern types, set with
+	// Options.Layers.
+	Composite Pattern = "composite"
+)
+
+// Patterns are the pattern types Generate renders.
+var Patterns = []Pattern{Box, Blob, TigerStripe, Hex, Composite}
+
+// Default option values, used for fields left at zero.
+const (
+	DefaultWidth     = 1500
+	DefaultHeight    = 1500
+	DefaultPixelSize = 4
+	DefaultColors    = 4
+)
+
+// Options describe a pattern. The zero value of each field except Colors
+// selects the default.
+type Options struct {
+	// Pattern is the pattern type, Box by default.
+	Pattern Pattern
+	// Colors is the palette as hex codes such as "#46482f" or "46482f",
+	// with an optional alpha such as "#46482f80". "none" makes a fully
+	// transparent color. At least 2 are required.
+	Colors []string
+	// Width and Height are the image size in pixels.
+	Width, Height int
+	// PixelSize is the base pixel size, adjusted if needed so that cells
+	// tile the image.
+	PixelSize int
+	// ShapeSize is the largest box shape in cells.
+	ShapeSize int
+	// FlowAngle turns pat6 tiger stripes this many degrees counterclockwise
+	// from horizontal.
+	FlowAngle float64
+	// Layers are the bottom and top pattern types of a Composite pattern,
+	// Blob under Box by default.
+	Layers [2]Pattern
+	// LayerOpacity is the percentage of a Composite pattern the top layer
+	// covers, 50 by default. Use a negative value to hide the top layer.
+	LayerOpacity int
+	// Ratios are the relative color proportions, with the syntax of the -r
+	// flag: "5,3,1,1", "marpat" or "macro=5,3,1,1;detail=1,1,3,3".
+	Ratios string
+	// Noise and Edge add fine noise and edge details.
+	Noise, Edge bool
+	// NoiseLevel is the percentage of pixels Noise changes, 5 by default.
+	NoiseLevel int
+	// EdgeStrength is the largest brightness change of Edge details, in
+	// steps of about 1/43 stop, 20 by default.
+	EdgeStrength int
+	// Distort warps the cell grid by up to this many cells with smooth
+	// noise.
+	Distort int
+	// Blend feathers color boundaries into gradients over this radius in
+	// pixels.
+	Blend int
+	// LegacyBlend blends noise, edge details and Blend gradients on gamma
+	// encoded values like older versions, rather than in linear light.
+	LegacyBlend bool
+	// QR works a scannable QR code of this text into the pattern.
+	QR string
+	// Seed selects the random pattern.
+	Seed uint64
+	// MainColors is the number of main colors FromImage finds in the
+	// reference image.
+	MainColors int
+}
+
+// Generate renders a pattern.
+func Generate(ctx context.Context, opts Options) (image.Image, error) {
+	cfg, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+	return generator.Render(ctx, cfg, config.CamoColors{Colors: opts.Colors}, 0)
+}
+
+// FromImage renders a pattern in the main colors of the reference image at
+// path, which may be a JPEG, PNG, GIF, BMP, TIFF or WebP image, picked by its
+// file extension. Colors, Pattern, Ratios, ShapeSize and QR don't apply. The
+// main colors are returned sorted.
+func FromImage(ctx context.Context, path string, opts Options) (image.Image, []color.RGBA, error) {
+	cfg, err := opts.config()
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.MainColors < 0 {
+		return nil, nil, fmt.Errorf("invalid main colors %d (must be at least 1)", opts.MainColors)
+	}
+	cfg.PatternType = "image"
+	cfg.KValue = cmp.Or(opts.MainColors, DefaultColors)
+	img, colors, err := generator.RenderImage(ctx, cfg, path, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Main colors are always opaque, so they convert as they are
+	rgba := make([]color.RGBA, len(colors))
+	for i, c := range colors {
+		rgba[i] = color.RGBA(c)
+	}
+	return img, rgba, nil
+}
+
+// config converts the options to a generator configuration.
+func (o Options) config() (*config.Config, error) {
+	cfg := &config.Config{
+		PatternType:   string(o.Pattern),
+		Width:         cmp.Or(o.Width, DefaultWidth),
+		Height:        cmp.Or(o.Height, DefaultHeight),
+		BasePixelSize: cmp.Or(o.PixelSize, DefaultPixelSize),
+		ShapeSize:     cmp.Or(o.ShapeSize, config.DefaultShapeSize),
+		FlowAngle:     o.FlowAngle,
+		AddNoise:      o.Noise,
+		AddEdge:       o.Edge,
+		NoiseLevel:    min(max(o.NoiseLevel, 0), 100),
+		EdgeStrength:  min(max(o.EdgeStrength, 0), 100),
+		LegacyBlend:   o.LegacyBlend,
+		Blend:         max(o.Blend, 0),
+		Distort:       max(o.Distort, 0),
+		QRText:        o.QR,
+		Seed:          o.Seed,
+		KMeansBatch:   1024,
+		Scaler:        "bilinear",
+	}
+	if cfg.PatternType == "" {
+		cfg.PatternType = string(Box)
+	}
+	names := string(o.Layers[0]) + "," + string(o.Layers[1])
+	if o.Layers == [2]Pattern{} {
+		names = config.DefaultLayers
+	}
+	layers, err := config.ParseLayers(names)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layers: %w", err)
+	}
+	cfg.Layers = layers
+	cfg.LayerOpacity = min(max(cmp.Or(o.LayerOpacity, config.DefaultLayerOpacity), 0), 100)
+	if cfg.ShapeSize < 2 {
+		return nil, fmt.Errorf("invalid shape size %d (must be at least 2)", cfg.ShapeSize)
+	}
+
+	ratios, err := config.ParseLayerRatios(o.Ratios)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ratios: %w", err)
+	}
+	cfg.ColorRatios = ratios
+	return cfg, nil
+}